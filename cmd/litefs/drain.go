@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/superfly/litefs/http"
+)
+
+// Default drain settings.
+const (
+	DefaultDrainURL      = "http://localhost:20202"
+	DefaultDrainTimeout  = 30 * time.Second
+	DefaultDrainInterval = 500 * time.Millisecond
+)
+
+// DrainCommand represents the "litefs drain" command. It demotes a node and
+// waits until another node is primary & no replicas stream from it so that
+// the node can be safely shut down.
+type DrainCommand struct {
+	// URL of the node to drain.
+	URL string
+
+	// Auth token of the node, if it requires one.
+	AuthToken string
+
+	// Time to wait for the node to drain before returning an error.
+	Timeout time.Duration
+
+	// Time between drain status checks.
+	Interval time.Duration
+}
+
+// NewDrainCommand returns a new instance of DrainCommand.
+func NewDrainCommand() *DrainCommand {
+	return &DrainCommand{
+		URL:      DefaultDrainURL,
+		Timeout:  DefaultDrainTimeout,
+		Interval: DefaultDrainInterval,
+	}
+}
+
+// ParseFlags parses the command line flags for the drain command.
+func (c *DrainCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-drain", flag.ContinueOnError)
+	fs.StringVar(&c.URL, "url", c.URL, "URL of the node to drain")
+	fs.StringVar(&c.AuthToken, "auth-token", c.AuthToken, "auth token of the node")
+	fs.DurationVar(&c.Timeout, "timeout", c.Timeout, "time to wait for the node to drain")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	return nil
+}
+
+// Run begins draining the node & blocks until it is safe to shut down.
+// Returns an error if the node does not drain within the timeout.
+func (c *DrainCommand) Run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	client := http.NewClient()
+	client.AuthToken = c.AuthToken
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	var status string
+	for {
+		info, err := client.Drain(ctx, c.URL)
+		if err != nil {
+			status = err.Error()
+		} else if info.Drained {
+			fmt.Printf("node drained, primary is %s\n", info.PrimaryURL)
+			return nil
+		} else {
+			status = fmt.Sprintf("is-primary=%v primary-url=%q streams=%d", info.IsPrimary, info.PrimaryURL, info.Streams)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("node did not drain within %s: %s", c.Timeout, status)
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,15 +3,21 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"os/user"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,9 +29,31 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Version is the LiteFS version. Set at build time via ldflags.
+var Version = "development"
+
 func main() {
 	log.SetFlags(0)
 
+	// Run a subcommand instead of a node, if specified.
+	if len(os.Args) > 1 {
+		var cmd Command
+		switch os.Args[1] {
+		case "drain":
+			cmd = NewDrainCommand()
+		case "export-state":
+			cmd = NewExportStateCommand()
+		case "import-state":
+			cmd = NewImportStateCommand()
+		case "verify-against":
+			cmd = NewVerifyAgainstCommand()
+		}
+		if cmd != nil {
+			runCommand(cmd, os.Args[2:])
+			return
+		}
+	}
+
 	signalCh := make(chan os.Signal, 2)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
 
@@ -51,6 +79,12 @@ func main() {
 		cancel()
 		fmt.Println("subprocess exited, litefs shutting down")
 
+	case err := <-m.Fatal():
+		cancel()
+		fmt.Fprintln(os.Stderr, err)
+		_ = m.Close()
+		os.Exit(1)
+
 	case sig := <-signalCh:
 		if m.cmd != nil {
 			fmt.Println("sending signal to exec process")
@@ -76,27 +110,74 @@ func main() {
 	}
 }
 
+// Command represents a subcommand that runs in place of a LiteFS node.
+type Command interface {
+	ParseFlags(ctx context.Context, args []string) error
+	Run(ctx context.Context) error
+}
+
+// runCommand parses args & executes cmd. Exits the process on error.
+func runCommand(cmd Command, args []string) {
+	if err := cmd.ParseFlags(context.Background(), args); err == flag.ErrHelp {
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := cmd.Run(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 // Main represents the command line program.
 type Main struct {
 	cmd    *exec.Cmd  // subcommand
 	execCh chan error // subcommand error channel
 
+	fatalCh chan error // receives an error if the node must exit
+
+	reloadCh chan os.Signal // receives SIGHUP to reload config
+	reloadMu sync.Mutex     // serializes reloads
+	reloadWg sync.WaitGroup
+
+	stopNoPrimaryMonitor func() // stops the dead-man's switch, if enabled
+	noPrimaryWg          sync.WaitGroup
+
+	stopMountMonitor func() // stops the mount check, if enabled
+	mountWg          sync.WaitGroup
+
+	unmountOnce sync.Once
+	unmountErr  error
+	unmounted   int32 // set once the file system is intentionally unmounted
+
+	// Config is not modified once Run() is called. Reloaded settings are
+	// applied to the components that use them, which guard them with a lock.
 	Config Config
 
+	// Path to the config file that was read. Used to reload on SIGHUP.
+	ConfigPath  string
+	NoExpandEnv bool
+
 	Store      *litefs.Store
-	Leaser     *consul.Leaser
+	Leaser     litefs.Leaser // if set before Run(), used instead of Consul
 	FileSystem *fuse.FileSystem
 	HTTPServer *http.Server
 
 	// Used for generating the advertise URL for testing.
 	AdvertiseURLFn func() string
+
+	// Used for injecting mount failures for testing.
+	MountFn func(fsys *fuse.FileSystem) error
 }
 
 // NewMain returns a new instance of Main.
 func NewMain() *Main {
 	return &Main{
-		execCh: make(chan error),
-		Config: NewConfig(),
+		execCh:  make(chan error),
+		fatalCh: make(chan error, 1),
+		Config:  NewConfig(),
 	}
 }
 
@@ -111,8 +192,11 @@ func (m *Main) ParseFlags(ctx context.Context, args []string) (err error) {
 		return fmt.Errorf("too many arguments")
 	}
 
+	m.NoExpandEnv = *noExpandEnv
+
 	// Only read from explicit path, if specified. Report any error.
 	if *configPath != "" {
+		m.ConfigPath = *configPath
 		return ReadConfigFile(&m.Config, *configPath, !*noExpandEnv)
 	}
 
@@ -124,6 +208,7 @@ func (m *Main) ParseFlags(ctx context.Context, args []string) (err error) {
 
 		if err := ReadConfigFile(&m.Config, path, !*noExpandEnv); err == nil {
 			fmt.Printf("config file read from %s\n", path)
+			m.ConfigPath = path
 			return nil
 		} else if err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("cannot read config file at %s: %s", path, err)
@@ -145,34 +230,69 @@ func configSearchPaths() []string {
 }
 
 func (m *Main) Close() (err error) {
-	if m.HTTPServer != nil {
-		if e := m.HTTPServer.Close(); err == nil {
+	if m.reloadCh != nil {
+		signal.Stop(m.reloadCh)
+		close(m.reloadCh)
+		m.reloadWg.Wait()
+		m.reloadCh = nil
+	}
+
+	if m.stopNoPrimaryMonitor != nil {
+		m.stopNoPrimaryMonitor()
+		m.noPrimaryWg.Wait()
+	}
+
+	if m.stopMountMonitor != nil {
+		m.stopMountMonitor()
+		m.mountWg.Wait()
+	}
+
+	// Shut down in an order that does not lose in-flight transactions. New
+	// writes are stopped & pending transactions are made durable and sent to
+	// replicas before the lease is released. The mount is removed last.
+	ctx, cancel := context.WithTimeout(context.Background(), m.Config.ShutdownTimeout)
+	defer cancel()
+
+	if m.Store != nil {
+		m.Store.StopWrites()
+		if e := m.Store.Flush(ctx); err == nil {
 			err = e
 		}
 	}
 
-	if m.FileSystem != nil {
-		if e := m.FileSystem.Unmount(); err == nil {
+	if m.HTTPServer != nil {
+		if e := m.HTTPServer.WaitForReplicas(ctx); e != nil {
+			log.Printf("shutting down before replicas caught up: %s", e)
+		}
+		if e := m.HTTPServer.Close(); err == nil {
 			err = e
 		}
 	}
 
+	// Closing the store releases the lease, if held.
 	if m.Store != nil {
 		if e := m.Store.Close(); err == nil {
 			err = e
 		}
 	}
 
+	if e := m.unmount(); err == nil {
+		err = e
+	}
+
 	return err
 }
 
 func (m *Main) Run(ctx context.Context) (err error) {
-	if m.Config.MountDir == "" {
-		return fmt.Errorf("mount path required")
-	} else if m.Config.Consul.URL == "" {
-		return fmt.Errorf("consul URL required")
-	} else if m.Config.Consul.Key == "" {
-		return fmt.Errorf("consul key required")
+	if err := m.Config.Validate(); err != nil {
+		return err
+	}
+
+	// Verify FUSE, SQLite & LTX generation work on this host before mounting.
+	if m.Config.SelfCheck {
+		if err := SelfCheck(ctx, m.Config.Debug); err != nil {
+			return fmt.Errorf("self-check failed: %w", err)
+		}
 	}
 
 	// Start listening on HTTP server first so we can determine the URL.
@@ -182,9 +302,22 @@ func (m *Main) Run(ctx context.Context) (err error) {
 		return fmt.Errorf("cannot init http server: %w", err)
 	}
 
-	if err := m.initConsul(ctx); err != nil {
-		return fmt.Errorf("cannot init consul: %w", err)
-	} else if err := m.openStore(ctx); err != nil {
+	// Standalone nodes are always primary so no leaser is required.
+	if !m.Config.Standalone && m.Leaser == nil {
+		if err := m.initConsul(ctx); err != nil {
+			return fmt.Errorf("cannot init consul: %w", err)
+		}
+	}
+
+	// Wait for the leaser to become reachable, such as when Consul is
+	// started alongside LiteFS, instead of exiting & restarting repeatedly.
+	if m.Leaser != nil {
+		if err := WaitForLeaser(ctx, m.Leaser, m.Config.LeaserConnectTimeout, m.Config.LeaserConnectRetryDelay); err != nil {
+			return err
+		}
+	}
+
+	if err := m.openStore(ctx); err != nil {
 		return fmt.Errorf("cannot open store: %w", err)
 	}
 
@@ -196,6 +329,37 @@ func (m *Main) Run(ctx context.Context) (err error) {
 	m.HTTPServer.Serve()
 	log.Printf("http server listening on: %s", m.HTTPServer.URL())
 
+	// Reload hot-reloadable settings whenever the process receives a SIGHUP.
+	m.reloadCh = make(chan os.Signal, 1)
+	signal.Notify(m.reloadCh, syscall.SIGHUP)
+	m.reloadWg.Add(1)
+	go func() { defer m.reloadWg.Done(); m.monitorReload(ctx) }()
+
+	// Unmount if this replica cannot reach a primary for too long.
+	if m.Config.MaxNoPrimaryDuration > 0 {
+		noPrimaryCtx, cancel := context.WithCancel(ctx)
+		m.stopNoPrimaryMonitor = cancel
+		m.noPrimaryWg.Add(1)
+		go func() { defer m.noPrimaryWg.Done(); m.monitorNoPrimary(noPrimaryCtx) }()
+	}
+
+	// Respond if the mount or data directory disappears from under the node.
+	if m.Config.MountCheckInterval > 0 {
+		mountCtx, cancel := context.WithCancel(ctx)
+		m.stopMountMonitor = cancel
+		m.mountWg.Add(1)
+		go func() { defer m.mountWg.Done(); m.monitorMount(mountCtx) }()
+	}
+
+	// Block until this node is primary so the write endpoint is live once
+	// Run() returns, if enabled.
+	if m.Config.WaitForPrimary {
+		log.Printf("waiting to become primary")
+		if err := WaitForPrimary(ctx, m.Store, m.Config.WaitForPrimaryTimeout); err != nil {
+			return err
+		}
+	}
+
 	// Execute subcommand, if specified in config.
 	if err := m.execCmd(ctx); err != nil {
 		return fmt.Errorf("cannot exec: %w", err)
@@ -204,6 +368,283 @@ func (m *Main) Run(ctx context.Context) (err error) {
 	return nil
 }
 
+// monitorReload reloads the config file each time a SIGHUP is received.
+func (m *Main) monitorReload(ctx context.Context) {
+	for range m.reloadCh {
+		log.Printf("SIGHUP received, reloading config")
+		if err := m.Reload(ctx); err != nil {
+			log.Printf("cannot reload config, keeping current config: %s", err)
+		}
+	}
+}
+
+// Reload re-reads the config file and applies settings which can be changed
+// without a restart. Changes to all other settings are logged and ignored
+// until the process is restarted. The current config is kept on error.
+func (m *Main) Reload(ctx context.Context) error {
+	if m.ConfigPath == "" {
+		return fmt.Errorf("no config file to reload")
+	}
+
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	config := NewConfig()
+	if err := ReadConfigFile(&config, m.ConfigPath, !m.NoExpandEnv); err != nil {
+		return err
+	} else if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// Report settings that cannot be applied to a running process.
+	prev := m.Config
+	for _, c := range []struct {
+		name    string
+		changed bool
+	}{
+		{"mount-dir", config.MountDir != prev.MountDir},
+		{"exec", config.Exec != prev.Exec},
+		{"debug", config.Debug != prev.Debug},
+		{"debug-replication-delay", config.DebugReplicationDelay != prev.DebugReplicationDelay},
+		{"debug-apply-delay", config.DebugApplyDelay != prev.DebugApplyDelay},
+		{"node-id", config.NodeID != prev.NodeID},
+		{"self-check", config.SelfCheck != prev.SelfCheck},
+		{"mount-retries", config.MountRetries != prev.MountRetries},
+		{"mount-retry-delay", config.MountRetryDelay != prev.MountRetryDelay},
+		{"mount-check-interval", config.MountCheckInterval != prev.MountCheckInterval},
+		{"mount-lost-policy", config.MountLostPolicy != prev.MountLostPolicy},
+		{"max-open-handles", config.MaxOpenHandles != prev.MaxOpenHandles},
+		{"invalidate-handles-on-role-change", config.InvalidateHandlesOnRoleChange != prev.InvalidateHandlesOnRoleChange},
+		{"read-only-replica", config.ReadOnlyReplica != prev.ReadOnlyReplica},
+		{"busy-timeout", config.BusyTimeout != prev.BusyTimeout},
+		{"local-temp-files", config.LocalTempFiles != prev.LocalTempFiles},
+		{"leaser-connect-timeout", config.LeaserConnectTimeout != prev.LeaserConnectTimeout},
+		{"wait-for-primary", config.WaitForPrimary != prev.WaitForPrimary},
+		{"wait-for-primary-timeout", config.WaitForPrimaryTimeout != prev.WaitForPrimaryTimeout},
+		{"leaser-connect-retry-delay", config.LeaserConnectRetryDelay != prev.LeaserConnectRetryDelay},
+		{"shutdown-timeout", config.ShutdownTimeout != prev.ShutdownTimeout},
+		{"page-size", config.PageSize != prev.PageSize},
+		{"standalone", config.Standalone != prev.Standalone},
+		{"priority", config.Priority != prev.Priority},
+		{"zone", config.Zone != prev.Zone},
+		{"preferred-zone", config.PreferredZone != prev.PreferredZone},
+		{"ignore-drops", config.IgnoreDrops != prev.IgnoreDrops},
+		{"min-free-space", config.MinFreeSpace != prev.MinFreeSpace},
+		{"max-database-bytes", config.MaxDatabaseBytes != prev.MaxDatabaseBytes},
+		{"max-database-bytes-overrides", !reflect.DeepEqual(config.MaxDatabaseBytesOverrides, prev.MaxDatabaseBytesOverrides)},
+		{"pinned-primary-node-ids", !reflect.DeepEqual(config.PinnedPrimaryNodeIDs, prev.PinnedPrimaryNodeIDs)},
+		{"txid-warn-threshold", config.TXIDWarnThreshold != prev.TXIDWarnThreshold},
+		{"max-no-primary-duration", config.MaxNoPrimaryDuration != prev.MaxNoPrimaryDuration},
+		{"read-grace-period", config.ReadGracePeriod != prev.ReadGracePeriod},
+		{"read-max-lag", config.ReadMaxLag != prev.ReadMaxLag},
+		{"standby-only", config.StandbyOnly != prev.StandbyOnly},
+		{"max-startup-recovery-duration", config.MaxStartupRecoveryDuration != prev.MaxStartupRecoveryDuration},
+		{"replica-fsync-policy", config.ReplicaFsyncPolicy != prev.ReplicaFsyncPolicy},
+		{"replica-fsync-interval", config.ReplicaFsyncInterval != prev.ReplicaFsyncInterval},
+		{"durable-before-replicate", config.DurableBeforeReplicate != prev.DurableBeforeReplicate},
+		{"write-buffer-window", config.WriteBufferWindow != prev.WriteBufferWindow},
+		{"write-buffer-max-size", config.WriteBufferMaxSize != prev.WriteBufferMaxSize},
+		{"group-commit-window", config.GroupCommitWindow != prev.GroupCommitWindow},
+		{"group-commit-max-size", config.GroupCommitMaxSize != prev.GroupCommitMaxSize},
+		{"segment-compression", config.SegmentCompression != prev.SegmentCompression},
+		{"strict-segment-continuity", config.StrictSegmentContinuity != prev.StrictSegmentContinuity},
+		{"apply-error-policy", config.ApplyErrorPolicy != prev.ApplyErrorPolicy},
+		{"warmup-concurrency", config.WarmupConcurrency != prev.WarmupConcurrency},
+		{"integrity-check-interval", config.IntegrityCheckInterval != prev.IntegrityCheckInterval},
+		{"schema-version-queries", !reflect.DeepEqual(config.SchemaVersionQueries, prev.SchemaVersionQueries)},
+		{"seeds", !reflect.DeepEqual(config.Seeds, prev.Seeds)},
+		{"http.addr", config.HTTP.Addr != prev.HTTP.Addr},
+		{"http.addrs", strings.Join(config.HTTP.Addrs, ",") != strings.Join(prev.HTTP.Addrs, ",")},
+		{"http.transport", config.HTTP.Transport != prev.HTTP.Transport},
+		{"http.base-path", config.HTTP.BasePath != prev.HTTP.BasePath},
+		{"http.auth-token", config.HTTP.AuthToken != prev.HTTP.AuthToken},
+		{"http.source-url", config.HTTP.SourceURL != prev.HTTP.SourceURL},
+		{"http.dedup-snapshots", config.HTTP.DedupSnapshots != prev.HTTP.DedupSnapshots},
+		{"http.max-stream-bytes-per-sec", config.HTTP.MaxStreamBytesPerSec != prev.HTTP.MaxStreamBytesPerSec},
+		{"http.max-frame-size", config.HTTP.MaxFrameSize != prev.HTTP.MaxFrameSize},
+		{"http.stream-compression", config.HTTP.StreamCompression != prev.HTTP.StreamCompression},
+		{"http.access-log", config.HTTP.AccessLog != prev.HTTP.AccessLog},
+		{"http.reject-duplicate-node-id", config.HTTP.RejectDuplicateNodeID != prev.HTTP.RejectDuplicateNodeID},
+		{"http.min-free-file-descriptors", config.HTTP.MinFreeFileDescriptors != prev.HTTP.MinFreeFileDescriptors},
+		{"http.replica-drain-timeout", config.HTTP.ReplicaDrainTimeout != prev.HTTP.ReplicaDrainTimeout},
+		{"http.dial-timeout", config.HTTP.DialTimeout != prev.HTTP.DialTimeout},
+		{"http.keep-alive", config.HTTP.KeepAlive != prev.HTTP.KeepAlive},
+		{"http.idle-conn-timeout", config.HTTP.IdleConnTimeout != prev.HTTP.IdleConnTimeout},
+		{"http.max-idle-conns-per-host", config.HTTP.MaxIdleConnsPerHost != prev.HTTP.MaxIdleConnsPerHost},
+		{"consul", config.Consul != prev.Consul},
+		{"election.coordinated", config.Election.Coordinated != prev.Election.Coordinated},
+		{"election.peers", strings.Join(config.Election.Peers, ",") != strings.Join(prev.Election.Peers, ",")},
+		{"election.timeout", config.Election.Timeout != prev.Election.Timeout},
+	} {
+		if c.changed {
+			log.Printf("config reload: %s changed, restart required to apply", c.name)
+		}
+	}
+
+	// Apply stream timeouts. These take effect on the next stream. The
+	// current values are read from the client & server as m.Config is not
+	// updated by a reload.
+	heartbeatInterval, idleTimeout := m.HTTPServer.StreamTimeouts()
+	readTimeout := prev.HTTP.StreamReadTimeout
+	client, _ := m.Store.Client.(*http.Client)
+	if client != nil {
+		_, readTimeout = client.StreamTimeouts()
+	}
+
+	for _, c := range []struct {
+		name      string
+		prev, new time.Duration
+	}{
+		{"http.heartbeat-interval", heartbeatInterval, config.HTTP.HeartbeatInterval},
+		{"http.stream-read-timeout", readTimeout, config.HTTP.StreamReadTimeout},
+		{"http.stream-idle-timeout", idleTimeout, config.HTTP.StreamIdleTimeout},
+	} {
+		if c.new != c.prev {
+			log.Printf("config reload: %s changed from %s to %s", c.name, c.prev, c.new)
+		}
+	}
+
+	if client != nil {
+		client.SetStreamTimeouts(config.HTTP.HeartbeatInterval, config.HTTP.StreamReadTimeout)
+	}
+	m.HTTPServer.SetStreamTimeouts(config.HTTP.HeartbeatInterval, config.HTTP.StreamIdleTimeout)
+
+	return nil
+}
+
+// monitorNoPrimary unmounts the file system once the node has gone longer
+// than MaxNoPrimaryDuration without a primary so applications do not silently
+// read increasingly stale data.
+func (m *Main) monitorNoPrimary(ctx context.Context) {
+	interval := m.Config.MaxNoPrimaryDuration / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d := m.Store.NoPrimaryDuration()
+			if d <= m.Config.MaxNoPrimaryDuration {
+				continue
+			}
+
+			log.Printf("no primary for %s, exceeds max-no-primary-duration of %s; unmounting %s",
+				d.Truncate(time.Millisecond), m.Config.MaxNoPrimaryDuration, m.FileSystem.Path())
+			if err := m.unmount(); err != nil {
+				log.Printf("cannot unmount file system: %s", err)
+			}
+			return
+		}
+	}
+}
+
+// unmount unmounts the file system, if mounted. Safe to call multiple times.
+func (m *Main) unmount() error {
+	if m.FileSystem == nil {
+		return nil
+	}
+	m.unmountOnce.Do(func() {
+		atomic.StoreInt32(&m.unmounted, 1)
+		m.unmountErr = m.FileSystem.Unmount()
+	})
+	return m.unmountErr
+}
+
+// Fatal returns a channel that receives an error if the node can no longer
+// run & the process should exit, such as after its mount is lost.
+func (m *Main) Fatal() <-chan error { return m.fatalCh }
+
+// monitorMount periodically verifies that the mount & data directory still
+// exist & responds according to the MountLostPolicy once either is lost.
+func (m *Main) monitorMount(ctx context.Context) {
+	ticker := time.NewTicker(m.Config.MountCheckInterval)
+	defer ticker.Stop()
+
+	var alerted bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := m.checkMount()
+		if err == nil {
+			alerted = false
+			continue
+		} else if atomic.LoadInt32(&m.unmounted) == 1 {
+			return // unmounted intentionally, such as by max-no-primary-duration
+		}
+
+		switch m.Config.MountLostPolicy {
+		case MountLostPolicyExit:
+			log.Printf("ALERT: mount lost, exiting: %s", err)
+			m.fatalCh <- fmt.Errorf("mount lost: %w", err)
+			return
+
+		case MountLostPolicyRemount:
+			log.Printf("ALERT: mount lost, remounting: %s", err)
+			if e := m.remount(ctx); e != nil {
+				m.fatalCh <- fmt.Errorf("cannot remount after mount lost: %w", e)
+				return
+			}
+			log.Printf("LiteFS remounted to: %s", m.FileSystem.Path())
+
+		default:
+			if !alerted {
+				log.Printf("ALERT: mount lost: %s", err)
+				alerted = true
+			}
+		}
+	}
+}
+
+// checkMount returns an error if the data directory no longer exists or if
+// the mount directory no longer exists or is no longer mounted.
+func (m *Main) checkMount() error {
+	if _, err := os.Stat(m.Store.Path()); err != nil {
+		return fmt.Errorf("data directory unavailable: %w", err)
+	}
+
+	fi, err := os.Stat(m.FileSystem.Path())
+	if err != nil {
+		return fmt.Errorf("mount directory unavailable: %w", err)
+	}
+	parent, err := os.Stat(filepath.Dir(m.FileSystem.Path()))
+	if err != nil {
+		return fmt.Errorf("mount parent directory unavailable: %w", err)
+	}
+
+	// The mount directory is on a different device than its parent while the
+	// file system is mounted.
+	if fi.Sys().(*syscall.Stat_t).Dev == parent.Sys().(*syscall.Stat_t).Dev {
+		return fmt.Errorf("mount directory is no longer mounted: %s", m.FileSystem.Path())
+	}
+	return nil
+}
+
+// remount recreates the mount directory & mounts the file system again. The
+// data directory must still exist as the databases cannot be recovered
+// without it.
+func (m *Main) remount(ctx context.Context) error {
+	if _, err := os.Stat(m.Store.Path()); err != nil {
+		return fmt.Errorf("data directory unavailable: %w", err)
+	}
+
+	if err := m.FileSystem.Unmount(); err != nil {
+		log.Printf("cannot unmount lost file system, continuing: %s", err)
+	}
+	if err := os.MkdirAll(m.FileSystem.Path(), 0777); err != nil {
+		return fmt.Errorf("create mount directory: %w", err)
+	}
+	return m.mount(ctx, m.FileSystem)
+}
+
 func (m *Main) initConsul(ctx context.Context) error {
 	// TEMP: Allow non-localhost addresses.
 
@@ -213,13 +654,28 @@ func (m *Main) initConsul(ctx context.Context) error {
 		advertiseURL = m.AdvertiseURLFn()
 	}
 
+	// Advertise the base path so replicas connect through the same prefix.
+	if basePath := strings.TrimSuffix(m.Config.HTTP.BasePath, "/"); basePath != "" {
+		u, err := url.Parse(advertiseURL)
+		if err != nil {
+			return fmt.Errorf("invalid advertise url: %w", err)
+		} else if u.Path == "" || u.Path == "/" {
+			u.Path = basePath
+			advertiseURL = u.String()
+		}
+	}
+
 	leaser := consul.NewLeaser(m.Config.Consul.URL, advertiseURL)
 
 	leaser.Key = m.Config.Consul.Key
+	leaser.KeyPrefix = m.Config.Consul.KeyPrefix
+	leaser.Cluster = m.Config.Consul.Cluster
+	leaser.TTL = m.Config.Consul.TTL
+	leaser.LockDelay = m.Config.Consul.LockDelay
 	if err := leaser.Open(); err != nil {
 		return fmt.Errorf("cannot connect to consul: %w", err)
 	}
-	log.Printf("initializing consul: key=%s url=%s advertise-url=%s", m.Config.Consul.URL, m.Config.Consul.Key, advertiseURL)
+	log.Printf("initializing consul: key=%s url=%s advertise-url=%s", leaser.LeaseKey(), m.Config.Consul.URL, advertiseURL)
 
 	m.Leaser = leaser
 	return nil
@@ -232,16 +688,160 @@ func (m *Main) initStore(ctx context.Context) error {
 	}
 	dir, file := filepath.Split(mountDir)
 
+	client := http.NewClient()
+	client.Transport = m.Config.HTTP.Transport
+	client.SetTransportOptions(http.TransportOptions{
+		DialTimeout:         m.Config.HTTP.DialTimeout,
+		KeepAlive:           m.Config.HTTP.KeepAlive,
+		IdleConnTimeout:     m.Config.HTTP.IdleConnTimeout,
+		MaxIdleConnsPerHost: m.Config.HTTP.MaxIdleConnsPerHost,
+	})
+	client.HeartbeatInterval = m.Config.HTTP.HeartbeatInterval
+	client.ReadTimeout = m.Config.HTTP.StreamReadTimeout
+
 	m.Store = litefs.NewStore(filepath.Join(dir, "."+file))
-	m.Store.Client = http.NewClient()
+	if m.Config.NodeID != "" {
+		if id := ExpandNodeID(m.Config.NodeID); id == "" {
+			log.Printf("node-id %q expanded to an empty value, using random id", m.Config.NodeID)
+		} else {
+			if isCommonNodeID(id) {
+				log.Printf("node-id %q is unlikely to be unique, nodes with the same id cannot be told apart", id)
+			}
+			m.Store.ID = id
+		}
+	}
+	log.Printf("node id: %s", m.Store.ID)
+
+	client.NodeID = m.Store.ID
+	m.Store.Client = client
+	m.Store.PageSize = m.Config.PageSize
+	m.Store.Standalone = m.Config.Standalone
+	m.Store.IgnoreDrops = m.Config.IgnoreDrops
+	m.Store.SourceURL = m.Config.HTTP.SourceURL
+	m.Store.MinFreeSpace = m.Config.MinFreeSpace
+	m.Store.MaxDatabaseBytes = m.Config.MaxDatabaseBytes
+	m.Store.MaxDatabaseBytesOverrides = m.Config.MaxDatabaseBytesOverrides
+	m.Store.PinnedPrimaryNodeIDs = m.Config.PinnedPrimaryNodeIDs
+	m.Store.TXIDWarnThreshold = m.Config.TXIDWarnThreshold
+	m.Store.ReadGracePeriod = m.Config.ReadGracePeriod
+	m.Store.ReadMaxLag = m.Config.ReadMaxLag
+	m.Store.StandbyOnly = m.Config.StandbyOnly
+	m.Store.MaxRecoveryDuration = m.Config.MaxStartupRecoveryDuration
+	m.Store.ReplicaFsyncPolicy = m.Config.ReplicaFsyncPolicy
+	m.Store.ReplicaFsyncInterval = m.Config.ReplicaFsyncInterval
+	m.Store.DurableBeforeReplicate = m.Config.DurableBeforeReplicate
+	m.Store.WriteBufferWindow = m.Config.WriteBufferWindow
+	m.Store.WriteBufferMaxSize = m.Config.WriteBufferMaxSize
+	m.Store.GroupCommitWindow = m.Config.GroupCommitWindow
+	m.Store.GroupCommitMaxSize = m.Config.GroupCommitMaxSize
+	m.Store.SegmentCompression = m.Config.SegmentCompression
+	m.Store.StrictSegmentContinuity = m.Config.StrictSegmentContinuity
+	m.Store.ApplyErrorPolicy = m.Config.ApplyErrorPolicy
+	m.Store.WarmupConcurrency = m.Config.WarmupConcurrency
+	m.Store.IntegrityCheckInterval = m.Config.IntegrityCheckInterval
+	m.Store.DebugApplyDelay = m.Config.DebugApplyDelay
+	if m.Config.DebugApplyDelay > 0 {
+		log.Printf("WARNING: delaying each replicated transaction by %s (debug-apply-delay)", m.Config.DebugApplyDelay)
+	}
+	m.Store.AcquireDelay = time.Duration(MaxPriority-m.Config.Priority) * PriorityDelay
+	if m.Config.PreferredZone != "" && !m.Config.InPreferredZone() {
+		if m.Config.Zone == "" {
+			log.Printf("preferred-zone %q is set but zone is not, node is treated as outside the preferred zone", m.Config.PreferredZone)
+		}
+		m.Store.AcquireDelay += PreferredZoneDelay
+	}
+	m.Store.BehindAcquireDelay = BehindPrimaryDelay
 	return nil
 }
 
 func (m *Main) openStore(ctx context.Context) error {
-	m.Store.Leaser = m.Leaser
+	if m.Leaser != nil {
+		m.Store.Leaser = m.Leaser
+
+		// Identify this node to upstream nodes so it appears in the topology.
+		if client, ok := m.Store.Client.(*http.Client); ok {
+			client.AdvertiseURL = m.Leaser.AdvertiseURL()
+		}
+	}
+
+	// Layer coordinated failover over the leaser so that the most up-to-date
+	// candidate is promoted when the primary is lost.
+	if m.Leaser != nil && m.Config.Election.Coordinated {
+		client, ok := m.Store.Client.(litefs.CandidateClient)
+		if !ok {
+			return fmt.Errorf("client does not support coordinated elections")
+		}
+		coordinator := litefs.NewElectionCoordinator(m.Leaser, m.Store, client)
+		coordinator.Peers = m.Config.Election.Peers
+		coordinator.Priority = m.Config.Priority
+		coordinator.Zone = m.Config.Zone
+		coordinator.Preferred = m.Config.InPreferredZone()
+		coordinator.Timeout = m.Config.Election.Timeout
+		m.Store.Leaser = coordinator
+	}
+
+	if err := m.seedDatabases(ctx); err != nil {
+		return fmt.Errorf("seed databases: %w", err)
+	}
+
 	return m.Store.Open()
 }
 
+// seedDatabases creates each configured seed database that does not exist on
+// this node from its local copy. The position of the copy is read from the
+// primary's history & the copy is rejected if it does not match.
+func (m *Main) seedDatabases(ctx context.Context) error {
+	if len(m.Config.Seeds) == 0 {
+		return nil
+	}
+
+	client, ok := m.Store.Client.(*http.Client)
+	if !ok {
+		return fmt.Errorf("client does not support seeding")
+	}
+
+	primaryURL, err := m.Leaser.PrimaryURL(ctx)
+	if errors.Is(err, litefs.ErrNoPrimary) {
+		log.Printf("no primary available to verify seed databases, skipping")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("fetch primary url: %w", err)
+	} else if primaryURL == m.Leaser.AdvertiseURL() {
+		// The lease may still be held by this node if it restarted within
+		// the TTL. Its own API is not serving yet so it cannot verify seeds.
+		log.Printf("lease is held by this node, skipping seed databases")
+		return nil
+	}
+
+	for _, seed := range m.Config.Seeds {
+		txID, err := litefs.ParseTXID(seed.TXID)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, SeedPosTimeout)
+		info, err := client.PosAt(ctx, primaryURL, seed.Name, txID)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("fetch seed position: name=%q err=%w", seed.Name, err)
+		}
+
+		// Skip databases that already exist so a restart does not reseed.
+		if _, err := os.Stat(m.Store.DBDir(info.ID)); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		pos := litefs.Pos{TXID: info.TXID, Chksum: info.Chksum}
+		if _, err := m.Store.SeedDB(info.ID, info.Name, seed.Path, pos); err != nil {
+			return fmt.Errorf("seed: name=%q err=%w", seed.Name, err)
+		}
+		log.Printf("database seeded from %s: name=%q txid=%016x", seed.Path, info.Name, info.TXID)
+	}
+	return nil
+}
+
 func (m *Main) initFileSystem(ctx context.Context) error {
 	mountDir, err := filepath.Abs(m.Config.MountDir)
 	if err != nil {
@@ -251,7 +851,14 @@ func (m *Main) initFileSystem(ctx context.Context) error {
 	// Build the file system to interact with the store.
 	fsys := fuse.NewFileSystem(mountDir, m.Store)
 	fsys.Debug = m.Config.Debug
-	if err := fsys.Mount(); err != nil {
+	fsys.MaxOpenHandles = m.Config.MaxOpenHandles
+	fsys.InvalidateHandlesOnRoleChange = m.Config.InvalidateHandlesOnRoleChange
+	fsys.ReadOnlyReplica = m.Config.ReadOnlyReplica
+	fsys.BusyTimeout = m.Config.BusyTimeout
+	if m.Config.LocalTempFiles {
+		fsys.TempDir = filepath.Join(m.Store.Path(), "tmp")
+	}
+	if err := m.mount(ctx, fsys); err != nil {
 		return fmt.Errorf("cannot open file system: %s", err)
 	}
 
@@ -262,8 +869,117 @@ func (m *Main) initFileSystem(ctx context.Context) error {
 	return nil
 }
 
+// mount mounts fsys, retrying with exponential backoff on failure. Errors that
+// a retry cannot resolve, such as a missing FUSE install or mount point, are
+// returned immediately.
+func (m *Main) mount(ctx context.Context, fsys *fuse.FileSystem) error {
+	if err := fsys.CheckMount(); err != nil {
+		return err
+	}
+
+	mountFn := fsys.Mount
+	if m.MountFn != nil {
+		mountFn = func() error { return m.MountFn(fsys) }
+	}
+
+	delay := m.Config.MountRetryDelay
+	for i := 0; ; i++ {
+		err := mountFn()
+		if err == nil || i >= m.Config.MountRetries {
+			return err
+		}
+
+		log.Printf("mount failed, retrying in %s (%d/%d): %s", delay, i+1, m.Config.MountRetries, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// WaitForPrimary blocks until store becomes the primary. Returns an error if
+// the node is not primary before timeout has elapsed. A zero timeout waits
+// until ctx is done.
+func WaitForPrimary(ctx context.Context, store *litefs.Store, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(WaitForPrimaryInterval)
+	defer ticker.Stop()
+
+	for !store.IsPrimary() {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("node did not become primary within %s", timeout)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// WaitForLeaser blocks until leaser can be reached, retrying with exponential
+// backoff from delay until timeout has elapsed. The leaser is reachable once
+// it can report the current primary, or that there is none.
+func WaitForLeaser(ctx context.Context, leaser litefs.Leaser, timeout, delay time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := leaser.PrimaryURL(ctx)
+		if err == nil || errors.Is(err, litefs.ErrNoPrimary) {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("cannot connect to leaser within %s: %w", timeout, err)
+		} else if delay > remaining {
+			delay = remaining
+		}
+
+		log.Printf("cannot connect to leaser, retrying in %s: %s", delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
 func (m *Main) initHTTPServer(ctx context.Context) error {
 	server := http.NewServer(m.Store, m.Config.HTTP.Addr)
+	server.Addrs = m.Config.HTTP.Addrs
+	server.HeartbeatInterval = m.Config.HTTP.HeartbeatInterval
+	server.IdleTimeout = m.Config.HTTP.StreamIdleTimeout
+	server.BasePath = m.Config.HTTP.BasePath
+	server.AuthToken = m.Config.HTTP.AuthToken
+	server.DedupSnapshots = m.Config.HTTP.DedupSnapshots
+	server.MaxStreamBytesPerSec = m.Config.HTTP.MaxStreamBytesPerSec
+	server.MaxFrameSize = m.Config.HTTP.MaxFrameSize
+	server.StreamCompression = m.Config.HTTP.StreamCompression
+	server.AccessLog = m.Config.HTTP.AccessLog
+	server.RejectDuplicateNodeID = m.Config.HTTP.RejectDuplicateNodeID
+	server.MinFreeFileDescriptors = m.Config.HTTP.MinFreeFileDescriptors
+	server.ReplicaDrainTimeout = m.Config.HTTP.ReplicaDrainTimeout
+	server.DebugReplicationDelay = m.Config.DebugReplicationDelay
+	if m.Config.DebugReplicationDelay > 0 {
+		log.Printf("WARNING: delaying each streamed transaction by %s (debug-replication-delay)", m.Config.DebugReplicationDelay)
+	}
+	if len(m.Config.SchemaVersionQueries) > 0 {
+		server.SchemaVersionFunc = m.schemaVersion
+	}
+	server.Priority = m.Config.Priority
+	server.Zone = m.Config.Zone
+	server.Preferred = m.Config.InPreferredZone()
+	server.Version = Version
+	server.MountDir = m.Config.MountDir
 	if err := server.Listen(); err != nil {
 		return fmt.Errorf("cannot open http server: %w", err)
 	}
@@ -271,6 +987,27 @@ func (m *Main) initHTTPServer(ctx context.Context) error {
 	return nil
 }
 
+// schemaVersion runs the configured schema version query against db through
+// the mount. Returns false if no query is configured for the database.
+func (m *Main) schemaVersion(ctx context.Context, db *litefs.DB) (int64, bool, error) {
+	query, ok := m.Config.SchemaVersionQueries[db.Name()]
+	if !ok {
+		return 0, false, nil
+	}
+
+	sqlDB, err := sql.Open("sqlite3", "file:"+filepath.Join(m.Config.MountDir, db.Name())+"?mode=ro")
+	if err != nil {
+		return 0, false, err
+	}
+	defer sqlDB.Close()
+
+	var version int64
+	if err := sqlDB.QueryRowContext(ctx, query).Scan(&version); err != nil {
+		return 0, false, fmt.Errorf("query: %w", err)
+	}
+	return version, true, nil
+}
+
 func (m *Main) execCmd(ctx context.Context) error {
 	// Exit if no subcommand specified.
 	if m.Config.Exec == "" {
@@ -307,30 +1044,557 @@ type Config struct {
 	MountDir string `yaml:"mount-dir"`
 	Exec     string `yaml:"exec"`
 	Debug    bool   `yaml:"debug"`
+	PageSize uint32 `yaml:"page-size"`
+
+	// Artificial delays before the primary sends & before a replica applies
+	// each transaction. Used to test application behavior under lag.
+	DebugReplicationDelay time.Duration `yaml:"debug-replication-delay"`
+	DebugApplyDelay       time.Duration `yaml:"debug-apply-delay"`
+
+	// Unique identifier for the node. Environment variables, such as
+	// "${HOSTNAME}", are expanded. A random ID is generated if blank.
+	NodeID string `yaml:"node-id"`
+
+	// If true, a scratch database is written through a temporary mount at
+	// startup to verify the host before mounting the real file system.
+	SelfCheck bool `yaml:"self-check"`
+
+	// Number of times to retry mounting the file system after a failure and
+	// the delay before the first retry. The delay doubles after each retry.
+	MountRetries    int           `yaml:"mount-retries"`
+	MountRetryDelay time.Duration `yaml:"mount-retry-delay"`
+
+	// How often the mount & data directory are checked & how the node
+	// responds if either disappears. One of "alert", "exit", or "remount".
+	MountCheckInterval time.Duration   `yaml:"mount-check-interval"`
+	MountLostPolicy    MountLostPolicy `yaml:"mount-lost-policy"`
+
+	// If greater than zero, a warning is logged when the number of open FUSE
+	// file handles exceeds this soft limit.
+	MaxOpenHandles int `yaml:"max-open-handles"`
+
+	// If true, open FUSE file handles fail with ESTALE after the node switches
+	// between primary & replica so applications re-open their files.
+	InvalidateHandlesOnRoleChange bool `yaml:"invalidate-handles-on-role-change"`
+
+	// If true, the FUSE mount is presented as read-only while the node is a
+	// replica & becomes writable when the node is promoted.
+	ReadOnlyReplica bool `yaml:"read-only-replica"`
+
+	// If greater than zero, contended SQLite locks are retried for up to this
+	// duration before the application receives SQLITE_BUSY.
+	BusyTimeout time.Duration `yaml:"busy-timeout"`
+
+	// If true, SQLite temporary files created in the mount are stored locally
+	// & never replicated so that temp tables can be used on replicas.
+	LocalTempFiles bool `yaml:"local-temp-files"`
+
+	// Length of time to wait for the leaser to become reachable at startup &
+	// the delay before the first retry. The delay doubles after each retry.
+	LeaserConnectTimeout    time.Duration `yaml:"leaser-connect-timeout"`
+	LeaserConnectRetryDelay time.Duration `yaml:"leaser-connect-retry-delay"`
+
+	// If true, Run() blocks until the node becomes primary so orchestration
+	// knows the write endpoint is live. Fails after the timeout, if set.
+	WaitForPrimary        bool          `yaml:"wait-for-primary"`
+	WaitForPrimaryTimeout time.Duration `yaml:"wait-for-primary-timeout"`
+
+	// Maximum time to wait on shutdown for write transactions in progress to
+	// complete & for replicas to receive pending transactions.
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout"`
+
+	// If true, databases deleted on the primary are kept on this replica.
+	IgnoreDrops bool `yaml:"ignore-drops"`
+
+	// If true, the node is always primary and Consul is not used.
+	Standalone bool `yaml:"standalone"`
+
+	// Election priority from 0 to MaxPriority. Lower priority nodes wait
+	// longer before acquiring the lease when there is no primary.
+	Priority int `yaml:"priority"`
+
+	// Availability zone of the node & the zone the primary should preferably
+	// run in. Nodes outside the preferred zone wait longer before acquiring
+	// the lease & lose election ties to nodes in the preferred zone.
+	Zone          string `yaml:"zone"`
+	PreferredZone string `yaml:"preferred-zone"`
+
+	// If non-zero, the primary rejects new writes while the data directory
+	// has fewer than this many bytes of free space.
+	MinFreeSpace uint64 `yaml:"min-free-space"`
+
+	// If greater than zero, the primary rejects writes that would grow a
+	// database beyond this many bytes. Limits for individual databases, by
+	// name, may be set in the overrides where zero means unlimited.
+	MaxDatabaseBytes          int64            `yaml:"max-database-bytes"`
+	MaxDatabaseBytesOverrides map[string]int64 `yaml:"max-database-bytes-overrides"`
+
+	// Maps database names to the node ID that must be primary to write them.
+	// Pinned databases are read-only on all other nodes instead of failing over.
+	PinnedPrimaryNodeIDs map[string]string `yaml:"pinned-primary-node-ids"`
+
+	// Transaction ID after which a warning is logged that a database is
+	// approaching the maximum transaction ID.
+	TXIDWarnThreshold uint64 `yaml:"txid-warn-threshold"`
+
+	// If greater than zero, a replica unmounts its file system after it has
+	// been unable to reach a primary for this long. Disabled by default.
+	MaxNoPrimaryDuration time.Duration `yaml:"max-no-primary-duration"`
+
+	// If greater than zero, a newly-started replica rejects reads until it
+	// has caught up to within ReadMaxLag transactions of the primary or until
+	// this period has elapsed.
+	ReadGracePeriod time.Duration `yaml:"read-grace-period"`
+	ReadMaxLag      uint64        `yaml:"read-max-lag"`
+
+	// If true, the node is a hot standby. It replicates & can be promoted
+	// but rejects reads & reports as not ready while it is a replica.
+	StandbyOnly bool `yaml:"standby-only"`
+
+	// If greater than zero, startup fails if recovering the databases in the
+	// data directory takes longer than this duration. Unlimited by default.
+	MaxStartupRecoveryDuration time.Duration `yaml:"max-startup-recovery-duration"`
+
+	// Determines how often a replica fsyncs transactions applied from the
+	// primary. One of "always", "interval", or "os".
+	ReplicaFsyncPolicy   litefs.FsyncPolicy `yaml:"replica-fsync-policy"`
+	ReplicaFsyncInterval time.Duration      `yaml:"replica-fsync-interval"`
+
+	// If true, the primary fsyncs each transaction before streaming it.
+	DurableBeforeReplicate bool `yaml:"durable-before-replicate"`
+
+	// If greater than zero, the primary fsyncs the database file in the
+	// background within this window. Commits are replayed from their LTX
+	// files after a host crash.
+	WriteBufferWindow  time.Duration `yaml:"write-buffer-window"`
+	WriteBufferMaxSize int           `yaml:"write-buffer-max-size"`
+
+	// If greater than zero, the primary combines commits made within this
+	// window into a single LTX file before they are streamed to replicas.
+	GroupCommitWindow  time.Duration `yaml:"group-commit-window"`
+	GroupCommitMaxSize int           `yaml:"group-commit-max-size"`
+
+	// Compression applied to LTX files stored in the data directory. One of
+	// "none" or "gzip". Does not affect the replication wire format.
+	SegmentCompression litefs.SegmentCompression `yaml:"segment-compression"`
+
+	// If true, startup fails if any database is missing an LTX file between
+	// its earliest file & its current position.
+	StrictSegmentContinuity bool `yaml:"strict-segment-continuity"`
+
+	// Determines how a replica recovers after failing to apply a transaction
+	// from the primary. One of "retry" or "resync".
+	ApplyErrorPolicy litefs.ApplyErrorPolicy `yaml:"apply-error-policy"`
+
+	// Number of databases a new replica applies concurrently until its
+	// initial sync with the primary completes.
+	WarmupConcurrency int `yaml:"warmup-concurrency"`
+
+	// If greater than zero, each database's checksum is recomputed from disk
+	// on this interval to detect drift. Disabled by default.
+	IntegrityCheckInterval time.Duration `yaml:"integrity-check-interval"`
+
+	// Query for each database, by name, that returns its schema version as a
+	// single integer, such as "PRAGMA user_version". Reported by the API.
+	SchemaVersionQueries map[string]string `yaml:"schema-version-queries"`
+
+	// Databases created at startup from local copies, instead of streaming
+	// their full history, if they do not yet exist on this node.
+	Seeds []SeedConfig `yaml:"seeds"`
 
 	HTTP struct {
-		Addr string `yaml:"addr"`
+		Addr              string        `yaml:"addr"`
+		Addrs             []string      `yaml:"addrs"`
+		Transport         string        `yaml:"transport"`
+		BasePath          string        `yaml:"base-path"`
+		AuthToken         string        `yaml:"auth-token"`
+		SourceURL         string        `yaml:"source-url"`
+		HeartbeatInterval time.Duration `yaml:"heartbeat-interval"`
+		StreamReadTimeout time.Duration `yaml:"stream-read-timeout"`
+		StreamIdleTimeout time.Duration `yaml:"stream-idle-timeout"`
+
+		DedupSnapshots bool `yaml:"dedup-snapshots"`
+
+		MaxStreamBytesPerSec   int64 `yaml:"max-stream-bytes-per-sec"`
+		MaxFrameSize           int64 `yaml:"max-frame-size"`
+		StreamCompression      bool  `yaml:"stream-compression"`
+		AccessLog              bool  `yaml:"access-log"`
+		RejectDuplicateNodeID  bool  `yaml:"reject-duplicate-node-id"`
+		MinFreeFileDescriptors int   `yaml:"min-free-file-descriptors"`
+
+		ReplicaDrainTimeout time.Duration `yaml:"replica-drain-timeout"`
+
+		DialTimeout         time.Duration `yaml:"dial-timeout"`
+		KeepAlive           time.Duration `yaml:"keep-alive"`
+		IdleConnTimeout     time.Duration `yaml:"idle-conn-timeout"`
+		MaxIdleConnsPerHost int           `yaml:"max-idle-conns-per-host"`
 	} `yaml:"http"`
 
 	Consul struct {
 		URL          string        `yaml:"url"`
 		AdvertiseURL string        `yaml:"advertise-url"`
 		Key          string        `yaml:"key"`
+		KeyPrefix    string        `yaml:"key-prefix"`
+		Cluster      string        `yaml:"cluster"`
 		TTL          time.Duration `yaml:"ttl"`
 		LockDelay    time.Duration `yaml:"lock-delay"`
 	} `yaml:"consul"`
+
+	// If coordinated, candidates compare positions when there is no primary
+	// & only the most up-to-date candidate acquires the lease.
+	Election struct {
+		Coordinated bool          `yaml:"coordinated"`
+		Peers       []string      `yaml:"peers"`
+		Timeout     time.Duration `yaml:"timeout"`
+	} `yaml:"election"`
+}
+
+// SeedConfig represents a database to seed from a local copy. The copy must
+// match the primary's database after the transaction with the given TXID.
+type SeedConfig struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+	TXID string `yaml:"txid"`
+}
+
+// Election priority settings. Each level below MaxPriority delays lease
+// acquisition by PriorityDelay.
+const (
+	MaxPriority   = 10
+	PriorityDelay = 200 * time.Millisecond
+)
+
+// PreferredZoneDelay is the additional delay before a node outside of the
+// preferred zone acquires the lease. It exceeds the delay between the highest
+// & lowest priority so any node in the preferred zone acquires first.
+const PreferredZoneDelay = (MaxPriority + 1) * PriorityDelay
+
+// BehindPrimaryDelay is the additional delay before a node acquires the lease
+// if any of its databases is behind the primary's last known position. It
+// exceeds every combination of priority & zone delays so that an up-to-date
+// node acquires first.
+const BehindPrimaryDelay = MaxPriority*PriorityDelay + PreferredZoneDelay + PriorityDelay
+
+// Default mount retry settings.
+const (
+	DefaultMountRetries    = 3
+	DefaultMountRetryDelay = 1 * time.Second
+)
+
+// DefaultMountCheckInterval is the default interval between mount checks.
+const DefaultMountCheckInterval = 5 * time.Second
+
+// MountLostPolicy determines how the node responds when its mount or data
+// directory disappears, such as when an ephemeral volume is removed.
+type MountLostPolicy string
+
+const (
+	// Log an alert & continue running. File system calls fail until the
+	// mount is restored by an operator.
+	MountLostPolicyAlert = MountLostPolicy("alert")
+
+	// Exit with a non-zero status so a supervisor can restart the node.
+	MountLostPolicyExit = MountLostPolicy("exit")
+
+	// Recreate the mount directory & mount again. Exits if the data directory
+	// has also been lost.
+	MountLostPolicyRemount = MountLostPolicy("remount")
+)
+
+// IsValid returns true if p is a valid mount lost policy.
+func (p MountLostPolicy) IsValid() bool {
+	switch p {
+	case MountLostPolicyAlert, MountLostPolicyExit, MountLostPolicyRemount:
+		return true
+	default:
+		return false
+	}
 }
 
+// Default settings for connecting to the leaser at startup.
+const (
+	DefaultLeaserConnectTimeout    = 30 * time.Second
+	DefaultLeaserConnectRetryDelay = 1 * time.Second
+)
+
+// Default settings for waiting to become primary at startup.
+const (
+	DefaultWaitForPrimaryTimeout = 30 * time.Second
+	WaitForPrimaryInterval       = 10 * time.Millisecond
+)
+
+// SeedPosTimeout is the time to wait for the primary to report the position
+// of a seed database at startup.
+const SeedPosTimeout = 10 * time.Second
+
+// DefaultShutdownTimeout is the default time to wait for in-flight
+// transactions to be flushed & replicated on shutdown.
+const DefaultShutdownTimeout = 10 * time.Second
+
 // NewConfig returns a new instance of Config with defaults set.
 func NewConfig() Config {
 	var config Config
+	config.Priority = MaxPriority
+	config.MountRetries = DefaultMountRetries
+	config.MountRetryDelay = DefaultMountRetryDelay
+	config.MountCheckInterval = DefaultMountCheckInterval
+	config.MountLostPolicy = MountLostPolicyAlert
+	config.LocalTempFiles = true
+	config.LeaserConnectTimeout = DefaultLeaserConnectTimeout
+	config.WaitForPrimaryTimeout = DefaultWaitForPrimaryTimeout
+	config.LeaserConnectRetryDelay = DefaultLeaserConnectRetryDelay
+	config.ShutdownTimeout = DefaultShutdownTimeout
+	config.ReplicaFsyncPolicy = litefs.FsyncPolicyAlways
+	config.ReplicaFsyncInterval = litefs.DefaultReplicaFsyncInterval
+	config.WriteBufferMaxSize = litefs.DefaultWriteBufferMaxSize
+	config.SegmentCompression = litefs.SegmentCompressionNone
+	config.ApplyErrorPolicy = litefs.ApplyErrorPolicyRetry
+	config.WarmupConcurrency = 1
+	config.TXIDWarnThreshold = litefs.DefaultTXIDWarnThreshold
+	config.GroupCommitMaxSize = litefs.DefaultGroupCommitMaxSize
 	config.HTTP.Addr = http.DefaultAddr
+	config.HTTP.Transport = http.TransportHTTP
+	config.HTTP.HeartbeatInterval = http.DefaultHeartbeatInterval
+	config.HTTP.StreamReadTimeout = http.DefaultStreamReadTimeout
+	config.HTTP.StreamIdleTimeout = http.DefaultStreamIdleTimeout
+	config.HTTP.DialTimeout = http.DefaultDialTimeout
+	config.HTTP.KeepAlive = http.DefaultKeepAlive
+	config.HTTP.IdleConnTimeout = http.DefaultIdleConnTimeout
+	config.HTTP.MaxIdleConnsPerHost = http.DefaultMaxIdleConnsPerHost
 	config.Consul.Key = consul.DefaultKey
 	config.Consul.TTL = consul.DefaultTTL
 	config.Consul.LockDelay = consul.DefaultLockDelay
+	config.Election.Timeout = litefs.DefaultElectionTimeout
 	return config
 }
 
+// InPreferredZone returns true if a preferred zone is set & the node is in it.
+func (c *Config) InPreferredZone() bool {
+	return c.PreferredZone != "" && c.Zone == c.PreferredZone
+}
+
+// Validate returns an error if the config is missing required settings or
+// contains invalid values.
+func (c *Config) Validate() error {
+	if c.MountDir == "" {
+		return fmt.Errorf("mount path required")
+	}
+
+	if !c.Standalone {
+		if c.Consul.URL == "" {
+			return fmt.Errorf("consul URL required")
+		} else if c.Consul.Key == "" {
+			return fmt.Errorf("consul key required")
+		} else if strings.HasSuffix(c.Consul.Key, "/") {
+			return fmt.Errorf("consul key cannot end with a slash")
+		} else if v := c.Consul.Cluster; strings.Contains(v, "/") || v == "." || v == ".." {
+			return fmt.Errorf("invalid consul cluster name: %q", v)
+		}
+	}
+
+	if n := c.PageSize; n != 0 && (n < 512 || n > 65536 || n&(n-1) != 0) {
+		return fmt.Errorf("page size must be a power of two between 512 and 65536")
+	}
+
+	switch c.HTTP.Transport {
+	case http.TransportHTTP, http.TransportWebSocket:
+	default:
+		return fmt.Errorf("invalid http transport: %q", c.HTTP.Transport)
+	}
+
+	if c.MountRetries < 0 {
+		return fmt.Errorf("mount retries cannot be negative")
+	} else if c.MountRetryDelay < 0 {
+		return fmt.Errorf("mount retry delay cannot be negative")
+	} else if c.MountCheckInterval < 0 {
+		return fmt.Errorf("mount check interval cannot be negative")
+	} else if !c.MountLostPolicy.IsValid() {
+		return fmt.Errorf("invalid mount lost policy: %q", c.MountLostPolicy)
+	} else if c.MaxOpenHandles < 0 {
+		return fmt.Errorf("max open handles cannot be negative")
+	} else if c.BusyTimeout < 0 {
+		return fmt.Errorf("busy timeout cannot be negative")
+	}
+
+	if c.LeaserConnectTimeout < 0 {
+		return fmt.Errorf("leaser connect timeout cannot be negative")
+	} else if c.LeaserConnectRetryDelay <= 0 {
+		return fmt.Errorf("leaser connect retry delay must be positive")
+	}
+
+	if c.WaitForPrimaryTimeout < 0 {
+		return fmt.Errorf("wait for primary timeout cannot be negative")
+	}
+
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown timeout cannot be negative")
+	}
+
+	if c.DebugReplicationDelay < 0 {
+		return fmt.Errorf("debug replication delay cannot be negative")
+	} else if c.DebugApplyDelay < 0 {
+		return fmt.Errorf("debug apply delay cannot be negative")
+	}
+
+	if c.Priority < 0 || c.Priority > MaxPriority {
+		return fmt.Errorf("priority must be between 0 and %d", MaxPriority)
+	}
+
+	if c.TXIDWarnThreshold == 0 {
+		return fmt.Errorf("txid warn threshold must be positive")
+	}
+
+	if c.MaxNoPrimaryDuration < 0 {
+		return fmt.Errorf("max no primary duration cannot be negative")
+	} else if c.ReadGracePeriod < 0 {
+		return fmt.Errorf("read grace period cannot be negative")
+	} else if c.MaxStartupRecoveryDuration < 0 {
+		return fmt.Errorf("max startup recovery duration cannot be negative")
+	} else if c.IntegrityCheckInterval < 0 {
+		return fmt.Errorf("integrity check interval cannot be negative")
+	}
+
+	if !c.ReplicaFsyncPolicy.IsValid() {
+		return fmt.Errorf("invalid replica fsync policy: %q", c.ReplicaFsyncPolicy)
+	} else if c.ReplicaFsyncPolicy == litefs.FsyncPolicyInterval && c.ReplicaFsyncInterval <= 0 {
+		return fmt.Errorf("replica fsync interval must be positive")
+	}
+
+	if c.WriteBufferWindow < 0 {
+		return fmt.Errorf("write buffer window cannot be negative")
+	} else if c.WriteBufferMaxSize < 0 {
+		return fmt.Errorf("write buffer max size cannot be negative")
+	} else if c.WriteBufferWindow > 0 && c.DurableBeforeReplicate {
+		return fmt.Errorf("write buffer window cannot be used with durable-before-replicate")
+	}
+
+	if c.GroupCommitWindow < 0 {
+		return fmt.Errorf("group commit window cannot be negative")
+	} else if c.GroupCommitMaxSize < 0 {
+		return fmt.Errorf("group commit max size cannot be negative")
+	}
+
+	if len(c.Seeds) > 0 && c.Standalone {
+		return fmt.Errorf("seeds cannot be used with standalone")
+	}
+	for _, seed := range c.Seeds {
+		if seed.Name == "" {
+			return fmt.Errorf("seed name required")
+		} else if seed.Path == "" {
+			return fmt.Errorf("seed path required: %q", seed.Name)
+		} else if txID, err := litefs.ParseTXID(seed.TXID); err != nil || txID == 0 {
+			return fmt.Errorf("invalid seed txid: %q", seed.Name)
+		}
+	}
+
+	if !c.SegmentCompression.IsValid() {
+		return fmt.Errorf("invalid segment compression: %q", c.SegmentCompression)
+	} else if !c.ApplyErrorPolicy.IsValid() {
+		return fmt.Errorf("invalid apply error policy: %q", c.ApplyErrorPolicy)
+	}
+
+	if c.WarmupConcurrency < 1 {
+		return fmt.Errorf("warmup concurrency must be at least 1")
+	} else if c.WarmupConcurrency > litefs.MaxWarmupConcurrency {
+		return fmt.Errorf("warmup concurrency cannot exceed %d", litefs.MaxWarmupConcurrency)
+	}
+
+	if c.MaxDatabaseBytes < 0 {
+		return fmt.Errorf("max database bytes cannot be negative")
+	}
+	for name, n := range c.MaxDatabaseBytesOverrides {
+		if name == "" {
+			return fmt.Errorf("max database bytes override requires a database name")
+		} else if n < 0 {
+			return fmt.Errorf("max database bytes for %q cannot be negative", name)
+		}
+	}
+
+	for name, id := range c.PinnedPrimaryNodeIDs {
+		if name == "" {
+			return fmt.Errorf("pinned primary requires a database name")
+		} else if id == "" {
+			return fmt.Errorf("pinned primary for %q requires a node id", name)
+		}
+	}
+
+	for name, query := range c.SchemaVersionQueries {
+		if name == "" {
+			return fmt.Errorf("schema version query requires a database name")
+		} else if strings.TrimSpace(query) == "" {
+			return fmt.Errorf("schema version query for %q cannot be blank", name)
+		}
+	}
+
+	for _, addr := range c.HTTP.Addrs {
+		if addr == "" {
+			return fmt.Errorf("http addrs cannot contain an empty address")
+		}
+	}
+
+	if c.HTTP.BasePath != "" && !strings.HasPrefix(c.HTTP.BasePath, "/") {
+		return fmt.Errorf("http base path must begin with a slash")
+	}
+
+	if c.HTTP.HeartbeatInterval < 0 {
+		return fmt.Errorf("http heartbeat interval cannot be negative")
+	} else if c.HTTP.StreamReadTimeout < 0 {
+		return fmt.Errorf("http stream read timeout cannot be negative")
+	} else if c.HTTP.StreamIdleTimeout < 0 {
+		return fmt.Errorf("http stream idle timeout cannot be negative")
+	} else if c.HTTP.MaxStreamBytesPerSec < 0 {
+		return fmt.Errorf("http max stream bytes per sec cannot be negative")
+	} else if c.HTTP.MaxFrameSize < 0 {
+		return fmt.Errorf("http max frame size cannot be negative")
+	} else if c.HTTP.MinFreeFileDescriptors < 0 {
+		return fmt.Errorf("http min free file descriptors cannot be negative")
+	} else if c.HTTP.ReplicaDrainTimeout < 0 {
+		return fmt.Errorf("http replica drain timeout cannot be negative")
+	}
+
+	if c.HTTP.DialTimeout < 0 {
+		return fmt.Errorf("http dial timeout cannot be negative")
+	} else if c.HTTP.IdleConnTimeout < 0 {
+		return fmt.Errorf("http idle connection timeout cannot be negative")
+	} else if c.HTTP.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("http max idle connections per host cannot be negative")
+	}
+
+	if c.Election.Timeout < 0 {
+		return fmt.Errorf("election timeout cannot be negative")
+	}
+	for _, peer := range c.Election.Peers {
+		if peer == "" {
+			return fmt.Errorf("election peer url cannot be blank")
+		}
+	}
+	return nil
+}
+
+// ExpandNodeID expands environment variables in the node ID template s.
+// HOSTNAME falls back to the system hostname as shells do not export it.
+func ExpandNodeID(s string) string {
+	return strings.TrimSpace(os.Expand(s, func(key string) string {
+		if v, ok := os.LookupEnv(key); ok {
+			return v
+		} else if key == "HOSTNAME" {
+			hostname, _ := os.Hostname()
+			return hostname
+		}
+		return ""
+	}))
+}
+
+// isCommonNodeID returns true if id is a default value shared by many hosts.
+func isCommonNodeID(id string) bool {
+	switch strings.ToLower(id) {
+	case "localhost", "localhost.localdomain", "127.0.0.1", "::1", "(none)":
+		return true
+	default:
+		return false
+	}
+}
+
 // ReadConfigFile unmarshals config from filename. If expandEnv is true then
 // environment variables are expanded in the config.
 func ReadConfigFile(config *Config, filename string, expandEnv bool) error {
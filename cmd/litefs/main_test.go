@@ -2,19 +2,30 @@
 package main_test
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
+	gohttp "net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/mattn/go-sqlite3"
+	"github.com/superfly/litefs"
 	main "github.com/superfly/litefs/cmd/litefs"
+	"github.com/superfly/litefs/fuse"
+	"github.com/superfly/litefs/http"
 	"github.com/superfly/litefs/internal/testingutil"
+	"github.com/superfly/ltx"
 	"gopkg.in/yaml.v3"
 )
 
@@ -45,103 +56,976 @@ func TestSingleNode(t *testing.T) {
 	}
 }
 
+// Ensure a standalone node is primary immediately without a leaser.
+func TestSingleNode_Standalone(t *testing.T) {
+	m0 := newMain(t, t.TempDir(), nil)
+	m0.Config.Standalone = true
+	m0.Config.Consul.URL, m0.Config.Consul.Key = "", ""
+	if err := m0.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m0.Close() })
+
+	if m0.Leaser != nil {
+		t.Fatal("expected no leaser")
+	} else if !m0.Store.IsPrimary() {
+		t.Fatal("expected primary")
+	}
+
+	db := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+
+	var x int
+	if err := db.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+		t.Fatal(err)
+	} else if got, want := x, 100; got != want {
+		t.Fatalf("x=%d, want %d", got, want)
+	}
+}
+
+// Ensure the startup self-check passes on a healthy host & does not leave
+// the scratch database in the real mount.
+func TestSingleNode_SelfCheck(t *testing.T) {
+	if err := main.SelfCheck(context.Background(), *debug); err != nil {
+		t.Fatal(err)
+	}
+
+	m0 := newMain(t, t.TempDir(), nil)
+	m0.Config.Standalone = true
+	m0.Config.SelfCheck = true
+	m0.Config.Consul.URL, m0.Config.Consul.Key = "", ""
+	if err := m0.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m0.Close() })
+
+	if db := m0.Store.DBByName(main.SelfCheckDBName); db != nil {
+		t.Fatal("expected self-check database to be isolated from store")
+	}
+}
+
+// Ensure a transient mount failure at startup is retried.
+func TestSingleNode_MountRetry(t *testing.T) {
+	m0 := newMain(t, t.TempDir(), nil)
+	m0.Config.Standalone = true
+	m0.Config.Consul.URL, m0.Config.Consul.Key = "", ""
+	m0.Config.MountRetryDelay = 10 * time.Millisecond
+
+	var attempts int
+	m0.MountFn = func(fsys *fuse.FileSystem) error {
+		if attempts++; attempts == 1 {
+			return fmt.Errorf("fusermount: device or resource busy")
+		}
+		return fsys.Mount()
+	}
+
+	if err := m0.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m0.Close() })
+
+	if got, want := attempts, 2; got != want {
+		t.Fatalf("attempts=%d, want %d", got, want)
+	} else if _, err := os.Stat(filepath.Join(m0.Config.MountDir, ".is-primary")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the node responds according to its policy once the directory holding
+// the mount & data directory is removed out from under it.
+func TestSingleNode_MountLost(t *testing.T) {
+	// newNode returns a running standalone node mounted within its own parent
+	// directory, along with a database containing a single table.
+	newNode := func(t *testing.T, policy main.MountLostPolicy) (*main.Main, string) {
+		parent := t.TempDir()
+		mountDir := filepath.Join(parent, "mnt")
+		if err := os.Mkdir(mountDir, 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		m0 := newMain(t, mountDir, nil)
+		m0.Config.Standalone = true
+		m0.Config.Consul.URL, m0.Config.Consul.Key = "", ""
+		m0.Config.MountCheckInterval = 10 * time.Millisecond
+		m0.Config.MountLostPolicy = policy
+		if err := m0.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = m0.Close() })
+
+		db := testingutil.OpenSQLDB(t, filepath.Join(mountDir, "db"))
+		if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+			t.Fatal(err)
+		} else if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return m0, parent
+	}
+
+	t.Run("Exit", func(t *testing.T) {
+		m0, parent := newNode(t, main.MountLostPolicyExit)
+
+		// Tear down the mount & remove its parent, along with the data directory.
+		if err := m0.FileSystem.Unmount(); err != nil {
+			t.Fatal(err)
+		} else if err := os.RemoveAll(parent); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-m0.Fatal():
+			if err == nil || !strings.Contains(err.Error(), "mount lost") {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected node to exit")
+		}
+	})
+
+	t.Run("Remount", func(t *testing.T) {
+		m0, _ := newNode(t, main.MountLostPolicyRemount)
+
+		// Tear down the mount & remove the mount directory only.
+		if err := m0.FileSystem.Unmount(); err != nil {
+			t.Fatal(err)
+		} else if err := os.Remove(m0.Config.MountDir); err != nil {
+			t.Fatal(err)
+		}
+
+		// The database is served again once the node has remounted.
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			_, err := os.Stat(filepath.Join(m0.Config.MountDir, "db"))
+			return err
+		})
+		select {
+		case err := <-m0.Fatal():
+			t.Fatalf("unexpected exit: %s", err)
+		default:
+		}
+
+		db := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+		if _, err := db.Exec(`INSERT INTO t VALUES (1)`); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Alert", func(t *testing.T) {
+		m0, parent := newNode(t, main.MountLostPolicyAlert)
+		if err := m0.FileSystem.Unmount(); err != nil {
+			t.Fatal(err)
+		} else if err := os.RemoveAll(parent); err != nil {
+			t.Fatal(err)
+		}
+
+		// The node keeps running without exiting.
+		select {
+		case err := <-m0.Fatal():
+			t.Fatalf("unexpected exit: %s", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+// Ensure an invalid mount point fails without retrying.
+func TestSingleNode_MountRetry_Permanent(t *testing.T) {
+	dir := t.TempDir()
+	mountDir := filepath.Join(dir, "mnt")
+	if err := os.WriteFile(mountDir, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m0 := newMain(t, mountDir, nil)
+	m0.Config.Standalone = true
+	m0.Config.Consul.URL, m0.Config.Consul.Key = "", ""
+
+	var attempts int
+	m0.MountFn = func(fsys *fuse.FileSystem) error {
+		attempts++
+		return fsys.Mount()
+	}
+
+	if err := m0.Run(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	t.Cleanup(func() { _ = m0.Close() })
+
+	if attempts != 0 {
+		t.Fatalf("attempts=%d, want 0", attempts)
+	}
+}
+
+// Ensure startup waits for a leaser that becomes reachable after a delay.
+func TestSingleNode_LeaserConnectRetry(t *testing.T) {
+	m0 := newMain(t, t.TempDir(), nil)
+	m0.Config.LeaserConnectRetryDelay = 10 * time.Millisecond
+	m0.Leaser = &delayedLeaser{
+		Leaser:  testingutil.NewLeaser().Node("http://localhost:20202"),
+		readyAt: time.Now().Add(500 * time.Millisecond),
+	}
+
+	start := time.Now()
+	if err := m0.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m0.Close() })
+
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("expected startup to wait for leaser, elapsed=%s", elapsed)
+	}
+	waitForPrimary(t, m0)
+}
+
+func TestWaitForLeaser(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		leaser := &delayedLeaser{
+			Leaser:  testingutil.NewLeaser().Node("http://localhost:20202"),
+			readyAt: time.Now().Add(100 * time.Millisecond),
+		}
+		if err := main.WaitForLeaser(context.Background(), leaser, 5*time.Second, 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		} else if leaser.attempts < 2 {
+			t.Fatalf("attempts=%d, expected retries", leaser.attempts)
+		}
+	})
+
+	// Ensure startup fails with a clear error once the window has elapsed.
+	t.Run("Timeout", func(t *testing.T) {
+		leaser := &delayedLeaser{
+			Leaser:  testingutil.NewLeaser().Node("http://localhost:20202"),
+			readyAt: time.Now().Add(time.Hour),
+		}
+		err := main.WaitForLeaser(context.Background(), leaser, 100*time.Millisecond, 10*time.Millisecond)
+		if err == nil || err.Error() != "cannot connect to leaser within 100ms: connection refused" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure the node ID is expanded from the environment & reported by the API.
+func TestSingleNode_NodeID(t *testing.T) {
+	t.Setenv("LITEFS_TEST_NODE_ID", "node-1")
+
+	m0 := newMain(t, t.TempDir(), nil)
+	m0.Config.Standalone = true
+	m0.Config.Consul.URL, m0.Config.Consul.Key = "", ""
+	m0.Config.NodeID = "${LITEFS_TEST_NODE_ID}"
+	if err := m0.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m0.Close() })
+
+	resp, err := gohttp.Get(m0.HTTPServer.URL() + "/instance/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var info http.InstanceInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	} else if got, want := info.ID, "node-1"; got != want {
+		t.Fatalf("ID=%q, want %q", got, want)
+	}
+}
+
+// Ensure node ID templates fall back to the system hostname.
+func TestExpandNodeID(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Env", func(t *testing.T) {
+		t.Setenv("LITEFS_TEST_POD", "pod-0")
+		if got, want := main.ExpandNodeID("${LITEFS_TEST_POD}.svc"), "pod-0.svc"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+	t.Run("Hostname", func(t *testing.T) {
+		t.Setenv("HOSTNAME", "") // restores the original value on cleanup
+		os.Unsetenv("HOSTNAME")
+		if got, want := main.ExpandNodeID("${HOSTNAME}"), hostname; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+	t.Run("Empty", func(t *testing.T) {
+		if got, want := main.ExpandNodeID("${LITEFS_TEST_UNSET}"), ""; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
+
+// Ensure startup fails with progress details if recovery exceeds the limit.
+func TestSingleNode_MaxStartupRecoveryDuration(t *testing.T) {
+	mountDir := t.TempDir()
+
+	// Populate the data directory before starting so there is work to recover.
+	dataDir := filepath.Join(filepath.Dir(mountDir), "."+filepath.Base(mountDir))
+	t.Cleanup(func() { _ = os.RemoveAll(dataDir) })
+
+	store := litefs.NewStore(dataDir)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.db", "b.db"} {
+		db, f, err := store.CreateDB(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, db, 1, 1, 1)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m0 := newMain(t, mountDir, nil)
+	m0.Config.Standalone = true
+	m0.Config.Consul.URL, m0.Config.Consul.Key = "", ""
+	m0.Config.MaxStartupRecoveryDuration = time.Nanosecond
+	t.Cleanup(func() { _ = m0.Close() })
+
+	if err := m0.Run(context.Background()); !errors.Is(err, litefs.ErrRecoveryTimeout) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !strings.Contains(err.Error(), "recovered 1 of 2 databases") {
+		t.Fatalf("expected progress in error: %s", err)
+	}
+}
+
 func TestMultiNode_Simple(t *testing.T) {
 	m0 := newRunningMain(t, t.TempDir(), nil)
 	waitForPrimary(t, m0)
 	m1 := newRunningMain(t, t.TempDir(), m0)
-	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
-	db1 := testingutil.OpenSQLDB(t, filepath.Join(m1.Config.MountDir, "db"))
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+	db1 := testingutil.OpenSQLDB(t, filepath.Join(m1.Config.MountDir, "db"))
+
+	// Create a simple table with a single value.
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+
+	var x int
+	if err := db0.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+		t.Fatal(err)
+	} else if got, want := x, 100; got != want {
+		t.Fatalf("x=%d, want %d", got, want)
+	}
+
+	// Ensure we can retrieve the data back from the database on the second node.
+	waitForSync(t, 1, m0, m1)
+	if err := db1.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+		t.Fatal(err)
+	} else if got, want := x, 100; got != want {
+		t.Fatalf("x=%d, want %d", got, want)
+	}
+
+	// Write another value.
+	if _, err := db0.Exec(`INSERT INTO t VALUES (200)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure it invalidates the page on the secondary.
+	waitForSync(t, 1, m0, m1)
+	if err := db1.QueryRow(`SELECT COUNT(*) FROM t`).Scan(&x); err != nil {
+		t.Fatal(err)
+	} else if got, want := x, 2; got != want {
+		t.Fatalf("count=%d, want %d", got, want)
+	}
+}
+
+// Ensure a replica can be seeded from a local copy of the primary's database.
+func TestMultiNode_Seed(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy the database file as if it were restored from a backup.
+	txID := m0.Store.DB(1).TXID()
+	buf, err := os.ReadFile(m0.Store.DB(1).DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "db")
+	if err := os.WriteFile(src, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db0.Exec(`INSERT INTO t VALUES (200)`); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		m1 := newMain(t, t.TempDir(), m0)
+		m1.Config.Seeds = []main.SeedConfig{{Name: "db", Path: src, TXID: fmt.Sprintf("%016x", txID+1)}}
+		if err := m1.Run(context.Background()); !errors.Is(err, litefs.ErrChecksumMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		t.Cleanup(func() { _ = m1.Close() })
+	})
+
+	m1 := newMain(t, t.TempDir(), m0)
+	m1.Config.Seeds = []main.SeedConfig{{Name: "db", Path: src, TXID: fmt.Sprintf("%016x", txID)}}
+	if err := m1.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := m1.Close(); err != nil {
+			log.Printf("cannot close main: %s", err)
+		}
+	})
+
+	// Ensure only the transactions after the seed are streamed.
+	waitForSync(t, 1, m0, m1)
+	if _, err := os.Stat(filepath.Join(m1.Store.DB(1).LTXDir(), ltx.FormatFilename(1, 1))); !os.IsNotExist(err) {
+		t.Fatalf("expected no initial LTX file, got %v", err)
+	}
+
+	var n int
+	db1 := testingutil.OpenSQLDB(t, filepath.Join(m1.Config.MountDir, "db"))
+	if err := db1.QueryRow(`SELECT COUNT(*) FROM t`).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, 2; got != want {
+		t.Fatalf("count=%d, want %d", got, want)
+	}
+}
+
+// Ensure a VACUUM that shrinks the database truncates the replica to match.
+func TestMultiNode_Vacuum(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+	m1 := newRunningMain(t, t.TempDir(), m0)
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+	db1 := testingutil.OpenSQLDB(t, filepath.Join(m1.Config.MountDir, "db"))
+
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`WITH RECURSIVE s(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM s WHERE i < 1000) INSERT INTO t (x) SELECT randomblob(1000) FROM s`); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, 1, m0, m1)
+
+	path0 := m0.Store.DBByName("db").DatabasePath()
+	path1 := m1.Store.DBByName("db").DatabasePath()
+	fi, err := os.Stat(path1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevSize := fi.Size()
+
+	// Delete all data & shrink the file.
+	if _, err := db0.Exec(`DELETE FROM t`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`VACUUM`); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, 1, m0, m1)
+
+	if fi, err := os.Stat(path1); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() >= prevSize {
+		t.Fatalf("expected replica file to shrink: size=%d, prev=%d", fi.Size(), prevSize)
+	}
+
+	// Ensure the replica is identical to the primary & is not corrupted.
+	if buf0, err := os.ReadFile(path0); err != nil {
+		t.Fatal(err)
+	} else if buf1, err := os.ReadFile(path1); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf0, buf1) {
+		t.Fatalf("replica database mismatch: len=%d, want %d", len(buf1), len(buf0))
+	}
+
+	var result string
+	if err := db1.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		t.Fatal(err)
+	} else if got, want := result, "ok"; got != want {
+		t.Fatalf("integrity_check=%q, want %q", got, want)
+	}
+
+	// Ensure writes after the truncation continue to replicate.
+	if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, 1, m0, m1)
+
+	var n int
+	if err := db1.QueryRow(`SELECT COUNT(*) FROM t`).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, 1; got != want {
+		t.Fatalf("count=%d, want %d", got, want)
+	}
+}
+
+// Ensure the schema version reported by replicas follows migrations applied
+// on the primary.
+func TestMultiNode_SchemaVersion(t *testing.T) {
+	newNode := func(peer *main.Main) *main.Main {
+		m := newMain(t, t.TempDir(), peer)
+		m.Config.SchemaVersionQueries = map[string]string{"db": "PRAGMA user_version"}
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = m.Close() })
+		return m
+	}
+
+	// Returns the schema version of "db" reported by "GET /dbs".
+	schemaVersion := func(m *main.Main) (int64, bool) {
+		resp, err := gohttp.Get(m.HTTPServer.URL() + "/dbs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var infos []http.DBInfo
+		if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range infos {
+			if info.Name == "db" && info.SchemaVersion != nil {
+				return *info.SchemaVersion, true
+			}
+		}
+		return 0, false
+	}
+
+	m0 := newNode(nil)
+	waitForPrimary(t, m0)
+	m1 := newNode(m0)
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, 1, m0, m1)
+	if v, ok := schemaVersion(m1); !ok || v != 0 {
+		t.Fatalf("version=%d, ok=%v; want 0", v, ok)
+	}
+
+	// Apply a migration on the primary & bump the version.
+	if _, err := db0.Exec(`ALTER TABLE t ADD COLUMN y`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`PRAGMA user_version = 2`); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, 1, m0, m1)
+
+	for _, m := range []*main.Main{m0, m1} {
+		if v, ok := schemaVersion(m); !ok || v != 2 {
+			t.Fatalf("version=%d, ok=%v; want 2", v, ok)
+		}
+	}
+}
+
+// Ensure draining a primary waits until a replica has become primary.
+func TestMultiNode_Drain(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+	m1 := newRunningMain(t, t.TempDir(), m0)
+	waitForReplica(t, m1)
+
+	cmd := main.NewDrainCommand()
+	cmd.URL = m0.HTTPServer.URL()
+	cmd.Interval = 10 * time.Millisecond
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m1.Store.IsPrimary() {
+		t.Fatal("expected replica to assume primary before drain returned")
+	} else if m0.Store.IsPrimary() {
+		t.Fatal("expected drained node to be demoted")
+	}
+}
+
+// Ensure draining fails if no other node can take over as primary.
+func TestSingleNode_Drain_Timeout(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+
+	cmd := main.NewDrainCommand()
+	cmd.URL = m0.HTTPServer.URL()
+	cmd.Timeout = 500 * time.Millisecond
+	cmd.Interval = 10 * time.Millisecond
+	if err := cmd.Run(context.Background()); err == nil {
+		t.Fatal("expected drain timeout")
+	}
+}
+
+// Ensure a database file restored to a past transaction is verified against
+// the primary's history for that transaction.
+func TestVerifyAgainstCommand(t *testing.T) {
+	store := litefs.NewStore(t.TempDir())
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	server := http.NewServer(store, "localhost:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+
+	// Back up the database at TXID 2 & continue writing to the primary.
+	data, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 2, 2, 3)
+	testingutil.MustWriteTx(t, db, 3, 3, 4)
+
+	// restore writes the backup, modified by fn, to a new file.
+	restore := func(fn func([]byte)) string {
+		buf := append([]byte(nil), data...)
+		if fn != nil {
+			fn(buf)
+		}
+		path := filepath.Join(t.TempDir(), "db")
+		if err := os.WriteFile(path, buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	verify := func(txID uint64, path string) error {
+		cmd := main.NewVerifyAgainstCommand()
+		if err := cmd.ParseFlags(context.Background(), []string{
+			"-primary", server.URL(),
+			"-db", "db",
+			"-txid", ltx.FormatTXID(txID),
+			path,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return cmd.Run(context.Background())
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		if err := verify(2, restore(nil)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("LaterTXID", func(t *testing.T) {
+		if err := verify(4, restore(nil)); err == nil {
+			t.Fatal("expected verification failure")
+		}
+	})
+
+	t.Run("CorruptPage", func(t *testing.T) {
+		path := restore(func(buf []byte) { buf[4096+100] ^= 0xFF })
+		if err := verify(2, path); err == nil {
+			t.Fatal("expected verification failure")
+		}
+	})
+}
+
+// Ensure a replica streaming over WebSockets syncs the same as over HTTP.
+func TestMultiNode_WebSocket(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+	m1 := newRunningMain(t, t.TempDir(), m0)
+
+	m2 := newMain(t, t.TempDir(), m0)
+	m2.Config.HTTP.Transport = "websocket"
+	if err := m2.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m2.Close() })
+
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+	db1 := testingutil.OpenSQLDB(t, filepath.Join(m1.Config.MountDir, "db"))
+	db2 := testingutil.OpenSQLDB(t, filepath.Join(m2.Config.MountDir, "db"))
+
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`INSERT INTO t VALUES (200)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure both replicas end at the same position with the same data.
+	waitForSync(t, 1, m0, m1, m2)
+	if got, want := m2.Store.DB(1).Pos(), m1.Store.DB(1).Pos(); got != want {
+		t.Fatalf("pos=%#v, want %#v", got, want)
+	}
+
+	var sum1, sum2 int
+	if err := db1.QueryRow(`SELECT SUM(x) FROM t`).Scan(&sum1); err != nil {
+		t.Fatal(err)
+	} else if err := db2.QueryRow(`SELECT SUM(x) FROM t`).Scan(&sum2); err != nil {
+		t.Fatal(err)
+	} else if sum1 != 300 || sum2 != 300 {
+		t.Fatalf("sum=(%d,%d), want (300,300)", sum1, sum2)
+	}
+}
+
+func TestMultiNode_ForcedReelection(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+	m1 := newRunningMain(t, t.TempDir(), m0)
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+	db1 := testingutil.OpenSQLDB(t, filepath.Join(m1.Config.MountDir, "db"))
+
+	// Create a simple table with a single value.
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for sync first.
+	waitForSync(t, 1, m0, m1)
+	var x int
+	if err := db1.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+		t.Fatal(err)
+	} else if got, want := x, 100; got != want {
+		t.Fatalf("x=%d, want %d", got, want)
+	}
+
+	// Stop the primary and wait for handoff to secondary.
+	t.Log("shutting down primary node")
+	if err := db0.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := m0.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second node should eventually become primary.
+	t.Log("waiting for promotion of replica")
+	waitForPrimary(t, m1)
+
+	// Update record on the new primary.
+	t.Log("updating record on new primary")
+	if _, err := db1.Exec(`UPDATE t SET x = 200`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen first node and ensure it propagates changes.
+	t.Log("restarting first node as replica")
+	m0 = newRunningMain(t, m0.Config.MountDir, m1)
+	waitForSync(t, 1, m0, m1)
+
+	db0 = testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+
+	t.Log("verifying propagation of record update")
+	if err := db0.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+		t.Fatal(err)
+	} else if got, want := x, 200; got != want {
+		t.Fatalf("x=%d, want %d", got, want)
+	}
+}
+
+// Ensure Run() blocks until the node becomes primary when enabled.
+func TestMultiNode_WaitForPrimary(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		m0 := newRunningMain(t, t.TempDir(), nil)
+		waitForPrimary(t, m0)
+
+		m1 := newMain(t, t.TempDir(), m0)
+		m1.Config.WaitForPrimary = true
+		t.Cleanup(func() { _ = m1.Close() })
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- m1.Run(context.Background()) }()
+
+		// Run() should not return while the other node holds the lease.
+		select {
+		case err := <-errCh:
+			t.Fatalf("Run() returned before primary: %v", err)
+		case <-time.After(1 * time.Second):
+		}
+
+		// Stop the primary so the waiting node can acquire the lease.
+		if err := m0.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			} else if !m1.Store.IsPrimary() {
+				t.Fatal("expected primary")
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("timeout waiting for Run()")
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		m0 := newRunningMain(t, t.TempDir(), nil)
+		waitForPrimary(t, m0)
+
+		m1 := newMain(t, t.TempDir(), m0)
+		m1.Config.WaitForPrimary = true
+		m1.Config.WaitForPrimaryTimeout = 100 * time.Millisecond
+		t.Cleanup(func() { _ = m1.Close() })
+
+		if err := m1.Run(context.Background()); err == nil || err.Error() != `node did not become primary within 100ms` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure a write committed immediately before shutdown is durable on the
+// primary & replicated before the lease is released.
+func TestMultiNode_CloseFlushesWrites(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+	m1 := newRunningMain(t, t.TempDir(), m0)
+	waitForReplica(t, m1)
 
-	// Create a simple table with a single value.
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
 	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
 		t.Fatal(err)
-	} else if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
-		t.Fatal(err)
 	}
+	waitForSync(t, 1, m0, m1)
 
-	var x int
-	if err := db0.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+	// Write & shut down without waiting for replication.
+	if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+	txID := m0.Store.DB(1).TXID()
+	if err := db0.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := m0.Close(); err != nil {
 		t.Fatal(err)
-	} else if got, want := x, 100; got != want {
-		t.Fatalf("x=%d, want %d", got, want)
 	}
 
-	// Ensure we can retrieve the data back from the database on the second node.
+	// The replica should have been sent the write before the lease was released.
+	testingutil.RetryUntil(t, 1*time.Millisecond, 1*time.Second, func() error {
+		if got := m1.Store.DB(1).TXID(); got != txID {
+			return fmt.Errorf("replica TXID=%d, want %d", got, txID)
+		}
+		return nil
+	})
+	waitForPrimary(t, m1)
+
+	// Restart the old primary & ensure the write survived on its own disk.
+	m0 = newRunningMain(t, m0.Config.MountDir, m1)
+	if got := m0.Store.DB(1).TXID(); got < txID {
+		t.Fatalf("TXID=%d after restart, want at least %d", got, txID)
+	}
 	waitForSync(t, 1, m0, m1)
-	if err := db1.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+
+	var x int
+	db0 = testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+	if err := db0.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
 		t.Fatal(err)
 	} else if got, want := x, 100; got != want {
 		t.Fatalf("x=%d, want %d", got, want)
 	}
+}
 
-	// Write another value.
-	if _, err := db0.Exec(`INSERT INTO t VALUES (200)`); err != nil {
-		t.Fatal(err)
-	}
+// Ensure the primary status file only exists on the node holding the lease.
+func TestMultiNode_IsPrimaryFile(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+	m1 := newRunningMain(t, t.TempDir(), m0)
+	waitForReplica(t, m1)
 
-	// Ensure it invalidates the page on the secondary.
-	waitForSync(t, 1, m0, m1)
-	if err := db1.QueryRow(`SELECT COUNT(*) FROM t`).Scan(&x); err != nil {
+	waitForIsPrimaryFile(t, m0, true)
+	waitForIsPrimaryFile(t, m1, false)
+
+	// Stop the primary and wait for the replica to take over.
+	if err := m0.Close(); err != nil {
 		t.Fatal(err)
-	} else if got, want := x, 2; got != want {
-		t.Fatalf("count=%d, want %d", got, want)
 	}
+	waitForPrimary(t, m1)
+	waitForIsPrimaryFile(t, m1, true)
+
+	// Restart first node as a replica & ensure it no longer has the file.
+	m0 = newRunningMain(t, m0.Config.MountDir, m1)
+	waitForReplica(t, m0)
+	waitForIsPrimaryFile(t, m0, false)
 }
 
-func TestMultiNode_ForcedReelection(t *testing.T) {
+// Ensure deleting a database on the primary removes it from the replica.
+func TestMultiNode_DropDB(t *testing.T) {
 	m0 := newRunningMain(t, t.TempDir(), nil)
 	waitForPrimary(t, m0)
 	m1 := newRunningMain(t, t.TempDir(), m0)
 	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
-	db1 := testingutil.OpenSQLDB(t, filepath.Join(m1.Config.MountDir, "db"))
 
-	// Create a simple table with a single value.
 	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
 		t.Fatal(err)
-	} else if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
-		t.Fatal(err)
 	}
-
-	// Wait for sync first.
 	waitForSync(t, 1, m0, m1)
-	var x int
-	if err := db1.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+	if err := db0.Close(); err != nil {
 		t.Fatal(err)
-	} else if got, want := x, 100; got != want {
-		t.Fatalf("x=%d, want %d", got, want)
 	}
 
-	// Stop the primary and wait for handoff to secondary.
-	t.Log("shutting down primary node")
-	if err := db0.Close(); err != nil {
-		t.Fatal(err)
-	} else if err := m0.Close(); err != nil {
+	if err := os.Remove(filepath.Join(m0.Config.MountDir, "db")); err != nil {
 		t.Fatal(err)
 	}
+	testingutil.RetryUntil(t, 1*time.Millisecond, 30*time.Second, func() error {
+		if _, err := os.Stat(filepath.Join(m1.Config.MountDir, "db")); !os.IsNotExist(err) {
+			return fmt.Errorf("database still exists on replica: %v", err)
+		}
+		return nil
+	})
+}
 
-	// Second node should eventually become primary.
-	t.Log("waiting for promotion of replica")
-	waitForPrimary(t, m1)
+// Ensure a replica unmounts once it cannot reach the primary for longer than
+// the max no primary duration.
+func TestMultiNode_MaxNoPrimaryDuration(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
 
-	// Update record on the new primary.
-	t.Log("updating record on new primary")
-	if _, err := db1.Exec(`UPDATE t SET x = 200`); err != nil {
+	m1 := newMain(t, t.TempDir(), m0)
+	m1.Config.MaxNoPrimaryDuration = 2 * time.Second
+	if err := m1.Run(context.Background()); err != nil {
 		t.Fatal(err)
 	}
+	t.Cleanup(func() {
+		if err := m1.Close(); err != nil {
+			log.Printf("cannot close main: %s", err)
+		}
+	})
 
-	// Reopen first node and ensure it propagates changes.
-	t.Log("restarting first node as replica")
-	m0 = newRunningMain(t, m0.Config.MountDir, m1)
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	}
 	waitForSync(t, 1, m0, m1)
 
-	db0 = testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
-
-	t.Log("verifying propagation of record update")
-	if err := db0.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+	// Cut off the primary's API while it retains the lease.
+	if err := m0.HTTPServer.Close(); err != nil {
 		t.Fatal(err)
-	} else if got, want := x, 200; got != want {
-		t.Fatalf("x=%d, want %d", got, want)
 	}
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 30*time.Second, func() error {
+		if _, err := os.Stat(filepath.Join(m1.Config.MountDir, "db")); !os.IsNotExist(err) {
+			return fmt.Errorf("replica still mounted: %v", err)
+		}
+		return nil
+	})
 }
 
 func TestMultiNode_EnsureReadOnlyReplica(t *testing.T) {
@@ -158,10 +1042,118 @@ func TestMultiNode_EnsureReadOnlyReplica(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Ensure we cannot write to the replica.
+	// Ensure we cannot write to the replica & that SQLite reports the database
+	// as read-only rather than an incidental file error.
+	waitForSync(t, 1, m0, m1)
+	var sqliteErr sqlite3.Error
+	if _, err := db1.Exec(`INSERT INTO t VALUES (200)`); !errors.As(err, &sqliteErr) {
+		t.Fatalf("unexpected error: %#v", err)
+	} else if got, want := sqliteErr.Code, sqlite3.ErrReadonly; got != want {
+		t.Fatalf("Code=%d, want %d (%s)", got, want, err)
+	}
+
+	// Ensure the underlying write is rejected with the replica error.
+	if _, err := m1.Store.DBByName("db").CreateJournal(); err != litefs.ErrReadOnlyReplica {
+		t.Fatalf("unexpected error: %v", err)
+	} else if got, want := err.Error(), `cannot write: node is a read-only replica`; got != want {
+		t.Fatalf("Error()=%q, want %q", got, want)
+	}
+}
+
+// Ensure temp tables can be created on a replica when SQLite's temp files are
+// placed in the mount & that they are not replicated.
+func TestMultiNode_ReplicaTempTable(t *testing.T) {
+	m0 := newRunningMain(t, t.TempDir(), nil)
+	waitForPrimary(t, m0)
+	m1 := newRunningMain(t, t.TempDir(), m0)
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.MountDir, "db"))
+	db1 := testingutil.OpenSQLDB(t, filepath.Join(m1.Config.MountDir, "db"))
+
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
 	waitForSync(t, 1, m0, m1)
-	if _, err := db1.Exec(`INSERT INTO t VALUES (200)`); err == nil || err.Error() != `unable to open database file: no such file or directory` {
-		t.Fatalf("unexpected error: %s", err)
+
+	// Place temp files in the replica's mount. The directory is process-wide.
+	conn, err := db1.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), `PRAGMA temp_store_directory = '`+m1.Config.MountDir+`'`); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = conn.ExecContext(context.Background(), `PRAGMA temp_store_directory = ''`) }()
+
+	// Write enough to the temp table that it spills to a file.
+	if _, err := conn.ExecContext(context.Background(), `PRAGMA temp_store = FILE`); err != nil {
+		t.Fatal(err)
+	} else if _, err := conn.ExecContext(context.Background(), `PRAGMA temp.cache_size = 10`); err != nil {
+		t.Fatal(err)
+	} else if _, err := conn.ExecContext(context.Background(), `CREATE TEMP TABLE tmp AS SELECT x FROM t`); err != nil {
+		t.Fatal(err)
+	} else if _, err := conn.ExecContext(context.Background(), `INSERT INTO tmp WITH RECURSIVE c(n) AS (SELECT 1 UNION ALL SELECT n+1 FROM c WHERE n < 10000) SELECT randomblob(100) FROM c`); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := conn.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM tmp`).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, 10001; got != want {
+		t.Fatalf("count=%d, want %d", got, want)
+	}
+
+	// Writes to the database itself must still fail on the replica.
+	var sqliteErr sqlite3.Error
+	if _, err := conn.ExecContext(context.Background(), `INSERT INTO t VALUES (200)`); !errors.As(err, &sqliteErr) {
+		t.Fatalf("unexpected error: %#v", err)
+	} else if got, want := sqliteErr.Code, sqlite3.ErrReadonly; got != want {
+		t.Fatalf("Code=%d, want %d (%s)", got, want, err)
+	}
+
+	// The temp files should not be created as databases on either node.
+	for _, m := range []*main.Main{m0, m1} {
+		if got, want := len(m.Store.DBs()), 1; got != want {
+			t.Fatalf("len(DBs)=%d, want %d", got, want)
+		}
+	}
+}
+
+// Ensure hot-reloadable settings are applied when the process receives SIGHUP.
+func TestMain_Reload(t *testing.T) {
+	m := newMain(t, t.TempDir(), nil)
+	m.ConfigPath = filepath.Join(t.TempDir(), "litefs.yml")
+	config := m.Config
+	writeConfigFile(t, m.ConfigPath, config)
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+
+	// Update the heartbeat interval & signal the process to reload.
+	config.HTTP.HeartbeatInterval = 1 * time.Second
+	writeConfigFile(t, m.ConfigPath, config)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if got, _ := m.HTTPServer.StreamTimeouts(); got != 1*time.Second {
+			return fmt.Errorf("heartbeat interval not reloaded: %s", got)
+		}
+		return nil
+	})
+
+	// Ensure an invalid config is rejected and the current config is kept.
+	config.HTTP.HeartbeatInterval = 2 * time.Second
+	config.HTTP.Transport = "invalid"
+	writeConfigFile(t, m.ConfigPath, config)
+	if err := m.Reload(context.Background()); err == nil {
+		t.Fatal("expected error")
+	} else if got, _ := m.HTTPServer.StreamTimeouts(); got != 1*time.Second {
+		t.Fatalf("HeartbeatInterval=%s, want %s", got, 1*time.Second)
 	}
 }
 
@@ -180,21 +1172,240 @@ func TestConfigExample(t *testing.T) {
 	if got, want := config.Debug, false; got != want {
 		t.Fatalf("Debug=%v, want %v", got, want)
 	}
+	if got, want := config.DebugReplicationDelay, time.Duration(0); got != want {
+		t.Fatalf("DebugReplicationDelay=%s, want %s", got, want)
+	}
+	if got, want := config.DebugApplyDelay, time.Duration(0); got != want {
+		t.Fatalf("DebugApplyDelay=%s, want %s", got, want)
+	}
+	if got, want := config.PageSize, uint32(0); got != want {
+		t.Fatalf("PageSize=%d, want %d", got, want)
+	}
+	if got, want := config.NodeID, ""; got != want {
+		t.Fatalf("NodeID=%q, want %q", got, want)
+	}
+	if got, want := config.SelfCheck, false; got != want {
+		t.Fatalf("SelfCheck=%v, want %v", got, want)
+	}
+	if got, want := config.MountRetries, 3; got != want {
+		t.Fatalf("MountRetries=%d, want %d", got, want)
+	}
+	if got, want := config.MountRetryDelay, 1*time.Second; got != want {
+		t.Fatalf("MountRetryDelay=%s, want %s", got, want)
+	}
+	if got, want := config.MountCheckInterval, 5*time.Second; got != want {
+		t.Fatalf("MountCheckInterval=%s, want %s", got, want)
+	}
+	if got, want := config.MountLostPolicy, main.MountLostPolicyAlert; got != want {
+		t.Fatalf("MountLostPolicy=%s, want %s", got, want)
+	}
+	if got, want := config.MaxOpenHandles, 0; got != want {
+		t.Fatalf("MaxOpenHandles=%d, want %d", got, want)
+	}
+	if got, want := config.InvalidateHandlesOnRoleChange, false; got != want {
+		t.Fatalf("InvalidateHandlesOnRoleChange=%v, want %v", got, want)
+	}
+	if got, want := config.ReadOnlyReplica, false; got != want {
+		t.Fatalf("ReadOnlyReplica=%v, want %v", got, want)
+	}
+	if got, want := config.BusyTimeout, time.Duration(0); got != want {
+		t.Fatalf("BusyTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.LocalTempFiles, true; got != want {
+		t.Fatalf("LocalTempFiles=%v, want %v", got, want)
+	}
+	if got, want := config.LeaserConnectTimeout, 30*time.Second; got != want {
+		t.Fatalf("LeaserConnectTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.LeaserConnectRetryDelay, 1*time.Second; got != want {
+		t.Fatalf("LeaserConnectRetryDelay=%s, want %s", got, want)
+	}
+	if got, want := config.WaitForPrimary, false; got != want {
+		t.Fatalf("WaitForPrimary=%v, want %v", got, want)
+	}
+	if got, want := config.WaitForPrimaryTimeout, 30*time.Second; got != want {
+		t.Fatalf("WaitForPrimaryTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.ShutdownTimeout, 10*time.Second; got != want {
+		t.Fatalf("ShutdownTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.IgnoreDrops, false; got != want {
+		t.Fatalf("IgnoreDrops=%v, want %v", got, want)
+	}
+	if got, want := config.Standalone, false; got != want {
+		t.Fatalf("Standalone=%v, want %v", got, want)
+	}
+	if got, want := config.Priority, 10; got != want {
+		t.Fatalf("Priority=%d, want %d", got, want)
+	}
+	if got, want := config.Zone, ""; got != want {
+		t.Fatalf("Zone=%q, want %q", got, want)
+	}
+	if got, want := config.PreferredZone, ""; got != want {
+		t.Fatalf("PreferredZone=%q, want %q", got, want)
+	}
+	if got, want := config.MinFreeSpace, uint64(0); got != want {
+		t.Fatalf("MinFreeSpace=%d, want %d", got, want)
+	}
+	if got, want := config.MaxDatabaseBytes, int64(0); got != want {
+		t.Fatalf("MaxDatabaseBytes=%d, want %d", got, want)
+	}
+	if got, want := len(config.MaxDatabaseBytesOverrides), 0; got != want {
+		t.Fatalf("len(MaxDatabaseBytesOverrides)=%d, want %d", got, want)
+	}
+	if got, want := len(config.PinnedPrimaryNodeIDs), 0; got != want {
+		t.Fatalf("len(PinnedPrimaryNodeIDs)=%d, want %d", got, want)
+	}
+	if got, want := config.TXIDWarnThreshold, uint64(litefs.DefaultTXIDWarnThreshold); got != want {
+		t.Fatalf("TXIDWarnThreshold=%x, want %x", got, want)
+	}
+	if got, want := config.MaxNoPrimaryDuration, time.Duration(0); got != want {
+		t.Fatalf("MaxNoPrimaryDuration=%s, want %s", got, want)
+	}
+	if got, want := config.ReadGracePeriod, time.Duration(0); got != want {
+		t.Fatalf("ReadGracePeriod=%s, want %s", got, want)
+	}
+	if got, want := config.ReadMaxLag, uint64(0); got != want {
+		t.Fatalf("ReadMaxLag=%d, want %d", got, want)
+	}
+	if got, want := config.StandbyOnly, false; got != want {
+		t.Fatalf("StandbyOnly=%v, want %v", got, want)
+	}
+	if got, want := config.MaxStartupRecoveryDuration, time.Duration(0); got != want {
+		t.Fatalf("MaxStartupRecoveryDuration=%s, want %s", got, want)
+	}
+	if got, want := config.ReplicaFsyncPolicy, litefs.FsyncPolicyAlways; got != want {
+		t.Fatalf("ReplicaFsyncPolicy=%s, want %s", got, want)
+	}
+	if got, want := config.ReplicaFsyncInterval, 1*time.Second; got != want {
+		t.Fatalf("ReplicaFsyncInterval=%s, want %s", got, want)
+	}
+	if got, want := config.SegmentCompression, litefs.SegmentCompressionNone; got != want {
+		t.Fatalf("SegmentCompression=%s, want %s", got, want)
+	}
+	if got, want := config.StrictSegmentContinuity, false; got != want {
+		t.Fatalf("StrictSegmentContinuity=%v, want %v", got, want)
+	}
+	if got, want := config.ApplyErrorPolicy, litefs.ApplyErrorPolicyRetry; got != want {
+		t.Fatalf("ApplyErrorPolicy=%s, want %s", got, want)
+	}
+	if got, want := config.WarmupConcurrency, 1; got != want {
+		t.Fatalf("WarmupConcurrency=%d, want %d", got, want)
+	}
+	if got, want := config.IntegrityCheckInterval, time.Duration(0); got != want {
+		t.Fatalf("IntegrityCheckInterval=%s, want %s", got, want)
+	}
+	if got, want := config.DurableBeforeReplicate, false; got != want {
+		t.Fatalf("DurableBeforeReplicate=%v, want %v", got, want)
+	}
+	if got, want := config.WriteBufferWindow, time.Duration(0); got != want {
+		t.Fatalf("WriteBufferWindow=%s, want %s", got, want)
+	}
+	if got, want := config.WriteBufferMaxSize, 1000; got != want {
+		t.Fatalf("WriteBufferMaxSize=%d, want %d", got, want)
+	}
+	if got, want := config.GroupCommitWindow, time.Duration(0); got != want {
+		t.Fatalf("GroupCommitWindow=%s, want %s", got, want)
+	}
+	if got, want := config.GroupCommitMaxSize, 100; got != want {
+		t.Fatalf("GroupCommitMaxSize=%d, want %d", got, want)
+	}
 	if got, want := config.HTTP.Addr, ":20202"; got != want {
 		t.Fatalf("HTTP.Addr=%s, want %s", got, want)
 	}
+	if got, want := len(config.HTTP.Addrs), 0; got != want {
+		t.Fatalf("len(HTTP.Addrs)=%d, want %d", got, want)
+	}
+	if got, want := config.HTTP.Transport, "http"; got != want {
+		t.Fatalf("HTTP.Transport=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.BasePath, ""; got != want {
+		t.Fatalf("HTTP.BasePath=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.AuthToken, ""; got != want {
+		t.Fatalf("HTTP.AuthToken=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.SourceURL, ""; got != want {
+		t.Fatalf("HTTP.SourceURL=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.HeartbeatInterval, 5*time.Second; got != want {
+		t.Fatalf("HTTP.HeartbeatInterval=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.StreamReadTimeout, 30*time.Second; got != want {
+		t.Fatalf("HTTP.StreamReadTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.StreamIdleTimeout, 30*time.Second; got != want {
+		t.Fatalf("HTTP.StreamIdleTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.DedupSnapshots, false; got != want {
+		t.Fatalf("HTTP.DedupSnapshots=%v, want %v", got, want)
+	}
+	if got, want := config.HTTP.MaxStreamBytesPerSec, int64(0); got != want {
+		t.Fatalf("HTTP.MaxStreamBytesPerSec=%d, want %d", got, want)
+	}
+	if got, want := config.HTTP.MaxFrameSize, int64(0); got != want {
+		t.Fatalf("HTTP.MaxFrameSize=%d, want %d", got, want)
+	}
+	if got, want := config.HTTP.StreamCompression, false; got != want {
+		t.Fatalf("HTTP.StreamCompression=%v, want %v", got, want)
+	}
+	if got, want := config.HTTP.AccessLog, false; got != want {
+		t.Fatalf("HTTP.AccessLog=%v, want %v", got, want)
+	}
+	if got, want := config.HTTP.RejectDuplicateNodeID, false; got != want {
+		t.Fatalf("HTTP.RejectDuplicateNodeID=%v, want %v", got, want)
+	}
+	if got, want := config.HTTP.MinFreeFileDescriptors, 0; got != want {
+		t.Fatalf("HTTP.MinFreeFileDescriptors=%d, want %d", got, want)
+	}
+	if got, want := config.HTTP.ReplicaDrainTimeout, time.Duration(0); got != want {
+		t.Fatalf("HTTP.ReplicaDrainTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.DialTimeout, 5*time.Second; got != want {
+		t.Fatalf("HTTP.DialTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.KeepAlive, 15*time.Second; got != want {
+		t.Fatalf("HTTP.KeepAlive=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.IdleConnTimeout, 90*time.Second; got != want {
+		t.Fatalf("HTTP.IdleConnTimeout=%s, want %s", got, want)
+	}
+	if got, want := config.HTTP.MaxIdleConnsPerHost, 2; got != want {
+		t.Fatalf("HTTP.MaxIdleConnsPerHost=%d, want %d", got, want)
+	}
 	if got, want := config.Consul.URL, "http://localhost:8500"; got != want {
 		t.Fatalf("Consul.URL=%s, want %s", got, want)
 	}
 	if got, want := config.Consul.Key, "litefs/primary"; got != want {
 		t.Fatalf("Consul.Key=%s, want %s", got, want)
 	}
+	if got, want := config.Consul.KeyPrefix, ""; got != want {
+		t.Fatalf("Consul.KeyPrefix=%s, want %s", got, want)
+	}
+	if got, want := config.Consul.Cluster, ""; got != want {
+		t.Fatalf("Consul.Cluster=%s, want %s", got, want)
+	}
 	if got, want := config.Consul.TTL, 10*time.Second; got != want {
 		t.Fatalf("Consul.TTL=%s, want %s", got, want)
 	}
 	if got, want := config.Consul.LockDelay, 5*time.Second; got != want {
 		t.Fatalf("Consul.LockDelay=%s, want %s", got, want)
 	}
+	if got, want := len(config.SchemaVersionQueries), 0; got != want {
+		t.Fatalf("len(SchemaVersionQueries)=%d, want %d", got, want)
+	}
+	if got, want := len(config.Seeds), 0; got != want {
+		t.Fatalf("len(Seeds)=%d, want %d", got, want)
+	}
+	if got, want := config.Election.Coordinated, false; got != want {
+		t.Fatalf("Election.Coordinated=%v, want %v", got, want)
+	}
+	if got, want := len(config.Election.Peers), 0; got != want {
+		t.Fatalf("len(Election.Peers)=%d, want %d", got, want)
+	}
+	if got, want := config.Election.Timeout, 10*time.Second; got != want {
+		t.Fatalf("Election.Timeout=%s, want %s", got, want)
+	}
 }
 
 func newMain(tb testing.TB, mountDir string, peer *main.Main) *main.Main {
@@ -246,6 +1457,40 @@ func newRunningMain(tb testing.TB, mountDir string, peer *main.Main) *main.Main
 	return m
 }
 
+// delayedLeaser is a leaser that cannot be reached until readyAt, such as a
+// Consul agent that starts after LiteFS.
+type delayedLeaser struct {
+	litefs.Leaser
+	readyAt  time.Time
+	attempts int
+}
+
+func (l *delayedLeaser) Acquire(ctx context.Context) (litefs.Lease, error) {
+	if time.Now().Before(l.readyAt) {
+		return nil, fmt.Errorf("connection refused")
+	}
+	return l.Leaser.Acquire(ctx)
+}
+
+func (l *delayedLeaser) PrimaryURL(ctx context.Context) (string, error) {
+	if l.attempts++; time.Now().Before(l.readyAt) {
+		return "", fmt.Errorf("connection refused")
+	}
+	return l.Leaser.PrimaryURL(ctx)
+}
+
+// writeConfigFile writes config to filename as YAML.
+func writeConfigFile(tb testing.TB, filename string, config main.Config) {
+	tb.Helper()
+
+	buf, err := yaml.Marshal(config)
+	if err != nil {
+		tb.Fatal(err)
+	} else if err := os.WriteFile(filename, buf, 0666); err != nil {
+		tb.Fatal(err)
+	}
+}
+
 // waitForPrimary waits for m to obtain the primary lease.
 func waitForPrimary(tb testing.TB, m *main.Main) {
 	tb.Helper()
@@ -258,6 +1503,31 @@ func waitForPrimary(tb testing.TB, m *main.Main) {
 	})
 }
 
+// waitForReplica waits for m to connect to a primary.
+func waitForReplica(tb testing.TB, m *main.Main) {
+	tb.Helper()
+	testingutil.RetryUntil(tb, 1*time.Millisecond, 30*time.Second, func() error {
+		if m.Store.PrimaryURL() == "" {
+			return fmt.Errorf("not connected to primary")
+		}
+		return nil
+	})
+}
+
+// waitForIsPrimaryFile waits until the primary status file exists, or not.
+func waitForIsPrimaryFile(tb testing.TB, m *main.Main, exists bool) {
+	tb.Helper()
+	testingutil.RetryUntil(tb, 1*time.Millisecond, 30*time.Second, func() error {
+		_, err := os.Stat(filepath.Join(m.Config.MountDir, fuse.IsPrimaryFilename))
+		if exists && err != nil {
+			return fmt.Errorf("expected primary status file: %w", err)
+		} else if !exists && !os.IsNotExist(err) {
+			return fmt.Errorf("expected no primary status file: %v", err)
+		}
+		return nil
+	})
+}
+
 // waitForSync waits for all processes to sync to the same TXID.
 func waitForSync(tb testing.TB, dbID uint32, mains ...*main.Main) {
 	tb.Helper()
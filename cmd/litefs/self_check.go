@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/fuse"
+	"github.com/superfly/ltx"
+)
+
+// SelfCheckDBName is the name of the scratch database used by SelfCheck.
+const SelfCheckDBName = "self-check.db"
+
+// SelfCheck mounts a scratch LiteFS file system in a temporary directory,
+// writes & reads a database through it with SQLite, and verifies that each
+// transaction produced a valid LTX file. It is isolated from the main store
+// so it does not affect replication. Returns a diagnostic error on failure.
+func SelfCheck(ctx context.Context, debug bool) (err error) {
+	dir, err := os.MkdirTemp("", "litefs-self-check-")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := litefs.NewStore(filepath.Join(dir, "data"))
+	store.Standalone = true
+	if err := store.Open(); err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer store.Close()
+
+	fsys := fuse.NewFileSystem(filepath.Join(dir, "mnt"), store)
+	fsys.Debug = debug
+	if err := os.MkdirAll(fsys.Path(), 0777); err != nil {
+		return fmt.Errorf("create mount dir: %w", err)
+	} else if err := fsys.Mount(); err != nil {
+		return fmt.Errorf("mount: %w", err)
+	}
+	defer func() {
+		if e := fsys.Unmount(); err == nil && e != nil {
+			err = fmt.Errorf("unmount: %w", e)
+		}
+	}()
+	store.Invalidator = fsys
+
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(fsys.Path(), SelfCheckDBName))
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer sqlDB.Close()
+
+	// Exercise a write & read through the mount.
+	var version string
+	var x int
+	if err := sqlDB.QueryRowContext(ctx, `SELECT sqlite_version()`).Scan(&version); err != nil {
+		return fmt.Errorf("read sqlite version: %w", err)
+	} else if _, err := sqlDB.ExecContext(ctx, `CREATE TABLE t (x)`); err != nil {
+		return fmt.Errorf("create table: %w", err)
+	} else if _, err := sqlDB.ExecContext(ctx, `INSERT INTO t VALUES (100)`); err != nil {
+		return fmt.Errorf("insert: %w", err)
+	} else if err := sqlDB.QueryRowContext(ctx, `SELECT x FROM t`).Scan(&x); err != nil {
+		return fmt.Errorf("select: %w", err)
+	} else if x != 100 {
+		return fmt.Errorf("select: unexpected value %d, expected 100", x)
+	} else if err := sqlDB.Close(); err != nil {
+		return fmt.Errorf("close database: %w", err)
+	}
+
+	// Ensure both transactions were converted to valid LTX files.
+	db := store.DBByName(SelfCheckDBName)
+	if db == nil {
+		return fmt.Errorf("database not tracked by store")
+	}
+	pos := db.Pos()
+	if pos.TXID != 2 {
+		return fmt.Errorf("unexpected txid %d, expected 2", pos.TXID)
+	}
+	for txID := uint64(1); txID <= pos.TXID; txID++ {
+		if err := checkLTXFile(db, txID); err != nil {
+			return fmt.Errorf("ltx file (tx %d): %w", txID, err)
+		}
+	}
+
+	log.Printf("self-check passed: sqlite=%s", version)
+	return nil
+}
+
+// checkLTXFile decodes the LTX file for txID & verifies it against the
+// database's position when txID is the most recent transaction.
+func checkLTXFile(db *litefs.DB, txID uint64) error {
+	f, err := db.OpenLTXFile(txID)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var spec ltx.FileSpec
+	if _, err := spec.ReadFrom(f); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	} else if spec.Header.MinTXID != txID || spec.Header.MaxTXID != txID {
+		return fmt.Errorf("unexpected tx range %s", ltx.FormatTXIDRange(spec.Header.MinTXID, spec.Header.MaxTXID))
+	} else if len(spec.PageHeaders) == 0 {
+		return fmt.Errorf("no pages")
+	}
+
+	if pos := db.Pos(); pos.TXID == txID && spec.Header.PostChecksum != pos.Chksum {
+		return fmt.Errorf("post checksum %016x does not match database checksum %016x", spec.Header.PostChecksum, pos.Chksum)
+	}
+	return nil
+}
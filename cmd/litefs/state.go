@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
+)
+
+// ExportStateCommand represents the "litefs export-state" command. It writes
+// the databases, transaction files & positions in a node's data directory to
+// a single archive so the node can be restored after a disaster.
+//
+// The node must be stopped while its state is exported.
+type ExportStateCommand struct {
+	// Path to the LiteFS data directory. This is the hidden directory next
+	// to the mount directory, e.g. "/mnt/.litefs" for a mount of "/mnt/litefs".
+	DataDir string
+
+	// Path to write the archive to.
+	Path string
+}
+
+// NewExportStateCommand returns a new instance of ExportStateCommand.
+func NewExportStateCommand() *ExportStateCommand {
+	return &ExportStateCommand{}
+}
+
+// ParseFlags parses the command line flags for the export-state command.
+func (c *ExportStateCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-export-state", flag.ContinueOnError)
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "path to the LiteFS data directory")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: litefs export-state -data-dir DIR PATH")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		return fmt.Errorf("archive path required")
+	} else if fs.NArg() > 1 {
+		return fmt.Errorf("too many arguments")
+	} else if c.DataDir == "" {
+		return fmt.Errorf("data directory required")
+	}
+	c.Path = fs.Arg(0)
+	return nil
+}
+
+// Run opens the data directory & writes its state to the archive path.
+func (c *ExportStateCommand) Run(ctx context.Context) (err error) {
+	if _, err := os.Stat(c.DataDir); err != nil {
+		return err
+	}
+
+	// Open the store standalone so positions are recovered without
+	// attempting to connect to the rest of the cluster.
+	store := litefs.NewStore(c.DataDir)
+	store.Standalone = true
+	if err := store.Open(); err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	f, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		if err != nil {
+			_ = os.Remove(c.Path)
+		}
+	}()
+
+	if err := store.ExportState(f); err != nil {
+		return fmt.Errorf("export state: %w", err)
+	} else if err := f.Sync(); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+
+	for _, db := range store.DBs() {
+		pos := db.Pos()
+		fmt.Printf("exported %s: id=%s txid=%s chksum=%016x\n", db.Name(), litefs.FormatDBID(db.ID()), ltx.FormatTXID(pos.TXID), pos.Chksum)
+	}
+	return nil
+}
+
+// ImportStateCommand represents the "litefs import-state" command. It restores
+// an archive written by "litefs export-state" into an empty data directory and
+// validates it so the node resumes with the same database IDs & positions.
+type ImportStateCommand struct {
+	// Path to the LiteFS data directory. Must be empty or not exist.
+	DataDir string
+
+	// Path to read the archive from.
+	Path string
+}
+
+// NewImportStateCommand returns a new instance of ImportStateCommand.
+func NewImportStateCommand() *ImportStateCommand {
+	return &ImportStateCommand{}
+}
+
+// ParseFlags parses the command line flags for the import-state command.
+func (c *ImportStateCommand) ParseFlags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("litefs-import-state", flag.ContinueOnError)
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "path to the LiteFS data directory")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: litefs import-state -data-dir DIR PATH")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		return fmt.Errorf("archive path required")
+	} else if fs.NArg() > 1 {
+		return fmt.Errorf("too many arguments")
+	} else if c.DataDir == "" {
+		return fmt.Errorf("data directory required")
+	}
+	c.Path = fs.Arg(0)
+	return nil
+}
+
+// Run extracts the archive into the data directory. Returns an error, and
+// leaves the data directory empty, if the archive fails validation.
+func (c *ImportStateCommand) Run(ctx context.Context) error {
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := litefs.ImportState(c.DataDir, f); err != nil {
+		return fmt.Errorf("import state: %w", err)
+	}
+
+	fmt.Printf("state imported to %s\n", c.DataDir)
+	return nil
+}
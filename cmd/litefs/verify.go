@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/superfly/litefs/http"
+	"github.com/superfly/ltx"
+)
+
+// DefaultVerifyAgainstURL is the default URL of the node to verify against.
+const DefaultVerifyAgainstURL = "http://localhost:20202"
+
+// VerifyAgainstCommand represents the "litefs verify-against" command. It
+// compares the pages of a database file, such as one restored from a backup,
+// against the pages of the database on the primary after a given transaction.
+type VerifyAgainstCommand struct {
+	// URL of the primary to verify against.
+	PrimaryURL string
+
+	// Name of the database on the primary.
+	DB string
+
+	// Transaction the file is expected to match.
+	TXID uint64
+
+	// Path to the database file to verify.
+	Path string
+
+	// If true, matching pages are also printed.
+	Verbose bool
+}
+
+// NewVerifyAgainstCommand returns a new instance of VerifyAgainstCommand.
+func NewVerifyAgainstCommand() *VerifyAgainstCommand {
+	return &VerifyAgainstCommand{
+		PrimaryURL: DefaultVerifyAgainstURL,
+	}
+}
+
+// ParseFlags parses the command line flags for the verify-against command.
+func (c *VerifyAgainstCommand) ParseFlags(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("litefs-verify-against", flag.ContinueOnError)
+	fs.StringVar(&c.PrimaryURL, "primary", c.PrimaryURL, "URL of the primary")
+	fs.StringVar(&c.DB, "db", c.DB, "name of the database on the primary")
+	txID := fs.String("txid", "", "transaction ID to verify against, in hex")
+	fs.BoolVar(&c.Verbose, "v", c.Verbose, "print matching pages")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: litefs verify-against -primary URL -db NAME -txid TXID PATH")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		return fmt.Errorf("database path required")
+	} else if fs.NArg() > 1 {
+		return fmt.Errorf("too many arguments")
+	} else if c.DB == "" {
+		return fmt.Errorf("database name required")
+	} else if *txID == "" {
+		return fmt.Errorf("txid required")
+	}
+
+	if c.TXID, err = strconv.ParseUint(*txID, 16, 64); err != nil || c.TXID == 0 {
+		return fmt.Errorf("invalid txid: %q", *txID)
+	}
+	c.Path = fs.Arg(0)
+	return nil
+}
+
+// Run compares each page of the file against the primary & prints any that
+// differ. Returns an error if any page does not match.
+func (c *VerifyAgainstCommand) Run(ctx context.Context) error {
+	info, err := http.NewClient().PageChecksums(ctx, c.PrimaryURL, c.DB, c.TXID)
+	if err != nil {
+		return fmt.Errorf("fetch primary checksums: %w", err)
+	}
+
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Read the page size from the database header. Empty files have no pages.
+	var pageN uint32
+	if fi.Size() > 0 {
+		hdr := make([]byte, 100)
+		if _, err := io.ReadFull(f, hdr); err != nil {
+			return fmt.Errorf("read database header: %w", err)
+		}
+		pageSize := uint32(binary.BigEndian.Uint16(hdr[16:18]))
+		if pageSize == 1 {
+			pageSize = 65536
+		}
+		if pageSize != info.PageSize {
+			return fmt.Errorf("page size %d does not match primary page size %d", pageSize, info.PageSize)
+		}
+		pageN = uint32(fi.Size() / int64(pageSize))
+	}
+
+	n := pageN
+	if uint32(len(info.Checksums)) > n {
+		n = uint32(len(info.Checksums))
+	}
+
+	var failN int
+	buf := make([]byte, info.PageSize)
+	for pgno := uint32(1); pgno <= n; pgno++ {
+		switch {
+		case pgno > pageN:
+			fmt.Printf("page %d: FAIL missing from file\n", pgno)
+			failN++
+			continue
+		case pgno > uint32(len(info.Checksums)):
+			fmt.Printf("page %d: FAIL not on primary\n", pgno)
+			failN++
+			continue
+		}
+
+		if _, err := f.ReadAt(buf, int64(pgno-1)*int64(info.PageSize)); err != nil {
+			return fmt.Errorf("read page %d: %w", pgno, err)
+		}
+
+		if chksum, want := ltx.ChecksumPage(pgno, buf), info.Checksums[pgno-1]; chksum != want {
+			fmt.Printf("page %d: FAIL chksum=%016x primary=%016x\n", pgno, chksum, want)
+			failN++
+		} else if c.Verbose {
+			fmt.Printf("page %d: ok chksum=%016x\n", pgno, chksum)
+		}
+	}
+
+	if failN > 0 {
+		return fmt.Errorf("FAIL: %d of %d pages do not match primary at txid %s", failN, n, ltx.FormatTXID(info.TXID))
+	}
+	fmt.Printf("PASS: %d pages match primary at txid %s\n", n, ltx.FormatTXID(info.TXID))
+	return nil
+}
@@ -3,10 +3,13 @@ package consul
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"log"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
@@ -27,15 +30,25 @@ type Leaser struct {
 	advertiseURL string
 	client       *api.Client
 
+	mu          sync.Mutex
+	warnedFlags uint64 // last mismatched fingerprint logged
+
 	// SessionName is the name associated with the Consul session.
 	SessionName string
 
 	// Key is the Consul KV key use to acquire the lock.
 	Key string
 
-	// Prefix that is prepended to the key. Automatically set if the URL contains a path.
+	// Prefix that is prepended to the key. If the URL contains a path, it is
+	// prepended to this prefix.
 	KeyPrefix string
 
+	// Cluster is the name of the cluster. If set, it is inserted before the
+	// final element of the key so that multiple clusters can share a Consul
+	// server. For example, "staging" with the default key results in
+	// "litefs/staging/primary".
+	Cluster string
+
 	// TTL is the time until the lease expires.
 	TTL time.Duration
 
@@ -74,7 +87,7 @@ func (l *Leaser) Open() error {
 		config.Token, _ = u.User.Password()
 	}
 	if v := strings.TrimPrefix(u.Path, "/"); v != "" {
-		l.KeyPrefix = v
+		l.KeyPrefix = path.Join(v, l.KeyPrefix)
 	}
 
 	if l.client, err = api.NewClient(config); err != nil {
@@ -89,11 +102,86 @@ func (l *Leaser) Close() (err error) {
 	return nil
 }
 
+// Type returns the name of the leaser backend.
+func (l *Leaser) Type() string { return "consul" }
+
 // AdvertiseURL returns the URL being advertised to nodes when primary.
 func (l *Leaser) AdvertiseURL() string {
 	return l.advertiseURL
 }
 
+// LeaseKey returns the full Consul KV key composed from the prefix, cluster
+// name, and key.
+func (l *Leaser) LeaseKey() string {
+	if l.Cluster == "" {
+		return path.Join(l.KeyPrefix, l.Key)
+	}
+	dir, name := path.Split(l.Key)
+	return path.Join(l.KeyPrefix, dir, l.Cluster, name)
+}
+
+// fingerprint returns a hash of the settings that must match across all nodes
+// sharing a key. It is stored in the flags of the key so that nodes from a
+// differently-configured cluster can be detected. Never returns zero.
+func (l *Leaser) fingerprint() uint64 {
+	return fingerprint(l.Cluster, l.TTL, l.LockDelay)
+}
+
+func fingerprint(cluster string, ttl, lockDelay time.Duration) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", cluster, ttl, lockDelay)
+	if v := h.Sum64(); v != 0 {
+		return v
+	}
+	return 1
+}
+
+// checkFingerprint logs a warning if the primary holding the key was
+// configured differently than this node. Nodes that do not set a fingerprint
+// are ignored. Each mismatched fingerprint is only logged once in a row.
+func (l *Leaser) checkFingerprint(kv *api.KVPair) {
+	if kv.Flags == 0 || kv.Flags == l.fingerprint() {
+		return
+	}
+
+	l.mu.Lock()
+	if kv.Flags == l.warnedFlags {
+		l.mu.Unlock()
+		return
+	}
+	l.warnedFlags = kv.Flags
+	l.mu.Unlock()
+
+	log.Printf("WARNING: consul key %q is held by a node with a different cluster configuration (local: cluster=%q ttl=%s lock-delay=%s, remote: %s); clusters may be sharing a key",
+		l.LeaseKey(), l.Cluster, l.TTL, l.LockDelay, l.remoteSettings(kv))
+}
+
+// remoteSettings returns a description of the settings of the node holding
+// the key. The TTL & lock delay are read from the node's session. The cluster
+// name is not stored so it is only reported whether it matches this node's.
+func (l *Leaser) remoteSettings(kv *api.KVPair) string {
+	if kv.Session == "" {
+		return "unknown"
+	}
+	entry, _, err := l.client.Session().Info(kv.Session, nil)
+	if err != nil {
+		return fmt.Sprintf("unknown (%s)", err)
+	} else if entry == nil {
+		return "unknown"
+	}
+
+	ttl, err := time.ParseDuration(entry.TTL)
+	if err != nil {
+		return fmt.Sprintf("unknown (invalid session ttl %q)", entry.TTL)
+	}
+
+	cluster := "(different)"
+	if fingerprint(l.Cluster, ttl, entry.LockDelay) == kv.Flags {
+		cluster = fmt.Sprintf("%q", l.Cluster)
+	}
+	return fmt.Sprintf("cluster=%s ttl=%s lock-delay=%s", cluster, ttl, entry.LockDelay)
+}
+
 // Acquire acquires a lock on the key and sets the value.
 // Returns an error if the lease could not be obtained.
 func (l *Leaser) Acquire(ctx context.Context) (_ litefs.Lease, retErr error) {
@@ -118,7 +206,8 @@ func (l *Leaser) Acquire(ctx context.Context) (_ litefs.Lease, retErr error) {
 
 	// Set key with lock on session.
 	acquired, _, err := l.client.KV().Acquire(&api.KVPair{
-		Key:     path.Join(l.KeyPrefix, l.Key),
+		Key:     l.LeaseKey(),
+		Flags:   l.fingerprint(),
 		Value:   []byte(l.advertiseURL),
 		Session: sessionID,
 	}, nil)
@@ -143,12 +232,13 @@ func (l *Leaser) AcquireExisting(ctx context.Context, leaseID string) (litefs.Le
 
 // PrimaryURL attempts to return the current primary URL.
 func (l *Leaser) PrimaryURL(ctx context.Context) (string, error) {
-	kv, _, err := l.client.KV().Get(path.Join(l.KeyPrefix, l.Key), nil)
+	kv, _, err := l.client.KV().Get(l.LeaseKey(), nil)
 	if err != nil {
 		return "", err
 	} else if kv == nil {
 		return "", litefs.ErrNoPrimary
 	}
+	l.checkFingerprint(kv)
 	return string(kv.Value), nil
 }
 
@@ -0,0 +1,171 @@
+package consul_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/superfly/litefs/consul"
+)
+
+func TestLeaser_LeaseKey(t *testing.T) {
+	for _, tt := range []struct {
+		prefix, cluster, key string
+		want                 string
+	}{
+		{"", "", "litefs/primary", "litefs/primary"},
+		{"", "staging", "litefs/primary", "litefs/staging/primary"},
+		{"", "staging", "primary", "staging/primary"},
+		{"prod", "", "litefs/primary", "prod/litefs/primary"},
+		{"prod", "app", "litefs/primary", "prod/litefs/app/primary"},
+	} {
+		leaser := consul.NewLeaser("http://localhost:8500", "http://localhost:20202")
+		leaser.KeyPrefix, leaser.Cluster, leaser.Key = tt.prefix, tt.cluster, tt.key
+		if got := leaser.LeaseKey(); got != tt.want {
+			t.Errorf("LeaseKey(%q,%q,%q)=%q, want %q", tt.prefix, tt.cluster, tt.key, got, tt.want)
+		}
+	}
+}
+
+// Ensure the composed key is used when acquiring the lease & reading the primary.
+func TestLeaser_ComposedKey(t *testing.T) {
+	var mu sync.Mutex
+	var kvPaths []string
+	var value []byte
+	var flags uint64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/v1/session/create":
+			_ = json.NewEncoder(w).Encode(map[string]string{"ID": "SESSIONID"})
+		case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+			_ = json.NewEncoder(w).Encode(true)
+		case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodPut:
+			kvPaths = append(kvPaths, r.URL.Path)
+			if r.URL.Query().Get("acquire") != "SESSIONID" {
+				t.Errorf("unexpected acquire session: %q", r.URL.RawQuery)
+			}
+			v, err := strconv.ParseUint(r.URL.Query().Get("flags"), 10, 64)
+			if err != nil {
+				t.Errorf("invalid flags: %s", err)
+			}
+			flags = v
+			_ = json.NewEncoder(w).Encode(true)
+		case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodGet:
+			kvPaths = append(kvPaths, r.URL.Path)
+			_ = json.NewEncoder(w).Encode([]*api.KVPair{{
+				Key:   strings.TrimPrefix(r.URL.Path, "/v1/kv/"),
+				Flags: flags,
+				Value: value,
+			}})
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	leaser := consul.NewLeaser(srv.URL+"/env", "http://localhost:20202")
+	leaser.Cluster = "staging"
+	if err := leaser.Open(); err != nil {
+		t.Fatal(err)
+	}
+	value = []byte(leaser.AdvertiseURL())
+
+	lease, err := leaser.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if err := lease.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if primaryURL, err := leaser.PrimaryURL(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := primaryURL, "http://localhost:20202"; got != want {
+		t.Fatalf("PrimaryURL=%s, want %s", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := len(kvPaths), 2; got != want {
+		t.Fatalf("len(kvPaths)=%d, want %d", got, want)
+	}
+	for _, p := range kvPaths {
+		if got, want := p, "/v1/kv/env/litefs/staging/primary"; got != want {
+			t.Fatalf("path=%s, want %s", got, want)
+		}
+	}
+	if flags == 0 {
+		t.Fatal("expected configuration fingerprint in key flags")
+	}
+}
+
+// Ensure a mismatched configuration reports both the local & remote settings.
+func TestLeaser_FingerprintMismatch(t *testing.T) {
+	var mu sync.Mutex
+	var flags uint64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/v1/session/create":
+			_ = json.NewEncoder(w).Encode(map[string]string{"ID": "SESSIONID"})
+		case r.URL.Path == "/v1/session/info/SESSIONID":
+			_ = json.NewEncoder(w).Encode([]*api.SessionEntry{{ID: "SESSIONID", TTL: "30s", LockDelay: 5 * time.Second}})
+		case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodPut:
+			flags, _ = strconv.ParseUint(r.URL.Query().Get("flags"), 10, 64)
+			_ = json.NewEncoder(w).Encode(true)
+		case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodGet:
+			_ = json.NewEncoder(w).Encode([]*api.KVPair{{
+				Key:     strings.TrimPrefix(r.URL.Path, "/v1/kv/"),
+				Flags:   flags,
+				Session: "SESSIONID",
+				Value:   []byte("http://remote:20202"),
+			}})
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	// Acquire the key from a node with different timing settings.
+	remote := consul.NewLeaser(srv.URL, "http://remote:20202")
+	remote.Cluster, remote.TTL, remote.LockDelay = "staging", 30*time.Second, 5*time.Second
+	if err := remote.Open(); err != nil {
+		t.Fatal(err)
+	} else if _, err := remote.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	leaser := consul.NewLeaser(srv.URL, "http://localhost:20202")
+	leaser.Cluster = "staging"
+	if err := leaser.Open(); err != nil {
+		t.Fatal(err)
+	} else if _, err := leaser.PrimaryURL(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), `(local: cluster="staging" ttl=10s lock-delay=1s, remote: cluster="staging" ttl=30s lock-delay=5s)`; !strings.Contains(got, want) {
+		t.Fatalf("unexpected log: %s", got)
+	}
+}
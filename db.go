@@ -1,15 +1,23 @@
 package litefs
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/superfly/litefs/internal"
 	"github.com/superfly/ltx"
@@ -25,6 +33,23 @@ type DB struct {
 	pageSize uint32 // database page size, if known
 	pos      Pos    // current tx position
 
+	frozen bool // if true, new write transactions are rejected
+
+	catchUp CatchUp // progress of the last catch-up to the primary
+
+	writers []Writer // nodes that wrote the recent transactions, oldest first
+
+	resyncing int32 // set to 1 while the replica restores from the primary
+
+	syncedPos        Pos       // last position fsynced to the database file
+	syncedAt         time.Time // time of last fsync of applied transactions
+	syncedPosWritten bool      // if true, syncedPos is recorded on disk
+	bufferedN        int       // commits not yet fsynced by the write buffer
+
+	groupPos   Pos         // position before the pending group commit
+	groupN     int         // number of transactions in the pending group commit
+	groupTimer *time.Timer // flushes the pending group commit after its window
+
 	dirtyPageSet map[uint32]struct{}
 
 	// SQLite locks
@@ -61,6 +86,11 @@ func (db *DB) LTXPath(minTXID, maxTXID uint64) string {
 	return filepath.Join(db.LTXDir(), ltx.FormatFilename(minTXID, maxTXID))
 }
 
+// FrozenPath returns the path to the file that marks the database as frozen.
+func (db *DB) FrozenPath() string {
+	return filepath.Join(db.path, "frozen")
+}
+
 // DatabasePath returns the path to the underlying database file.
 func (db *DB) DatabasePath() string {
 	return filepath.Join(db.path, "database")
@@ -81,6 +111,150 @@ func (db *DB) Pos() Pos {
 // TXID returns the current transaction ID.
 func (db *DB) TXID() uint64 { return db.Pos().TXID }
 
+// CatchUp returns the progress of the most recent catch-up to the primary.
+// Returns false if the replica has never fallen behind.
+func (db *DB) CatchUp() (CatchUp, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	c := db.catchUp
+	c.TXID = db.pos.TXID
+	return c, c.TargetTXID != 0
+}
+
+// SetCatchUpTarget sets the primary position the replica is catching up to.
+// A new catch-up is started if the previous one completed.
+func (db *DB) SetCatchUpTarget(txID uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if txID <= db.pos.TXID {
+		return
+	}
+	if db.catchUp.TargetTXID <= db.pos.TXID {
+		db.catchUp = CatchUp{StartTXID: db.pos.TXID, StartedAt: time.Now()}
+	}
+	db.catchUp.TargetTXID = txID
+}
+
+// Resyncing returns true if the database is being restored from the primary
+// after its local state was discarded.
+func (db *DB) Resyncing() bool {
+	return atomic.LoadInt32(&db.resyncing) == 1
+}
+
+func (db *DB) setResyncing(v bool) {
+	var i int32
+	if v {
+		i = 1
+	}
+	atomic.StoreInt32(&db.resyncing, i)
+}
+
+// Reset discards the database contents & transaction files so that a replica
+// can restore the database from the primary. The name & frozen state are kept.
+func (db *DB) Reset() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := os.RemoveAll(db.LTXDir()); err != nil {
+		return fmt.Errorf("remove ltx dir: %w", err)
+	} else if err := os.MkdirAll(db.LTXDir(), 0777); err != nil {
+		return err
+	}
+
+	for _, filename := range []string{db.SyncedPosPath(), db.JournalPath(), db.WritersPath()} {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Truncate(db.DatabasePath(), 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("truncate database file: %w", err)
+	} else if err := internal.Sync(db.path); err != nil {
+		return err
+	}
+
+	db.pos, db.syncedPos, db.syncedPosWritten = Pos{}, Pos{}, false
+	db.clearWriteBuffer()
+	db.clearGroupCommit()
+	db.pageSize = 0
+	db.catchUp = CatchUp{}
+	db.writers = nil
+	db.dirtyPageSet = make(map[uint32]struct{})
+	return nil
+}
+
+// Sync fsyncs the database file & its LTX files so that every committed or
+// applied transaction is durable, such as before shutting down. This also
+// clears the synced position recorded by replicas that fsync lazily.
+func (db *DB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ents, err := os.ReadDir(db.LTXDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, ent := range ents {
+		if _, _, err := ltx.ParseFilename(ent.Name()); err != nil {
+			continue
+		} else if err := internal.Sync(filepath.Join(db.LTXDir(), ent.Name())); err != nil {
+			return fmt.Errorf("sync ltx file: %w", err)
+		}
+	}
+	if len(ents) > 0 {
+		if err := internal.Sync(db.LTXDir()); err != nil {
+			return fmt.Errorf("sync ltx dir: %w", err)
+		}
+	}
+
+	if err := internal.Sync(db.DatabasePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("sync database file: %w", err)
+	}
+
+	if err := os.Remove(db.SyncedPosPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db.markSynced(db.pos)
+	return nil
+}
+
+// Frozen returns true if the database rejects new write transactions.
+func (db *DB) Frozen() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.frozen
+}
+
+// SetFrozen freezes or thaws the database. While frozen, new write
+// transactions fail with ErrDatabaseFrozen. Transactions already in progress
+// are allowed to complete. The state is persisted & sent to replicas.
+func (db *DB) SetFrozen(frozen bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if frozen == db.frozen {
+		return nil
+	}
+
+	if frozen {
+		if err := os.WriteFile(db.FrozenPath(), nil, 0666); err != nil {
+			return err
+		}
+	} else if err := os.Remove(db.FrozenPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := internal.Sync(db.path); err != nil {
+		return fmt.Errorf("sync db dir: %w", err)
+	}
+	db.frozen = frozen
+
+	// Notify store so the state is streamed to replicas.
+	db.store.MarkDirty(db.id)
+
+	return nil
+}
+
 // Open initializes the database from files in its data directory.
 func (db *DB) Open() error {
 	// Read name file.
@@ -90,6 +264,13 @@ func (db *DB) Open() error {
 	}
 	db.name = string(name)
 
+	// Databases remain frozen across restarts.
+	if _, err := os.Stat(db.FrozenPath()); err == nil {
+		db.frozen = true
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("stat frozen file: %w", err)
+	}
+
 	// Ensure "ltx" directory exists.
 	if err := os.MkdirAll(db.LTXDir(), 0777); err != nil {
 		return err
@@ -99,6 +280,36 @@ func (db *DB) Open() error {
 		return fmt.Errorf("recover ltx: %w", err)
 	}
 
+	if db.store.StrictSegmentContinuity {
+		if err := db.checkLTXContinuity(); err != nil {
+			return err
+		}
+	}
+
+	// Replay buffered transactions that were not synced before the host
+	// stopped. This must occur before the journal is rolled back as the
+	// rolled back database is verified against the last LTX file.
+	if err := db.recoverSyncedPos(); err != nil {
+		return fmt.Errorf("recover synced position: %w", err)
+	}
+
+	// Roll back any write transaction that was in progress when the process
+	// stopped so the database file matches the last LTX file.
+	if err := db.recoverJournal(); err != nil {
+		return fmt.Errorf("recover journal: %w", err)
+	}
+	db.syncedPos = db.pos
+
+	// Determine the page size from the database header, if it exists.
+	if db.pageSize, err = readDatabasePageSize(db.DatabasePath()); err != nil {
+		return fmt.Errorf("read page size: %w", err)
+	}
+
+	if err := db.readWriters(); err != nil {
+		return fmt.Errorf("read writers: %w", err)
+	}
+	db.warnTXID()
+
 	return nil
 }
 
@@ -114,7 +325,7 @@ func (db *DB) recoverFromLTX() error {
 		return fmt.Errorf("readdir: %w", err)
 	}
 	for _, fi := range fis {
-		_, maxTXID, err := ltx.ParseFilename(fi.Name())
+		_, maxTXID, err := parseLTXFilename(fi.Name())
 		if err != nil {
 			continue
 		} else if maxTXID <= db.pos.TXID {
@@ -138,9 +349,399 @@ func (db *DB) recoverFromLTX() error {
 	return nil
 }
 
+// checkLTXContinuity returns ErrSegmentGap if any transaction between the
+// earliest LTX file & the current position is not covered by an LTX file.
+func (db *DB) checkLTXContinuity() error {
+	ents, err := os.ReadDir(db.LTXDir())
+	if err != nil {
+		return fmt.Errorf("read ltx dir: %w", err)
+	}
+
+	// Collect the TXID range of each file. A file may exist both compressed
+	// & uncompressed so duplicate ranges are ignored.
+	type txRange struct{ min, max uint64 }
+	m := make(map[txRange]struct{})
+	for _, ent := range ents {
+		if minTXID, maxTXID, err := parseLTXFilename(ent.Name()); err == nil {
+			m[txRange{minTXID, maxTXID}] = struct{}{}
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+
+	ranges := make([]txRange, 0, len(m))
+	for r := range m {
+		ranges = append(ranges, r)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].min < ranges[j].min })
+
+	// Report every missing range so the operator can see the full extent of
+	// the damage rather than only the first gap.
+	var gaps []string
+	next := ranges[0].min
+	for _, r := range ranges {
+		if r.min > next {
+			gaps = append(gaps, ltx.FormatTXID(next)+"-"+ltx.FormatTXID(r.min-1))
+		}
+		if r.max+1 > next {
+			next = r.max + 1
+		}
+	}
+
+	if len(gaps) > 0 {
+		return fmt.Errorf("%w: db=%q base=%s head=%s files=%d missing=%s",
+			ErrSegmentGap, db.name, ltx.FormatTXID(ranges[0].min), ltx.FormatTXID(db.pos.TXID), len(ranges), strings.Join(gaps, ","))
+	}
+	return nil
+}
+
+// recoverJournal rolls back a hot journal left behind by a write transaction
+// that did not complete. Original pages are copied from the journal back into
+// the database file & the journal is removed. A journal without a valid header
+// belongs to a completed transaction so it is removed without playback.
+func (db *DB) recoverJournal() error {
+	jf, err := os.Open(db.JournalPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	defer jf.Close()
+
+	dbf, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("open database file: %w", err)
+	} else if err == nil {
+		defer dbf.Close()
+
+		pageN, err := rollbackJournal(dbf, jf)
+		if err != nil {
+			return fmt.Errorf("rollback: %w", err)
+		} else if err := dbf.Sync(); err != nil {
+			return fmt.Errorf("sync database file: %w", err)
+		}
+		if pageN > 0 {
+			log.Printf("rolled back hot journal: db=%s pages=%d", FormatDBID(db.id), pageN)
+		}
+
+		// Ensure the restored database matches the last committed transaction.
+		if db.pos.TXID > 0 {
+			if chksum, err := checksumRolledBackDatabaseFile(dbf); err != nil {
+				return fmt.Errorf("checksum database file: %w", err)
+			} else if chksum != db.pos.Chksum {
+				if ok, err := db.removeUncommittedLTX(chksum); err != nil {
+					return fmt.Errorf("remove uncommitted ltx: %w", err)
+				} else if !ok {
+					db.store.recordChecksumMismatch(db.id, db.pos.TXID)
+					return fmt.Errorf("database checksum %016x does not match tx %s after rollback", chksum, ltx.FormatTXID(db.pos.TXID))
+				}
+			}
+		}
+	}
+
+	if err := os.Remove(db.JournalPath()); err != nil {
+		return fmt.Errorf("remove journal: %w", err)
+	} else if err := internal.Sync(db.path); err != nil {
+		return fmt.Errorf("sync database directory: %w", err)
+	}
+	return nil
+}
+
+// removeUncommittedLTX removes the LTX file for the current position if the
+// rolled back database, with checksum chksum, matches the state before it.
+// This occurs if the process stopped after CommitJournal wrote the LTX file
+// but before the journal was invalidated. SQLite rolls the transaction back
+// so its LTX file is discarded & the position is reset to the prior one. The
+// position was not yet updated so the transaction was never replicated.
+func (db *DB) removeUncommittedLTX(chksum uint64) (bool, error) {
+	path := filepath.Join(db.LTXDir(), ltx.FormatFilename(db.pos.TXID, db.pos.TXID))
+	hdr, err := readLTXFileHeader(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else if hdr.PreChecksum != chksum && !(hdr.PreChecksum == 0 && chksum == ltx.ChecksumFlag) {
+		return false, nil
+	}
+
+	if err := os.Remove(path); err != nil {
+		return false, err
+	} else if err := internal.Sync(db.LTXDir()); err != nil {
+		return false, err
+	}
+
+	log.Printf("removed ltx file for uncommitted transaction: db=%s tx=%s", FormatDBID(db.id), ltx.FormatTXID(db.pos.TXID))
+	db.pos = Pos{TXID: db.pos.TXID - 1, Chksum: hdr.PreChecksum}
+	return true, nil
+}
+
 // OpenLTXFile returns a file handle to an LTX file that contains the given TXID.
+// Group commits & replicas may store batches of transactions in a single file
+// so the batch starting at txID is used if there is no single transaction file.
 func (db *DB) OpenLTXFile(txID uint64) (*os.File, error) {
-	return os.Open(filepath.Join(db.LTXDir(), ltx.FormatFilename(txID, txID)))
+	f, err := openLTXFile(filepath.Join(db.LTXDir(), ltx.FormatFilename(txID, txID)))
+	if !os.IsNotExist(err) {
+		return f, err
+	}
+
+	ents, e := os.ReadDir(db.LTXDir())
+	if e != nil {
+		return nil, e
+	}
+	for _, ent := range ents {
+		if minTXID, _, e := parseLTXFilename(ent.Name()); e == nil && minTXID == txID {
+			return openLTXFile(filepath.Join(db.LTXDir(), ent.Name()))
+		}
+	}
+	return nil, err
+}
+
+// CompressLTXFiles compresses the LTX files in the data directory that
+// precede the current position. The latest LTX file is left uncompressed as it
+// is the one most likely to be streamed to replicas. Returns the number of
+// files compressed.
+func (db *DB) CompressLTXFiles() (n int, err error) {
+	db.mu.Lock()
+	txID := db.streamPos().TXID
+	db.mu.Unlock()
+
+	ents, err := os.ReadDir(db.LTXDir())
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	for _, ent := range ents {
+		if _, maxTXID, err := ltx.ParseFilename(ent.Name()); err != nil || maxTXID >= txID {
+			continue
+		}
+
+		// Files may be removed by a concurrent reset so ignore missing files.
+		if err := compressLTXFile(filepath.Join(db.LTXDir(), ent.Name())); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return n, fmt.Errorf("compress ltx file (%s): %w", ent.Name(), err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// copyDatabaseFile copies the database file to an unlinked temporary file &
+// returns it positioned at the start. Lock must be held.
+func (db *DB) copyDatabaseFile() (*os.File, error) {
+	src, err := os.Open(db.DatabasePath())
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	f, err := os.CreateTemp(db.path, "snapshot-*")
+	if err != nil {
+		return nil, err
+	} else if err := os.Remove(f.Name()); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if _, err := io.Copy(f, src); err != nil {
+		_ = f.Close()
+		return nil, err
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// PosAt returns the position of the database after the transaction with
+// the given TXID was committed, as recorded in its LTX file. Only the
+// checksum after the last transaction of a batch is recorded so Chksum is
+// zero for other transactions within a batch.
+func (db *DB) PosAt(txID uint64) (Pos, error) {
+	hdr, err := db.readLTXFileHeaderAt(txID)
+	if err != nil {
+		return Pos{}, err
+	} else if hdr.MaxTXID != txID {
+		return Pos{TXID: txID}, nil
+	}
+	return Pos{TXID: hdr.MaxTXID, Chksum: hdr.PostChecksum}, nil
+}
+
+// readLTXFileHeaderAt returns the header of the LTX file that contains txID.
+// The transaction may be stored in its own file or within a range of
+// transactions, such as a batch or a seed file.
+func (db *DB) readLTXFileHeaderAt(txID uint64) (ltx.Header, error) {
+	hdr, err := readLTXFileHeader(db.LTXPath(txID, txID))
+	if !os.IsNotExist(err) {
+		return hdr, err
+	}
+
+	ents, e := os.ReadDir(db.LTXDir())
+	if e != nil {
+		return ltx.Header{}, e
+	}
+	for _, ent := range ents {
+		if minTXID, maxTXID, e := parseLTXFilename(ent.Name()); e == nil && minTXID <= txID && txID <= maxTXID {
+			return readLTXFileHeader(filepath.Join(db.LTXDir(), ent.Name()))
+		}
+	}
+	return ltx.Header{}, err
+}
+
+// PageChecksumsAt returns the header of the LTX file ending at txID & the
+// checksum of each page of the database after it was applied, indexed by page
+// number minus one. The checksums are computed by replaying the LTX files from
+// the start of the database's history so every file up to txID must exist.
+func (db *DB) PageChecksumsAt(txID uint64) (ltx.Header, []uint64, error) {
+	ents, err := os.ReadDir(db.LTXDir())
+	if err != nil && !os.IsNotExist(err) {
+		return ltx.Header{}, nil, err
+	}
+
+	var hdr ltx.Header
+	var chksums []uint64
+	next := uint64(1)
+	for _, ent := range ents {
+		// Skip unrelated files & the compressed copy of a file already read.
+		minTXID, maxTXID, err := parseLTXFilename(ent.Name())
+		if err != nil || minTXID < next {
+			continue
+		} else if minTXID > txID {
+			break
+		} else if minTXID != next {
+			return ltx.Header{}, nil, fmt.Errorf("ltx file not retained: txid=%s", ltx.FormatTXID(next))
+		} else if maxTXID > txID {
+			return ltx.Header{}, nil, fmt.Errorf("txid %s is within batch %s-%s", ltx.FormatTXID(txID), ltx.FormatTXID(minTXID), ltx.FormatTXID(maxTXID))
+		}
+
+		filename := filepath.Join(db.LTXDir(), strings.TrimSuffix(ent.Name(), CompressedLTXExt))
+		if hdr, chksums, err = readLTXPageChecksums(filename, chksums); err != nil {
+			return ltx.Header{}, nil, fmt.Errorf("read ltx page checksums (%s): %w", ent.Name(), err)
+		}
+		next = maxTXID + 1
+	}
+
+	if txID == 0 || next <= txID {
+		return ltx.Header{}, nil, fmt.Errorf("txid %s: %w", ltx.FormatTXID(txID), os.ErrNotExist)
+	}
+	return hdr, chksums, nil
+}
+
+// readLTXPageChecksums updates chksums with the checksum of each page in the
+// LTX file & truncates it to the database size after the transaction.
+func readLTXPageChecksums(filename string, chksums []uint64) (ltx.Header, []uint64, error) {
+	f, err := openLTXFile(filename)
+	if err != nil {
+		return ltx.Header{}, nil, err
+	}
+	defer f.Close()
+
+	var hdr ltx.Header
+	hr := ltx.NewHeaderBlockReader(f)
+	if err := hr.ReadHeader(&hdr); err != nil {
+		return hdr, nil, fmt.Errorf("read header: %w", err)
+	}
+
+	pf := io.NewSectionReader(f, int64(hdr.HeaderBlockSize()), math.MaxInt64-int64(hdr.HeaderBlockSize()))
+	pr := ltx.NewPageBlockReader(pf, hdr.PageN, hdr.PageSize, hdr.PageBlockChecksum)
+	pageBuf := make([]byte, hdr.PageSize)
+	for i := uint32(0); i < hdr.PageN; i++ {
+		var phdr ltx.PageHeader
+		if err := hr.ReadPageHeader(&phdr); err != nil {
+			return hdr, nil, fmt.Errorf("read page header[%d]: %w", i, err)
+		} else if _, err := io.ReadFull(pr, pageBuf); err != nil {
+			return hdr, nil, fmt.Errorf("read page data[%d]: %w", i, err)
+		}
+
+		for uint32(len(chksums)) < phdr.Pgno {
+			chksums = append(chksums, 0)
+		}
+		chksums[phdr.Pgno-1] = ltx.ChecksumPage(phdr.Pgno, pageBuf)
+	}
+
+	for uint32(len(chksums)) < hdr.Commit {
+		chksums = append(chksums, 0)
+	}
+	return hdr, chksums[:hdr.Commit], nil
+}
+
+// CommittedAt returns the time that the transaction with the given TXID was
+// committed on the primary, truncated to the second. Transactions within a
+// batch report the time that the batch was written.
+func (db *DB) CommittedAt(txID uint64) (time.Time, error) {
+	hdr, err := db.readLTXFileHeaderAt(txID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(hdr.Timestamp), 0), nil
+}
+
+// ReadPage returns the contents of the page at pgno as of the current
+// committed position. Returns ErrDatabaseLocked if a write transaction is
+// updating the database file or ErrPageNotFound if pgno is past the end of
+// the database.
+func (db *DB) ReadPage(pgno uint32) ([]byte, Pos, error) {
+	// Hold a SHARED lock so SQLite cannot write to the database file.
+	guard := db.sharedLock.TryRLock()
+	if guard == nil {
+		return nil, Pos{}, ErrDatabaseLocked
+	}
+	defer guard.Unlock()
+
+	// Prevent replicated transactions from being applied during the read.
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	f, err := os.Open(db.DatabasePath())
+	if err != nil {
+		return nil, Pos{}, err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, databaseHeaderSize)
+	if _, err := f.ReadAt(hdr, 0); err == io.EOF {
+		return nil, Pos{}, ErrPageNotFound // empty database
+	} else if err != nil {
+		return nil, Pos{}, fmt.Errorf("read database header: %w", err)
+	}
+
+	pageSize := parseDatabasePageSize(hdr)
+	commit := binary.BigEndian.Uint32(hdr[SQLITE_DATABASE_SIZE_OFFSET:])
+	if pgno == 0 || pgno > commit {
+		return nil, Pos{}, ErrPageNotFound
+	}
+
+	buf := make([]byte, pageSize)
+	if _, err := f.ReadAt(buf, int64(pgno-1)*int64(pageSize)); err != nil {
+		return nil, Pos{}, fmt.Errorf("read database page: pgno=%d err=%w", pgno, err)
+	}
+	return buf, db.pos, nil
+}
+
+// PageInfo returns the page size & page count recorded in the database header.
+// Both are zero if the database has not been written to yet.
+func (db *DB) PageInfo() (pageSize, pageN uint32, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	f, err := os.Open(db.DatabasePath())
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, databaseHeaderSize)
+	if _, err := io.ReadFull(f, hdr); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, fmt.Errorf("read database header: %w", err)
+	}
+	return parseDatabasePageSize(hdr), binary.BigEndian.Uint32(hdr[SQLITE_DATABASE_SIZE_OFFSET:]), nil
 }
 
 // WriteDatabase writes data to the main database file.
@@ -151,6 +752,8 @@ func (db *DB) WriteDatabase(f *os.File, data []byte, offset int64) error {
 	// Return an error if the current process is not the leader.
 	if !db.store.IsPrimary() {
 		return ErrReadOnlyReplica
+	} else if err := db.store.CheckPinnedPrimary(db.name); err != nil {
+		return err
 	} else if len(data) == 0 {
 		return nil
 	}
@@ -160,10 +763,31 @@ func (db *DB) WriteDatabase(f *os.File, data []byte, offset int64) error {
 		return fmt.Errorf("cannot enable WAL mode with LiteFS")
 	}
 
-	// Use page size from the write.
-	// TODO: Read page size from meta page.
-	if db.pageSize == 0 {
-		db.pageSize = uint32(len(data))
+	// Read page size from the header when it is written. Otherwise fallback
+	// to the size of the write if the page size is not yet known.
+	pageSize := db.pageSize
+	if offset == 0 && len(data) >= databaseHeaderSize {
+		pageSize = parseDatabasePageSize(data)
+	} else if pageSize == 0 {
+		pageSize = uint32(len(data))
+	}
+
+	if err := db.validatePageSize(pageSize); err != nil {
+		return err
+	}
+	db.pageSize = pageSize
+
+	// Reject writes that grow the database beyond its size limit. Writes
+	// within the current file are allowed so existing pages can be updated
+	// even if the limit was lowered below the current size.
+	if max := db.store.MaxDatabaseBytesFor(db.name); max > 0 && offset+int64(len(data)) > max {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		} else if offset+int64(len(data)) > fi.Size() {
+			db.store.errLog.Printf("database %q cannot grow beyond size limit of %d bytes", db.name, max)
+			return ErrDatabaseTooLarge
+		}
 	}
 
 	// Mark page as dirty.
@@ -182,6 +806,16 @@ func (db *DB) WriteDatabase(f *os.File, data []byte, offset int64) error {
 func (db *DB) CreateJournal() (*os.File, error) {
 	if !db.store.IsPrimary() {
 		return nil, ErrReadOnlyReplica
+	} else if err := db.store.CheckPinnedPrimary(db.Name()); err != nil {
+		return nil, err
+	} else if db.store.WritesStopped() {
+		return nil, ErrShuttingDown
+	} else if db.Frozen() {
+		return nil, ErrDatabaseFrozen
+	} else if db.TXID() >= MaxTXID {
+		return nil, ErrTXIDExhausted
+	} else if err := db.store.CheckFreeSpace(); err != nil {
+		return nil, err
 	}
 	return os.OpenFile(db.JournalPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0666)
 }
@@ -221,8 +855,13 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 		return nil
 	}
 
-	// Determine transaction ID of the in-process transaction.
+	// Determine transaction ID of the in-process transaction. This is
+	// checked when the journal is created but is verified again as a wrapped
+	// TXID would corrupt the ordering of LTX files.
 	pos := db.pos
+	if pos.TXID >= MaxTXID {
+		return ErrTXIDExhausted
+	}
 	txID := pos.TXID + 1
 
 	dbFile, err := os.Open(db.DatabasePath())
@@ -256,13 +895,32 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 		chksum ^= pageChksum
 	}
 
-	// Build sorted list of dirty page numbers.
+	// Determine if the transaction shrank the database, such as by a VACUUM.
+	initialSize, err := readJournalInitialSize(journalFile)
+	if err != nil {
+		return fmt.Errorf("cannot read journal initial size: %w", err)
+	}
+	truncated := commit < initialSize
+
+	// Build sorted list of dirty page numbers. Pages past the end of the
+	// database were removed by truncation & are not written.
 	pgnos := make([]uint32, 0, len(db.dirtyPageSet))
 	for pgno := range db.dirtyPageSet {
-		pgnos = append(pgnos, pgno)
+		if pgno <= commit {
+			pgnos = append(pgnos, pgno)
+		}
 	}
 	sort.Slice(pgnos, func(i, j int) bool { return pgnos[i] < pgnos[j] })
 
+	// Pages removed by truncation are not guaranteed to be in the journal so
+	// their checksums cannot be removed incrementally. Instead, recompute the
+	// checksum over the remaining page range.
+	if truncated {
+		if _, _, chksum, err = checksumDatabaseFile(dbFile); err != nil {
+			return fmt.Errorf("cannot checksum truncated database: %w", err)
+		}
+	}
+
 	hdr := ltx.Header{
 		Version:      1,
 		PageSize:     db.pageSize,
@@ -271,6 +929,7 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 		DBID:         db.id,
 		MinTXID:      txID,
 		MaxTXID:      txID,
+		Timestamp:    uint64(time.Now().Unix()),
 		PreChecksum:  pos.Chksum,
 		PostChecksum: chksum,
 	}
@@ -318,11 +977,11 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 		}
 
 		// Update incremental checksum.
-		chksum ^= ltx.ChecksumPage(pgno, buf)
+		if !truncated {
+			chksum ^= ltx.ChecksumPage(pgno, buf)
+		}
 	}
 
-	// TODO: Checksum pages removed by truncation.
-
 	// TODO: Write event data to LTX file.
 
 	// Finish page block to compute checksum and then finish header block.
@@ -337,9 +996,32 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 	// Update header with computed checksums.
 	hdr = hw.Header()
 
-	// Ensure file is persisted to disk.
-	if err := dbFile.Sync(); err != nil {
-		return fmt.Errorf("cannot sync ltx file: %w", err)
+	// Ensure file is persisted to disk. If the write buffer has room then the
+	// fsync is deferred & the last synced position is recorded instead so that
+	// recovery replays the LTX files committed since. Those LTX files must be
+	// durable before the journal is invalidated as they are the only record
+	// of the transaction until the database file is synced.
+	buffered := db.store.writeBufferEnabled() && db.bufferedN < db.store.WriteBufferMaxSize
+	if buffered {
+		if err := db.store.FsyncFunc(hf); err != nil {
+			return fmt.Errorf("cannot sync ltx file: %w", err)
+		} else if err := internal.Sync(db.LTXDir()); err != nil {
+			return fmt.Errorf("cannot sync ltx dir: %w", err)
+		} else if err := db.writeSyncedPos(); err != nil {
+			return fmt.Errorf("write synced position: %w", err)
+		}
+	} else if err := db.store.FsyncFunc(dbFile); err != nil {
+		return fmt.Errorf("cannot sync database file: %w", err)
+	}
+
+	// Persist the LTX file before the position is updated, which is what
+	// makes the transaction visible to replication streams.
+	if db.store.DurableBeforeReplicate {
+		if err := db.store.FsyncFunc(hf); err != nil {
+			return fmt.Errorf("cannot sync ltx file: %w", err)
+		} else if err := internal.Sync(db.LTXDir()); err != nil {
+			return fmt.Errorf("cannot sync ltx dir: %w", err)
+		}
 	}
 
 	if err := db.invalidateJournal(mode); err != nil {
@@ -351,13 +1033,144 @@ func (db *DB) CommitJournal(mode JournalMode) error {
 		TXID:   hdr.MaxTXID,
 		Chksum: hdr.PostChecksum,
 	}
+	db.warnTXID()
 
-	// Notify store of database change.
+	if err := db.recordWriter(db.store.ID, hdr.MinTXID); err != nil {
+		db.store.errLog.Printf("cannot record writer: db=%s err=%s", db.name, err)
+	}
+
+	// Track the buffered commit or, if the file was just fsynced, release any
+	// commits buffered before it.
+	if buffered {
+		db.bufferedN++
+		writeBufferDepthMetric.Inc()
+	} else if db.bufferedN > 0 {
+		if err := os.Remove(db.SyncedPosPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		db.markSynced(db.pos)
+	}
+
+	// Hold the transaction in a pending group commit, if enabled, so that it
+	// is sent to replicas in a single LTX file with the transactions that
+	// follow it. Otherwise notify the store of the database change.
+	if db.store.groupCommitEnabled() {
+		if db.groupN == 0 {
+			db.groupPos = pos
+			db.groupTimer = time.AfterFunc(db.store.GroupCommitWindow, db.flushGroupCommitAfterWindow)
+		}
+		if db.groupN++; db.groupN >= db.store.GroupCommitMaxSize {
+			db.flushGroupCommit()
+		}
+	} else {
+		db.store.MarkDirty(db.id)
+	}
+
+	db.store.notifyCommit(CommitEvent{
+		DBID:      db.id,
+		Name:      db.name,
+		TXID:      hdr.MaxTXID,
+		PageN:     hdr.PageN,
+		Timestamp: time.Unix(int64(hdr.Timestamp), 0).UTC(),
+	})
+
+	return nil
+}
+
+// StreamPos returns the position of the database that is visible to
+// replication streams. Transactions held by a pending group commit on the
+// primary are excluded until they are written as a single LTX file.
+func (db *DB) StreamPos() Pos {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.streamPos()
+}
+
+// streamPos returns the position visible to replication streams. Lock must be held.
+func (db *DB) streamPos() Pos {
+	if db.groupN > 0 {
+		return db.groupPos
+	}
+	return db.pos
+}
+
+// flushGroupCommitAfterWindow flushes the pending group commit once the group
+// commit window has elapsed.
+func (db *DB) flushGroupCommitAfterWindow() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.flushGroupCommit()
+}
+
+// flushGroupCommit combines the LTX files of the pending group commit into a
+// single LTX file & makes the transactions visible to replication streams. If
+// the files cannot be combined then they are streamed individually instead
+// as each transaction is already committed. Lock must be held.
+func (db *DB) flushGroupCommit() {
+	if db.groupN == 0 {
+		return
+	}
+	if db.groupTimer != nil {
+		db.groupTimer.Stop()
+		db.groupTimer = nil
+	}
+
+	minTXID, maxTXID, n := db.groupPos.TXID+1, db.pos.TXID, db.groupN
+	db.groupPos, db.groupN = Pos{}, 0
+
+	if n > 1 {
+		if err := db.compactLTXFiles(minTXID, maxTXID); err != nil {
+			db.store.errLog.Printf("cannot combine group commit, streaming transactions individually: db=%s tx=%s err=%s",
+				db.name, ltx.FormatTXIDRange(minTXID, maxTXID), err)
+		}
+	}
+	groupCommitSizeMetric.Observe(float64(n))
 	db.store.MarkDirty(db.id)
+}
 
+// clearGroupCommit discards the pending group commit. Lock must be held.
+func (db *DB) clearGroupCommit() {
+	if db.groupTimer != nil {
+		db.groupTimer.Stop()
+		db.groupTimer = nil
+	}
+	db.groupPos, db.groupN = Pos{}, 0
+}
+
+// compactLTXFiles replaces the LTX files of each transaction from minTXID to
+// maxTXID with a single LTX file. The compactor stages the new file with a
+// ".tmp" suffix, which is ignored by scans of the LTX directory, & the
+// original files are only removed once the new file is durable.
+func (db *DB) compactLTXFiles(minTXID, maxTXID uint64) error {
+	inputs := make([]string, 0, maxTXID-minTXID+1)
+	for txID := minTXID; txID <= maxTXID; txID++ {
+		inputs = append(inputs, db.LTXPath(txID, txID))
+	}
+
+	if err := ltx.NewCompactor().Compact(db.LTXPath(minTXID, maxTXID), inputs); err != nil {
+		return fmt.Errorf("compact: %w", err)
+	} else if err := internal.Sync(db.LTXDir()); err != nil {
+		return fmt.Errorf("sync ltx dir: %w", err)
+	}
+
+	for _, filename := range inputs {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return nil
 }
 
+// warnTXID logs a warning if the database's transaction ID has passed the
+// store's warning threshold. Repeated warnings are rate limited.
+func (db *DB) warnTXID() {
+	if db.pos.TXID < db.store.TXIDWarnThreshold {
+		return
+	}
+	db.store.errLog.Printf("WARNING: database %q has passed transaction id %s & is approaching the maximum of %s",
+		db.name, ltx.FormatTXID(db.store.TXIDWarnThreshold), ltx.FormatTXID(MaxTXID))
+}
+
 // isJournalHeaderValid returns true if the journal starts with the journal magic.
 func (db *DB) isJournalHeaderValid() (bool, error) {
 	f, err := os.Open(db.JournalPath())
@@ -395,100 +1208,486 @@ func (db *DB) invalidateJournal(mode JournalMode) error {
 		return fmt.Errorf("invalid journal: %q", mode)
 	}
 
-	// Sync the underlying directory.
-	if err := internal.Sync(db.path); err != nil {
-		return fmt.Errorf("sync database directory: %w", err)
+	// Sync the underlying directory.
+	if err := internal.Sync(db.path); err != nil {
+		return fmt.Errorf("sync database directory: %w", err)
+	}
+
+	db.dirtyPageSet = make(map[uint32]struct{})
+
+	return nil
+}
+
+// TryApplyLTX attempts to apply an LTX file to the database.
+func (db *DB) TryApplyLTX(path string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// TODO: Obtain RESERVED lock.
+
+	// Ensure the transaction follows the current state of the database.
+	if err := db.verifyPreChecksum(path); err != nil {
+		return err
+	}
+
+	// Open database file for writing.
+	dbf, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	if err != nil {
+		return fmt.Errorf("open database file: %w", err)
+	}
+	defer dbf.Close()
+
+	hdr, err := db.applyLTX(dbf, path)
+	if err != nil {
+		return err
+	}
+	pos := Pos{TXID: hdr.MaxTXID, Chksum: hdr.PostChecksum}
+
+	// Sync changes to disk according to the replica fsync policy. If the sync
+	// is skipped then the last synced position is recorded so that recovery
+	// knows which LTX files must be replayed.
+	if db.store.shouldSyncReplica(db.syncedAt) {
+		if err := dbf.Sync(); err != nil {
+			return fmt.Errorf("sync database file: %w", err)
+		}
+		db.markSynced(pos)
+	} else if err := db.writeSyncedPos(); err != nil {
+		return fmt.Errorf("write synced position: %w", err)
+	}
+
+	// Update transaction for database.
+	db.pos = pos
+	db.pageSize = hdr.PageSize
+	db.warnTXID()
+	if c := &db.catchUp; c.CompletedAt.IsZero() && c.TargetTXID != 0 && pos.TXID >= c.TargetTXID {
+		c.CompletedAt = time.Now()
+	}
+
+	// Notify store of database change.
+	db.store.MarkDirty(db.id)
+
+	return nil
+}
+
+// verifyPreChecksum returns ErrChecksumMismatch if the pre-checksum of the LTX
+// file at path does not match the current position of the database. Snapshots
+// replace the database so they are not checked. Lock must be held.
+func (db *DB) verifyPreChecksum(path string) error {
+	hdr, err := readLTXFileHeader(path)
+	if err != nil {
+		return fmt.Errorf("read ltx header: %w", err)
+	} else if hdr.MinTXID <= 1 || hdr.MinTXID != db.pos.TXID+1 || hdr.PreChecksum == db.pos.Chksum {
+		return nil
+	}
+
+	db.store.recordChecksumMismatch(db.id, hdr.MinTXID)
+	return fmt.Errorf("%w: db=%s tx=%s pre-checksum=%016x, expected %016x",
+		ErrChecksumMismatch, FormatDBID(db.id), ltx.FormatTXID(hdr.MinTXID), hdr.PreChecksum, db.pos.Chksum)
+}
+
+// errVerifyBusy is returned internally when a batch of an integrity check
+// cannot obtain a SHARED lock because a write is in progress.
+var errVerifyBusy = errors.New("database busy")
+
+// VerifyChecksum recomputes the checksum of the entire database file &
+// compares it against the checksum of the current position. Pages are read in
+// batches of batchSize while holding a SHARED lock, pausing for delay between
+// batches. Returns false if the database changed before the scan completed so
+// that the check can be retried later. Returns ErrChecksumMismatch if the
+// file has drifted from its tracked position.
+func (db *DB) VerifyChecksum(ctx context.Context, batchSize int, delay time.Duration) (bool, error) {
+	pos := db.Pos()
+	if pos.TXID == 0 {
+		return true, nil // empty database
+	}
+
+	f, err := os.Open(db.DatabasePath())
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var pageSize, commit uint32
+	var chksum uint64
+	var buf []byte
+	var headerRead bool
+	readBatch := func(pgno uint32) (next uint32, err error) {
+		guard := db.sharedLock.TryRLock()
+		if guard == nil {
+			return pgno, errVerifyBusy
+		}
+		defer guard.Unlock()
+
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		if db.pos != pos {
+			return pgno, nil // database changed, caller checks position
+		}
+
+		// Read the page size & count from the header on the first batch.
+		if !headerRead {
+			hdr := make([]byte, databaseHeaderSize)
+			if _, err := f.ReadAt(hdr, 0); err != nil {
+				return pgno, fmt.Errorf("read database header: %w", err)
+			}
+			pageSize = parseDatabasePageSize(hdr)
+			commit = binary.BigEndian.Uint32(hdr[SQLITE_DATABASE_SIZE_OFFSET:])
+			buf = make([]byte, pageSize)
+			headerRead = true
+		}
+
+		for n := 0; n < batchSize && pgno <= commit; n, pgno = n+1, pgno+1 {
+			if _, err := f.ReadAt(buf, int64(pgno-1)*int64(pageSize)); err != nil {
+				return pgno, fmt.Errorf("read database page: pgno=%d err=%w", pgno, err)
+			}
+			chksum ^= ltx.ChecksumPage(pgno, buf)
+		}
+		return pgno, nil
+	}
+
+	for pgno := uint32(1); !headerRead || pgno <= commit; {
+		next, err := readBatch(pgno)
+		if err != nil && err != errVerifyBusy {
+			return false, err
+		} else if db.Pos() != pos {
+			return false, nil
+		}
+		pgno = next
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	if chksum = ltx.ChecksumFlag | chksum; chksum != pos.Chksum {
+		db.store.recordChecksumMismatch(db.id, pos.TXID)
+		return true, fmt.Errorf("%w: db=%s tx=%s checksum=%016x, expected %016x",
+			ErrChecksumMismatch, FormatDBID(db.id), ltx.FormatTXID(pos.TXID), chksum, pos.Chksum)
+	}
+	return true, nil
+}
+
+// applyLTX copies the pages from the LTX file at path into dbf & truncates it
+// to the commit size. The file is not synced. Lock must be held.
+func (db *DB) applyLTX(dbf *os.File, path string) (ltx.Header, error) {
+	// Open LTX header reader.
+	f, err := openLTXFile(path)
+	if err != nil {
+		return ltx.Header{}, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	var hdr ltx.Header
+	hr := ltx.NewHeaderBlockReader(f)
+	if err := hr.ReadHeader(&hdr); err != nil {
+		return hdr, fmt.Errorf("read header: %s", err)
+	}
+
+	// Ensure the primary is using the same page size as this replica.
+	if err := db.validatePageSize(hdr.PageSize); err != nil {
+		return hdr, err
+	}
+
+	// Open page block reader on the same file, starting after the header block.
+	pf := io.NewSectionReader(f, int64(hdr.HeaderBlockSize()), math.MaxInt64-int64(hdr.HeaderBlockSize()))
+	pr := ltx.NewPageBlockReader(pf, hdr.PageN, hdr.PageSize, hdr.PageBlockChecksum)
+	pageBuf := make([]byte, hdr.PageSize)
+	for i := uint32(0); i < hdr.PageN; i++ {
+		// Read pgno & page data from LTX file.
+		var phdr ltx.PageHeader
+		if err := hr.ReadPageHeader(&phdr); err != nil {
+			return hdr, fmt.Errorf("read page header[%d]: %w", i, err)
+		} else if _, err := io.ReadFull(pr, pageBuf); err != nil {
+			return hdr, fmt.Errorf("read page data[%d]: %w", i, err)
+		}
+
+		// Copy to database file.
+		offset := int64(phdr.Pgno-1) * int64(hdr.PageSize)
+		if _, err := dbf.WriteAt(pageBuf, offset); err != nil {
+			return hdr, fmt.Errorf("write to database file: %w", err)
+		}
+
+		// Invalidate page cache.
+		if err := db.invalidate(offset, int64(len(pageBuf))); err != nil {
+			return hdr, fmt.Errorf("invalidate db: %w", err)
+		}
+	}
+
+	// Truncate database file to size after LTX file.
+	fi, err := dbf.Stat()
+	if err != nil {
+		return hdr, fmt.Errorf("stat database file: %w", err)
+	}
+	size := int64(hdr.Commit) * int64(hdr.PageSize)
+	if err := dbf.Truncate(size); err != nil {
+		return hdr, fmt.Errorf("truncate database file: %w", err)
+	}
+
+	// Invalidate the page cache for pages removed by truncation, such as after
+	// a VACUUM on the primary, so the kernel does not serve stale pages.
+	if fi.Size() > size {
+		if err := db.invalidate(size, fi.Size()-size); err != nil {
+			return hdr, fmt.Errorf("invalidate truncated pages: %w", err)
+		}
+	}
+
+	return hdr, nil
+}
+
+// invalidate invalidates a range of the database in the page cache, if the
+// store has an invalidator. The number & duration of calls are tracked so
+// read latency after large transactions can be correlated with them.
+func (db *DB) invalidate(offset, size int64) error {
+	invalidator := db.store.Invalidator
+	if invalidator == nil {
+		return nil
+	}
+
+	t := time.Now()
+	err := invalidator.InvalidateDB(db, offset, size)
+	invalidateCountMetric.Inc()
+	invalidateSecondsMetric.Observe(time.Since(t).Seconds())
+	return err
+}
+
+// SyncedPos returns the last position known to be fsynced to the database
+// file. This trails Pos() on replicas that do not fsync every transaction.
+func (db *DB) SyncedPos() Pos {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.syncedPos
+}
+
+// markSynced records that the database file has been fsynced through pos.
+// This releases any commits held by the write buffer. Lock must be held.
+func (db *DB) markSynced(pos Pos) {
+	db.syncedPos, db.syncedAt, db.syncedPosWritten = pos, time.Now(), false
+	db.clearWriteBuffer()
+}
+
+// clearWriteBuffer resets the number of buffered commits. Lock must be held.
+func (db *DB) clearWriteBuffer() {
+	writeBufferDepthMetric.Sub(float64(db.bufferedN))
+	db.bufferedN = 0
+}
+
+// BufferedN returns the number of commits on the primary that have not yet
+// been fsynced to the database file because of the write buffer.
+func (db *DB) BufferedN() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.bufferedN
+}
+
+// FlushWriteBuffer fsyncs the database file if it has buffered commits so
+// that they are durable. The lock is not held during the fsync so that
+// writes can continue.
+func (db *DB) FlushWriteBuffer() error {
+	db.mu.Lock()
+	pos, n := db.pos, db.bufferedN
+	db.mu.Unlock()
+	if n == 0 {
+		return nil
+	}
+
+	f, err := os.Open(db.DatabasePath())
+	if err != nil {
+		return fmt.Errorf("open database file: %w", err)
+	}
+	defer f.Close()
+
+	if err := db.store.FsyncFunc(f); err != nil {
+		return fmt.Errorf("sync database file: %w", err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Skip if another sync or a reset occurred while the file was synced.
+	if db.syncedPos.TXID >= pos.TXID || db.bufferedN < n {
+		return nil
+	}
+
+	// Commits made during the fsync remain buffered & keep the newly synced
+	// position recorded on disk.
+	db.syncedPos, db.syncedAt, db.syncedPosWritten = pos, time.Now(), false
+	writeBufferDepthMetric.Sub(float64(n))
+	db.bufferedN -= n
+	if db.bufferedN > 0 {
+		return db.writeSyncedPos()
+	}
+	if err := os.Remove(db.SyncedPosPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SyncedPosPath returns the path to the file that records the last synced
+// position while the database file has unsynced transactions.
+func (db *DB) SyncedPosPath() string {
+	return filepath.Join(db.path, "synced")
+}
+
+// writeSyncedPos durably records the last synced position, if it has not
+// already been written since the last sync. Lock must be held.
+func (db *DB) writeSyncedPos() error {
+	if db.syncedPosWritten {
+		return nil
+	}
+
+	tmpPath := db.SyncedPosPath() + ".tmp"
+	defer os.Remove(tmpPath)
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	db.dirtyPageSet = make(map[uint32]struct{})
+	if _, err := f.WriteString(ltx.FormatTXID(db.syncedPos.TXID)); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	} else if err := os.Rename(tmpPath, db.SyncedPosPath()); err != nil {
+		return err
+	} else if err := internal.Sync(db.path); err != nil {
+		return err
+	}
 
+	db.syncedPosWritten = true
 	return nil
 }
 
-// TryApplyLTX attempts to apply an LTX file to the database.
-func (db *DB) TryApplyLTX(path string) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// recoverSyncedPos replays LTX files applied after the last synced position
+// if the database was not fsynced before the process stopped. The database
+// file is then synced & the recorded position is removed.
+func (db *DB) recoverSyncedPos() error {
+	buf, err := os.ReadFile(db.SyncedPosPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
 
-	// TODO: Obtain RESERVED lock.
+	txID, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 16, 64)
+	if err != nil {
+		return fmt.Errorf("parse synced txid: %w", err)
+	}
+
+	// Replay LTX files, in order, that contain transactions after txID.
+	ents, err := os.ReadDir(db.LTXDir())
+	if err != nil {
+		return err
+	}
 
-	// Open database file for writing.
 	dbf, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
 	if err != nil {
 		return fmt.Errorf("open database file: %w", err)
 	}
 	defer dbf.Close()
 
-	// Open LTX header reader.
-	hf, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+	for _, ent := range ents {
+		minTXID, maxTXID, err := parseLTXFilename(ent.Name())
+		if err != nil || maxTXID <= txID {
+			continue
+		} else if minTXID > txID+1 {
+			return fmt.Errorf("missing ltx file for tx %s", ltx.FormatTXID(txID+1))
+		}
+
+		log.Printf("replaying unsynced ltx file: db=%s tx=%s", FormatDBID(db.id), ltx.FormatTXIDRange(minTXID, maxTXID))
+		if _, err := db.applyLTX(dbf, filepath.Join(db.LTXDir(), ent.Name())); err != nil {
+			return fmt.Errorf("replay ltx (%s): %w", ent.Name(), err)
+		}
+		txID = maxTXID
 	}
-	defer hf.Close()
 
-	var hdr ltx.Header
-	hr := ltx.NewHeaderBlockReader(hf)
-	if err := hr.ReadHeader(&hdr); err != nil {
-		return fmt.Errorf("read header: %s", err)
+	if err := dbf.Sync(); err != nil {
+		return fmt.Errorf("sync database file: %w", err)
+	} else if err := os.Remove(db.SyncedPosPath()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeSnapshot writes an LTX snapshot of every page in dbFile that brings
+// the database from an empty state to pos. The snapshot lets the database
+// recover its position on open without the LTX files before it.
+func (db *DB) writeSnapshot(dbFile *os.File, pageSize, commit uint32, pos Pos) error {
+	hdr := ltx.Header{
+		Version:      1,
+		PageSize:     pageSize,
+		PageN:        commit,
+		Commit:       commit,
+		DBID:         db.id,
+		MinTXID:      1,
+		MaxTXID:      pos.TXID,
+		PostChecksum: pos.Chksum,
 	}
 
-	// TODO: Verify pre-checksum matches.
+	ltxPath := db.LTXPath(hdr.MinTXID, hdr.MaxTXID)
+	tmpPath := ltxPath + ".tmp"
+	defer os.Remove(tmpPath)
+
+	hf, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("cannot create LTX file: %w", err)
+	}
+	defer hf.Close()
 
-	// Open page block reader.
-	pf, err := os.Open(path)
+	pf, err := os.OpenFile(tmpPath, os.O_RDWR, 0666)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		return fmt.Errorf("cannot open LTX page block for writing: %w", err)
 	}
 	defer pf.Close()
 
-	if _, err := pf.Seek(int64(hdr.HeaderBlockSize()), io.SeekStart); err != nil {
-		return fmt.Errorf("seek to page block: %w", err)
+	if _, err := pf.Seek(hdr.HeaderBlockSize(), io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek to page block: %w", err)
 	}
 
-	pr := ltx.NewPageBlockReader(pf, hdr.PageN, hdr.PageSize, hdr.PageBlockChecksum)
-	pageBuf := make([]byte, hdr.PageSize)
-	for i := uint32(0); i < hdr.PageN; i++ {
-		// Read pgno & page data from LTX file.
-		var phdr ltx.PageHeader
-		if err := hr.ReadPageHeader(&phdr); err != nil {
-			return fmt.Errorf("read page header[%d]: %w", i, err)
-		} else if _, err := io.ReadFull(pr, pageBuf); err != nil {
-			return fmt.Errorf("read page data[%d]: %w", i, err)
-		}
+	hw := ltx.NewHeaderBlockWriter(hf)
+	if err := hw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("cannot write header: %s", err)
+	}
+	pw := ltx.NewPageBlockWriter(pf, hdr.PageN, hdr.PageSize)
 
-		// Copy to database file.
-		offset := int64(phdr.Pgno-1) * int64(hdr.PageSize)
-		if _, err := dbf.WriteAt(pageBuf, offset); err != nil {
-			return fmt.Errorf("write to database file: %w", err)
+	buf := make([]byte, pageSize)
+	for pgno := uint32(1); pgno <= commit; pgno++ {
+		if _, err := dbFile.ReadAt(buf, int64(pgno-1)*int64(pageSize)); err != nil {
+			return fmt.Errorf("cannot read database page: pgno=%d err=%w", pgno, err)
 		}
 
-		// Invalidate page cache.
-		if invalidator := db.store.Invalidator; invalidator != nil {
-			if err := invalidator.InvalidateDB(db, offset, int64(len(pageBuf))); err != nil {
-				return fmt.Errorf("invalidate db: %w", err)
-			}
+		if err := hw.WritePageHeader(ltx.PageHeader{Pgno: pgno}); err != nil {
+			return fmt.Errorf("cannot write page header: pgno=%d err=%w", pgno, err)
+		} else if _, err := pw.Write(buf); err != nil {
+			return fmt.Errorf("cannot write page data: pgno=%d err=%w", pgno, err)
 		}
 	}
 
-	// Truncate database file to size after LTX file.
-	if err := dbf.Truncate(int64(hdr.Commit) * int64(hdr.PageSize)); err != nil {
-		return fmt.Errorf("truncate database file: %w", err)
+	hw.SetPageBlockChecksum(pw.Checksum())
+	if err := pw.Close(); err != nil {
+		return fmt.Errorf("close page block writer: %s", err)
+	} else if err := hw.Close(); err != nil {
+		return fmt.Errorf("close header block writer: %s", err)
+	} else if err := pf.Sync(); err != nil {
+		return fmt.Errorf("sync ltx file: %w", err)
 	}
 
-	// Sync changes to disk.
-	if err := dbf.Sync(); err != nil {
-		return fmt.Errorf("sync database file: %w", err)
-	}
+	return os.Rename(tmpPath, ltxPath)
+}
 
-	// Update transaction for database.
-	db.pos = Pos{
-		TXID:   hdr.MaxTXID,
-		Chksum: hdr.PostChecksum,
+// validatePageSize returns ErrPageSizeMismatch if pageSize does not match the
+// known page size of the database or the page size pinned by the store.
+func (db *DB) validatePageSize(pageSize uint32) error {
+	if db.pageSize != 0 && pageSize != db.pageSize {
+		return fmt.Errorf("%w: got %d, database uses %d", ErrPageSizeMismatch, pageSize, db.pageSize)
+	} else if db.store.PageSize != 0 && pageSize != db.store.PageSize {
+		return fmt.Errorf("%w: got %d, expected %d", ErrPageSizeMismatch, pageSize, db.store.PageSize)
 	}
-
-	// Notify store of database change.
-	db.store.MarkDirty(db.id)
-
 	return nil
 }
 
@@ -519,6 +1718,18 @@ func buildJournalPageMap(f *os.File) (map[uint32]uint64, error) {
 	}
 }
 
+// readJournalInitialSize returns the size of the database, in pages, when the
+// rollback journal was created. This is read from the first journal header.
+func readJournalInitialSize(f *os.File) (uint32, error) {
+	buf := make([]byte, len(SQLITE_JOURNAL_HEADER_STRING)+12)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return 0, err
+	} else if string(buf[:len(SQLITE_JOURNAL_HEADER_STRING)]) != SQLITE_JOURNAL_HEADER_STRING {
+		return 0, errInvalidJournalHeader
+	}
+	return binary.BigEndian.Uint32(buf[len(SQLITE_JOURNAL_HEADER_STRING)+8:]), nil
+}
+
 /// Reads a journal header and subsequent pages.
 ///
 /// Returns true if the end-of-file was reached. Function should be called
@@ -580,6 +1791,84 @@ func buildJournalPageMapFromSegment(f *os.File, m map[uint32]uint64) error {
 	return nil
 }
 
+// rollbackJournal copies the original pages in the rollback journal jf back
+// into the database file dbf & truncates it to its size before the transaction.
+// Playback stops at the first incomplete frame or frame with an invalid
+// checksum, as SQLite does. Returns the number of pages restored.
+func rollbackJournal(dbf, jf *os.File) (int, error) {
+	var offset int64
+	var initialSize, pageSize uint32
+	restored := make(map[uint32]struct{})
+
+	hdr := make([]byte, len(SQLITE_JOURNAL_HEADER_STRING)+20)
+	for i := 0; ; i++ {
+		if _, err := jf.ReadAt(hdr, offset); err != nil || string(hdr[:len(SQLITE_JOURNAL_HEADER_STRING)]) != SQLITE_JOURNAL_HEADER_STRING {
+			break // no more segments
+		}
+
+		fields := hdr[len(SQLITE_JOURNAL_HEADER_STRING):]
+		pageN := binary.BigEndian.Uint32(fields[0:])
+		nonce := binary.BigEndian.Uint32(fields[4:])
+		sectorSize := binary.BigEndian.Uint32(fields[12:])
+		if i == 0 {
+			initialSize = binary.BigEndian.Uint32(fields[8:])
+			pageSize = binary.BigEndian.Uint32(fields[16:])
+		}
+		if sectorSize == 0 || pageSize == 0 {
+			return 0, fmt.Errorf("invalid journal header: segment=%d", i)
+		}
+		offset += int64(sectorSize)
+
+		// Page count may be -1 to read all frames to the end of the file.
+		frame := make([]byte, 4+pageSize+4)
+		var done bool
+		for j := uint32(0); pageN == 0xFFFFFFFF || j < pageN; j++ {
+			if _, err := jf.ReadAt(frame, offset); err != nil {
+				done = true
+				break
+			}
+			pgno := binary.BigEndian.Uint32(frame[0:])
+			data := frame[4 : 4+pageSize]
+			if pgno == 0 || binary.BigEndian.Uint32(frame[4+pageSize:]) != journalChecksum(nonce, data) {
+				done = true
+				break
+			}
+			offset += int64(len(frame))
+
+			// Only the first copy of a page holds its original content.
+			if _, ok := restored[pgno]; ok {
+				continue
+			}
+			if _, err := dbf.WriteAt(data, int64(pgno-1)*int64(pageSize)); err != nil {
+				return 0, fmt.Errorf("write database page: pgno=%d err=%w", pgno, err)
+			}
+			restored[pgno] = struct{}{}
+		}
+		if done {
+			break
+		}
+		offset = nextMultipleOf(offset, int64(sectorSize))
+	}
+
+	if pageSize != 0 {
+		if err := dbf.Truncate(int64(initialSize) * int64(pageSize)); err != nil {
+			return 0, fmt.Errorf("truncate database file: %w", err)
+		}
+	}
+	return len(restored), nil
+}
+
+// journalChecksum returns the SQLite checksum of a journal frame's page data.
+//
+// See: https://www.sqlite.org/fileformat.html#the_rollback_journal
+func journalChecksum(nonce uint32, data []byte) uint32 {
+	chksum := nonce
+	for i := len(data) - 200; i > 0; i -= 200 {
+		chksum += uint32(data[i])
+	}
+	return chksum
+}
+
 // nextMultipleOf returns the next multiple of denom based on v.
 // Returns v if it is a multiple of denom.
 func nextMultipleOf(v, denom int64) int64 {
@@ -590,16 +1879,29 @@ func nextMultipleOf(v, denom int64) int64 {
 	return v + (denom - mod)
 }
 
-// readLTXFileHeader reads and unmarshals the header from an LTX file.
+// readLTXFileHeader reads and unmarshals the header from an LTX file. Falls
+// back to the compressed copy of the file if the original does not exist.
 func readLTXFileHeader(filename string) (ltx.Header, error) {
 	f, err := os.Open(filename)
+	if os.IsNotExist(err) && !strings.HasSuffix(filename, CompressedLTXExt) {
+		f, err = os.Open(filename + CompressedLTXExt)
+	}
 	if err != nil {
 		return ltx.Header{}, err
 	}
 	defer f.Close()
 
+	var r io.Reader = f
+	if strings.HasSuffix(f.Name(), CompressedLTXExt) {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return ltx.Header{}, fmt.Errorf("gzip: %w", err)
+		}
+		r = zr
+	}
+
 	buf := make([]byte, ltx.HeaderSize)
-	if _, err := io.ReadFull(f, buf); err != nil {
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return ltx.Header{}, err
 	}
 
@@ -608,6 +1910,94 @@ func readLTXFileHeader(filename string) (ltx.Header, error) {
 	return hdr, err
 }
 
+// CompressedLTXExt is appended to the name of an LTX file compressed in the
+// data directory.
+const CompressedLTXExt = ".gz"
+
+// parseLTXFilename parses the TXID range from the name of an LTX file, which
+// may be compressed.
+func parseLTXFilename(name string) (minTXID, maxTXID uint64, err error) {
+	return ltx.ParseFilename(strings.TrimSuffix(name, CompressedLTXExt))
+}
+
+// openLTXFile opens the LTX file at filename. If only a compressed copy of the
+// file exists then it is decompressed into an unlinked temporary file which is
+// returned instead so callers can seek & stat it like the original.
+func openLTXFile(filename string) (*os.File, error) {
+	if !strings.HasSuffix(filename, CompressedLTXExt) {
+		f, err := os.Open(filename)
+		if !os.IsNotExist(err) {
+			return f, err
+		}
+		filename += CompressedLTXExt
+	}
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), "decompress-*")
+	if err != nil {
+		return nil, err
+	} else if err := os.Remove(f.Name()); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if _, err := io.Copy(f, zr); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("decompress: %w", err)
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// compressLTXFile writes a gzip-compressed copy of the LTX file at filename &
+// then removes the original. Readers fall back to the compressed copy so the
+// file remains readable throughout.
+func compressLTXFile(filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dstPath := filename + CompressedLTXExt
+	tmpPath := dstPath + ".tmp"
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		return fmt.Errorf("compress: %w", err)
+	} else if err := zw.Close(); err != nil {
+		return fmt.Errorf("close gzip writer: %w", err)
+	} else if err := dst.Sync(); err != nil {
+		return err
+	} else if err := dst.Close(); err != nil {
+		return err
+	} else if err := os.Rename(tmpPath, dstPath); err != nil {
+		return err
+	} else if err := internal.Sync(filepath.Dir(filename)); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
 // TrimName removes "-journal", "-shm" or "-wal" from the given name.
 func TrimName(name string) string {
 	if suffix := "-journal"; strings.HasSuffix(name, suffix) {
@@ -668,7 +2058,235 @@ func isRollbackJournalEnabled(b []byte) bool {
 	return b[18] == 1 || b[19] == 1
 }
 
+// parseDatabasePageSize returns the page size from a SQLite database header.
+// A stored value of 1 represents a page size of 65536.
+func parseDatabasePageSize(b []byte) uint32 {
+	if n := binary.BigEndian.Uint16(b[16:18]); n != 1 {
+		return uint32(n)
+	}
+	return 65536
+}
+
+// checksumDatabaseFile returns the page size, page count, and LTX checksum
+// of the SQLite database file f. The checksum matches the post-checksum that
+// the primary records for the transaction that produced the same contents.
+func checksumDatabaseFile(f *os.File) (pageSize, commit uint32, chksum uint64, err error) {
+	hdr := make([]byte, databaseHeaderSize)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return 0, 0, 0, fmt.Errorf("read database header: %w", err)
+	}
+	pageSize = parseDatabasePageSize(hdr)
+	commit = binary.BigEndian.Uint32(hdr[SQLITE_DATABASE_SIZE_OFFSET:])
+
+	buf := make([]byte, pageSize)
+	for pgno := uint32(1); pgno <= commit; pgno++ {
+		if _, err := f.ReadAt(buf, int64(pgno-1)*int64(pageSize)); err != nil {
+			return 0, 0, 0, fmt.Errorf("read database page: pgno=%d err=%w", pgno, err)
+		}
+		chksum ^= ltx.ChecksumPage(pgno, buf)
+	}
+	return pageSize, commit, ltx.ChecksumFlag | chksum, nil
+}
+
+// checksumRolledBackDatabaseFile returns the checksum of the database file
+// after a journal rollback. A database that was created by the rolled back
+// transaction is empty & has the checksum of an empty database.
+func checksumRolledBackDatabaseFile(f *os.File) (uint64, error) {
+	if fi, err := f.Stat(); err != nil {
+		return 0, err
+	} else if fi.Size() == 0 {
+		return ltx.ChecksumFlag, nil
+	}
+	_, _, chksum, err := checksumDatabaseFile(f)
+	return chksum, err
+}
+
+// readDatabasePageSize returns the page size from the header of the database
+// file at path. Returns zero if the file does not exist or has no header yet.
+func readDatabasePageSize(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, databaseHeaderSize)
+	if _, err := io.ReadFull(f, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return parseDatabasePageSize(buf), nil
+}
+
 // SQLite constants
 const (
 	databaseHeaderSize = 100
 )
+
+// Writer represents the node that wrote a run of consecutive transactions to a
+// database, starting at MinTXID & continuing until the next writer's MinTXID.
+type Writer struct {
+	NodeID  string
+	MinTXID uint64
+}
+
+// WritersPath returns the path to the file that records the writer history.
+func (db *DB) WritersPath() string {
+	return filepath.Join(db.path, "writers")
+}
+
+// LastWriter returns the node that wrote the most recent transactions.
+// Returns a zero value if the writer is not known.
+func (db *DB) LastWriter() Writer {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.writers) == 0 {
+		return Writer{}
+	}
+	return db.writers[len(db.writers)-1]
+}
+
+// WriterAt returns the ID of the node that wrote the transaction. Returns a
+// blank string if the writer is not known, such as for transactions written
+// before the oldest recorded writer.
+func (db *DB) WriterAt(txID uint64) string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i := len(db.writers) - 1; i >= 0; i-- {
+		if w := db.writers[i]; w.MinTXID <= txID {
+			return w.NodeID
+		}
+	}
+	return ""
+}
+
+// RecordWriter records that nodeID wrote the transactions starting at minTXID.
+// Replicas record the writer sent by the primary with each transaction.
+func (db *DB) RecordWriter(nodeID string, minTXID uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.recordWriter(nodeID, minTXID)
+}
+
+// recordWriter appends nodeID to the writer history if it differs from the
+// last writer. Any writers from minTXID onward are replaced, such as after a
+// snapshot is applied. Lock must be held.
+func (db *DB) recordWriter(nodeID string, minTXID uint64) error {
+	if nodeID == "" {
+		return nil
+	}
+
+	// Determine the writers that remain before minTXID.
+	n := len(db.writers)
+	for n > 0 && db.writers[n-1].MinTXID >= minTXID {
+		n--
+	}
+	if n == len(db.writers) && n > 0 && db.writers[n-1].NodeID == nodeID {
+		return nil // unchanged
+	}
+
+	writers := append([]Writer(nil), db.writers[:n]...)
+	if n == 0 || writers[n-1].NodeID != nodeID {
+		writers = append(writers, Writer{NodeID: nodeID, MinTXID: minTXID})
+	}
+	if n := len(writers); n > WriterHistorySize {
+		writers = writers[n-WriterHistorySize:]
+	}
+
+	if err := db.writeWriters(writers); err != nil {
+		return err
+	}
+	db.writers = writers
+	return nil
+}
+
+// writeWriters atomically replaces the writer history file.
+func (db *DB) writeWriters(writers []Writer) error {
+	var buf bytes.Buffer
+	for _, w := range writers {
+		fmt.Fprintf(&buf, "%s %s\n", ltx.FormatTXID(w.MinTXID), w.NodeID)
+	}
+
+	tmpPath := db.WritersPath() + ".tmp"
+	defer os.Remove(tmpPath)
+
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, db.WritersPath())
+}
+
+// readWriters reads the writer history from disk. Writers of transactions
+// after the current position, such as those lost in a crash, are ignored.
+func (db *DB) readWriters() error {
+	buf, err := os.ReadFile(db.WritersPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	db.writers = nil
+	for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+		if line == "" {
+			continue
+		}
+
+		txIDStr, nodeID, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("invalid writer: %q", line)
+		}
+		txID, err := strconv.ParseUint(txIDStr, 16, 64)
+		if err != nil {
+			return fmt.Errorf("parse writer txid: %w", err)
+		} else if txID > db.pos.TXID {
+			break
+		}
+		db.writers = append(db.writers, Writer{NodeID: nodeID, MinTXID: txID})
+	}
+	return nil
+}
+
+// CatchUp represents the progress of a replica applying transactions to reach
+// the primary's position, such as when a new replica syncs a large database.
+type CatchUp struct {
+	StartTXID   uint64    // applied TXID when catch-up began
+	TXID        uint64    // currently applied TXID
+	TargetTXID  uint64    // primary TXID being caught up to
+	StartedAt   time.Time // time catch-up began
+	CompletedAt time.Time // time target was reached, if complete
+}
+
+// Percent returns the percentage of transactions applied, from 0 to 100.
+func (c CatchUp) Percent() float64 {
+	if c.TXID >= c.TargetTXID {
+		return 100
+	}
+	return float64(c.TXID-c.StartTXID) / float64(c.TargetTXID-c.StartTXID) * 100
+}
+
+// Rate returns the number of transactions applied per second.
+func (c CatchUp) Rate() float64 {
+	end := c.CompletedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	elapsed := end.Sub(c.StartedAt).Seconds()
+	if elapsed <= 0 || c.TXID <= c.StartTXID {
+		return 0
+	}
+	return float64(c.TXID-c.StartTXID) / elapsed
+}
+
+// ETA returns the estimated time until the target is reached. Returns zero if
+// complete or if no transactions have been applied yet.
+func (c CatchUp) ETA() time.Duration {
+	rate := c.Rate()
+	if c.TXID >= c.TargetTXID || rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(c.TargetTXID-c.TXID) / rate * float64(time.Second))
+}
@@ -1,13 +1,24 @@
 package litefs_test
 
 import (
-	// "path/filepath"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"os"
-	// "reflect"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/superfly/litefs"
-	// "github.com/superfly/litefs/internal/testingutil"
+	"github.com/superfly/litefs/internal/testingutil"
+	"github.com/superfly/ltx"
 )
 
 /*
@@ -18,6 +29,896 @@ func TestDB(t *testing.T) {
 }
 */
 
+// Ensure a replica refuses to apply transactions with a different page size.
+func TestDB_TryApplyLTX_PageSizeMismatch(t *testing.T) {
+	primaryDB, _ := newDB(t, "db")
+	testingutil.MustWriteTx(t, primaryDB, 1, 1, 0)
+
+	t.Run("Pinned", func(t *testing.T) {
+		store := newStore(t)
+		store.PageSize = 8192
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		db, f, err := store.CreateDB("db")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.TryApplyLTX(primaryDB.LTXPath(1, 1)); !errors.Is(err, litefs.ErrPageSizeMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := db.TXID(), uint64(0); got != want {
+			t.Fatalf("TXID=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("DatabaseHeader", func(t *testing.T) {
+		store := newOpenStore(t)
+		db, f, err := store.CreateDB("db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Write a header with a 1024-byte page size & reopen to detect it.
+		page := make([]byte, 1024)
+		copy(page, "SQLite format 3\x00")
+		page[16], page[17] = 0x04, 0x00
+		if _, err := f.Write(page); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		store = litefs.NewStore(store.Path())
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		db = store.DB(db.ID())
+		if err := db.TryApplyLTX(primaryDB.LTXPath(1, 1)); !errors.Is(err, litefs.ErrPageSizeMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure a transaction that does not follow the replica's state is rejected &
+// the mismatch is recorded.
+func TestDB_TryApplyLTX_ChecksumMismatch(t *testing.T) {
+	primaryDB, _ := newDB(t, "db")
+	testingutil.MustWriteTx(t, primaryDB, 1, 1, 1)
+	testingutil.MustWriteTx(t, primaryDB, 1, 1, 2)
+
+	// Diverge the replica by writing a different first transaction.
+	store := newOpenStore(t)
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 9)
+
+	prev := counterValue(t, "litefs_checksum_mismatch_total")
+	if err := db.TryApplyLTX(primaryDB.LTXPath(2, 2)); !errors.Is(err, litefs.ErrChecksumMismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if got, want := db.TXID(), uint64(1); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+
+	if got, want := counterValue(t, "litefs_checksum_mismatch_total"), prev+1; got != want {
+		t.Fatalf("counter=%v, want %v", got, want)
+	}
+	n, last := store.ChecksumMismatches()
+	if got, want := n, uint64(1); got != want {
+		t.Fatalf("n=%d, want %d", got, want)
+	} else if got, want := last.DBID, db.ID(); got != want {
+		t.Fatalf("DBID=%d, want %d", got, want)
+	} else if got, want := last.TXID, uint64(2); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	} else if last.DetectedAt.IsZero() {
+		t.Fatal("expected detection time")
+	}
+}
+
+// Ensure a database cannot grow beyond its size limit while existing pages
+// can still be updated & removed.
+func TestDB_WriteDatabase_MaxDatabaseBytes(t *testing.T) {
+	store := newStore(t)
+	store.MaxDatabaseBytes = 3 * 4096
+	store.MaxDatabaseBytesOverrides = map[string]int64{"big.db": 0}
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Grow the database to the limit.
+	for i := uint32(1); i <= 3; i++ {
+		testingutil.MustWriteTx(t, db, i, i, byte(i))
+	}
+
+	// Growing past the limit is rejected.
+	if err := db.WriteDatabase(f, make([]byte, 4096), 3*4096); err != litefs.ErrDatabaseTooLarge {
+		t.Fatalf("unexpected error: %v", err)
+	} else if fi, err := f.Stat(); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Size(), int64(3*4096); got != want {
+		t.Fatalf("size=%d, want %d", got, want)
+	}
+
+	// Updates & deletes within the limit still succeed.
+	testingutil.MustWriteTx(t, db, 2, 3, 9)
+	testingutil.MustWriteTx(t, db, 1, 2, 10)
+	if got, want := db.TXID(), uint64(5); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+
+	// Overridden databases may grow without a limit.
+	bigDB, bigF, err := store.CreateDB("big.db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := bigF.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= 4; i++ {
+		testingutil.MustWriteTx(t, bigDB, i, i, byte(i))
+	}
+}
+
+// counterValue returns the current value of the named Prometheus counter.
+func counterValue(tb testing.TB, name string) float64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name && len(mf.GetMetric()) > 0 {
+			return mf.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// histogramCount returns the number of samples in the named Prometheus histogram.
+func histogramCount(tb testing.TB, name string) uint64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name && len(mf.GetMetric()) > 0 {
+			return mf.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+// Ensure every page of an applied transaction is invalidated in the page cache
+// & that the invalidations are counted.
+func TestDB_TryApplyLTX_InvalidateMetrics(t *testing.T) {
+	primaryDB, _ := newDB(t, "db")
+	pgnos := []uint32{1, 2, 3, 4, 5, 6, 7, 8}
+	testingutil.MustWriteTxPages(t, primaryDB, pgnos, uint32(len(pgnos)), 1)
+
+	var invalidator countingInvalidator
+	store := newStore(t)
+	store.Invalidator = &invalidator
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	countN := counterValue(t, "litefs_invalidate_total")
+	sampleN := histogramCount(t, "litefs_invalidate_seconds")
+	mustApplyLTX(t, db, primaryDB.LTXPath(1, 1))
+
+	if got, want := invalidator.n, len(pgnos); got != want {
+		t.Fatalf("invalidations=%d, want %d", got, want)
+	} else if got, want := counterValue(t, "litefs_invalidate_total")-countN, float64(len(pgnos)); got != want {
+		t.Fatalf("litefs_invalidate_total=%v, want %v", got, want)
+	} else if got, want := histogramCount(t, "litefs_invalidate_seconds")-sampleN, uint64(len(pgnos)); got != want {
+		t.Fatalf("litefs_invalidate_seconds count=%d, want %d", got, want)
+	}
+}
+
+// countingInvalidator counts the database invalidations it receives.
+type countingInvalidator struct {
+	n int
+}
+
+func (inv *countingInvalidator) InvalidateDB(db *litefs.DB, offset, size int64) error {
+	inv.n++
+	return nil
+}
+
+func (inv *countingInvalidator) InvalidateEntry(name string) error { return nil }
+func (inv *countingInvalidator) InvalidatePrimary() error          { return nil }
+
+// Ensure a replica only fsyncs applied transactions once per interval and
+// replays unsynced transactions from LTX files after a crash.
+func TestDB_TryApplyLTX_FsyncPolicy(t *testing.T) {
+	primaryDB, _ := newDB(t, "db")
+	for i := uint32(1); i <= 5; i++ {
+		testingutil.MustWriteTx(t, primaryDB, i, i, byte(i))
+	}
+
+	t.Run("Always", func(t *testing.T) {
+		db, _ := newDB(t, "db")
+		for i := uint64(1); i <= 5; i++ {
+			mustApplyLTX(t, db, primaryDB.LTXPath(i, i))
+			if got, want := db.SyncedPos(), db.Pos(); got != want {
+				t.Fatalf("SyncedPos=%#v, want %#v", got, want)
+			}
+		}
+	})
+
+	t.Run("Interval", func(t *testing.T) {
+		store := newStore(t)
+		store.ReplicaFsyncPolicy = litefs.FsyncPolicyInterval
+		store.ReplicaFsyncInterval = time.Hour
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		db, f, err := store.CreateDB("db")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The first transaction is synced as there has been no prior fsync.
+		mustApplyLTX(t, db, primaryDB.LTXPath(1, 1))
+		syncedPos := db.Pos()
+		synced, err := os.ReadFile(db.DatabasePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Remaining transactions are applied without an fsync.
+		for i := uint64(2); i <= 5; i++ {
+			mustApplyLTX(t, db, primaryDB.LTXPath(i, i))
+		}
+		if got, want := db.Pos(), primaryDB.Pos(); got != want {
+			t.Fatalf("Pos=%#v, want %#v", got, want)
+		} else if got, want := db.SyncedPos(), syncedPos; got != want {
+			t.Fatalf("SyncedPos=%#v, want %#v", got, want)
+		} else if _, err := os.Stat(db.SyncedPosPath()); err != nil {
+			t.Fatal(err)
+		}
+
+		// Simulate a crash by reverting the database file to its last synced state.
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(db.DatabasePath(), synced, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		// Reopen & ensure the unsynced transactions were replayed.
+		store = litefs.NewStore(store.Path())
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		db = store.DB(db.ID())
+		if got, want := db.Pos(), primaryDB.Pos(); got != want {
+			t.Fatalf("Pos=%#v, want %#v", got, want)
+		} else if got, want := db.SyncedPos(), primaryDB.Pos(); got != want {
+			t.Fatalf("SyncedPos=%#v, want %#v", got, want)
+		} else if _, err := os.Stat(db.SyncedPosPath()); !os.IsNotExist(err) {
+			t.Fatalf("expected synced position file to be removed: %v", err)
+		}
+
+		if got, err := os.ReadFile(db.DatabasePath()); err != nil {
+			t.Fatal(err)
+		} else if want, err := os.ReadFile(primaryDB.DatabasePath()); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, want) {
+			t.Fatal("database mismatch after recovery")
+		}
+	})
+}
+
+// Ensure the write buffer lowers commit latency during a burst of writes &
+// that the buffered commits are fsynced within the window.
+func TestDB_CommitJournal_WriteBuffer(t *testing.T) {
+	// commitBurst returns how long it takes to commit a burst of transactions
+	// when every fsync of the database file is slow.
+	commitBurst := func(tb testing.TB, window time.Duration) (*litefs.DB, *int32, time.Duration) {
+		var fsyncN int32
+		store := newStore(tb)
+		store.WriteBufferWindow = window
+		store.FsyncFunc = func(f *os.File) error {
+			if filepath.Ext(f.Name()) == ".ltx" {
+				return f.Sync()
+			}
+			atomic.AddInt32(&fsyncN, 1)
+			time.Sleep(20 * time.Millisecond)
+			return f.Sync()
+		}
+		if err := store.Open(); err != nil {
+			tb.Fatal(err)
+		}
+		db, f, err := store.CreateDB("db")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { _ = f.Close() })
+
+		start := time.Now()
+		for i := uint32(1); i <= 10; i++ {
+			testingutil.MustWriteTx(tb, db, i, i, byte(i))
+		}
+		return db, &fsyncN, time.Since(start)
+	}
+
+	_, _, unbuffered := commitBurst(t, 0)
+	db, fsyncN, buffered := commitBurst(t, 100*time.Millisecond)
+	if buffered >= unbuffered/2 {
+		t.Fatalf("expected buffered burst (%s) to be faster than unbuffered burst (%s)", buffered, unbuffered)
+	}
+
+	// Buffered commits are eventually fsynced & the synced position removed.
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if got, want := db.SyncedPos(), db.Pos(); got != want {
+			return fmt.Errorf("SyncedPos=%#v, want %#v", got, want)
+		} else if n := db.BufferedN(); n != 0 {
+			return fmt.Errorf("BufferedN=%d, want 0", n)
+		}
+		return nil
+	})
+	if atomic.LoadInt32(fsyncN) == 0 {
+		t.Fatal("expected database file to be fsynced")
+	} else if _, err := os.Stat(db.SyncedPosPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected synced position file to be removed: %v", err)
+	}
+}
+
+// Ensure a database with a missing LTX file fails to open in strict mode &
+// is recovered from the available files otherwise.
+func TestDB_Open_StrictSegmentContinuity(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= 5; i++ {
+		testingutil.MustWriteTx(t, db, i, i, byte(i))
+	}
+	pos := db.Pos()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove segments from the middle of the chain.
+	for _, txID := range []uint64{2, 3} {
+		if err := os.Remove(db.LTXPath(txID, txID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Strict", func(t *testing.T) {
+		store := litefs.NewStore(path)
+		store.StrictSegmentContinuity = true
+		if err := store.Open(); !errors.Is(err, litefs.ErrSegmentGap) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !strings.Contains(err.Error(), `missing=0000000000000002-0000000000000003`) {
+			t.Fatalf("expected gap in error: %s", err)
+		}
+		_ = store.Close()
+	})
+
+	t.Run("Lenient", func(t *testing.T) {
+		store := litefs.NewStore(path)
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		if got, want := store.DBByName("db").Pos(), pos; got != want {
+			t.Fatalf("Pos=%#v, want %#v", got, want)
+		}
+	})
+}
+
+// Ensure commits are held from replication streams until the group commit is
+// full & are then combined into a single LTX file.
+func TestDB_CommitJournal_GroupCommit(t *testing.T) {
+	store := newStore(t)
+	store.GroupCommitWindow = time.Hour
+	store.GroupCommitMaxSize = 3
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+	if got, want := db.StreamPos(), (litefs.Pos{}); got != want {
+		t.Fatalf("StreamPos=%#v, want %#v", got, want)
+	} else if got, want := db.TXID(), uint64(2); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+
+	// Filling the group combines its LTX files.
+	testingutil.MustWriteTx(t, db, 1, 1, 3)
+	if got, want := db.StreamPos(), db.Pos(); got != want {
+		t.Fatalf("StreamPos=%#v, want %#v", got, want)
+	}
+
+	// The next commit starts a new group.
+	testingutil.MustWriteTx(t, db, 1, 1, 4)
+	if got, want := db.StreamPos().TXID, uint64(3); got != want {
+		t.Fatalf("StreamPos.TXID=%d, want %d", got, want)
+	}
+
+	ents, err := os.ReadDir(db.LTXDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ent := range ents {
+		names = append(names, ent.Name())
+	}
+	if got, want := names, []string{ltx.FormatFilename(1, 3), ltx.FormatFilename(4, 4)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ltx files=%v, want %v", got, want)
+	}
+
+	// The combined file is read when opening any transaction at its start.
+	lf, err := db.OpenLTXFile(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	buf := make([]byte, ltx.HeaderSize)
+	var hdr ltx.Header
+	if _, err := io.ReadFull(lf, buf); err != nil {
+		t.Fatal(err)
+	} else if err := hdr.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	} else if got, want := hdr.MaxTXID, uint64(3); got != want {
+		t.Fatalf("MaxTXID=%d, want %d", got, want)
+	}
+}
+
+// Ensure buffered commits survive a crash that loses the unsynced writes to
+// the database file as their LTX files are synced before the journal is removed.
+func TestDB_CommitJournal_WriteBuffer_Crash(t *testing.T) {
+	var mu sync.Mutex
+	synced := make(map[string]bool)
+
+	store := newStore(t)
+	store.WriteBufferWindow = time.Hour
+	store.FsyncFunc = func(f *os.File) error {
+		mu.Lock()
+		synced[filepath.Base(f.Name())] = true
+		mu.Unlock()
+		return f.Sync()
+	}
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sync the first transaction & keep the following ones buffered.
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	if err := db.FlushWriteBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	syncedData, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+	testingutil.MustWriteTx(t, db, 2, 2, 3)
+	if got, want := db.BufferedN(), 2; got != want {
+		t.Fatalf("BufferedN=%d, want %d", got, want)
+	}
+
+	// Every LTX file must be synced even though the database file was not.
+	for txID := uint64(2); txID <= 3; txID++ {
+		if filename := ltx.FormatFilename(txID, txID); !synced[filename] {
+			t.Fatalf("expected ltx file to be synced: %s", filename)
+		}
+	}
+
+	pos := db.Pos()
+	want, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy the store as if the host crashed & lost the unsynced database writes.
+	path := t.TempDir()
+	testingutil.MustCopyDir(t, store.Path(), path)
+	rel, err := filepath.Rel(store.Path(), db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(filepath.Join(path, rel), syncedData, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the copy & ensure the buffered transactions are replayed.
+	other := litefs.NewStore(path)
+	if err := other.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	otherDB := other.DBByName("db")
+	if got := otherDB.Pos(); got != pos {
+		t.Fatalf("Pos=%#v, want %#v", got, pos)
+	} else if got, err := os.ReadFile(otherDB.DatabasePath()); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, want) {
+		t.Fatal("database mismatch after recovery")
+	} else if _, err := os.Stat(otherDB.SyncedPosPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected synced position file to be removed: %v", err)
+	}
+}
+
+// Ensure buffered transactions are replayed before a hot journal is verified
+// if the host crashed during a later write transaction.
+func TestDB_CommitJournal_WriteBuffer_CrashHotJournal(t *testing.T) {
+	store := newStore(t)
+	store.WriteBufferWindow = time.Hour
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sync the first transaction & keep the following ones buffered.
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	if err := db.FlushWriteBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+	testingutil.MustWriteTx(t, db, 3, 3, 3)
+
+	pos := db.Pos()
+	want, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start another transaction but leave its journal hot.
+	testingutil.MustWriteHotTx(t, db, 3, 3, 4)
+
+	// Copy the store as if the host crashed & lost the unsynced write to the
+	// second page, which is not in the journal.
+	path := t.TempDir()
+	testingutil.MustCopyDir(t, store.Path(), path)
+	rel, err := filepath.Rel(store.Path(), db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbf, err := os.OpenFile(filepath.Join(path, rel), os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := dbf.WriteAt(make([]byte, 4096), 4096); err != nil {
+		t.Fatal(err)
+	} else if err := dbf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the copy & ensure it matches the last committed transaction.
+	other := litefs.NewStore(path)
+	if err := other.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	otherDB := other.DBByName("db")
+	if got := otherDB.Pos(); got != pos {
+		t.Fatalf("Pos=%#v, want %#v", got, pos)
+	} else if got, err := os.ReadFile(otherDB.DatabasePath()); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, want) {
+		t.Fatal("database mismatch after recovery")
+	} else if _, err := os.Stat(otherDB.JournalPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected journal to be removed: %v", err)
+	}
+}
+
+// Ensure a hot journal left by an incomplete transaction is rolled back when
+// the database is reopened so it matches the last committed transaction.
+func TestDB_Open_HotJournal(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+
+	pos := db.Pos()
+	want, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Update the header page & grow the file without committing.
+	testingutil.MustWriteHotTx(t, db, 3, 3, 3)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(db.JournalPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen store & ensure the database is restored.
+	store = litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	db = store.DBByName("db")
+	if got := db.Pos(); got != pos {
+		t.Fatalf("Pos=%#v, want %#v", got, pos)
+	} else if got, err := os.ReadFile(db.DatabasePath()); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, want) {
+		t.Fatal("database mismatch after rollback")
+	} else if _, err := os.Stat(db.JournalPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected journal to be removed: %v", err)
+	}
+
+	// Ensure new transactions continue from the committed position.
+	testingutil.MustWriteTx(t, db, 3, 3, 4)
+	if got, want := db.TXID(), pos.TXID+1; got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+}
+
+// Ensure a journal left behind after its LTX file was written, such as by a
+// crash before the journal was invalidated, is rolled back along with the LTX
+// file so the database can reopen.
+func TestDB_Open_HotJournalAfterLTX(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+
+	pos := db.Pos()
+	want, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Commit the next transaction but restore its journal afterward as if the
+	// process stopped between writing the LTX file & removing the journal.
+	testingutil.MustWriteHotTx(t, db, 3, 3, 3)
+	journal, err := os.ReadFile(db.JournalPath())
+	if err != nil {
+		t.Fatal(err)
+	} else if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(db.JournalPath(), journal, 0666); err != nil {
+		t.Fatal(err)
+	}
+	ltxPath := db.LTXPath(3, 3)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen store & ensure the transaction is rolled back.
+	store = litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	db = store.DBByName("db")
+	if got := db.Pos(); got != pos {
+		t.Fatalf("Pos=%#v, want %#v", got, pos)
+	} else if got, err := os.ReadFile(db.DatabasePath()); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, want) {
+		t.Fatal("database mismatch after rollback")
+	} else if _, err := os.Stat(ltxPath); !os.IsNotExist(err) {
+		t.Fatalf("expected ltx file to be removed: %v", err)
+	}
+
+	// Ensure the transaction ID is reused by the next transaction.
+	testingutil.MustWriteTx(t, db, 3, 3, 4)
+	if got, want := db.TXID(), pos.TXID+1; got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+}
+
+// Ensure a warning is logged as a database approaches the maximum TXID and
+// that writes are rejected at the maximum instead of wrapping around.
+func TestDB_TXIDLimit(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seed a near-max TXID by rewriting the header of the only LTX file.
+	const txID = litefs.MaxTXID - 2
+	buf, err := os.ReadFile(db.LTXPath(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr ltx.Header
+	if err := hdr.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	hdr.MinTXID, hdr.MaxTXID = txID, txID
+	if b, err := hdr.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(db.LTXPath(txID, txID), append(b, buf[len(b):]...), 0666); err != nil {
+		t.Fatal(err)
+	} else if err := os.Remove(db.LTXPath(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Capture the warning logged on reopen & on each commit.
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	store = litefs.NewStore(path)
+	store.TXIDWarnThreshold = litefs.MaxTXID - 10
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	db = store.DBByName("db")
+	if got, want := db.TXID(), uint64(txID); got != want {
+		t.Fatalf("TXID=%x, want %x", got, want)
+	} else if !strings.Contains(logBuf.String(), `WARNING: database "db" has passed transaction id`) {
+		t.Fatalf("expected warning, got: %s", logBuf.String())
+	}
+
+	// Ensure transactions up to the maximum are committed in order.
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+	testingutil.MustWriteTx(t, db, 1, 1, 3)
+	if got, want := db.TXID(), uint64(litefs.MaxTXID); got != want {
+		t.Fatalf("TXID=%x, want %x", got, want)
+	}
+	for _, id := range []uint64{txID + 1, litefs.MaxTXID} {
+		if f, err := db.OpenLTXFile(id); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Ensure the next write transaction is rejected instead of wrapping.
+	if _, err := db.CreateJournal(); err != litefs.ErrTXIDExhausted {
+		t.Fatalf("unexpected error: %v", err)
+	} else if got, want := db.TXID(), uint64(litefs.MaxTXID); got != want {
+		t.Fatalf("TXID=%x, want %x", got, want)
+	}
+}
+
+// Ensure the position & commit time of a transaction stored within a range
+// of transactions, such as a batch, are found.
+func TestDB_PosAt_Batch(t *testing.T) {
+	db, f := newDB(t, "db")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+	testingutil.MustWriteTx(t, db, 2, 2, 3)
+	pos := db.Pos()
+
+	// Combine the last two transactions into a single file.
+	buf, err := os.ReadFile(db.LTXPath(3, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr ltx.Header
+	if err := hdr.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	hdr.MinTXID = 2
+	if b, err := hdr.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(db.LTXPath(2, 3), append(b, buf[len(b):]...), 0666); err != nil {
+		t.Fatal(err)
+	} else if err := os.Remove(db.LTXPath(2, 2)); err != nil {
+		t.Fatal(err)
+	} else if err := os.Remove(db.LTXPath(3, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := db.PosAt(3); err != nil {
+		t.Fatal(err)
+	} else if got != pos {
+		t.Fatalf("PosAt(3)=%#v, want %#v", got, pos)
+	}
+
+	// Only the position after the batch has a known checksum.
+	if got, err := db.PosAt(2); err != nil {
+		t.Fatal(err)
+	} else if want := (litefs.Pos{TXID: 2}); got != want {
+		t.Fatalf("PosAt(2)=%#v, want %#v", got, want)
+	}
+
+	if committedAt, err := db.CommittedAt(2); err != nil {
+		t.Fatal(err)
+	} else if committedAt.IsZero() {
+		t.Fatal("expected commit time")
+	}
+
+	if _, err := db.PosAt(4); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // newDB returns a new instance of DB attached to a temporary store.
 func newDB(tb testing.TB, name string) (*litefs.DB, *os.File) {
 	tb.Helper()
@@ -31,3 +932,61 @@ func newDB(tb testing.TB, name string) (*litefs.DB, *os.File) {
 
 	return db, f
 }
+
+// Ensure catch-up progress increases toward 100% as transactions are applied.
+func TestDB_CatchUp(t *testing.T) {
+	primaryDB, _ := newDB(t, "db")
+	for i := uint32(1); i <= 5; i++ {
+		testingutil.MustWriteTx(t, primaryDB, i, i, byte(i))
+	}
+
+	db, _ := newDB(t, "db")
+	if _, ok := db.CatchUp(); ok {
+		t.Fatal("expected no catch-up")
+	}
+
+	db.SetCatchUpTarget(5)
+	var prev float64
+	for i := uint64(1); i <= 5; i++ {
+		mustApplyLTX(t, db, primaryDB.LTXPath(i, i))
+
+		c, ok := db.CatchUp()
+		if !ok {
+			t.Fatal("expected catch-up")
+		} else if got, want := c.TXID, i; got != want {
+			t.Fatalf("TXID=%d, want %d", got, want)
+		} else if got, want := c.TargetTXID, uint64(5); got != want {
+			t.Fatalf("TargetTXID=%d, want %d", got, want)
+		} else if c.Percent() <= prev {
+			t.Fatalf("Percent=%f, want greater than %f", c.Percent(), prev)
+		} else if c.Rate() <= 0 {
+			t.Fatalf("Rate=%f, want positive", c.Rate())
+		}
+		prev = c.Percent()
+	}
+
+	if c, _ := db.CatchUp(); c.Percent() != 100 {
+		t.Fatalf("Percent=%f, want 100", c.Percent())
+	} else if c.ETA() != 0 {
+		t.Fatalf("ETA=%s, want 0", c.ETA())
+	} else if c.CompletedAt.IsZero() {
+		t.Fatal("expected completion time")
+	}
+}
+
+// mustApplyLTX copies the LTX file at src into db's LTX directory, as a
+// replica does when receiving it from the primary, and applies it.
+func mustApplyLTX(tb testing.TB, db *litefs.DB, src string) {
+	tb.Helper()
+
+	buf, err := os.ReadFile(src)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dst := filepath.Join(db.LTXDir(), filepath.Base(src))
+	if err := os.WriteFile(dst, buf, 0666); err != nil {
+		tb.Fatal(err)
+	} else if err := db.TryApplyLTX(dst); err != nil {
+		tb.Fatal(err)
+	}
+}
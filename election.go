@@ -0,0 +1,167 @@
+package litefs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultElectionTimeout is the default time a candidate defers to a more
+// up-to-date candidate before attempting to acquire the lease itself.
+const DefaultElectionTimeout = 10 * time.Second
+
+// Candidate represents a node that is eligible to become primary along with
+// the position of each of its databases.
+type Candidate struct {
+	URL      string
+	Priority int
+	PosMap   map[uint32]Pos
+
+	// Availability zone of the node & whether it is the preferred zone for
+	// the primary.
+	Zone      string
+	Preferred bool
+}
+
+// Beats returns true if c should be promoted instead of other. Candidates are
+// ordered by the sum of their database TXIDs so that a candidate at or ahead
+// of the other on every database, & ahead on at least one, always wins. Ties
+// are broken by the preferred zone, then by the higher priority, and then by
+// the lower URL. This is a total order so every node agrees on the winner,
+// even when candidates are each ahead on a different database.
+func (c *Candidate) Beats(other *Candidate) bool {
+	if a, b := sumPosMapTXIDs(c.PosMap), sumPosMapTXIDs(other.PosMap); a != b {
+		return a > b
+	} else if c.Preferred != other.Preferred {
+		return c.Preferred
+	} else if c.Priority != other.Priority {
+		return c.Priority > other.Priority
+	}
+	return c.URL < other.URL
+}
+
+// sumPosMapTXIDs returns the sum of the TXID of every database in m.
+func sumPosMapTXIDs(m map[uint32]Pos) (sum uint64) {
+	for _, pos := range m {
+		sum += uint64(pos.TXID)
+	}
+	return sum
+}
+
+// CandidateClient fetches the candidacy of another node.
+type CandidateClient interface {
+	// Candidate returns the candidacy of the node at rawurl. Returns a nil
+	// candidate if the node is not eligible to become primary.
+	Candidate(ctx context.Context, rawurl string) (*Candidate, error)
+}
+
+var _ Leaser = (*ElectionCoordinator)(nil)
+
+// ElectionCoordinator wraps a Leaser so that, when the primary is lost, the
+// candidate with the most up-to-date databases acquires the lease instead of
+// whichever candidate asks first. This minimizes the number of transactions
+// lost on failover.
+//
+// Before acquiring, the coordinator compares its position to each peer. If a
+// reachable peer beats it then the acquisition is deferred so that peer can
+// become primary. Unreachable peers are ignored. If the winning peer has not
+// become primary within Timeout then the coordinator acquires the lease
+// anyway so the cluster is not left without a primary.
+type ElectionCoordinator struct {
+	Leaser
+
+	store  *Store
+	client CandidateClient
+
+	mu         sync.Mutex
+	deferredAt time.Time // time acquisition was first deferred to a peer
+
+	// Advertise URLs of the other candidates in the cluster.
+	Peers []string
+
+	// Priority used to break ties between candidates at the same position.
+	Priority int
+
+	// Availability zone of the node. Candidates in the preferred zone win
+	// ties before priority is compared.
+	Zone      string
+	Preferred bool
+
+	// Time to defer to a more up-to-date peer before acquiring the lease.
+	Timeout time.Duration
+}
+
+// NewElectionCoordinator returns a new coordinator that acquires leases from
+// leaser on behalf of store.
+func NewElectionCoordinator(leaser Leaser, store *Store, client CandidateClient) *ElectionCoordinator {
+	return &ElectionCoordinator{
+		Leaser:  leaser,
+		store:   store,
+		client:  client,
+		Timeout: DefaultElectionTimeout,
+	}
+}
+
+// Candidate returns the candidacy of the local node.
+func (c *ElectionCoordinator) Candidate() *Candidate {
+	return &Candidate{
+		URL:       c.AdvertiseURL(),
+		Priority:  c.Priority,
+		PosMap:    c.store.PosMap(),
+		Zone:      c.Zone,
+		Preferred: c.Preferred,
+	}
+}
+
+// Acquire acquires the lease from the underlying leaser unless a peer is more
+// up-to-date, in which case ErrPrimaryExists is returned so the caller waits
+// for the peer to become primary.
+func (c *ElectionCoordinator) Acquire(ctx context.Context) (Lease, error) {
+	if winner := c.winner(ctx); winner != nil {
+		c.mu.Lock()
+		if c.deferredAt.IsZero() {
+			c.deferredAt = time.Now()
+		}
+		deferredAt := c.deferredAt
+		c.mu.Unlock()
+
+		if time.Since(deferredAt) < c.Timeout {
+			log.Printf("deferring election to more up-to-date candidate: url=%s", winner.URL)
+			return nil, ErrPrimaryExists
+		}
+		log.Printf("candidate did not become primary within %s, acquiring lease: url=%s", c.Timeout, winner.URL)
+	}
+
+	c.mu.Lock()
+	c.deferredAt = time.Time{}
+	c.mu.Unlock()
+
+	return c.Leaser.Acquire(ctx)
+}
+
+// winner returns the peer that beats every other candidate, including the
+// local node. Returns nil if the local node is the winner.
+func (c *ElectionCoordinator) winner(ctx context.Context) *Candidate {
+	local := c.Candidate()
+
+	var winner *Candidate
+	for _, peer := range c.Peers {
+		if peer == local.URL {
+			continue
+		}
+
+		other, err := c.client.Candidate(ctx, peer)
+		if err != nil {
+			log.Printf("cannot fetch election candidate, skipping: url=%s err=%s", peer, err)
+			continue
+		} else if other == nil {
+			continue // not eligible
+		}
+
+		if other.Beats(local) && (winner == nil || other.Beats(winner)) {
+			winner = other
+		}
+	}
+	return winner
+}
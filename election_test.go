@@ -0,0 +1,107 @@
+package litefs_test
+
+import (
+	"testing"
+
+	"github.com/superfly/litefs"
+)
+
+func TestCandidate_Beats(t *testing.T) {
+	t.Run("Ahead", func(t *testing.T) {
+		a := &litefs.Candidate{URL: "http://a", Priority: 1, PosMap: map[uint32]litefs.Pos{1: {TXID: 6}, 2: {TXID: 3}}}
+		b := &litefs.Candidate{URL: "http://b", Priority: 10, PosMap: map[uint32]litefs.Pos{1: {TXID: 5}, 2: {TXID: 3}}}
+		if !a.Beats(b) {
+			t.Fatal("expected candidate ahead on every database to win over higher priority")
+		} else if b.Beats(a) {
+			t.Fatal("expected candidate behind to lose")
+		}
+	})
+
+	t.Run("MissingDB", func(t *testing.T) {
+		a := &litefs.Candidate{URL: "http://a", Priority: 1, PosMap: map[uint32]litefs.Pos{1: {TXID: 5}, 2: {TXID: 1}}}
+		b := &litefs.Candidate{URL: "http://b", Priority: 10, PosMap: map[uint32]litefs.Pos{1: {TXID: 5}}}
+		if !a.Beats(b) {
+			t.Fatal("expected candidate with an additional database to win")
+		} else if b.Beats(a) {
+			t.Fatal("expected candidate missing a database to lose")
+		}
+	})
+
+	t.Run("Diverged", func(t *testing.T) {
+		a := &litefs.Candidate{URL: "http://a", Priority: 1, PosMap: map[uint32]litefs.Pos{1: {TXID: 5}, 2: {TXID: 30}}}
+		b := &litefs.Candidate{URL: "http://b", Priority: 10, PosMap: map[uint32]litefs.Pos{1: {TXID: 6}, 2: {TXID: 3}}}
+		if !a.Beats(b) {
+			t.Fatal("expected higher total TXID to win when neither candidate is ahead on every database")
+		} else if b.Beats(a) {
+			t.Fatal("expected lower total TXID to lose")
+		}
+	})
+
+	// Ensure candidates that are each ahead on a different database cannot
+	// form a cycle where every candidate is beaten by another.
+	t.Run("Cycle", func(t *testing.T) {
+		// a is ahead of b on every database while b beats c & c beats a on
+		// priority, which would be a cycle if diverged candidates were only
+		// compared by priority.
+		candidates := []*litefs.Candidate{
+			{URL: "http://a", Priority: 1, PosMap: map[uint32]litefs.Pos{1: {TXID: 5}, 2: {TXID: 5}}},
+			{URL: "http://b", Priority: 3, PosMap: map[uint32]litefs.Pos{1: {TXID: 4}, 2: {TXID: 4}}},
+			{URL: "http://c", Priority: 2, PosMap: map[uint32]litefs.Pos{1: {TXID: 6}, 2: {TXID: 1}}},
+		}
+
+		var winners int
+		for _, c := range candidates {
+			beaten := false
+			for _, other := range candidates {
+				if other != c && other.Beats(c) {
+					beaten = true
+				}
+			}
+			if !beaten {
+				winners++
+			}
+		}
+		if winners != 1 {
+			t.Fatalf("expected exactly one winner, got %d", winners)
+		} else if !candidates[0].Beats(candidates[1]) || !candidates[0].Beats(candidates[2]) {
+			t.Fatal("expected candidate with highest total TXID to win")
+		}
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		a := &litefs.Candidate{URL: "http://a", Priority: 1, PosMap: map[uint32]litefs.Pos{1: {TXID: 5}}}
+		b := &litefs.Candidate{URL: "http://b", Priority: 10, PosMap: map[uint32]litefs.Pos{1: {TXID: 5}}}
+		if !b.Beats(a) {
+			t.Fatal("expected higher priority to break tie")
+		} else if a.Beats(b) {
+			t.Fatal("expected lower priority to lose tie")
+		}
+	})
+
+	t.Run("PreferredZone", func(t *testing.T) {
+		a := &litefs.Candidate{URL: "http://a", Priority: 10, Zone: "us-east-1a", PosMap: map[uint32]litefs.Pos{1: {TXID: 5}}}
+		b := &litefs.Candidate{URL: "http://b", Priority: 1, Zone: "us-east-1b", Preferred: true, PosMap: map[uint32]litefs.Pos{1: {TXID: 5}}}
+		if !b.Beats(a) {
+			t.Fatal("expected preferred zone to break tie over priority")
+		} else if a.Beats(b) {
+			t.Fatal("expected other zone to lose tie")
+		}
+
+		b.PosMap = map[uint32]litefs.Pos{1: {TXID: 4}}
+		if !a.Beats(b) {
+			t.Fatal("expected higher TXID to win over preferred zone")
+		}
+	})
+
+	t.Run("URL", func(t *testing.T) {
+		a := &litefs.Candidate{URL: "http://a", PosMap: map[uint32]litefs.Pos{1: {TXID: 5}}}
+		b := &litefs.Candidate{URL: "http://b", PosMap: map[uint32]litefs.Pos{1: {TXID: 5}}}
+		if !a.Beats(b) {
+			t.Fatal("expected lower URL to break tie")
+		} else if b.Beats(a) {
+			t.Fatal("expected higher URL to lose tie")
+		} else if a.Beats(a) {
+			t.Fatal("expected candidate not to beat itself")
+		}
+	})
+}
@@ -7,9 +7,11 @@ import (
 	"log"
 	"os"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/superfly/litefs"
 )
 
@@ -34,7 +36,7 @@ func (n *DatabaseNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 		return err
 	}
 
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode()
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -42,6 +44,19 @@ func (n *DatabaseNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 }
 
 func (n *DatabaseNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	// Read-only opens are reported on the read path & all others on the write path.
+	observer := databaseOpenWriteDurationMetric
+	if req.Flags.IsReadOnly() {
+		observer = databaseOpenReadDurationMetric
+	}
+	defer prometheus.NewTimer(observer).ObserveDuration()
+
+	if err := n.fsys.store.CheckReadable(n.db); err != nil {
+		return nil, ToError(err)
+	} else if !req.Flags.IsReadOnly() && n.fsys.readOnly() {
+		return nil, fuse.Errno(syscall.EROFS) // SQLite retries as read-only
+	}
+
 	f, err := os.OpenFile(n.db.DatabasePath(), os.O_RDWR, 0666)
 	if err != nil {
 		return nil, err
@@ -50,6 +65,8 @@ func (n *DatabaseNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fu
 }
 
 func (n *DatabaseNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	defer prometheus.NewTimer(databaseFsyncDurationMetric).ObserveDuration()
+
 	f, err := os.Open(n.db.DatabasePath())
 	if err != nil {
 		return err
@@ -77,6 +94,7 @@ var _ fs.HandlePOSIXLocker = (*DatabaseHandle)(nil)
 type DatabaseHandle struct {
 	node *DatabaseNode
 	file *os.File
+	gen  uint64 // role generation when opened
 
 	// SQLite locks held
 	pendingGuard  *litefs.RWMutexGuard
@@ -85,10 +103,19 @@ type DatabaseHandle struct {
 }
 
 func newDatabaseHandle(node *DatabaseNode, file *os.File) *DatabaseHandle {
-	return &DatabaseHandle{node: node, file: file}
+	node.fsys.addHandle()
+	return &DatabaseHandle{node: node, file: file, gen: node.fsys.roleGeneration()}
 }
 
 func (h *DatabaseHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	defer prometheus.NewTimer(databaseReadDurationMetric).ObserveDuration()
+
+	if h.node.fsys.isStale(h.gen) {
+		return ToError(litefs.ErrStaleHandle)
+	} else if err := h.node.fsys.store.CheckReadable(h.node.db); err != nil {
+		return ToError(err)
+	}
+
 	buf := make([]byte, req.Size)
 	n, err := h.file.ReadAt(buf, req.Offset)
 	if err == io.EOF {
@@ -99,6 +126,12 @@ func (h *DatabaseHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *
 }
 
 func (h *DatabaseHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	defer prometheus.NewTimer(databaseWriteDurationMetric).ObserveDuration()
+
+	if h.node.fsys.isStale(h.gen) {
+		return ToError(litefs.ErrStaleHandle)
+	}
+
 	if err := h.node.db.WriteDatabase(h.file, req.Data, req.Offset); err != nil {
 		log.Printf("fuse: write(): database error: %s", err)
 		return err
@@ -108,6 +141,20 @@ func (h *DatabaseHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp
 }
 
 func (h *DatabaseHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	h.unlockAll()
+	return nil
+}
+
+// Release closes the underlying file. Any locks still held, such as when the
+// process exited without unlocking, are released so they cannot be leaked.
+func (h *DatabaseHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	defer h.node.fsys.removeHandle()
+	h.unlockAll()
+	return h.file.Close()
+}
+
+// unlockAll releases all SQLite locks held by the handle.
+func (h *DatabaseHandle) unlockAll() {
 	// TODO: Obtain handle lock.
 
 	for _, mu := range []**litefs.RWMutexGuard{&h.pendingGuard, &h.sharedGuard, &h.reservedGuard} {
@@ -116,17 +163,21 @@ func (h *DatabaseHandle) Flush(ctx context.Context, req *fuse.FlushRequest) erro
 			*mu = nil
 		}
 	}
-
-	return nil
 }
 
-func (h *DatabaseHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-	return h.file.Close()
-}
+// busyRetryInterval is the delay between attempts to acquire a contended lock
+// while waiting for the busy timeout.
+const busyRetryInterval = 1 * time.Millisecond
 
 // Lock tries to acquire a lock on a byte range of the node.
-// If a conflicting lock is already held, returns syscall.EAGAIN.
+// If a conflicting lock is already held, returns syscall.EAGAIN, which SQLite
+// reports as SQLITE_BUSY. If the file system has a busy timeout, the lock is
+// retried until the timeout elapses before EAGAIN is returned.
 func (h *DatabaseHandle) Lock(ctx context.Context, req *fuse.LockRequest) error {
+	if h.node.fsys.isStale(h.gen) {
+		return ToError(litefs.ErrStaleHandle)
+	}
+
 	// Parse lock range and ensure we are only performing one lock at a time.
 	lockTypes := litefs.ParseLockRange(req.Lock.Start, req.Lock.End)
 	if len(lockTypes) == 0 {
@@ -136,6 +187,39 @@ func (h *DatabaseHandle) Lock(ctx context.Context, req *fuse.LockRequest) error
 	}
 	lockType := lockTypes[0]
 
+	deadline := time.Now().Add(h.node.fsys.BusyTimeout)
+	for {
+		err := h.tryLock(req.Lock.Type, lockType)
+		if err != fuse.Errno(syscall.EAGAIN) {
+			return err
+		} else if !time.Now().Before(deadline) || h.mayDeadlock(lockType) {
+			lockBusyCountMetric.Inc()
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			lockBusyCountMetric.Inc()
+			return err
+		case <-time.After(busyRetryInterval):
+		}
+	}
+}
+
+// mayDeadlock returns true if waiting for a lock of the given type could
+// deadlock. A handle waiting for RESERVED while holding SHARED blocks another
+// handle that holds PENDING & is waiting for SHARED locks to be released so it
+// can commit. SQLite also reports SQLITE_BUSY immediately in this case.
+func (h *DatabaseHandle) mayDeadlock(lockType litefs.LockType) bool {
+	return lockType == litefs.LockTypeReserved &&
+		h.sharedGuard != nil &&
+		h.pendingGuard == nil &&
+		h.node.db.PendingLock().State() == litefs.RWMutexStateExclusive
+}
+
+// tryLock makes a single attempt to acquire a lock of the given type.
+// If a conflicting lock is already held, returns syscall.EAGAIN.
+func (h *DatabaseHandle) tryLock(typ fuse.LockType, lockType litefs.LockType) error {
 	// TODO: Hold file handle lock for rest of function.
 	mu, guard, err := h.mutexAndGuardRefByLockType(lockType)
 	if err != nil {
@@ -143,7 +227,7 @@ func (h *DatabaseHandle) Lock(ctx context.Context, req *fuse.LockRequest) error
 	}
 
 	if *guard != nil {
-		switch typ := req.Lock.Type; typ {
+		switch typ {
 		case fuse.LockRead:
 			(*guard).RLock()
 			return nil
@@ -157,7 +241,7 @@ func (h *DatabaseHandle) Lock(ctx context.Context, req *fuse.LockRequest) error
 		}
 	}
 
-	switch typ := req.Lock.Type; typ {
+	switch typ {
 	case fuse.LockRead:
 		if *guard = mu.TryRLock(); *guard == nil {
 			return fuse.Errno(syscall.EAGAIN)
@@ -1,9 +1,13 @@
 package fuse
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -24,12 +28,41 @@ type FileSystem struct {
 	server *fs.Server
 	root   *RootNode
 
+	handleN      int64 // number of open database & journal handles
+	handleWarned int32 // set to 1 while over the handle soft limit
+
+	isPrimary int32  // set to 1 if the node was primary at the last role check
+	roleGen   uint64 // incremented on each role change, if enabled
+
 	// User & Group ID for all files in the filesystem.
 	Uid int
 	Gid int
 
 	// If true, logs debug information about every FUSE call.
 	Debug bool
+
+	// If greater than zero, a warning is logged when the number of open file
+	// handles exceeds this limit. This helps to catch handle leaks early.
+	MaxOpenHandles int
+
+	// If true, open database & journal handles fail with ESTALE once the node
+	// switches between primary & replica so applications re-open their files.
+	InvalidateHandlesOnRoleChange bool
+
+	// If true, the mount is presented as read-only while the node is a
+	// replica. Files report read-only permissions & opening a database for
+	// writing fails with EROFS. The mount becomes writable on promotion.
+	ReadOnlyReplica bool
+
+	// If set, SQLite temporary files created in the mount are stored in this
+	// local directory instead of being created as databases. They are never
+	// replicated so temp tables work on replicas. Cleared on mount.
+	TempDir string
+
+	// If greater than zero, a contended SQLite lock is retried for up to this
+	// duration before SQLITE_BUSY is returned to the application. This smooths
+	// over short contention for applications that do not set a busy timeout.
+	BusyTimeout time.Duration
 }
 
 // NewFileSystem returns a new instance of FileSystem.
@@ -53,8 +86,118 @@ func (fsys *FileSystem) Path() string { return fsys.path }
 // Store returns the underlying store.
 func (fsys *FileSystem) Store() *litefs.Store { return fsys.store }
 
+// OpenHandleN returns the number of open database & journal file handles.
+func (fsys *FileSystem) OpenHandleN() int {
+	return int(atomic.LoadInt64(&fsys.handleN))
+}
+
+// NodeN returns the number of nodes cached in the root directory.
+func (fsys *FileSystem) NodeN() int {
+	fsys.root.mu.Lock()
+	defer fsys.root.mu.Unlock()
+	return len(fsys.root.nodes)
+}
+
+// addHandle tracks a newly opened file handle. A warning is logged the first
+// time the handle count exceeds MaxOpenHandles.
+func (fsys *FileSystem) addHandle() {
+	n := atomic.AddInt64(&fsys.handleN, 1)
+	openHandleCountMetric.Inc()
+
+	if fsys.MaxOpenHandles > 0 && n > int64(fsys.MaxOpenHandles) && atomic.CompareAndSwapInt32(&fsys.handleWarned, 0, 1) {
+		log.Printf("WARNING: open file handles exceed soft limit, possible handle leak: n=%d limit=%d", n, fsys.MaxOpenHandles)
+	}
+}
+
+// removeHandle tracks a released file handle. The warning is re-armed once
+// the handle count drops back to the limit.
+func (fsys *FileSystem) removeHandle() {
+	n := atomic.AddInt64(&fsys.handleN, -1)
+	openHandleCountMetric.Dec()
+
+	if n <= int64(fsys.MaxOpenHandles) {
+		atomic.StoreInt32(&fsys.handleWarned, 0)
+	}
+}
+
+// roleGeneration returns the current role generation. Handles opened in an earlier
+// generation are stale.
+func (fsys *FileSystem) roleGeneration() uint64 {
+	return atomic.LoadUint64(&fsys.roleGen)
+}
+
+// isStale returns true if a handle opened in role generation gen has been
+// invalidated by a role change.
+func (fsys *FileSystem) isStale(gen uint64) bool {
+	return fsys.roleGeneration() != gen
+}
+
+// checkRoleChange invalidates all open handles if the node has switched
+// between primary & replica since the last check.
+func (fsys *FileSystem) checkRoleChange() {
+	isPrimary := fsys.primaryFlag()
+	if atomic.SwapInt32(&fsys.isPrimary, isPrimary) == isPrimary {
+		return
+	}
+
+	role := "replica"
+	if isPrimary == 1 {
+		role = "primary"
+	}
+	atomic.AddUint64(&fsys.roleGen, 1)
+	log.Printf("role changed to %s, invalidating %d open file handle(s)", role, fsys.OpenHandleN())
+}
+
+// readOnly returns true if the mount is currently presented as read-only.
+func (fsys *FileSystem) readOnly() bool {
+	return fsys.ReadOnlyReplica && !fsys.store.IsPrimary()
+}
+
+// fileMode returns the permissions for files in the mount.
+func (fsys *FileSystem) fileMode() os.FileMode {
+	if fsys.readOnly() {
+		return 0444
+	}
+	return 0666
+}
+
+// primaryFlag returns 1 if the store is currently the primary.
+func (fsys *FileSystem) primaryFlag() int32 {
+	if fsys.store.IsPrimary() {
+		return 1
+	}
+	return 0
+}
+
+// CheckMount returns an error if the file system cannot be mounted because
+// FUSE is not installed or the mount point is not an existing directory.
+// Retrying the mount does not resolve these errors.
+func (fsys *FileSystem) CheckMount() error {
+	if _, err := exec.LookPath("fusermount"); err != nil {
+		return fmt.Errorf("fuse not installed: %w", err)
+	} else if _, err := os.Stat("/dev/fuse"); err != nil {
+		return fmt.Errorf("fuse device unavailable: %w", err)
+	}
+
+	if fi, err := os.Stat(fsys.path); err != nil {
+		return fmt.Errorf("invalid mount point: %w", err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("invalid mount point: not a directory: %s", fsys.path)
+	}
+	return nil
+}
+
 // Mount mounts the file system to the mount point.
 func (fsys *FileSystem) Mount() (err error) {
+	// Remove temporary files left behind by a previous mount.
+	if fsys.TempDir != "" {
+		if err := os.RemoveAll(fsys.TempDir); err != nil {
+			return fmt.Errorf("remove temp dir: %w", err)
+		} else if err := os.MkdirAll(fsys.TempDir, 0700); err != nil {
+			return fmt.Errorf("create temp dir: %w", err)
+		}
+	}
+
 	fsys.conn, err = fuse.Mount(fsys.path,
 		fuse.FSName("litefs"),
 		fuse.LockingPOSIX(),
@@ -63,6 +206,9 @@ func (fsys *FileSystem) Mount() (err error) {
 		return err
 	}
 
+	// Record the role at mount as no handles can have been opened before it.
+	atomic.StoreInt32(&fsys.isPrimary, fsys.primaryFlag())
+
 	var config fs.Config
 	if fsys.Debug {
 		config.Debug = func(msg interface{}) { log.Print(msg) }
@@ -110,3 +256,59 @@ func (fsys *FileSystem) InvalidateDB(db *litefs.DB, offset, size int64) error {
 	}
 	return nil
 }
+
+// InvalidateEntry invalidates a file in the root directory so that the kernel
+// does not serve a stale entry after it is removed by replication.
+func (fsys *FileSystem) InvalidateEntry(name string) error {
+	if node := fsys.root.Node(name); node != nil {
+		fsys.root.ForgetNode(node)
+	}
+
+	if err := fsys.server.InvalidateEntry(fsys.root, name); err != nil && err != fuse.ErrNotCached {
+		return err
+	}
+	return nil
+}
+
+// InvalidatePrimary invalidates the primary status files in the root directory
+// so that the kernel does not serve stale entries after a role change. Open
+// handles are also invalidated if InvalidateHandlesOnRoleChange is set.
+func (fsys *FileSystem) InvalidatePrimary() error {
+	if fsys.InvalidateHandlesOnRoleChange {
+		fsys.checkRoleChange()
+	}
+
+	// Refresh cached permissions as the mount changes between read-only &
+	// read-write with the role of the node.
+	if fsys.ReadOnlyReplica {
+		if err := fsys.invalidateAttrs(); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{PrimaryFilename, IsPrimaryFilename} {
+		if err := fsys.server.InvalidateEntry(fsys.root, name); err != nil && err != fuse.ErrNotCached {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateAttrs invalidates the cached attributes of the root directory &
+// every file in it.
+func (fsys *FileSystem) invalidateAttrs() error {
+	fsys.root.mu.Lock()
+	nodes := make([]fs.Node, 0, len(fsys.root.nodes)+1)
+	nodes = append(nodes, fsys.root)
+	for _, node := range fsys.root.nodes {
+		nodes = append(nodes, node)
+	}
+	fsys.root.mu.Unlock()
+
+	for _, node := range nodes {
+		if err := fsys.server.InvalidateNodeAttr(node); err != nil && err != fuse.ErrNotCached {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,16 +1,24 @@
 package fuse_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/superfly/litefs"
 	"github.com/superfly/litefs/fuse"
 	"github.com/superfly/litefs/internal/testingutil"
@@ -146,6 +154,51 @@ func TestFileSystem_NoWrite(t *testing.T) {
 	}
 }
 
+// Ensure a transaction that writes to multiple attached databases is rejected
+// while writes to a single database still succeed.
+func TestFileSystem_Attach(t *testing.T) {
+	fs := newOpenFileSystem(t)
+	db := testingutil.OpenSQLDB(t, filepath.Join(fs.Path(), "db"))
+	db.SetMaxOpenConns(1) // attached databases are per-connection
+
+	if _, err := db.Exec(`ATTACH DATABASE ? AS aux`, filepath.Join(fs.Path(), "aux")); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec(`CREATE TABLE main.t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec(`CREATE TABLE aux.t (x)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write to both databases in a single transaction.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO main.t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := tx.Exec(`INSERT INTO aux.t VALUES (200)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected cross-database commit to fail")
+	}
+
+	// Ensure neither database received the write.
+	for _, name := range []string{"main", "aux"} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + name + `.t`).Scan(&n); err != nil {
+			t.Fatal(err)
+		} else if n != 0 {
+			t.Fatalf("%s: count=%d, want 0", name, n)
+		}
+	}
+
+	// Writing to a single database while attached is still allowed.
+	if _, err := db.Exec(`INSERT INTO aux.t VALUES (300)`); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestFileSystem_MultipleJournalSegments(t *testing.T) {
 	fs := newOpenFileSystem(t)
 	dsn := filepath.Join(fs.Path(), "db")
@@ -205,6 +258,97 @@ func TestFileSystem_MultipleJournalSegments(t *testing.T) {
 	}
 }
 
+// Ensure a hot journal left behind by an incomplete transaction is rolled back
+// on restart so the database recovers to its last committed state.
+func TestFileSystem_HotJournalRecovery(t *testing.T) {
+	fs := newOpenFileSystem(t)
+	dsn := filepath.Join(fs.Path(), "db")
+	db := testingutil.OpenSQLDB(t, dsn)
+	db.SetMaxOpenConns(1) // cache size is per-connection
+	const rowN = 1000
+
+	// Ensure cache size is low so pages spill to the database mid-transaction.
+	if _, err := db.Exec(`PRAGMA cache_size = 10`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec(`CREATE TABLE t (id INTEGER PRIMARY KEY, x TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= rowN; i++ {
+		if _, err := tx.Exec(`INSERT INTO t (id, x) VALUES (?, ?)`, i, strings.Repeat(fmt.Sprintf("%08x", i), 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	pos := fs.Store().DB(1).Pos()
+
+	// Update every row but copy the data directory before committing, as if
+	// the process stopped in the middle of the transaction.
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for i := 1; i <= rowN; i++ {
+		if _, err := tx.Exec(`UPDATE t SET x = ? WHERE id = ?`, strings.Repeat(fmt.Sprintf("%04x", i), 100), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataDir := t.TempDir()
+	testingutil.MustCopyDir(t, fs.Store().Path(), dataDir)
+	if _, err := os.Stat(fs.Store().DB(1).JournalPath()); err != nil {
+		t.Fatalf("expected hot journal: %s", err)
+	}
+
+	// Mount a new file system on the copied data directory.
+	store := litefs.NewStore(dataDir)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	fs2 := fuse.NewFileSystem(filepath.Join(t.TempDir(), "mnt"), store)
+	if err := os.MkdirAll(fs2.Path(), 0777); err != nil {
+		t.Fatal(err)
+	} else if err := fs2.Mount(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs2.Unmount()
+	store.Invalidator = fs2
+
+	if got := store.DB(1).Pos(); got != pos {
+		t.Fatalf("Pos=%#v, want %#v", got, pos)
+	} else if _, err := os.Stat(store.DB(1).JournalPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected journal to be removed: %v", err)
+	}
+
+	// Ensure only the committed rows are visible & the database is intact.
+	db2 := testingutil.OpenSQLDB(t, filepath.Join(fs2.Path(), "db"))
+	var n int
+	var result string
+	if err := db2.QueryRow(`SELECT COUNT(*) FROM t WHERE x = ?`, strings.Repeat(fmt.Sprintf("%08x", 1), 100)).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, 1; got != want {
+		t.Fatalf("n=%d, want %d", got, want)
+	} else if err := db2.QueryRow(`SELECT COUNT(*) FROM t`).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, rowN; got != want {
+		t.Fatalf("n=%d, want %d", got, want)
+	} else if err := db2.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		t.Fatal(err)
+	} else if result != "ok" {
+		t.Fatalf("integrity check: %s", result)
+	}
+}
+
 func TestFileSystem_ReadDir(t *testing.T) {
 	fs := newOpenFileSystem(t)
 	db0 := testingutil.OpenSQLDB(t, filepath.Join(fs.Path(), "db0"))
@@ -226,6 +370,339 @@ func TestFileSystem_ReadDir(t *testing.T) {
 	}
 }
 
+func TestFileSystem_Metrics(t *testing.T) {
+	fs := newOpenFileSystem(t)
+	dsn := filepath.Join(fs.Path(), "db")
+	db := testingutil.OpenSQLDB(t, dsn)
+
+	readN := histogramSampleCount(t, "litefs_fuse_read_path_duration_seconds", "read", "database")
+	writeN := histogramSampleCount(t, "litefs_fuse_write_path_duration_seconds", "write", "database")
+	fsyncN := histogramSampleCount(t, "litefs_fuse_write_path_duration_seconds", "fsync", "journal")
+
+	// Write through the file system.
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen so the kernel page cache is dropped & reads reach the file system.
+	testingutil.ReopenSQLDB(t, &db, dsn)
+	var x int
+	if err := db.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+		t.Fatal(err)
+	} else if got, want := x, 100; got != want {
+		t.Fatalf("x=%d, want %d", got, want)
+	}
+
+	if got := histogramSampleCount(t, "litefs_fuse_read_path_duration_seconds", "read", "database"); got <= readN {
+		t.Fatalf("expected database read observations, got %d", got)
+	}
+	if got := histogramSampleCount(t, "litefs_fuse_write_path_duration_seconds", "write", "database"); got <= writeN {
+		t.Fatalf("expected database write observations, got %d", got)
+	}
+	if got := histogramSampleCount(t, "litefs_fuse_write_path_duration_seconds", "fsync", "journal"); got <= fsyncN {
+		t.Fatalf("expected journal fsync observations, got %d", got)
+	}
+}
+
+// Ensure file handles are released when closed so the gauge does not drift.
+func TestFileSystem_OpenHandles(t *testing.T) {
+	fs := newOpenFileSystem(t)
+	fs.MaxOpenHandles = 5
+
+	db := testingutil.OpenSQLDB(t, filepath.Join(fs.Path(), "db"))
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitForOpenHandleN(t, fs, 0)
+	baseline := gaugeValue(t, "litefs_fuse_open_handle_count")
+
+	// Open more handles than the soft limit, which only logs a warning.
+	files := make([]*os.File, 10)
+	for i := range files {
+		f, err := os.Open(filepath.Join(fs.Path(), "db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = f
+	}
+	if got, want := fs.OpenHandleN(), len(files); got != want {
+		t.Fatalf("OpenHandleN=%d, want %d", got, want)
+	} else if got, want := gaugeValue(t, "litefs_fuse_open_handle_count"), baseline+float64(len(files)); got != want {
+		t.Fatalf("gauge=%v, want %v", got, want)
+	}
+
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForOpenHandleN(t, fs, 0)
+
+	if got, want := gaugeValue(t, "litefs_fuse_open_handle_count"), baseline; got != want {
+		t.Fatalf("gauge=%v, want %v", got, want)
+	}
+}
+
+// Ensure open handles fail with ESTALE after the node steps down as primary
+// when enabled & are unaffected otherwise.
+func TestFileSystem_InvalidateHandlesOnRoleChange(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires linux")
+	}
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("Enabled=%v", enabled), func(t *testing.T) {
+			path := t.TempDir()
+			store := litefs.NewStore(filepath.Join(path, ".mnt"))
+			store.Leaser = &testLeaser{}
+			if err := store.Open(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = store.Close() })
+			waitForPrimary(t, store, true)
+
+			fs := fuse.NewFileSystem(filepath.Join(path, "mnt"), store)
+			fs.InvalidateHandlesOnRoleChange = enabled
+			if err := os.MkdirAll(fs.Path(), 0777); err != nil {
+				t.Fatal(err)
+			} else if err := fs.Mount(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				if err := fs.Unmount(); err != nil {
+					t.Errorf("cannot unmount: %s", err)
+				}
+			})
+			store.Invalidator = fs
+
+			db := testingutil.OpenSQLDB(t, filepath.Join(fs.Path(), "db"))
+			if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+				t.Fatal(err)
+			} else if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(filepath.Join(fs.Path(), "db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if err := lockShared(f); err != nil {
+				t.Fatal(err)
+			}
+
+			// Force the node to step down & become a replica.
+			store.Demote()
+			waitForPrimary(t, store, false)
+
+			if err := lockShared(f); enabled && !errors.Is(err, syscall.ESTALE) {
+				t.Fatalf("unexpected error: %v", err)
+			} else if !enabled && err != nil {
+				t.Fatal(err)
+			}
+
+			// Handles opened after the role change are always valid.
+			other, err := os.Open(filepath.Join(fs.Path(), "db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer other.Close()
+			if err := lockShared(other); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Ensure the mount is read-only on a replica & read-write on the primary
+// without a remount.
+func TestFileSystem_ReadOnlyReplica(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires linux")
+	}
+
+	path := t.TempDir()
+	store := litefs.NewStore(filepath.Join(path, ".mnt"))
+	store.Leaser = &testLeaser{}
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	waitForPrimary(t, store, true)
+
+	fs := fuse.NewFileSystem(filepath.Join(path, "mnt"), store)
+	fs.ReadOnlyReplica = true
+	if err := os.MkdirAll(fs.Path(), 0777); err != nil {
+		t.Fatal(err)
+	} else if err := fs.Mount(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := fs.Unmount(); err != nil {
+			t.Errorf("cannot unmount: %s", err)
+		}
+	})
+	store.Invalidator = fs
+
+	dsn := filepath.Join(fs.Path(), "db")
+	db := testingutil.OpenSQLDB(t, dsn)
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary is read-write.
+	waitForFileMode(t, fs.Path(), os.ModeDir|0777)
+	waitForFileMode(t, dsn, 0666)
+	if f, err := os.OpenFile(dsn, os.O_RDWR, 0666); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step down so the node becomes a replica.
+	store.Demote()
+	waitForPrimary(t, store, false)
+
+	waitForFileMode(t, fs.Path(), os.ModeDir|0555)
+	waitForFileMode(t, dsn, 0444)
+	if _, err := os.OpenFile(dsn, os.O_RDWR, 0666); !errors.Is(err, syscall.EROFS) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f, err := os.Open(dsn); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure write contention is reported as busy so SQLite returns SQLITE_BUSY,
+// & that contended locks are retried for the busy timeout when set.
+func TestFileSystem_BusyTimeout(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		fs := newOpenFileSystem(t)
+		dsn := filepath.Join(fs.Path(), "db")
+		if _, err := testingutil.OpenSQLDB(t, dsn).Exec(`CREATE TABLE t (x)`); err != nil {
+			t.Fatal(err)
+		}
+
+		f0, f1 := mustOpenFile(t, dsn), mustOpenFile(t, dsn)
+		if err := setLock(f0, syscall.F_WRLCK, litefs.LockTypeReserved); err != nil {
+			t.Fatal(err)
+		} else if err := setLock(f1, syscall.F_WRLCK, litefs.LockTypeReserved); err != syscall.EAGAIN {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		fs := newOpenFileSystem(t)
+		fs.BusyTimeout = 5 * time.Second
+		dsn := filepath.Join(fs.Path(), "db")
+		if _, err := testingutil.OpenSQLDB(t, dsn).Exec(`CREATE TABLE t (x)`); err != nil {
+			t.Fatal(err)
+		}
+
+		// Release the lock while the second handle is waiting for it.
+		f0, f1 := mustOpenFile(t, dsn), mustOpenFile(t, dsn)
+		if err := setLock(f0, syscall.F_WRLCK, litefs.LockTypeReserved); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = setLock(f0, syscall.F_UNLCK, litefs.LockTypeReserved)
+		}()
+
+		start := time.Now()
+		if err := setLock(f1, syscall.F_WRLCK, litefs.LockTypeReserved); err != nil {
+			t.Fatal(err)
+		} else if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Fatalf("lock acquired after %s, expected wait", elapsed)
+		} else if err := setLock(f1, syscall.F_UNLCK, litefs.LockTypeReserved); err != nil {
+			t.Fatal(err)
+		}
+
+		// A lock that is never released is still reported as busy.
+		fs.BusyTimeout = 100 * time.Millisecond
+		if err := setLock(f0, syscall.F_WRLCK, litefs.LockTypeReserved); err != nil {
+			t.Fatal(err)
+		} else if err := setLock(f1, syscall.F_WRLCK, litefs.LockTypeReserved); err != syscall.EAGAIN {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Concurrent writers without a busy timeout receive SQLITE_BUSY, rather
+	// than an I/O error, & all writes succeed once retried.
+	t.Run("ConcurrentWriters", func(t *testing.T) {
+		const writerN, insertN = 4, 25
+
+		fs := newOpenFileSystem(t)
+		fs.BusyTimeout = 10 * time.Millisecond
+		dsn := filepath.Join(fs.Path(), "db")
+		if _, err := testingutil.OpenSQLDB(t, dsn).Exec(`CREATE TABLE t (x)`); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, writerN)
+		for i := 0; i < writerN; i++ {
+			db := testingutil.OpenSQLDB(t, dsn+"?_busy_timeout=0&_txlock=immediate")
+			db.SetMaxOpenConns(1)
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < insertN; {
+					var e sqlite3.Error
+					if _, err := db.Exec(`INSERT INTO t VALUES (?)`, i*insertN+j); errors.As(err, &e) && e.Code == sqlite3.ErrBusy {
+						time.Sleep(time.Millisecond)
+						continue
+					} else if err != nil {
+						errs <- fmt.Errorf("writer %d: %w", i, err)
+						return
+					}
+					j++
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		var n int
+		if err := testingutil.OpenSQLDB(t, dsn).QueryRow(`SELECT COUNT(*) FROM t`).Scan(&n); err != nil {
+			t.Fatal(err)
+		} else if got, want := n, writerN*insertN; got != want {
+			t.Fatalf("n=%d, want %d", got, want)
+		}
+	})
+}
+
+// mustOpenFile opens the file at path for reading & writing.
+func mustOpenFile(tb testing.TB, path string) *os.File {
+	tb.Helper()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+// setLock sets a lock of the given type on a single SQLite lock byte of f.
+func setLock(f *os.File, typ int16, lockType litefs.LockType) error {
+	lock := syscall.Flock_t{Type: typ, Whence: io.SeekStart, Start: int64(lockType), Len: 1}
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock)
+}
+
 func newFileSystem(tb testing.TB) *fuse.FileSystem {
 	tb.Helper()
 
@@ -264,3 +741,118 @@ func newOpenFileSystem(tb testing.TB) *fuse.FileSystem {
 
 	return fs
 }
+
+// histogramSampleCount returns the number of observations recorded by the
+// named histogram in the default registry for the given labels.
+func histogramSampleCount(tb testing.TB, name, op, file string) uint64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if labels["op"] == op && labels["file"] == file {
+				return m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+// waitForOpenHandleN waits for the kernel to release handles asynchronously.
+func waitForOpenHandleN(tb testing.TB, fs *fuse.FileSystem, n int) {
+	tb.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fs.OpenHandleN() != n {
+		if time.Now().After(deadline) {
+			tb.Fatalf("OpenHandleN=%d, want %d", fs.OpenHandleN(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// gaugeValue returns the value of the named unlabeled gauge in the default
+// registry.
+func gaugeValue(tb testing.TB, name string) float64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name && len(mf.GetMetric()) > 0 {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+// waitForFileMode waits for the file at path to report mode. Cached
+// attributes are invalidated asynchronously after a role change.
+func waitForFileMode(tb testing.TB, path string, mode os.FileMode) {
+	tb.Helper()
+	testingutil.RetryUntil(tb, 10*time.Millisecond, 5*time.Second, func() error {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		} else if got := fi.Mode(); got != mode {
+			return fmt.Errorf("mode(%s)=%s, want %s", filepath.Base(path), got, mode)
+		}
+		return nil
+	})
+}
+
+// lockShared acquires & releases the SQLite SHARED lock through f so that the
+// request always reaches the file system rather than the kernel page cache.
+func lockShared(f *os.File) error {
+	lock := syscall.Flock_t{Type: syscall.F_RDLCK, Whence: io.SeekStart, Start: litefs.LockTypeShared, Len: 1}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock); err != nil {
+		return err
+	}
+	lock.Type = syscall.F_UNLCK
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock)
+}
+
+// waitForPrimary waits for the store to acquire or release the primary lease.
+func waitForPrimary(tb testing.TB, store *litefs.Store, isPrimary bool) {
+	tb.Helper()
+	testingutil.RetryUntil(tb, 10*time.Millisecond, 5*time.Second, func() error {
+		if got := store.IsPrimary(); got != isPrimary {
+			return fmt.Errorf("IsPrimary=%v, want %v", got, isPrimary)
+		}
+		return nil
+	})
+}
+
+// testLeaser grants a lease that is always renewed. No other node is ever the
+// primary so the store becomes a replica with no primary once demoted.
+type testLeaser struct{}
+
+func (l *testLeaser) Close() error         { return nil }
+func (l *testLeaser) AdvertiseURL() string { return "http://localhost:20202" }
+
+func (l *testLeaser) Acquire(ctx context.Context) (litefs.Lease, error) {
+	return &testLease{}, nil
+}
+
+func (l *testLeaser) PrimaryURL(ctx context.Context) (string, error) {
+	return "", litefs.ErrNoPrimary
+}
+
+type testLease struct{}
+
+func (l *testLease) RenewedAt() time.Time            { return time.Now() }
+func (l *testLease) TTL() time.Duration              { return 10 * time.Second }
+func (l *testLease) Renew(ctx context.Context) error { return nil }
+func (l *testLease) Close() error                    { return nil }
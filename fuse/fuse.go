@@ -7,6 +7,8 @@ import (
 	"syscall"
 
 	"bazil.org/fuse"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/superfly/litefs"
 )
 
@@ -38,12 +40,58 @@ func ParseFilename(name string) (dbName string, fileType litefs.FileType) {
 	return name, litefs.FileTypeDatabase
 }
 
+// IsSuperJournalFilename returns true if name is a SQLite super-journal. SQLite
+// creates one named "<db>-mjXXXXXXXXX" when a transaction writes to multiple
+// attached databases so that they commit atomically.
+func IsSuperJournalFilename(name string) bool {
+	i := strings.LastIndex(name, "-mj")
+	if i == -1 || len(name)-(i+3) != 9 {
+		return false
+	}
+	for _, ch := range name[i+3:] {
+		if !strings.ContainsRune("0123456789ABCDEFabcdef", ch) {
+			return false
+		}
+	}
+	return true
+}
+
+// TempFilePrefix is the prefix SQLite uses to name its temporary files, such
+// as those backing temp tables & sorts.
+const TempFilePrefix = "etilqs_"
+
+// IsTempFilename returns true if name is a SQLite temporary file. These are
+// only created in the mount if SQLite's temp directory is set to it.
+func IsTempFilename(name string) bool {
+	return strings.HasPrefix(name, TempFilePrefix)
+}
+
 // ToError converts an error to a wrapped error with a FUSE status code.
+//
+// Writes to a replica return EACCES as SQLite reports a failure to create the
+// journal with EACCES as SQLITE_READONLY. Other errors, including EROFS, cause
+// SQLite to retry the open as read-only & report an unrelated ENOENT error.
 func ToError(err error) error {
 	if os.IsNotExist(err) {
 		return &Error{err: err, errno: fuse.ENOENT}
 	} else if err == litefs.ErrReadOnlyReplica {
-		return &Error{err: err, errno: fuse.Errno(syscall.EROFS)}
+		return &Error{err: err, errno: fuse.Errno(syscall.EACCES)}
+	} else if err == litefs.ErrNoSpace || err == litefs.ErrDatabaseTooLarge {
+		return &Error{err: err, errno: fuse.Errno(syscall.ENOSPC)}
+	} else if err == litefs.ErrDatabaseFrozen {
+		return &Error{err: err, errno: fuse.Errno(syscall.EACCES)}
+	} else if err == litefs.ErrSyncing {
+		return &Error{err: err, errno: fuse.Errno(syscall.EAGAIN)}
+	} else if err == litefs.ErrStandby || err == litefs.ErrPinnedPrimary {
+		return &Error{err: err, errno: fuse.Errno(syscall.EACCES)}
+	} else if err == litefs.ErrShuttingDown {
+		return &Error{err: err, errno: fuse.Errno(syscall.EACCES)}
+	} else if err == litefs.ErrTXIDExhausted {
+		return &Error{err: err, errno: fuse.Errno(syscall.EOVERFLOW)}
+	} else if err == litefs.ErrCrossDBTx {
+		return &Error{err: err, errno: fuse.Errno(syscall.EPERM)}
+	} else if err == litefs.ErrStaleHandle {
+		return &Error{err: err, errno: fuse.Errno(syscall.ESTALE)}
 	}
 	return err
 }
@@ -62,3 +110,49 @@ func assert(condition bool, msg string) {
 		panic("assertion failed: " + msg)
 	}
 }
+
+// FUSE metrics. Read-path operations dominate on replicas so they are
+// reported separately from write-path operations.
+var (
+	readPathDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "litefs_fuse_read_path_duration_seconds",
+		Help:    "Latency of FUSE read-path operations, by operation & file type.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"op", "file"})
+
+	writePathDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "litefs_fuse_write_path_duration_seconds",
+		Help:    "Latency of FUSE write-path operations, by operation & file type.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"op", "file"})
+
+	openHandleCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_fuse_open_handle_count",
+		Help: "Number of open FUSE database & journal file handles.",
+	})
+
+	nodeCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_fuse_node_count",
+		Help: "Number of FUSE nodes cached by the root directory.",
+	})
+
+	lockBusyCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_fuse_lock_busy_total",
+		Help: "Number of SQLite lock requests rejected as busy because of contention.",
+	})
+)
+
+// Latency observers for each FUSE operation. These are resolved once as the
+// label lookup would otherwise run on every read & write.
+var (
+	databaseOpenReadDurationMetric  = readPathDurationMetric.WithLabelValues("open", "database")
+	databaseOpenWriteDurationMetric = writePathDurationMetric.WithLabelValues("open", "database")
+	databaseFsyncDurationMetric     = writePathDurationMetric.WithLabelValues("fsync", "database")
+	databaseReadDurationMetric      = readPathDurationMetric.WithLabelValues("read", "database")
+	databaseWriteDurationMetric     = writePathDurationMetric.WithLabelValues("write", "database")
+
+	journalOpenDurationMetric  = writePathDurationMetric.WithLabelValues("open", "journal")
+	journalFsyncDurationMetric = writePathDurationMetric.WithLabelValues("fsync", "journal")
+	journalReadDurationMetric  = writePathDurationMetric.WithLabelValues("read", "journal")
+	journalWriteDurationMetric = writePathDurationMetric.WithLabelValues("write", "journal")
+)
@@ -59,11 +59,81 @@ func TestToErrno(t *testing.T) {
 		}
 	})
 
-	t.Run("EROFS", func(t *testing.T) {
+	t.Run("ReadOnlyReplica", func(t *testing.T) {
 		err := fuse.ToError(litefs.ErrReadOnlyReplica).(*fuse.Error)
-		if got, want := err.Error(), `read only replica`; got != want {
+		if got, want := err.Error(), `cannot write: node is a read-only replica`; got != want {
 			t.Fatalf("Error()=%q, want %q", got, want)
-		} else if got, want := syscall.Errno(err.Errno()), syscall.EROFS; got != want {
+		} else if got, want := syscall.Errno(err.Errno()), syscall.EACCES; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("ENOSPC", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrNoSpace).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.ENOSPC; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("DatabaseTooLarge", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrDatabaseTooLarge).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.ENOSPC; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("EACCES", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrDatabaseFrozen).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.EACCES; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("Standby", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrStandby).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.EACCES; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("PinnedPrimary", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrPinnedPrimary).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.EACCES; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("ShuttingDown", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrShuttingDown).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.EACCES; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("EAGAIN", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrSyncing).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.EAGAIN; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("EOVERFLOW", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrTXIDExhausted).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.EOVERFLOW; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("EPERM", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrCrossDBTx).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.EPERM; got != want {
+			t.Fatalf("Errno()=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("ESTALE", func(t *testing.T) {
+		err := fuse.ToError(litefs.ErrStaleHandle).(*fuse.Error)
+		if got, want := syscall.Errno(err.Errno()), syscall.ESTALE; got != want {
 			t.Fatalf("Errno()=%v, want %v", got, want)
 		}
 	})
@@ -75,6 +145,42 @@ func TestToErrno(t *testing.T) {
 	})
 }
 
+func TestIsSuperJournalFilename(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  bool
+	}{
+		{"db-mj4A1C2E93B", true},
+		{"my.db-mj0000009FF", true},
+		{"db", false},
+		{"db-journal", false},
+		{"db-mj", false},
+		{"db-mj4A1C2E9", false},
+		{"db-mj4A1C2E93Z", false},
+	} {
+		if got := fuse.IsSuperJournalFilename(tt.input); got != tt.want {
+			t.Fatalf("IsSuperJournalFilename(%q)=%v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestIsTempFilename(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  bool
+	}{
+		{"etilqs_3c5a2f6d1e0b9a87", true},
+		{"etilqs_", true},
+		{"db", false},
+		{"db-journal", false},
+		{"my_etilqs_db", false},
+	} {
+		if got := fuse.IsTempFilename(tt.input); got != tt.want {
+			t.Fatalf("IsTempFilename(%q)=%v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
 func TestParseFilename(t *testing.T) {
 	for _, tt := range []struct {
 		input    string
@@ -0,0 +1,45 @@
+package fuse
+
+import (
+	"context"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// IsPrimaryFilename is the name of the file that exists only while the node
+// holds the primary lease. Applications can check for its existence to
+// determine if they can write to the databases on this node.
+const IsPrimaryFilename = ".is-primary"
+
+var _ fs.Node = (*IsPrimaryNode)(nil)
+var _ fs.NodeForgetter = (*IsPrimaryNode)(nil)
+
+// IsPrimaryNode represents an empty file that exists only on the primary.
+type IsPrimaryNode struct {
+	fsys *FileSystem
+}
+
+func newIsPrimaryNode(fsys *FileSystem) *IsPrimaryNode {
+	return &IsPrimaryNode{fsys: fsys}
+}
+
+func (n *IsPrimaryNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	if !n.fsys.store.IsPrimary() {
+		return fuse.Errno(syscall.ENOENT)
+	}
+	attr.Mode = 0444
+	attr.Uid = uint32(n.fsys.Uid)
+	attr.Gid = uint32(n.fsys.Gid)
+	return nil
+}
+
+func (n *IsPrimaryNode) ReadAll(ctx context.Context) ([]byte, error) {
+	if !n.fsys.store.IsPrimary() {
+		return nil, fuse.Errno(syscall.ENOENT)
+	}
+	return []byte{}, nil
+}
+
+func (n *IsPrimaryNode) Forget() { n.fsys.root.ForgetNode(n) }
@@ -8,6 +8,7 @@ import (
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/superfly/litefs"
 )
 
@@ -30,7 +31,7 @@ func (n *JournalNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 		return err
 	}
 
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode()
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -38,6 +39,8 @@ func (n *JournalNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 }
 
 func (n *JournalNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	defer prometheus.NewTimer(journalOpenDurationMetric).ObserveDuration()
+
 	f, err := os.OpenFile(n.db.JournalPath(), os.O_RDWR, 0666)
 	if err != nil {
 		return nil, err
@@ -47,6 +50,8 @@ func (n *JournalNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fus
 
 // Fsync performs an fsync() on the underlying file.
 func (n *JournalNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	defer prometheus.NewTimer(journalFsyncDurationMetric).ObserveDuration()
+
 	f, err := os.Open(n.db.JournalPath())
 	if err != nil {
 		return err
@@ -84,13 +89,21 @@ var _ fs.HandleWriter = (*JournalHandle)(nil)
 type JournalHandle struct {
 	node *JournalNode
 	file *os.File
+	gen  uint64 // role generation when opened
 }
 
 func newJournalHandle(node *JournalNode, file *os.File) *JournalHandle {
-	return &JournalHandle{node: node, file: file}
+	node.fsys.addHandle()
+	return &JournalHandle{node: node, file: file, gen: node.fsys.roleGeneration()}
 }
 
 func (h *JournalHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	defer prometheus.NewTimer(journalReadDurationMetric).ObserveDuration()
+
+	if h.node.fsys.isStale(h.gen) {
+		return ToError(litefs.ErrStaleHandle)
+	}
+
 	n, err := h.file.ReadAt(resp.Data, req.Offset)
 	if n != len(resp.Data) {
 		return io.ErrShortBuffer
@@ -99,6 +112,12 @@ func (h *JournalHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *f
 }
 
 func (h *JournalHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	defer prometheus.NewTimer(journalWriteDurationMetric).ObserveDuration()
+
+	if h.node.fsys.isStale(h.gen) {
+		return ToError(litefs.ErrStaleHandle)
+	}
+
 	if err := h.node.db.WriteJournal(h.file, req.Data, req.Offset); err != nil {
 		log.Printf("fuse: write(): journal error: %s", err)
 		return err
@@ -113,6 +132,7 @@ func (h *JournalHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error
 }
 
 func (h *JournalHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	defer h.node.fsys.removeHandle()
 	h.file.Close()
 	return nil
 }
@@ -48,6 +48,9 @@ func (n *RootNode) Node(name string) fs.Node {
 func (n *RootNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 	attr.Inode = RootInode
 	attr.Mode = os.ModeDir | 0777
+	if n.fsys.readOnly() {
+		attr.Mode = os.ModeDir | 0555
+	}
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
 	return nil
@@ -58,16 +61,27 @@ func (n *RootNode) Lookup(ctx context.Context, name string) (node fs.Node, err e
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
+	// The primary status file only exists while this node holds the lease.
+	if name == IsPrimaryFilename && !n.fsys.store.IsPrimary() {
+		return nil, syscall.ENOENT
+	}
+
 	// Check if we've already seen this node.
 	if node = n.nodes[name]; node != nil {
 		return node, nil
 	}
 
-	switch name {
-	case PrimaryFilename:
+	switch {
+	case name == PrimaryFilename:
 		if node, err = n.lookupPrimaryNode(ctx); err != nil {
 			return nil, err
 		}
+	case name == IsPrimaryFilename:
+		node = newIsPrimaryNode(n.fsys)
+	case n.isTemp(name):
+		if node, err = n.lookupTempNode(ctx, name); err != nil {
+			return nil, err
+		}
 	default:
 		if node, err = n.lookupDBNode(ctx, name); err != nil {
 			return nil, err
@@ -75,7 +89,7 @@ func (n *RootNode) Lookup(ctx context.Context, name string) (node fs.Node, err e
 	}
 
 	// Cache node on successful lookup.
-	n.nodes[name] = node
+	n.setNode(name, node)
 
 	return node, nil
 }
@@ -88,6 +102,16 @@ func (n *RootNode) lookupPrimaryNode(ctx context.Context) (fs.Node, error) {
 	return newPrimaryNode(n.fsys), nil
 }
 
+func (n *RootNode) lookupTempNode(ctx context.Context, name string) (fs.Node, error) {
+	node := newTempNode(n.fsys, name)
+	if _, err := os.Stat(node.Path()); os.IsNotExist(err) {
+		return nil, fuse.ENOENT
+	} else if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
 func (n *RootNode) lookupDBNode(ctx context.Context, name string) (fs.Node, error) {
 	dbName, fileType := ParseFilename(name)
 
@@ -117,6 +141,23 @@ func (n *RootNode) Create(ctx context.Context, req *fuse.CreateRequest, resp *fu
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
+	// Each database is replicated independently so a transaction spanning
+	// attached databases cannot be applied atomically on replicas. Refusing
+	// to create the super-journal causes SQLite to fail the commit instead.
+	if IsSuperJournalFilename(req.Name) {
+		log.Printf("fuse: create(): rejecting cross-database transaction: %s", req.Name)
+		return nil, nil, ToError(litefs.ErrCrossDBTx)
+	}
+
+	// Temporary files are kept locally so they can be written on replicas.
+	if n.isTemp(req.Name) {
+		if node, h, err = n.createTemp(ctx, req); err != nil {
+			return nil, nil, err
+		}
+		n.setNode(req.Name, node)
+		return node, h, nil
+	}
+
 	dbName, fileType := ParseFilename(req.Name)
 
 	switch fileType {
@@ -133,7 +174,7 @@ func (n *RootNode) Create(ctx context.Context, req *fuse.CreateRequest, resp *fu
 	}
 
 	// Cache node on creation.
-	n.nodes[req.Name] = node
+	n.setNode(req.Name, node)
 
 	return node, h, nil
 }
@@ -148,7 +189,7 @@ func (n *RootNode) createDatabase(ctx context.Context, dbName string, req *fuse.
 	}
 
 	node := newDatabaseNode(n.fsys, db)
-	return node, &DatabaseHandle{node: node, file: file}, nil
+	return node, newDatabaseHandle(node, file), nil
 }
 
 func (n *RootNode) createJournal(ctx context.Context, dbName string, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
@@ -168,6 +209,24 @@ func (n *RootNode) createJournal(ctx context.Context, dbName string, req *fuse.C
 	return node, newJournalHandle(node, file), nil
 }
 
+func (n *RootNode) createTemp(ctx context.Context, req *fuse.CreateRequest) (fs.Node, fs.Handle, error) {
+	node := newTempNode(n.fsys, req.Name)
+	file, err := os.OpenFile(node.Path(), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, nil, fuse.Errno(syscall.EEXIST)
+	} else if err != nil {
+		log.Printf("fuse: create(): cannot create temp file: %s", err)
+		return nil, nil, err
+	}
+	return node, newTempHandle(file), nil
+}
+
+// isTemp returns true if name is a SQLite temporary file that is stored in
+// the local temp directory.
+func (n *RootNode) isTemp(name string) bool {
+	return n.fsys.TempDir != "" && IsTempFilename(name)
+}
+
 // Fsync is a no-op as directory sync is handled by the file.
 // This is required as the database files are grouped by database internally.
 func (n *RootNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
@@ -178,11 +237,24 @@ func (n *RootNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.O
 	return NewRootHandle(n), nil
 }
 
-// Remove deletes the file from disk. This is only supported on the journal file currently.
+// Remove deletes the file from disk. Removing the journal commits the current
+// transaction. Removing the database drops it on the primary & its replicas.
 func (n *RootNode) Remove(ctx context.Context, req *fuse.RemoveRequest) (err error) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
+	// SQLite removes temporary files while they are still open. The open
+	// handles keep using the underlying file until they are released.
+	if n.isTemp(req.Name) {
+		if err := os.Remove(newTempNode(n.fsys, req.Name).Path()); os.IsNotExist(err) {
+			return fuse.ToErrno(syscall.ENOENT)
+		} else if err != nil {
+			return err
+		}
+		n.deleteNode(req.Name)
+		return nil
+	}
+
 	dbName, fileType := ParseFilename(req.Name)
 
 	db := n.fsys.store.DBByName(dbName)
@@ -193,6 +265,13 @@ func (n *RootNode) Remove(ctx context.Context, req *fuse.RemoveRequest) (err err
 	switch fileType {
 	case litefs.FileTypeJournal:
 		return db.CommitJournal(litefs.JournalModeDelete)
+	case litefs.FileTypeDatabase:
+		if err := n.fsys.store.DropDB(dbName); err != nil {
+			return ToError(err)
+		}
+		n.deleteNode(req.Name)
+		n.deleteNode(dbName + "-journal")
+		return nil
 	default:
 		return fuse.ToErrno(syscall.ENOSYS)
 	}
@@ -204,11 +283,27 @@ func (n *RootNode) ForgetNode(node fs.Node) {
 	defer n.mu.Unlock()
 	for k, v := range n.nodes {
 		if v == node {
-			delete(n.nodes, k)
+			n.deleteNode(k)
 		}
 	}
 }
 
+// setNode caches node by name. Must hold mu.
+func (n *RootNode) setNode(name string, node fs.Node) {
+	if _, ok := n.nodes[name]; !ok {
+		nodeCountMetric.Inc()
+	}
+	n.nodes[name] = node
+}
+
+// deleteNode removes the cached node by name, if any. Must hold mu.
+func (n *RootNode) deleteNode(name string) {
+	if _, ok := n.nodes[name]; ok {
+		nodeCountMetric.Dec()
+		delete(n.nodes, name)
+	}
+}
+
 var _ fs.Handle = (*RootHandle)(nil)
 var _ fs.HandleReadDirAller = (*RootHandle)(nil)
 
@@ -231,6 +326,14 @@ func (h *RootHandle) ReadDirAll(ctx context.Context) (ents []fuse.Dirent, err er
 		})
 	}
 
+	// Show ".is-primary" file if this node currently holds the primary lease.
+	if h.node.fsys.store.IsPrimary() {
+		ents = append(ents, fuse.Dirent{
+			Name: IsPrimaryFilename,
+			Type: fuse.DT_File,
+		})
+	}
+
 	// Return a list of database files.
 	dbs := h.node.fsys.store.DBs()
 	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name() < dbs[j].Name() })
@@ -0,0 +1,107 @@
+package fuse
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+var _ fs.Node = (*TempNode)(nil)
+var _ fs.NodeOpener = (*TempNode)(nil)
+var _ fs.NodeFsyncer = (*TempNode)(nil)
+var _ fs.NodeSetattrer = (*TempNode)(nil)
+var _ fs.NodeForgetter = (*TempNode)(nil)
+
+// TempNode represents a SQLite temporary file, such as one backing a temp
+// table or a sort. Temporary files are stored in the local temp directory &
+// are never replicated so they can be written on replicas.
+type TempNode struct {
+	fsys *FileSystem
+	name string
+}
+
+func newTempNode(fsys *FileSystem, name string) *TempNode {
+	return &TempNode{fsys: fsys, name: name}
+}
+
+// Path returns the path to the underlying file in the temp directory.
+func (n *TempNode) Path() string { return filepath.Join(n.fsys.TempDir, n.name) }
+
+func (n *TempNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	fi, err := os.Stat(n.Path())
+	if err != nil {
+		return err
+	}
+
+	attr.Mode = 0600
+	attr.Size = uint64(fi.Size())
+	attr.Uid = uint32(n.fsys.Uid)
+	attr.Gid = uint32(n.fsys.Gid)
+	return nil
+}
+
+func (n *TempNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	f, err := os.OpenFile(n.Path(), os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return newTempHandle(f), nil
+}
+
+// Fsync is a no-op as temporary files do not need to survive a crash.
+func (n *TempNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	return nil
+}
+
+func (n *TempNode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	// Only allow size updates.
+	if req.Valid.Size() {
+		if err := os.Truncate(n.Path(), int64(req.Size)); err != nil {
+			return err
+		}
+	}
+
+	return n.Attr(ctx, &resp.Attr)
+}
+
+func (n *TempNode) Forget() { n.fsys.root.ForgetNode(n) }
+
+var _ fs.Handle = (*TempHandle)(nil)
+var _ fs.HandleReader = (*TempHandle)(nil)
+var _ fs.HandleWriter = (*TempHandle)(nil)
+var _ fs.HandleReleaser = (*TempHandle)(nil)
+
+// TempHandle represents a file handle to a SQLite temporary file.
+type TempHandle struct {
+	file *os.File
+}
+
+func newTempHandle(file *os.File) *TempHandle {
+	return &TempHandle{file: file}
+}
+
+func (h *TempHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+	n, err := h.file.ReadAt(buf, req.Offset)
+	if err == io.EOF {
+		err = nil
+	}
+	resp.Data = buf[:n]
+	return err
+}
+
+func (h *TempHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if _, err := h.file.WriteAt(req.Data, req.Offset); err != nil {
+		return err
+	}
+	resp.Size = len(req.Data)
+	return nil
+}
+
+func (h *TempHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.file.Close()
+}
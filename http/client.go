@@ -2,28 +2,355 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/internal"
+	"github.com/superfly/ltx"
 )
 
 var _ litefs.Client = (*Client)(nil)
+var _ litefs.CandidateClient = (*Client)(nil)
+
+// ErrStreamReadTimeout is returned when no data is received from the primary
+// within the client's read timeout.
+var ErrStreamReadTimeout = errors.New("stream read timeout")
+
+// Stream transports.
+const (
+	TransportHTTP      = "http"
+	TransportWebSocket = "websocket"
+)
+
+// Default connection settings for clients.
+const (
+	DefaultDialTimeout         = 5 * time.Second
+	DefaultKeepAlive           = 15 * time.Second
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultMaxIdleConnsPerHost = 2
+)
+
+// TransportOptions represents the connection settings used by a client to
+// connect to other nodes.
+type TransportOptions struct {
+	// Maximum time to wait for a connection to be established.
+	// Set to zero to use the operating system's timeout.
+	DialTimeout time.Duration
+
+	// Interval between TCP keep-alive probes on open connections.
+	// Set to a negative value to disable keep-alives.
+	KeepAlive time.Duration
+
+	// Maximum time an unused connection is kept open for reuse.
+	// Set to zero to keep connections open indefinitely.
+	IdleConnTimeout time.Duration
+
+	// Maximum number of unused connections kept open to each node.
+	MaxIdleConnsPerHost int
+}
+
+// DefaultTransportOptions returns the default connection settings.
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		DialTimeout:         DefaultDialTimeout,
+		KeepAlive:           DefaultKeepAlive,
+		IdleConnTimeout:     DefaultIdleConnTimeout,
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+	}
+}
 
 // Client represents an client for a streaming LiteFS HTTP server.
 type Client struct {
 	// Underlying HTTP client
 	HTTPClient *http.Client
+
+	// Transport used to stream changes from the primary. Defaults to a
+	// long-running HTTP response but may be set to TransportWebSocket for
+	// replicas behind proxies that do not handle streaming responses well.
+	Transport string
+
+	// ID of the local node. Sent to the primary when streaming so that it can
+	// detect nodes configured with the same ID.
+	NodeID string
+
+	// Advertised URL of the local node. Sent when streaming so that the
+	// upstream node can report it as a downstream consumer.
+	AdvertiseURL string
+
+	// Auth token sent as a bearer token to admin endpoints, such as
+	// /admin/drain, when the remote server requires one.
+	AuthToken string
+
+	// Interval between heartbeats sent back to the primary while streaming.
+	HeartbeatInterval time.Duration
+
+	// Maximum time to wait for data, including heartbeats, from the primary
+	// before the stream is considered dead. Set to zero to disable.
+	ReadTimeout time.Duration
+
+	// Guards the stream timeouts once the client is in use.
+	timeoutsMu sync.Mutex
+
+	// Collapses repeated heartbeat errors while the primary is unreachable.
+	errLog *internal.RateLimitedLogger
 }
 
 // NewClient returns an instance of Client.
 func NewClient() *Client {
-	return &Client{
-		HTTPClient: http.DefaultClient,
+	c := &Client{
+		Transport:         TransportHTTP,
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		ReadTimeout:       DefaultStreamReadTimeout,
+
+		errLog: internal.NewRateLimitedLogger(nil, litefs.ErrorLogInterval),
+	}
+	c.SetTransportOptions(DefaultTransportOptions())
+	return c
+}
+
+// SetTransportOptions replaces HTTPClient with a client using the connection
+// settings in opts. Must be called before the client is in use.
+func (c *Client) SetTransportOptions(opts TransportOptions) {
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+
+	// Streams are long-running so no overall request timeout is set.
+	c.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+			IdleConnTimeout:       opts.IdleConnTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
+// DBs returns a list of databases on the server at rawurl.
+func (c *Client) DBs(ctx context.Context, rawurl string) ([]DBInfo, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/dbs"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ErrorFormatHeader, ErrorFormatJSONV1)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	var infos []DBInfo
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// PosAt returns the position of the named database on the server at rawurl
+// after the transaction with the given TXID.
+func (c *Client) PosAt(ctx context.Context, rawurl, name string, txID uint64) (*PosInfo, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/pos"
+	u.RawQuery = (url.Values{
+		"name": {name},
+		"txid": {ltx.FormatTXID(txID)},
+	}).Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set(ErrorFormatHeader, ErrorFormatJSONV1)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	var info PosInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// PageChecksums returns the checksum of each page of the named database after
+// the transaction with the given TXID was committed on the node at rawurl.
+func (c *Client) PageChecksums(ctx context.Context, rawurl, name string, txID uint64) (*PageChecksumsInfo, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/checksums"
+	u.RawQuery = (url.Values{
+		"name": {name},
+		"txid": {ltx.FormatTXID(txID)},
+	}).Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ErrorFormatHeader, ErrorFormatJSONV1)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	var info PageChecksumsInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Positions returns the election candidacy of the node at rawurl.
+func (c *Client) Positions(ctx context.Context, rawurl string) (*PositionsInfo, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/positions"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ErrorFormatHeader, ErrorFormatJSONV1)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	var info PositionsInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Candidate returns the election candidacy of the node at rawurl. Returns a
+// nil candidate if the node is not eligible to become primary.
+func (c *Client) Candidate(ctx context.Context, rawurl string) (*litefs.Candidate, error) {
+	info, err := c.Positions(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	} else if !info.Eligible {
+		return nil, nil
+	}
+
+	candidate := &litefs.Candidate{
+		URL:       info.URL,
+		Priority:  info.Priority,
+		PosMap:    make(map[uint32]litefs.Pos, len(info.DBs)),
+		Zone:      info.Zone,
+		Preferred: info.Preferred,
+	}
+	if candidate.URL == "" {
+		candidate.URL = rawurl
+	}
+	for _, db := range info.DBs {
+		candidate.PosMap[db.ID] = litefs.Pos{TXID: db.TXID, Chksum: db.Chksum}
+	}
+	return candidate, nil
+}
+
+// Drain begins draining the node at rawurl, if not already draining, and
+// returns its drain status.
+func (c *Client) Drain(ctx context.Context, rawurl string) (*DrainInfo, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/admin/drain"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ErrorFormatHeader, ErrorFormatJSONV1)
+	if c.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AuthToken)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	var info DrainInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// StreamTimeouts returns the current heartbeat interval & read timeout.
+func (c *Client) StreamTimeouts() (heartbeatInterval, readTimeout time.Duration) {
+	c.timeoutsMu.Lock()
+	defer c.timeoutsMu.Unlock()
+	return c.HeartbeatInterval, c.ReadTimeout
+}
+
+// SetStreamTimeouts updates the heartbeat interval & read timeout while the
+// client is in use. Changes only apply to streams opened afterward.
+func (c *Client) SetStreamTimeouts(heartbeatInterval, readTimeout time.Duration) {
+	c.timeoutsMu.Lock()
+	defer c.timeoutsMu.Unlock()
+	c.HeartbeatInterval, c.ReadTimeout = heartbeatInterval, readTimeout
 }
 
 // Stream returns a snapshot and continuous stream of WAL updates.
@@ -37,12 +364,32 @@ func (c *Client) Stream(ctx context.Context, rawurl string, posMap map[uint32]li
 		return nil, fmt.Errorf("URL host required")
 	}
 
-	// Strip off everything but the scheme & host.
-	*u = url.URL{
-		Scheme: u.Scheme,
-		Host:   u.Host,
-		Path:   "/stream",
+	// Canceling the context aborts the connection which allows the stream
+	// reader to time out blocked reads.
+	ctx, cancel := context.WithCancel(ctx)
+
+	var sr *StreamReader
+	switch c.Transport {
+	case "", TransportHTTP:
+		sr, err = c.streamHTTP(ctx, cancel, u, posMap)
+	case TransportWebSocket:
+		sr, err = c.streamWebSocket(ctx, cancel, u, posMap)
+	default:
+		cancel()
+		return nil, fmt.Errorf("invalid stream transport: %q", c.Transport)
 	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return sr, nil
+}
+
+func (c *Client) streamHTTP(ctx context.Context, cancel func(), u *url.URL, posMap map[uint32]litefs.Pos) (*StreamReader, error) {
+	// Strip off everything but the scheme, host & base path.
+	baseURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/")}
+	*u = baseURL
+	u.Path += "/stream"
 
 	var buf bytes.Buffer
 	if err := WritePosMapTo(&buf, posMap); err != nil {
@@ -54,35 +401,231 @@ func (c *Client) Stream(ctx context.Context, rawurl string, posMap map[uint32]li
 		return nil, err
 	}
 	req = req.WithContext(ctx)
+	req.Header.Set(ErrorFormatHeader, ErrorFormatJSONV1)
+	req.Header.Set(StreamEncodingHeader, StreamEncodingGzip)
+	if c.NodeID != "" {
+		req.Header.Set(NodeIDHeader, c.NodeID)
+	}
+	if c.AdvertiseURL != "" {
+		req.Header.Set(AdvertiseURLHeader, c.AdvertiseURL)
+	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	} else if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+
+	primaryPosMap, err := ParsePosMapHeader(resp.Header.Get(PrimaryPosHeader))
+	if err != nil {
 		resp.Body.Close()
-		return nil, fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+		return nil, err
 	}
 
-	return &StreamReader{
-		rc: resp.Body,
-		lr: io.LimitedReader{R: resp.Body},
-	}, nil
+	var body io.ReadCloser = resp.Body
+	switch encoding := resp.Header.Get(StreamEncodingHeader); encoding {
+	case "":
+	case StreamEncodingGzip:
+		body = &gzipReadCloser{rc: resp.Body}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unsupported stream encoding: %q", encoding)
+	}
+
+	sr := c.newStreamReader(ctx, cancel, body, baseURL, resp.Header.Get(StreamIDHeader))
+	sr.primaryPosMap = primaryPosMap
+	return sr, nil
+}
+
+func (c *Client) streamWebSocket(ctx context.Context, cancel func(), u *url.URL, posMap map[uint32]litefs.Pos) (*StreamReader, error) {
+	// Strip off everything but the scheme, host & base path.
+	baseURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/")}
+	*u = baseURL
+	u.Path += "/ws/stream"
+
+	// WebSocket connections use the same dialer, proxy & TLS settings as
+	// other requests, if the client uses an HTTP transport.
+	transport, _ := c.HTTPClient.Transport.(*http.Transport)
+
+	header := make(http.Header)
+	header.Set(ErrorFormatHeader, ErrorFormatJSONV1)
+	if c.NodeID != "" {
+		header.Set(NodeIDHeader, c.NodeID)
+	}
+	if c.AdvertiseURL != "" {
+		header.Set(AdvertiseURLHeader, c.AdvertiseURL)
+	}
+
+	conn, hdr, err := dialWebSocket(ctx, transport, u, header)
+	if err != nil {
+		return nil, err
+	}
+
+	primaryPosMap, err := ParsePosMapHeader(hdr.Get(PrimaryPosHeader))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Send the pos map as the first message.
+	var buf bytes.Buffer
+	if err := WritePosMapTo(&buf, posMap); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot write pos map: %w", err)
+	} else if _, err := conn.Write(buf.Bytes()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot send pos map: %w", err)
+	}
+
+	sr := c.newStreamReader(ctx, cancel, conn, baseURL, hdr.Get(StreamIDHeader))
+	sr.primaryPosMap = primaryPosMap
+	return sr, nil
+}
+
+// heartbeat sends a heartbeat for a stream to the primary.
+func (c *Client) heartbeat(ctx context.Context, baseURL url.URL, streamID string) error {
+	u := baseURL
+	u.Path += "/stream/heartbeat"
+	u.RawQuery = (url.Values{"id": {streamID}}).Encode()
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(ErrorFormatHeader, ErrorFormatJSONV1)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	return nil
+}
+
+// gzipReadCloser decompresses a gzip-encoded stream body. The gzip reader is
+// created on the first read so opening a stream does not block on the header.
+type gzipReadCloser struct {
+	rc io.ReadCloser
+	zr *gzip.Reader
+}
+
+func (r *gzipReadCloser) Read(p []byte) (int, error) {
+	if r.zr == nil {
+		zr, err := gzip.NewReader(r.rc)
+		if err != nil {
+			return 0, err
+		}
+		r.zr = zr
+	}
+	return r.zr.Read(p)
+}
+
+// Close closes the underlying stream body.
+func (r *gzipReadCloser) Close() error {
+	return r.rc.Close()
 }
 
 // StreamReader represents a stream of changes from a primary server.
 type StreamReader struct {
 	rc io.ReadCloser
 	lr io.LimitedReader
+
+	readTimeout time.Duration
+	timer       *time.Timer // cancels the connection after the read timeout
+	timedOut    int32       // set to 1 if timer fired
+
+	cancel func() // cancels the underlying connection
+	wg     sync.WaitGroup
+
+	primaryPosMap map[uint32]litefs.Pos // reported by the primary on connect
+}
+
+// PrimaryPosMap returns the position of each database on the primary at the
+// time the stream was opened.
+func (r *StreamReader) PrimaryPosMap() map[uint32]litefs.Pos {
+	return r.primaryPosMap
+}
+
+// newStreamReader returns a new StreamReader that reads from rc. The cancel
+// function must abort the connection associated with ctx. If the primary
+// assigned a stream ID then heartbeats are sent back until the reader is closed.
+func (c *Client) newStreamReader(ctx context.Context, cancel func(), rc io.ReadCloser, baseURL url.URL, streamID string) *StreamReader {
+	heartbeatInterval, readTimeout := c.StreamTimeouts()
+	r := &StreamReader{
+		rc:          rc,
+		lr:          io.LimitedReader{R: rc},
+		readTimeout: readTimeout,
+		cancel:      cancel,
+	}
+
+	if r.readTimeout > 0 {
+		r.timer = time.AfterFunc(r.readTimeout, func() {
+			atomic.StoreInt32(&r.timedOut, 1)
+			r.cancel()
+		})
+	}
+
+	if streamID != "" && heartbeatInterval > 0 {
+		r.wg.Add(1)
+		go func() { defer r.wg.Done(); r.monitorHeartbeat(ctx, c, heartbeatInterval, baseURL, streamID) }()
+	}
+
+	return r
+}
+
+// monitorHeartbeat periodically sends heartbeats until ctx is canceled.
+func (r *StreamReader) monitorHeartbeat(ctx context.Context, c *Client, interval time.Duration, baseURL url.URL, streamID string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.heartbeat(ctx, baseURL, streamID); err != nil && ctx.Err() == nil {
+				c.errLog.Printf("stream heartbeat error: %s", err)
+			}
+		}
+	}
 }
 
 // Close closes the underlying reader.
 func (r *StreamReader) Close() (err error) {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.cancel()
+	r.wg.Wait()
+
 	if e := r.rc.Close(); err == nil {
 		err = e
 	}
 	return err
 }
 
+// touch resets the read timeout after data has been received.
+func (r *StreamReader) touch() {
+	if r.timer != nil {
+		r.timer.Reset(r.readTimeout)
+	}
+}
+
+// translateError returns ErrStreamReadTimeout if the read timeout closed the reader.
+func (r *StreamReader) translateError(err error) error {
+	if err != nil && err != io.EOF && atomic.LoadInt32(&r.timedOut) == 1 {
+		return ErrStreamReadTimeout
+	}
+	return err
+}
+
 // NextFrame returns the frame from the underlying reader.. This call will block
 // until a record is available. After calling NextFrame(), the frame payload can
 // be read by calling Read() until io.EOF is reached.
@@ -90,18 +633,23 @@ func (r *StreamReader) NextFrame() (litefs.StreamFrame, error) {
 	// If bytes remain on the current file, discard.
 	if r.lr.N > 0 {
 		if _, err := io.Copy(io.Discard, &r.lr); err != nil {
-			return nil, err
+			return nil, r.translateError(err)
 		}
 	}
 
 	frame, err := litefs.ReadStreamFrame(r.rc)
 	if err != nil {
-		return nil, err
+		return nil, r.translateError(err)
 	}
+	r.touch()
 
 	// Limit body to the size from the frame data.
 	switch frame := frame.(type) {
 	case *litefs.LTXStreamFrame:
+		if frame.ChunkSize == 0 {
+			r.lr.N = frame.Size
+		}
+	case *litefs.LTXChunkStreamFrame:
 		r.lr.N = frame.Size
 	}
 
@@ -112,5 +660,9 @@ func (r *StreamReader) NextFrame() (litefs.StreamFrame, error) {
 // Read reads bytes of the current payload into p. Only valid after a successful
 // call to Next(). On io.EOF, call Next() again to begin reading next record.
 func (r *StreamReader) Read(p []byte) (n int, err error) {
-	return r.lr.Read(p)
+	n, err = r.lr.Read(p)
+	if n > 0 {
+		r.touch()
+	}
+	return n, r.translateError(err)
 }
@@ -2,12 +2,368 @@ package http
 
 import (
 	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
 )
 
+// DBInfo represents the name & position of a database returned by "GET /dbs".
+type DBInfo struct {
+	ID   uint32 `json:"id"`
+	Name string `json:"name"`
+	TXID uint64 `json:"txid"`
+
+	// If true, new write transactions on the database are rejected.
+	Frozen bool `json:"frozen,omitempty"`
+
+	// Number of transactions a replica is behind the primary & the age of
+	// the oldest transaction it has not applied. Only set on lagging replicas.
+	LagTXID    uint64  `json:"lag_txid,omitempty"`
+	LagSeconds float64 `json:"lag_seconds,omitempty"`
+
+	// Progress of the most recent catch-up to the primary. Only set on
+	// replicas that have been more than one transaction behind.
+	CatchUp *CatchUpInfo `json:"catch_up,omitempty"`
+
+	// Result of the schema version query configured for the database, such
+	// as "PRAGMA user_version". Used to confirm migrations reached all nodes.
+	SchemaVersion *int64 `json:"schema_version,omitempty"`
+}
+
+// CatalogInfo describes a single database returned by "GET /catalog".
+type CatalogInfo struct {
+	ID       uint32 `json:"id"`
+	Name     string `json:"name"`
+	TXID     uint64 `json:"txid"`
+	PageSize uint32 `json:"page_size"`
+	PageN    uint32 `json:"page_n"`
+	Size     int64  `json:"size"` // bytes, computed from the page count
+
+	// Replication status of the database on this node.
+	Status string `json:"status"`
+}
+
+// Database replication statuses reported in the catalog.
+const (
+	CatalogStatusPrimary    = "primary"
+	CatalogStatusReplica    = "replica"
+	CatalogStatusCatchingUp = "catching_up"
+	CatalogStatusResyncing  = "resyncing"
+)
+
+// SnapshotManifestName is the name of the first entry in a "GET /snapshot-all"
+// archive. The remaining entries are database files named after each database.
+const SnapshotManifestName = "manifest.json"
+
+// SnapshotManifest lists the position of every database in a "GET /snapshot-all"
+// archive. All databases were captured at the same moment.
+type SnapshotManifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Databases []PosInfo `json:"databases"`
+}
+
+// CatchUpInfo represents the progress of a replica catching up to the primary.
+type CatchUpInfo struct {
+	TXID       uint64  `json:"txid"`
+	TargetTXID uint64  `json:"target_txid"`
+	Percent    float64 `json:"percent"`
+	Rate       float64 `json:"rate"` // txids/sec
+	ETASeconds float64 `json:"eta_seconds"`
+}
+
+// NewCatchUpInfo returns the catch-up progress for db. Returns nil if the
+// database has never fallen behind the primary.
+func NewCatchUpInfo(db *litefs.DB) *CatchUpInfo {
+	c, ok := db.CatchUp()
+	if !ok {
+		return nil
+	}
+	return &CatchUpInfo{
+		TXID:       c.TXID,
+		TargetTXID: c.TargetTXID,
+		Percent:    c.Percent(),
+		Rate:       c.Rate(),
+		ETASeconds: c.ETA().Seconds(),
+	}
+}
+
+// PosInfo represents the position of a database after a transaction as
+// returned by "GET /pos". Used to validate a local copy before seeding.
+// Chksum is zero for transactions within a batch, other than the last, as
+// only the checksum after the batch is recorded.
+type PosInfo struct {
+	ID     uint32 `json:"id"`
+	Name   string `json:"name"`
+	TXID   uint64 `json:"txid"`
+	Chksum uint64 `json:"chksum"`
+
+	// Time the transaction was committed, in seconds since the Unix epoch.
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+// PageChecksumsInfo represents the checksum of each page of a database after
+// a transaction was committed as returned by "GET /checksums". Checksums are
+// indexed by page number minus one.
+type PageChecksumsInfo struct {
+	Name      string   `json:"name"`
+	TXID      uint64   `json:"txid"`
+	Chksum    uint64   `json:"chksum"`
+	PageSize  uint32   `json:"page_size"`
+	Checksums []uint64 `json:"checksums"`
+}
+
+// PositionsInfo represents the election candidacy of a node as returned by
+// "GET /positions". Candidates compare positions to promote the most
+// up-to-date node when the primary is lost.
+type PositionsInfo struct {
+	URL      string `json:"url,omitempty"`
+	Priority int    `json:"priority"`
+
+	// Availability zone of the node & whether it is the preferred zone.
+	Zone      string `json:"zone,omitempty"`
+	Preferred bool   `json:"preferred,omitempty"`
+
+	// If false, the node cannot become primary, such as when it is demoted.
+	Eligible bool `json:"eligible"`
+
+	DBs []PosInfo `json:"dbs"`
+}
+
+// Database comparison results returned by "GET /diff".
+const (
+	DiffStatusMatch       = "match"        // same TXID & checksum
+	DiffStatusBehind      = "behind"       // local TXID is lower than the peer
+	DiffStatusAhead       = "ahead"        // local TXID is higher than the peer
+	DiffStatusDiverged    = "diverged"     // same TXID with different checksums
+	DiffStatusMissingPeer = "missing_peer" // database only exists locally
+	DiffStatusMissing     = "missing"      // database only exists on the peer
+)
+
+// DiffInfo represents a comparison of the database positions on this node
+// with a peer as returned by "GET /diff".
+type DiffInfo struct {
+	PeerURL string   `json:"peer_url"`
+	InSync  bool     `json:"in_sync"` // true if every database matches
+	DBs     []DBDiff `json:"dbs"`
+}
+
+// DBDiff represents the comparison of a single database with a peer.
+type DBDiff struct {
+	ID         uint32 `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	TXID       uint64 `json:"txid"`
+	Chksum     uint64 `json:"chksum"`
+	PeerTXID   uint64 `json:"peer_txid"`
+	PeerChksum uint64 `json:"peer_chksum"`
+}
+
+// StatusInfo represents the state of the node returned by "GET /status".
+type StatusInfo struct {
+	IsPrimary  bool   `json:"is_primary"`
+	PrimaryURL string `json:"primary_url,omitempty"`
+
+	// If true, the replica is not applying changes from the primary.
+	Paused bool `json:"paused"`
+
+	// If true, the node can serve reads. Standby replicas are never ready.
+	Ready   bool `json:"ready"`
+	Standby bool `json:"standby,omitempty"`
+
+	// If true, the node does not acquire the lease while there is no primary.
+	Maintenance bool `json:"maintenance,omitempty"`
+
+	// Seconds elapsed since the primary lease was last renewed.
+	// Only set when the node holds the lease.
+	SecondsSinceLastRenew float64 `json:"seconds_since_last_renew,omitempty"`
+
+	// Number of checksum mismatches detected & the most recent mismatch. Any
+	// non-zero value indicates possible corruption & should be alerted on.
+	ChecksumMismatches   uint64                `json:"checksum_mismatches"`
+	LastChecksumMismatch *ChecksumMismatchInfo `json:"last_checksum_mismatch,omitempty"`
+
+	// Number of errors applying transactions from the primary & the most
+	// recent error. Only set on replicas.
+	ApplyErrors    uint64          `json:"apply_errors"`
+	LastApplyError *ApplyErrorInfo `json:"last_apply_error,omitempty"`
+
+	// Number of peers seen with this node's ID & the most recent one. Any
+	// non-zero value means node IDs are misconfigured.
+	NodeIDCollisions    uint64               `json:"node_id_collisions"`
+	LastNodeIDCollision *NodeIDCollisionInfo `json:"last_node_id_collision,omitempty"`
+
+	DBs []DBStatusInfo `json:"dbs"`
+}
+
+// DBStatusInfo describes a single database reported by "GET /status". The
+// last writer is the node that wrote the most recent transactions, starting
+// at LastWriterTXID, & is blank if unknown.
+type DBStatusInfo struct {
+	ID             uint32 `json:"id"`
+	Name           string `json:"name"`
+	TXID           uint64 `json:"txid"`
+	LastWriter     string `json:"last_writer,omitempty"`
+	LastWriterTXID uint64 `json:"last_writer_txid,omitempty"`
+}
+
+// ChecksumMismatchInfo describes a checksum mismatch reported by "GET /status".
+type ChecksumMismatchInfo struct {
+	DBID       uint32    `json:"db_id"`
+	TXID       uint64    `json:"txid"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// ApplyErrorInfo describes a replica apply error reported by "GET /status".
+type ApplyErrorInfo struct {
+	DBID       uint32    `json:"db_id"`
+	TXID       uint64    `json:"txid"`
+	Error      string    `json:"error"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// NodeIDCollisionInfo describes a peer with a duplicate node ID reported by
+// "GET /status".
+type NodeIDCollisionInfo struct {
+	PeerURL    string    `json:"peer_url,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// DrainInfo represents the drain status of the node returned by "/admin/drain".
+type DrainInfo struct {
+	Draining   bool   `json:"draining"`
+	IsPrimary  bool   `json:"is_primary"`
+	PrimaryURL string `json:"primary_url,omitempty"`
+
+	// Number of replica streams still connected to the node.
+	Streams int `json:"streams"`
+
+	// If true, another node is primary & no replicas depend on this node so
+	// it is safe to shut down.
+	Drained bool `json:"drained"`
+}
+
+// ServerInfo describes the node & its capabilities as returned by "GET /info".
+type ServerInfo struct {
+	NodeID          string   `json:"node_id"`
+	Version         string   `json:"version"`
+	ProtocolVersion int      `json:"protocol_version"`
+	Leaser          string   `json:"leaser"` // empty if the leaser does not report a type
+	MountDir        string   `json:"mount_dir,omitempty"`
+	AdvertiseURL    string   `json:"advertise_url,omitempty"`
+	Features        []string `json:"features"`
+}
+
+// InstanceInfo represents the identity of the node returned by "GET /instance/id".
+type InstanceInfo struct {
+	ID string `json:"id"`
+}
+
+// StreamStatsInfo represents the replica streams reported by "GET /stream/stats".
+type StreamStatsInfo struct {
+	Streams []StreamStats `json:"streams"`
+}
+
+// StreamStats represents the data sent on a single replica stream. The
+// compression ratio is the compressed size over the uncompressed size so a
+// value of 1 means the stream is not compressed.
+type StreamStats struct {
+	ID                string    `json:"id"`
+	RemoteAddr        string    `json:"remote_addr"`
+	Transport         string    `json:"transport"`
+	Encoding          string    `json:"encoding,omitempty"`
+	ConnectedAt       time.Time `json:"connected_at"`
+	UncompressedBytes int64     `json:"uncompressed_bytes"`
+	CompressedBytes   int64     `json:"compressed_bytes"`
+	CompressionRatio  float64   `json:"compression_ratio"`
+	BytesPerSec       float64   `json:"bytes_per_sec"` // wire throughput
+}
+
+// TopologyInfo represents a node's position in the replication tree as
+// returned by "GET /topology". The full tree can be assembled by crawling the
+// upstream & downstream URLs of each node.
+type TopologyInfo struct {
+	NodeID     string         `json:"node_id"`
+	URL        string         `json:"url,omitempty"`
+	Primary    bool           `json:"primary"`
+	Upstream   *TopologyEdge  `json:"upstream,omitempty"`
+	Downstream []TopologyEdge `json:"downstream"`
+}
+
+// TopologyEdge represents a replication stream between two nodes. For the
+// upstream edge, LagTXID is how far the node is behind the primary. For
+// downstream edges, it is how far the consumer is behind this node.
+type TopologyEdge struct {
+	NodeID     string `json:"node_id,omitempty"`
+	URL        string `json:"url,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	LagTXID    uint64 `json:"lag_txid"`
+}
+
+// ErrorResponse represents the body of an error response in the JSON error
+// format. Code is only set for typed LiteFS errors.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// StatusCode returns the HTTP status code for a typed LiteFS error. Returns
+// defaultCode if err is not a typed error.
+func StatusCode(err error, defaultCode int) int {
+	var e *litefs.Error
+	if !errors.As(err, &e) {
+		return defaultCode
+	}
+
+	switch e.Code {
+	case litefs.ECodeDatabaseNotFound:
+		return http.StatusNotFound
+	case litefs.ECodeNoPrimary, litefs.ECodeLagExceeded:
+		return http.StatusServiceUnavailable
+	case litefs.ECodePrimaryExists, litefs.ECodeLeaseExpired:
+		return http.StatusConflict
+	case litefs.ECodeNotPrimary:
+		return http.StatusMisdirectedRequest
+	default:
+		return defaultCode
+	}
+}
+
+// maxErrorBodySize is the maximum number of bytes read from an error response.
+const maxErrorBodySize = 4096
+
+// readError returns an error from a non-successful response. Returns a
+// *litefs.Error if the server sent a typed error in the JSON error format.
+// Otherwise the error includes the server's message, if any.
+func readError(resp *http.Response) error {
+	buf, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	msg := strings.TrimSpace(string(buf))
+
+	if resp.Header.Get(ErrorFormatHeader) == ErrorFormatJSONV1 {
+		var body ErrorResponse
+		if err := json.Unmarshal(buf, &body); err == nil {
+			if body.Code != "" {
+				return &litefs.Error{Code: body.Code, Message: body.Error}
+			}
+			msg = body.Error
+		}
+	}
+
+	if msg == "" {
+		return fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+	return fmt.Errorf("invalid response: code=%d: %s", resp.StatusCode, msg)
+}
+
 func ReadPosMapFrom(r io.Reader) (map[uint32]litefs.Pos, error) {
 	// Read entry count.
 	var n uint32
@@ -56,3 +412,52 @@ func WritePosMapTo(w io.Writer, m map[uint32]litefs.Pos) error {
 
 	return nil
 }
+
+// FormatPosMapHeader encodes m as a header value of comma-separated
+// "<dbid>=<txid>/<checksum>" entries sorted by database ID.
+func FormatPosMapHeader(m map[uint32]litefs.Pos) string {
+	dbIDs := make([]uint32, 0, len(m))
+	for dbID := range m {
+		dbIDs = append(dbIDs, dbID)
+	}
+	sort.Slice(dbIDs, func(i, j int) bool { return dbIDs[i] < dbIDs[j] })
+
+	entries := make([]string, len(dbIDs))
+	for i, dbID := range dbIDs {
+		pos := m[dbID]
+		entries[i] = fmt.Sprintf("%s=%s/%016x", litefs.FormatDBID(dbID), ltx.FormatTXID(pos.TXID), pos.Chksum)
+	}
+	return strings.Join(entries, ",")
+}
+
+// ParsePosMapHeader decodes a header value encoded by FormatPosMapHeader.
+func ParsePosMapHeader(s string) (map[uint32]litefs.Pos, error) {
+	m := make(map[uint32]litefs.Pos)
+	if s == "" {
+		return m, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		id, pos, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pos map entry: %q", entry)
+		}
+		txID, chksum, ok := strings.Cut(pos, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid pos map entry: %q", entry)
+		}
+
+		dbID, err := litefs.ParseDBID(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pos map db id: %q", id)
+		}
+		var p litefs.Pos
+		if p.TXID, err = strconv.ParseUint(txID, 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid pos map txid: %q", txID)
+		} else if p.Chksum, err = strconv.ParseUint(chksum, 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid pos map checksum: %q", chksum)
+		}
+		m[dbID] = p
+	}
+	return m, nil
+}
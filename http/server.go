@@ -1,52 +1,244 @@
 package http
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	httppprof "net/http/pprof"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/internal"
 	"github.com/superfly/ltx"
 	"golang.org/x/sync/errgroup"
 )
 
 // Default settings
 const (
-	DefaultAddr = ":20202"
+	DefaultAddr               = ":20202"
+	DefaultHeartbeatInterval  = 5 * time.Second
+	DefaultStreamReadTimeout  = 30 * time.Second
+	DefaultStreamIdleTimeout  = 30 * time.Second
+	DefaultReplicaLagInterval = 5 * time.Second
 )
 
+// ProtocolVersion is the version of the replication stream protocol. It is
+// incremented whenever the stream frames change incompatibly.
+const ProtocolVersion = 6
+
+// StreamIDHeader is the response header used to identify a stream when the
+// replica sends heartbeats back to the primary.
+const StreamIDHeader = "Litefs-Stream-Id"
+
+// NodeIDHeader is sent by the replica with its node ID when it connects so the
+// primary can detect nodes that are misconfigured with the same ID.
+const NodeIDHeader = "Litefs-Node-Id"
+
+// AdvertiseURLHeader is sent by the replica with its own advertised URL when
+// it connects so that it can be reported as a downstream node by "GET /topology".
+const AdvertiseURLHeader = "Litefs-Advertise-Url"
+
+// StreamEncodingHeader is sent by the replica with the stream encodings it
+// accepts & by the primary with the encoding used for the stream body.
+const StreamEncodingHeader = "Litefs-Stream-Encoding"
+
+// PrimaryPosHeader is the stream response header that reports the position of
+// each database on the node serving the stream, as encoded by
+// FormatPosMapHeader, so replicas know their lag as soon as they connect.
+const PrimaryPosHeader = "Litefs-Primary-Pos"
+
+// StreamEncodingGzip is the encoding for a gzip-compressed stream.
+const StreamEncodingGzip = "gzip"
+
+// ErrorFormatHeader is sent by the client with the error body formats it
+// accepts & by the server with the format used for an error body. Errors are
+// written as plain text unless the client accepts another format.
+const ErrorFormatHeader = "Litefs-Error-Format"
+
+// ErrorFormatJSONV1 is the format for errors encoded as an ErrorResponse.
+const ErrorFormatJSONV1 = "json-v1"
+
+// streamStatsWindow is the period over which stream throughput is measured.
+const streamStatsWindow = 5 * time.Second
+
+// Response headers for "GET /db/<name>/pages/<pgno>". The checksum is the LTX
+// checksum of the page formatted as hex so it can be compared across nodes.
+// The TXID is formatted as hex like other transaction IDs in the API.
+const (
+	PageChecksumHeader = "Litefs-Page-Checksum"
+	TXIDHeader         = "Litefs-Txid"
+)
+
+// maxPageRequests is the maximum number of concurrent page reads served by
+// "GET /db/<name>/pages/<pgno>". Additional requests are rejected.
+const maxPageRequests = 4
+
+// wsStreamBufferSize is the maximum size of each WebSocket stream message.
+const wsStreamBufferSize = 32 * 1024
+
 // Server represents an HTTP API server for LiteFS.
 type Server struct {
-	ln net.Listener
+	ln  net.Listener   // listener for the primary address
+	lns []net.Listener // listeners for all addresses, including ln
 
 	httpServer  *http.Server
 	promHandler http.Handler
 
-	addr  string
-	store *litefs.Store
+	addr   string
+	store  *litefs.Store
+	client *Client // used to query the primary when running as a replica
+
+	streamsMu sync.Mutex
+	streams   map[string]*serverStream
+	draining  bool // if true, new streams are rejected
+
+	pageSem chan struct{} // limits concurrent page reads
+
+	lagMu sync.Mutex
+	lags  map[uint32]replicaLag // last measured lag of each replica database
+
+	// Collapses repeated alerts while file descriptors are low.
+	errLog *internal.RateLimitedLogger
 
 	g      errgroup.Group
 	ctx    context.Context
 	cancel func()
+
+	// Guards the stream timeouts once the server is running.
+	timeoutsMu sync.Mutex
+
+	// Interval between heartbeat frames sent to connected replicas.
+	HeartbeatInterval time.Duration
+
+	// Time allowed between heartbeats from a replica before its stream is
+	// closed. This reaps dead connections that were dropped without a close.
+	// Set to zero to disable.
+	IdleTimeout time.Duration
+
+	// Interval between requests to the primary to measure the age of the
+	// oldest unapplied transaction of each database on a replica. Set to
+	// zero to disable.
+	ReplicaLagInterval time.Duration
+
+	// If greater than zero, limits the rate that data is written to each
+	// replica stream so that a replica catching up does not saturate a
+	// metered or shared link. The limit applies per connection & to the
+	// bytes sent, after compression.
+	MaxStreamBytesPerSec int64
+
+	// If greater than zero, LTX files larger than this size are sent to
+	// replicas as a series of chunks of at most this many bytes. This bounds
+	// the size of each frame for very large transactions.
+	MaxFrameSize int64
+
+	// If greater than zero, Close() rejects new replica streams & waits up to
+	// this long for connected replicas to be sent every committed transaction
+	// before disconnecting them.
+	ReplicaDrainTimeout time.Duration
+
+	// If greater than zero, the server waits this long before sending each
+	// LTX frame to a replica. This simulates replication lag for testing.
+	DebugReplicationDelay time.Duration
+
+	// If greater than zero, new replica streams are rejected while fewer than
+	// this many file descriptors remain before the process limit. This keeps
+	// a surge of replicas from exhausting descriptors needed by databases.
+	MinFreeFileDescriptors int
+
+	// Returns the number of open file descriptors & the process limit.
+	// Defaults to reading /proc but may be replaced for testing.
+	FileDescriptorsFunc func() (open, limit uint64, err error)
+
+	// If true, HTTP streams are gzip-compressed for replicas that accept it.
+	// This trades CPU for bandwidth on slow or metered links.
+	StreamCompression bool
+
+	// If true, a new database whose contents are identical to another
+	// database a replica already has is cloned by the replica from its local
+	// copy instead of streaming the database's history.
+	DedupSnapshots bool
+
+	// If set, all routes are served under this path prefix. This allows the
+	// API to be exposed under a subpath of a reverse proxy, e.g. "/litefs".
+	BasePath string
+
+	// If set, database endpoints under "/db/<name>/", such as the change
+	// stream or freeze controls, & admin endpoints under "/admin/" require
+	// an "Authorization: Bearer <token>" header with this token.
+	AuthToken string
+
+	// Additional addresses to listen on, such as an overlay network
+	// interface. All addresses serve the same handlers.
+	Addrs []string
+
+	// If set, returns the schema version of a database for "GET /dbs" &
+	// metrics. Returns false if no version is configured for the database.
+	SchemaVersionFunc func(ctx context.Context, db *litefs.DB) (version int64, ok bool, err error)
+
+	// Election priority reported to other candidates by "GET /positions".
+	Priority int
+
+	// Availability zone reported to other candidates by "GET /positions".
+	// Preferred is true if the zone is the preferred zone for the primary.
+	Zone      string
+	Preferred bool
+
+	// Version of LiteFS & the FUSE mount path reported by "GET /info".
+	Version  string
+	MountDir string
+
+	// If true, a line is logged for each HTTP request once it completes with
+	// its method, path, status, duration, remote address & database. The
+	// authorization header is redacted.
+	AccessLog bool
+
+	// If true, streams from replicas reporting this node's ID are rejected
+	// with a 409 instead of only being reported.
+	RejectDuplicateNodeID bool
 }
 
 func NewServer(store *litefs.Store, addr string) *Server {
 	s := &Server{
-		addr:  addr,
-		store: store,
+		addr:    addr,
+		store:   store,
+		client:  NewClient(),
+		streams: make(map[string]*serverStream),
+		pageSem: make(chan struct{}, maxPageRequests),
+
+		errLog: internal.NewRateLimitedLogger(nil, litefs.ErrorLogInterval),
+
+		FileDescriptorsFunc: internal.FileDescriptors,
+
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		IdleTimeout:       DefaultStreamIdleTimeout,
+
+		ReplicaLagInterval: DefaultReplicaLagInterval,
 	}
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	s.promHandler = promhttp.Handler()
 	s.httpServer = &http.Server{
-		Handler: http.HandlerFunc(s.serveHTTP),
+		Handler: s.withAccessLog(http.HandlerFunc(s.serveHTTP)),
 		BaseContext: func(_ net.Listener) context.Context {
 			return s.ctx
 		},
@@ -55,36 +247,72 @@ func NewServer(store *litefs.Store, addr string) *Server {
 }
 
 func (s *Server) Listen() (err error) {
-	if s.ln, err = net.Listen("tcp", s.addr); err != nil {
-		return err
+	for _, addr := range append([]string{s.addr}, s.Addrs...) {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, ln := range s.lns {
+				_ = ln.Close()
+			}
+			s.ln, s.lns = nil, nil
+			return err
+		}
+		s.lns = append(s.lns, ln)
 	}
+	s.ln = s.lns[0]
 	return nil
 }
 
 func (s *Server) Serve() {
-	s.g.Go(func() error {
-		if err := s.httpServer.Serve(s.ln); s.ctx.Err() != nil {
-			return err
-		}
-		return nil
-	})
+	for _, ln := range s.lns {
+		ln := ln
+		s.g.Go(func() error {
+			if err := s.httpServer.Serve(ln); s.ctx.Err() == nil {
+				return err
+			}
+			return nil
+		})
+	}
+
+	if s.ReplicaLagInterval > 0 {
+		s.g.Go(func() error { s.monitorReplicaLag(s.ctx); return nil })
+	}
+}
+
+// StreamTimeouts returns the current heartbeat interval & idle timeout.
+func (s *Server) StreamTimeouts() (heartbeatInterval, idleTimeout time.Duration) {
+	s.timeoutsMu.Lock()
+	defer s.timeoutsMu.Unlock()
+	return s.HeartbeatInterval, s.IdleTimeout
+}
+
+// SetStreamTimeouts updates the heartbeat interval & idle timeout while the
+// server is running. Changes only apply to streams opened afterward.
+func (s *Server) SetStreamTimeouts(heartbeatInterval, idleTimeout time.Duration) {
+	s.timeoutsMu.Lock()
+	defer s.timeoutsMu.Unlock()
+	s.HeartbeatInterval, s.IdleTimeout = heartbeatInterval, idleTimeout
 }
 
 func (s *Server) Close() (err error) {
-	if s.ln != nil {
-		if e := s.ln.Close(); e != nil && err == nil {
+	if s.ReplicaDrainTimeout > 0 {
+		s.drainReplicas()
+	}
+
+	s.cancel()
+
+	for _, ln := range s.lns {
+		if e := ln.Close(); e != nil && err == nil {
 			err = e
 		}
 	}
 
-	s.cancel()
 	if e := s.g.Wait(); e != nil && err == nil {
 		err = e
 	}
 	return err
 }
 
-// Port returns the port the listener is running on.
+// Port returns the port the listener for the primary address is running on.
 func (s *Server) Port() int {
 	if s.ln == nil {
 		return 0
@@ -92,16 +320,117 @@ func (s *Server) Port() int {
 	return s.ln.Addr().(*net.TCPAddr).Port
 }
 
+// ListenAddrs returns the addresses of all running listeners, starting with
+// the primary address.
+func (s *Server) ListenAddrs() []net.Addr {
+	a := make([]net.Addr, len(s.lns))
+	for i, ln := range s.lns {
+		a[i] = ln.Addr()
+	}
+	return a
+}
+
 // URL returns the full base URL for the running server.
 func (s *Server) URL() string {
 	host, _, _ := net.SplitHostPort(s.addr)
 	if host == "" {
 		host = "localhost"
 	}
-	return fmt.Sprintf("http://%s", net.JoinHostPort(host, fmt.Sprint(s.Port())))
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(host, fmt.Sprint(s.Port())), s.basePath())
+}
+
+// basePath returns the route prefix without a trailing slash.
+func (s *Server) basePath() string {
+	return strings.TrimSuffix(s.BasePath, "/")
+}
+
+// withAccessLog wraps h to log each request, if AccessLog is enabled.
+func (s *Server) withAccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.AccessLog {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// Capture the path before the base path is stripped during routing.
+		t, path := time.Now(), r.URL.Path
+		lw := &accessLogResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(lw, r)
+
+		var extra string
+		if id := r.Header.Get(NodeIDHeader); id != "" {
+			extra += fmt.Sprintf(" node=%q", id)
+		}
+		if r.Header.Get("Authorization") != "" {
+			extra += " authorization=" + redacted
+		}
+		log.Printf("http: access: method=%s path=%s status=%d duration=%s remote=%s db=%q%s",
+			r.Method, path, lw.status, time.Since(t), r.RemoteAddr, requestDBName(r), extra)
+	})
+}
+
+// redacted replaces the value of sensitive fields in access logs.
+const redacted = "[REDACTED]"
+
+// requestDBName returns the name of the database a request refers to, if any.
+// Must be called after the base path has been stripped from the request.
+func requestDBName(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/db/") {
+		name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/db/"), "/")
+		return name
+	}
+	q := r.URL.Query()
+	if name := q.Get("name"); name != "" {
+		return name
+	}
+	return q.Get("db")
+}
+
+// accessLogResponseWriter records the status code of a response. Streaming &
+// WebSocket upgrades are passed through to the underlying writer.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *accessLogResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking not supported")
+	}
+	w.status, w.wroteHeader = http.StatusSwitchingProtocols, true
+	return hj.Hijack()
 }
 
 func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Strip the base path, if any, before routing.
+	if basePath := s.basePath(); basePath != "" {
+		if r.URL.Path != basePath && !strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, basePath)
+	}
+
 	if strings.HasPrefix(r.URL.Path, "/debug/pprof") {
 		switch r.URL.Path {
 		case "/debug/pprof/cmdline":
@@ -118,142 +447,2262 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.HasPrefix(r.URL.Path, "/db/") {
+		s.serveDB(w, r)
+		return
+	}
+
 	switch r.URL.Path {
 	case "/metrics":
+		s.updateMetrics(r.Context())
 		s.promHandler.ServeHTTP(w, r)
 
-	case "/stream":
+	case "/dbs":
 		switch r.Method {
-		case http.MethodPost:
-			s.handlePostStream(w, r)
+		case http.MethodGet:
+			s.handleGetDBs(w, r)
 		default:
 			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
 		}
-	default:
-		http.NotFound(w, r)
-	}
-}
 
-func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
-	log.Printf("stream connected")
-	defer log.Printf("stream disconnected")
+	case "/catalog":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetCatalog(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	// Subscribe to store changes
-	subscription := s.store.Subscribe()
-	defer subscription.Close()
+	case "/snapshot-all":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetSnapshotAll(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	// Read in pos map.
-	posMap, err := ReadPosMapFrom(r.Body)
-	if err != nil {
-		Error(w, r, err, http.StatusBadRequest)
-		return
-	}
+	case "/info":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetInfo(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	dbs := s.store.DBs()
-	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID() < dbs[j].ID() })
+	case "/instance/id":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetInstanceID(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	// Build initial dirty set of databases.
-	dirtySet := make(map[uint32]struct{})
-	for dbID := range posMap {
-		dirtySet[dbID] = struct{}{}
-	}
-	for _, db := range dbs {
-		dirtySet[db.ID()] = struct{}{}
-	}
+	case "/status":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetStatus(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	// Continually iterate by writing dirty changes and then waiting for new changes.
-	for {
-		// Send pending transactions for each database.
-		for dbID := range dirtySet {
-			if err := s.streamDB(r.Context(), w, dbID, posMap); err != nil {
-				Error(w, r, fmt.Errorf("stream error: db=%s err=%s", litefs.FormatDBID(dbID), err), http.StatusInternalServerError)
-				return
-			}
+	case "/ready":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetReady(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
 		}
 
-		// Wait for new changes, repeat.
-		select {
-		case <-r.Context().Done():
-			return
-		case <-subscription.NotifyCh():
-			dirtySet = subscription.DirtySet()
+	case "/admin/replication/pause":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostReplicationPause(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
 		}
-	}
-}
 
-func (s *Server) streamDB(ctx context.Context, w http.ResponseWriter, dbID uint32, posMap map[uint32]litefs.Pos) error {
-	db := s.store.DB(dbID)
+	case "/admin/drain":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDrain(w, r)
+		case http.MethodPost:
+			s.handlePostDrain(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	// Stream database frame if this is the first time we're sending data.
-	if _, ok := posMap[dbID]; !ok {
-		log.Printf("send frame<db>: id=%d name=%q", db.ID(), db.Name())
+	case "/admin/maintenance":
+		switch r.Method {
+		case http.MethodPost:
+			s.handleSetMaintenance(w, r, true)
+		case http.MethodDelete:
+			s.handleSetMaintenance(w, r, false)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-		frame := litefs.DBStreamFrame{DBID: db.ID(), Name: db.Name()}
-		if err := litefs.WriteStreamFrame(w, &frame); err != nil {
-			return fmt.Errorf("write db stream frame: %w", err)
+	case "/admin/resync":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostResync(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
 		}
-		posMap[dbID] = litefs.Pos{}
-	}
 
-	for {
-		clientPos := posMap[dbID]
-		dbPos := db.Pos()
+	case "/admin/replication/resume":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostReplicationResume(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-		// Exit when client has caught up.
-		if clientPos.TXID >= dbPos.TXID {
-			return nil
+	case "/pos":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetPos(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
 		}
 
-		newPos, err := s.streamLTX(ctx, w, db, clientPos.TXID+1)
-		if err != nil {
-			return fmt.Errorf("stream ltx: pos=%d", clientPos.TXID)
+	case "/diff":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDiff(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
 		}
-		posMap[dbID] = newPos
-	}
-}
 
-func (s *Server) streamLTX(ctx context.Context, w http.ResponseWriter, db *litefs.DB, txID uint64) (newPos litefs.Pos, err error) {
-	// Open LTX file, read header.
-	f, err := db.OpenLTXFile(txID)
-	if err != nil {
-		return litefs.Pos{}, fmt.Errorf("open ltx file: %w", err)
-	}
-	defer f.Close()
+	case "/checksums":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetChecksums(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	fi, err := f.Stat()
-	if err != nil {
-		return litefs.Pos{}, fmt.Errorf("stat ltx file: %w", err)
-	}
+	case "/positions":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetPositions(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	// Parse header to extract max TXID & checksum.
-	buf := make([]byte, ltx.HeaderSize)
-	var hdr ltx.Header
-	if _, err := io.ReadFull(f, buf); err != nil {
-		return litefs.Pos{}, fmt.Errorf("read ltx header: %w", err)
-	} else if err := hdr.UnmarshalBinary(buf); err != nil {
-		return litefs.Pos{}, fmt.Errorf("unmarshal ltx header: %w", err)
-	}
+	case "/stream":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostStream(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	// Write frame.
-	frame := litefs.LTXStreamFrame{Size: fi.Size()}
-	if err := litefs.WriteStreamFrame(w, &frame); err != nil {
-		return litefs.Pos{}, fmt.Errorf("write ltx stream frame: %w", err)
-	}
+	case "/stream/stats":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetStreamStats(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
 
-	log.Printf("send frame<ltx>: db=%d tx=(%d,%d) size=%d", db.ID(), txID, txID, frame.Size)
+	case "/topology":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetTopology(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	case "/stream/heartbeat":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostStreamHeartbeat(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	case "/ws/stream":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleWebSocketStream(w, r)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleGetDBs returns a list of databases sorted by ID.
+func (s *Server) handleGetDBs(w http.ResponseWriter, r *http.Request) {
+	dbs := s.store.DBs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID() < dbs[j].ID() })
+
+	infos := make([]DBInfo, 0, len(dbs))
+	for _, db := range dbs {
+		infos = append(infos, DBInfo{
+			ID:      db.ID(),
+			Name:    db.Name(),
+			TXID:    db.TXID(),
+			Frozen:  db.Frozen(),
+			CatchUp: NewCatchUpInfo(db),
+
+			SchemaVersion: s.schemaVersion(r.Context(), db),
+		})
+	}
+
+	// Report how far behind the primary each database is on replicas. The
+	// position the primary reported on connect is used until it is refreshed.
+	// The age of the oldest unapplied transaction is measured in the
+	// background so the primary is not queried on every request.
+	if !s.store.IsPrimary() {
+		for i := range infos {
+			info := &infos[i]
+			if pos, ok := s.store.PrimaryPos(info.ID); ok && pos.TXID > info.TXID {
+				info.LagTXID = pos.TXID - info.TXID
+			}
+
+			// Measurements taken before the replica applied more transactions
+			// are stale & are ignored until the next refresh.
+			if lag, ok := s.replicaLag(info.ID); ok && lag.txID == info.TXID {
+				if lag.primaryTXID-info.TXID > info.LagTXID {
+					info.LagTXID = lag.primaryTXID - info.TXID
+				}
+				if !lag.committedAt.IsZero() {
+					info.LagSeconds = time.Since(lag.committedAt).Seconds()
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(infos); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetCatalog returns the size & replication status of every database,
+// sorted by ID. Sizes are computed from the database header so table data is
+// never scanned.
+func (s *Server) handleGetCatalog(w http.ResponseWriter, r *http.Request) {
+	dbs := s.store.DBs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID() < dbs[j].ID() })
+
+	isPrimary := s.store.IsPrimary()
+	infos := make([]CatalogInfo, 0, len(dbs))
+	for _, db := range dbs {
+		pageSize, pageN, err := db.PageInfo()
+		if err != nil {
+			Error(w, r, fmt.Errorf("db %q: %w", db.Name(), err), http.StatusInternalServerError)
+			return
+		}
+
+		info := CatalogInfo{
+			ID:       db.ID(),
+			Name:     db.Name(),
+			TXID:     db.TXID(),
+			PageSize: pageSize,
+			PageN:    pageN,
+			Size:     int64(pageSize) * int64(pageN),
+			Status:   CatalogStatusReplica,
+		}
+
+		if isPrimary {
+			info.Status = CatalogStatusPrimary
+		} else if db.Resyncing() {
+			info.Status = CatalogStatusResyncing
+		} else if c, ok := db.CatchUp(); ok && c.CompletedAt.IsZero() {
+			info.Status = CatalogStatusCatchingUp
+		}
+		infos = append(infos, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(infos); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetSnapshotAll streams a tar archive of every database captured at the
+// same moment. Writes are paused only while the databases are copied. The
+// first entry is a SnapshotManifest with the position of each database.
+func (s *Server) handleGetSnapshotAll(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	snapshots, err := s.store.SnapshotDBs(r.Context())
+	if errors.Is(err, context.DeadlineExceeded) {
+		Error(w, r, err, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		for _, snapshot := range snapshots {
+			_ = snapshot.File.Close()
+		}
+	}()
+
+	manifest := SnapshotManifest{
+		CreatedAt: time.Now().UTC(),
+		Databases: make([]PosInfo, 0, len(snapshots)),
+	}
+	for _, snapshot := range snapshots {
+		manifest.Databases = append(manifest.Databases, PosInfo{
+			ID:     snapshot.ID,
+			Name:   snapshot.Name,
+			TXID:   snapshot.Pos.TXID,
+			Chksum: snapshot.Pos.Chksum,
+		})
+	}
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    SnapshotManifestName,
+		Mode:    0644,
+		Size:    int64(len(buf)),
+		ModTime: manifest.CreatedAt,
+	}); err != nil {
+		log.Printf("http: snapshot-all: write manifest header: %s", err)
+		return
+	} else if _, err := tw.Write(buf); err != nil {
+		log.Printf("http: snapshot-all: write manifest: %s", err)
+		return
+	}
+
+	for _, snapshot := range snapshots {
+		fi, err := snapshot.File.Stat()
+		if err != nil {
+			log.Printf("http: snapshot-all: stat %q: %s", snapshot.Name, err)
+			return
+		}
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    snapshot.Name,
+			Mode:    0644,
+			Size:    fi.Size(),
+			ModTime: manifest.CreatedAt,
+		}); err != nil {
+			log.Printf("http: snapshot-all: write header %q: %s", snapshot.Name, err)
+			return
+		} else if _, err := io.Copy(tw, snapshot.File); err != nil {
+			log.Printf("http: snapshot-all: write %q: %s", snapshot.Name, err)
+			return
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		log.Printf("http: snapshot-all: close archive: %s", err)
+		return
+	}
+}
+
+// serveDB routes requests for "/db/<name>/..." endpoints.
+func (s *Server) serveDB(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	name, route, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/db/"), "/")
+	db := s.store.DBByName(name)
+	if db == nil {
+		Error(w, r, litefs.ErrDatabaseNotFound, http.StatusNotFound)
+		return
+	}
+	route, arg, _ := strings.Cut(route, "/")
+
+	switch route {
+	case "stream":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBStream(w, r, db)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "ltx":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBLTX(w, r, db, arg)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "pages":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetDBPage(w, r, db, arg)
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
+	case "freeze", "thaw":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostDBFreeze(w, r, db, route == "freeze")
+		default:
+			Error(w, r, fmt.Errorf("Method not allowed"), http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorized returns true if no auth token is set or if the request
+// contains the server's auth token as a bearer token.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.AuthToken == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.AuthToken)) == 1
+}
+
+// handleGetDBStream streams committed transactions for a single database to
+// external consumers, such as change-data-capture tools, starting after the
+// hex-formatted "since" TXID. The stream uses the same framing as replica
+// streams so that each frame is a 4-byte big-endian frame type followed by
+// the frame data. Integers are big-endian & strings are prefixed with their
+// 4-byte length. The following frames may be sent:
+//
+//	LTX (2):       8-byte file size, 8-byte chunk size & the ID of the node
+//	               that wrote the transaction, or empty if unknown. If the
+//	               chunk size is zero, an LTX file of the file size follows.
+//	               Otherwise, the file follows as LTXChunk frames.
+//	Heartbeat (3): no data; sent periodically while idle
+//	DropDB (4):    4-byte database ID; the stream ends afterward
+//	FreezeDB (5):  4-byte database ID & 1-byte frozen flag; sent when the
+//	               database is frozen or thawed
+//	CatchUp (6):   4-byte database ID & 8-byte TXID; sent before multiple
+//	               transactions to report the TXID the stream is catching up to
+//	LTXChunk (8):  8-byte size, followed by that many bytes of the LTX file
+//
+// The DB (1) & CloneDB (7) frames are only sent on replica streams.
+//
+// LTX files contain the pages changed by the transactions in their header's
+// TXID range and can be decoded with the github.com/superfly/ltx package.
+// Returns a 400 if "since" falls within such a range.
+func (s *Server) handleGetDBStream(w http.ResponseWriter, r *http.Request, db *litefs.DB) {
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = litefs.ParseTXID(v); err != nil {
+			Error(w, r, fmt.Errorf("invalid since"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Streams resume at the start of an LTX file so reject a position within
+	// a batch of transactions, such as a group commit, before the response
+	// is started. Otherwise the stream would end without an error.
+	if since < db.StreamPos().TXID {
+		f, err := db.OpenLTXFile(since + 1)
+		if os.IsNotExist(err) {
+			Error(w, r, fmt.Errorf("no ltx file begins at tx %s, since may be within a batch of transactions or no longer retained", ltx.FormatTXID(since+1)), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			Error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		_ = f.Close()
+	}
+
+	subscription := s.store.Subscribe()
+	defer subscription.Close()
+
+	heartbeatInterval, _ := s.StreamTimeouts()
+	var tickCh <-chan time.Time
+	if heartbeatInterval > 0 {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		tickCh = ticker.C
+	}
+
+	sw := &responseStreamWriter{w}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	if err := sw.Flush(); err != nil {
+		return
+	}
+
+	ctx := r.Context()
+	posMap := map[uint32]litefs.Pos{db.ID(): {TXID: since}}
+	frozenMap := map[uint32]bool{db.ID(): false} // only send on freeze
+	for {
+		if err := s.streamDB(ctx, sw, db.ID(), posMap, frozenMap); err != nil {
+			log.Printf("db stream error: db=%s err=%s", db.Name(), err)
+			return
+		} else if _, ok := posMap[db.ID()]; !ok {
+			return // database dropped
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-subscription.NotifyCh():
+			subscription.DirtySet()
+		case <-tickCh:
+			if err := litefs.WriteStreamFrame(sw, &litefs.HeartbeatStreamFrame{}); err != nil {
+				return
+			} else if err := sw.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleGetDBLTX returns the raw LTX file for the transaction with the given
+// hex-formatted TXID. Replicas that received a batch of transactions only retain the batch
+// file, which is returned if it begins at the TXID. Returns a 404 if no LTX
+// file for the transaction is retained on this node.
+func (s *Server) handleGetDBLTX(w http.ResponseWriter, r *http.Request, db *litefs.DB, arg string) {
+	txID, err := litefs.ParseTXID(arg)
+	if err != nil || txID == 0 {
+		Error(w, r, fmt.Errorf("invalid txid"), http.StatusBadRequest)
+		return
+	}
+
+	f, err := db.OpenLTXFile(txID)
+	if os.IsNotExist(err) {
+		Error(w, r, fmt.Errorf("transaction not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("http: ltx copy error: %s", err)
+	}
+}
+
+// handleGetDBPage returns the raw contents of a single page at the current
+// committed position. The page's checksum & the TXID it was read at are
+// returned in headers so the same page can be compared across nodes.
+func (s *Server) handleGetDBPage(w http.ResponseWriter, r *http.Request, db *litefs.DB, arg string) {
+	pgno, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil || pgno == 0 {
+		Error(w, r, fmt.Errorf("invalid page number"), http.StatusBadRequest)
+		return
+	}
+
+	// Bound the number of concurrent reads of the database file.
+	select {
+	case s.pageSem <- struct{}{}:
+		defer func() { <-s.pageSem }()
+	default:
+		Error(w, r, fmt.Errorf("too many page requests"), http.StatusTooManyRequests)
+		return
+	}
+
+	buf, pos, err := db.ReadPage(uint32(pgno))
+	if err == litefs.ErrPageNotFound {
+		Error(w, r, err, http.StatusNotFound)
+		return
+	} else if err == litefs.ErrDatabaseLocked {
+		Error(w, r, err, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.Header().Set(PageChecksumHeader, fmt.Sprintf("%016x", ltx.ChecksumPage(uint32(pgno), buf)))
+	w.Header().Set(TXIDHeader, ltx.FormatTXID(pos.TXID))
+	if _, err := w.Write(buf); err != nil {
+		log.Printf("http: page write error: %s", err)
+	}
+}
+
+// handlePostDBFreeze freezes or thaws a database on the primary & returns the
+// updated database info. While frozen, new write transactions are rejected on
+// every node.
+func (s *Server) handlePostDBFreeze(w http.ResponseWriter, r *http.Request, db *litefs.DB, frozen bool) {
+	if err := s.store.FreezeDB(db.Name(), frozen); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if frozen {
+		log.Printf("database frozen: %s", db.Name())
+	} else {
+		log.Printf("database thawed: %s", db.Name())
+	}
+
+	info := DBInfo{
+		ID:     db.ID(),
+		Name:   db.Name(),
+		TXID:   db.TXID(),
+		Frozen: db.Frozen(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetInfo returns the version & capabilities of the node.
+func (s *Server) handleGetInfo(w http.ResponseWriter, r *http.Request) {
+	info := ServerInfo{
+		NodeID:          s.store.ID,
+		Version:         s.Version,
+		ProtocolVersion: ProtocolVersion,
+		MountDir:        s.MountDir,
+		Features:        []string{"websocket"},
+	}
+
+	switch {
+	case s.store.Standalone:
+		info.Leaser = "standalone"
+	case s.store.Leaser != nil:
+		if typer, ok := s.store.Leaser.(litefs.LeaserTyper); ok {
+			info.Leaser = typer.Type()
+		}
+		info.AdvertiseURL = s.store.Leaser.AdvertiseURL()
+	}
+
+	if s.AuthToken != "" {
+		info.Features = append(info.Features, "auth")
+	}
+	if s.store.GroupCommitWindow > 0 {
+		info.Features = append(info.Features, "group-commit")
+	}
+	if s.StreamCompression {
+		info.Features = append(info.Features, "stream-compression")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// replicaLag is a measurement of how far a replica database is behind the
+// primary.
+type replicaLag struct {
+	txID        uint64    // local TXID when measured
+	primaryTXID uint64    // primary TXID when measured
+	committedAt time.Time // commit time of the first unapplied transaction
+}
+
+// replicaLag returns the last lag measurement of a database, if any.
+func (s *Server) replicaLag(dbID uint32) (replicaLag, bool) {
+	s.lagMu.Lock()
+	defer s.lagMu.Unlock()
+	lag, ok := s.lags[dbID]
+	return lag, ok
+}
+
+// monitorReplicaLag periodically measures the lag of each database while the
+// node is a replica until ctx is done.
+func (s *Server) monitorReplicaLag(ctx context.Context) {
+	ticker := time.NewTicker(s.ReplicaLagInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.updateReplicaLag(ctx); err != nil && ctx.Err() == nil {
+			s.errLog.Printf("cannot determine replica lag: %s", err)
+		}
+	}
+}
+
+// updateReplicaLag compares each database to its position on the primary and
+// records the lag of databases that are behind.
+func (s *Server) updateReplicaLag(ctx context.Context) error {
+	primaryURL := s.store.PrimaryURL()
+	if s.store.IsPrimary() || primaryURL == "" {
+		s.lagMu.Lock()
+		s.lags = nil
+		s.lagMu.Unlock()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	primaryInfos, err := s.client.DBs(ctx, primaryURL)
+	if err != nil {
+		return fmt.Errorf("fetch primary dbs: %w", err)
+	}
+	primaryTXIDs := make(map[uint32]uint64, len(primaryInfos))
+	for _, info := range primaryInfos {
+		primaryTXIDs[info.ID] = info.TXID
+	}
+
+	lags := make(map[uint32]replicaLag)
+	for _, db := range s.store.DBs() {
+		txID, primaryTXID := db.TXID(), primaryTXIDs[db.ID()]
+		if primaryTXID <= txID {
+			continue
+		}
+		lag := replicaLag{txID: txID, primaryTXID: primaryTXID}
+
+		// The age of the first unapplied transaction is how stale the data is.
+		pos, err := s.client.PosAt(ctx, primaryURL, db.Name(), txID+1)
+		if err != nil {
+			return fmt.Errorf("fetch primary position: db=%s err=%w", db.Name(), err)
+		} else if pos.Timestamp > 0 {
+			lag.committedAt = time.Unix(pos.Timestamp, 0)
+		}
+		lags[db.ID()] = lag
+	}
+
+	s.lagMu.Lock()
+	s.lags = lags
+	s.lagMu.Unlock()
+	return nil
+}
+
+// handleGetInstanceID returns the ID of the node.
+func (s *Server) handleGetInstanceID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(InstanceInfo{ID: s.store.ID}); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetStatus returns the current role of the node & lease health.
+func (s *Server) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+	info := StatusInfo{
+		IsPrimary:             s.store.IsPrimary(),
+		PrimaryURL:            s.store.PrimaryURL(),
+		Paused:                s.store.ReplicationPaused(),
+		Ready:                 s.store.Ready(),
+		Standby:               s.store.StandbyOnly,
+		Maintenance:           s.store.Maintenance(),
+		SecondsSinceLastRenew: s.secondsSinceLastRenew(),
+	}
+
+	n, last := s.store.ChecksumMismatches()
+	if info.ChecksumMismatches = n; n > 0 {
+		info.LastChecksumMismatch = &ChecksumMismatchInfo{
+			DBID:       last.DBID,
+			TXID:       last.TXID,
+			DetectedAt: last.DetectedAt,
+		}
+	}
+
+	if n, last := s.store.NodeIDCollisions(); n > 0 {
+		info.NodeIDCollisions = n
+		info.LastNodeIDCollision = &NodeIDCollisionInfo{
+			PeerURL:    last.PeerURL,
+			RemoteAddr: last.RemoteAddr,
+			DetectedAt: last.DetectedAt,
+		}
+	}
+
+	if n, last := s.store.ApplyErrors(); n > 0 {
+		info.ApplyErrors = n
+		info.LastApplyError = &ApplyErrorInfo{
+			DBID:       last.DBID,
+			TXID:       last.TXID,
+			Error:      last.Err,
+			OccurredAt: last.OccurredAt,
+		}
+	}
+
+	info.DBs = []DBStatusInfo{}
+	for _, db := range s.store.DBs() {
+		writer := db.LastWriter()
+		info.DBs = append(info.DBs, DBStatusInfo{
+			ID:             db.ID(),
+			Name:           db.Name(),
+			TXID:           db.TXID(),
+			LastWriter:     writer.NodeID,
+			LastWriterTXID: writer.MinTXID,
+		})
+	}
+	sort.Slice(info.DBs, func(i, j int) bool { return info.DBs[i].ID < info.DBs[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetReady returns the node's status if it can serve reads or a 503
+// otherwise, such as while a replica syncs or if the node is a standby. This
+// allows load balancers to route read traffic based on the node's readiness.
+func (s *Server) handleGetReady(w http.ResponseWriter, r *http.Request) {
+	if !s.store.Ready() {
+		Error(w, r, fmt.Errorf("not ready"), http.StatusServiceUnavailable)
+		return
+	}
+	s.handleGetStatus(w, r)
+}
+
+// handlePostReplicationPause stops a replica from applying changes from the
+// primary until replication is resumed.
+func (s *Server) handlePostReplicationPause(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	if s.store.IsPrimary() {
+		Error(w, r, fmt.Errorf("cannot pause replication on primary"), http.StatusBadRequest)
+		return
+	}
+	s.store.PauseReplication()
+	s.handleGetStatus(w, r)
+}
+
+// handlePostReplicationResume resumes replication on a paused replica.
+func (s *Server) handlePostReplicationResume(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	s.store.ResumeReplication()
+	s.handleGetStatus(w, r)
+}
+
+// handleSetMaintenance enables or disables maintenance mode on the node &
+// returns the node's status.
+func (s *Server) handleSetMaintenance(w http.ResponseWriter, r *http.Request, enabled bool) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.store.SetMaintenance(enabled); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.handleGetStatus(w, r)
+}
+
+// handlePostResync discards the local state of a replica database, or of all
+// databases if "db" is not set, & restores it from the primary.
+func (s *Server) handlePostResync(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	switch err := s.store.ResyncDB(r.URL.Query().Get("db")); err {
+	case nil:
+	case litefs.ErrNotReplica:
+		Error(w, r, fmt.Errorf("cannot resync on primary"), http.StatusBadRequest)
+		return
+	case litefs.ErrDatabaseNotFound:
+		Error(w, r, err, http.StatusNotFound)
+		return
+	default:
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.handleGetStatus(w, r)
+}
+
+// handlePostDrain begins draining the node & returns the drain status.
+func (s *Server) handlePostDrain(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	s.Drain()
+	s.handleGetDrain(w, r)
+}
+
+// handleGetDrain returns whether the node has finished draining.
+func (s *Server) handleGetDrain(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	s.streamsMu.Lock()
+	info := DrainInfo{
+		Draining: s.draining,
+		Streams:  len(s.streams),
+	}
+	s.streamsMu.Unlock()
+
+	info.IsPrimary = s.store.IsPrimary()
+	info.PrimaryURL = s.store.PrimaryURL()
+	info.Drained = info.Draining && !info.IsPrimary && info.PrimaryURL != "" && info.Streams == 0
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// Drain prepares the node to be shut down. The node is demoted so another
+// node can become primary & all replica streams are disconnected so that
+// replicas reconnect to the new primary or another source. New streams are
+// rejected afterward.
+func (s *Server) Drain() {
+	s.store.Demote()
+
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if s.draining {
+		return
+	}
+	log.Printf("draining, disconnecting %d stream(s)", len(s.streams))
+	s.draining = true
+	for _, st := range s.streams {
+		st.cancel()
+	}
+}
+
+// WaitForReplicas waits until every connected replica stream has been sent
+// the current position of each database or until ctx is done. This is used
+// on shutdown so recent transactions reach replicas before the lease is
+// released. Delivery is best-effort as the replica may not have applied them.
+func (s *Server) WaitForReplicas(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		posMap := s.store.PosMap()
+
+		s.streamsMu.Lock()
+		var n int
+		for _, st := range s.streams {
+			if !st.caughtUp(posMap) {
+				n++
+			}
+		}
+		s.streamsMu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d replica stream(s) not caught up: %w", n, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// drainReplicas rejects new replica streams & waits up to ReplicaDrainTimeout
+// for connected replicas to be sent every committed transaction.
+func (s *Server) drainReplicas() {
+	s.streamsMu.Lock()
+	s.draining = true
+	n := len(s.streams)
+	s.streamsMu.Unlock()
+
+	if n == 0 {
+		return
+	}
+	log.Printf("draining %d replica stream(s) before shutdown, timeout=%s", n, s.ReplicaDrainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.ReplicaDrainTimeout)
+	defer cancel()
+	if err := s.WaitForReplicas(ctx); err != nil {
+		log.Printf("closing replica streams before drain completed: %s", err)
+		return
+	}
+	log.Printf("replica streams drained")
+}
+
+// secondsSinceLastRenew returns the seconds elapsed since the store's lease
+// was last renewed. Returns zero if the store does not hold a lease.
+func (s *Server) secondsSinceLastRenew() float64 {
+	t := s.store.LastRenewAt()
+	if t.IsZero() {
+		return 0
+	}
+	return time.Since(t).Seconds()
+}
+
+// schemaVersion returns the schema version of db or nil if it is not
+// configured. Errors are logged so other fields can still be reported.
+func (s *Server) schemaVersion(ctx context.Context, db *litefs.DB) *int64 {
+	if s.SchemaVersionFunc == nil {
+		return nil
+	}
+
+	version, ok, err := s.SchemaVersionFunc(ctx, db)
+	if err != nil {
+		log.Printf("cannot determine schema version: db=%s err=%s", db.Name(), err)
+		return nil
+	} else if !ok {
+		return nil
+	}
+	return &version
+}
+
+// updateMetrics refreshes gauges that are derived from the store's state.
+func (s *Server) updateMetrics(ctx context.Context) {
+	secondsSinceLastRenewMetric.Set(s.secondsSinceLastRenew())
+
+	if free, err := s.store.FreeSpace(); err == nil {
+		freeSpaceMetric.Set(float64(free))
+	}
+
+	if open, limit, err := s.FileDescriptorsFunc(); err == nil {
+		openFileDescriptorsMetric.Set(float64(open))
+		maxFileDescriptorsMetric.Set(float64(limit))
+	}
+
+	for _, db := range s.store.DBs() {
+		if version := s.schemaVersion(ctx, db); version != nil {
+			schemaVersionMetric.WithLabelValues(db.Name()).Set(float64(*version))
+		}
+
+		info := NewCatchUpInfo(db)
+		if info == nil {
+			continue
+		}
+		catchUpTXIDMetric.WithLabelValues(db.Name()).Set(float64(info.TXID))
+		catchUpTargetTXIDMetric.WithLabelValues(db.Name()).Set(float64(info.TargetTXID))
+		catchUpPercentMetric.WithLabelValues(db.Name()).Set(info.Percent)
+		catchUpRateMetric.WithLabelValues(db.Name()).Set(info.Rate)
+		catchUpETAMetric.WithLabelValues(db.Name()).Set(info.ETASeconds)
+	}
+}
+
+// handleGetPos returns the position of a database after the given TXID.
+func (s *Server) handleGetPos(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	db := s.store.DBByName(q.Get("name"))
+	if db == nil {
+		Error(w, r, litefs.ErrDatabaseNotFound, http.StatusNotFound)
+		return
+	}
+
+	txID, err := litefs.ParseTXID(q.Get("txid"))
+	if err != nil {
+		Error(w, r, fmt.Errorf("invalid txid"), http.StatusBadRequest)
+		return
+	}
+
+	pos, err := db.PosAt(txID)
+	if os.IsNotExist(err) {
+		Error(w, r, fmt.Errorf("transaction not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	committedAt, err := db.CommittedAt(txID)
+	if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(PosInfo{
+		ID:        db.ID(),
+		Name:      db.Name(),
+		TXID:      pos.TXID,
+		Chksum:    pos.Chksum,
+		Timestamp: committedAt.Unix(),
+	}); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetChecksums returns the checksum of each page of a database after the
+// transaction with the given TXID was committed so that a restored copy of the
+// database can be verified against this node's history.
+func (s *Server) handleGetChecksums(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	db := s.store.DBByName(q.Get("name"))
+	if db == nil {
+		Error(w, r, litefs.ErrDatabaseNotFound, http.StatusNotFound)
+		return
+	}
+
+	txID, err := litefs.ParseTXID(q.Get("txid"))
+	if err != nil {
+		Error(w, r, fmt.Errorf("invalid txid"), http.StatusBadRequest)
+		return
+	}
+
+	hdr, chksums, err := db.PageChecksumsAt(txID)
+	if errors.Is(err, os.ErrNotExist) {
+		Error(w, r, fmt.Errorf("transaction not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(PageChecksumsInfo{
+		Name:      db.Name(),
+		TXID:      hdr.MaxTXID,
+		Chksum:    hdr.PostChecksum,
+		PageSize:  hdr.PageSize,
+		Checksums: chksums,
+	}); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetPositions returns the node's election candidacy & the position of
+// each database so that candidates can promote the most up-to-date node.
+func (s *Server) handleGetPositions(w http.ResponseWriter, r *http.Request) {
+	dbs := s.store.DBs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID() < dbs[j].ID() })
+
+	info := PositionsInfo{
+		Priority:  s.Priority,
+		Zone:      s.Zone,
+		Preferred: s.Preferred,
+		Eligible:  !s.store.Standalone && !s.store.Demoted(),
+		DBs:       make([]PosInfo, 0, len(dbs)),
+	}
+	if s.store.Leaser != nil {
+		info.URL = s.store.Leaser.AdvertiseURL()
+	}
+	for _, db := range dbs {
+		pos := db.Pos()
+		info.DBs = append(info.DBs, PosInfo{
+			ID:     db.ID(),
+			Name:   db.Name(),
+			TXID:   pos.TXID,
+			Chksum: pos.Chksum,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetDiff compares the position of each database on this node with the
+// node at the "peer" URL. Databases at the same TXID with different checksums
+// are reported as diverged as their contents differ.
+func (s *Server) handleGetDiff(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		Error(w, r, fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	peerURL := r.URL.Query().Get("peer")
+	if peerURL == "" {
+		Error(w, r, fmt.Errorf("peer required"), http.StatusBadRequest)
+		return
+	}
+
+	peer, err := s.client.Positions(r.Context(), peerURL)
+	if err != nil {
+		Error(w, r, fmt.Errorf("fetch peer positions: %w", err), http.StatusBadGateway)
+		return
+	}
+
+	diffs := make(map[uint32]*DBDiff)
+	for _, db := range s.store.DBs() {
+		pos := db.Pos()
+		diffs[db.ID()] = &DBDiff{ID: db.ID(), Name: db.Name(), Status: DiffStatusMissingPeer, TXID: pos.TXID, Chksum: pos.Chksum}
+	}
+	for _, p := range peer.DBs {
+		diff := diffs[p.ID]
+		if diff == nil {
+			diffs[p.ID] = &DBDiff{ID: p.ID, Name: p.Name, Status: DiffStatusMissing, PeerTXID: p.TXID, PeerChksum: p.Chksum}
+			continue
+		}
+
+		diff.PeerTXID, diff.PeerChksum = p.TXID, p.Chksum
+		switch {
+		case diff.TXID < p.TXID:
+			diff.Status = DiffStatusBehind
+		case diff.TXID > p.TXID:
+			diff.Status = DiffStatusAhead
+		case diff.Chksum != p.Chksum:
+			diff.Status = DiffStatusDiverged
+		default:
+			diff.Status = DiffStatusMatch
+		}
+	}
+
+	info := DiffInfo{PeerURL: peerURL, InSync: true, DBs: make([]DBDiff, 0, len(diffs))}
+	for _, diff := range diffs {
+		if diff.Status != DiffStatusMatch {
+			info.InSync = false
+		}
+		info.DBs = append(info.DBs, *diff)
+	}
+	sort.Slice(info.DBs, func(i, j int) bool { return info.DBs[i].ID < info.DBs[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
+	log.Printf("stream connected")
+	defer log.Printf("stream disconnected")
+
+	if err := s.checkNodeID(r); err != nil {
+		Error(w, r, err, http.StatusConflict)
+		return
+	}
+
+	// Read in pos map.
+	posMap, err := ReadPosMapFrom(r.Body)
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	st, err := s.openStream(r, TransportHTTP, cancel)
+	if err != nil {
+		Error(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+	defer s.closeStream(st)
+
+	// Compress the stream if enabled & the replica supports it.
+	compress := s.StreamCompression && acceptsStreamEncoding(r, StreamEncodingGzip)
+	if compress {
+		st.setEncoding(StreamEncodingGzip)
+		w.Header().Set(StreamEncodingHeader, StreamEncodingGzip)
+	}
+
+	// Send headers immediately so the client receives the stream ID.
+	sw := &countingStreamWriter{w: &responseStreamWriter{w}, add: st.addWireBytes}
+	w.Header().Set(StreamIDHeader, st.id)
+	w.Header().Set(PrimaryPosHeader, FormatPosMapHeader(s.store.PosMap()))
+	w.WriteHeader(http.StatusOK)
+	if err := sw.Flush(); err != nil {
+		return
+	}
+
+	// The status has already been sent so errors can only be logged.
+	if err := s.stream(ctx, sw, compress, posMap, st); err != nil {
+		log.Printf("http: stream: %s", err)
+		return
+	}
+}
+
+// checkNodeID records a collision if the replica connecting with r reports
+// the same node ID as this node. A replica with the same ID but a different
+// advertised URL is a different node, such as one started from a cloned
+// config. Returns an error if the replica should be rejected.
+func (s *Server) checkNodeID(r *http.Request) error {
+	var advertiseURL string
+	if s.store.Leaser != nil {
+		advertiseURL = s.store.Leaser.AdvertiseURL()
+	}
+
+	id, peerURL := r.Header.Get(NodeIDHeader), r.Header.Get(AdvertiseURLHeader)
+	if id == "" || id != s.store.ID || peerURL == advertiseURL {
+		return nil
+	}
+
+	s.store.RecordNodeIDCollision(peerURL, r.RemoteAddr)
+	if s.RejectDuplicateNodeID {
+		return fmt.Errorf("%w: replica %s reports the same node id as this node (%q)", litefs.ErrDuplicateNodeID, r.RemoteAddr, id)
+	}
+	return nil
+}
+
+// handlePostStreamHeartbeat records a heartbeat from the replica on a stream.
+func (s *Server) handlePostStreamHeartbeat(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		Error(w, r, fmt.Errorf("invalid stream id"), http.StatusBadRequest)
+		return
+	}
+
+	s.streamsMu.Lock()
+	st := s.streams[id]
+	s.streamsMu.Unlock()
+
+	if st == nil {
+		Error(w, r, fmt.Errorf("stream not found"), http.StatusNotFound)
+		return
+	}
+	st.heartbeat()
+}
+
+// handleWebSocketStream serves the same stream as handlePostStream but over a
+// WebSocket connection. The client sends its pos map as the first message and
+// the server then writes stream frames as binary messages.
+func (s *Server) handleWebSocketStream(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkNodeID(r); err != nil {
+		Error(w, r, err, http.StatusConflict)
+		return
+	}
+
+	// The stream is canceled when the client disconnects or the server drains.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	st, err := s.openStream(r, TransportWebSocket, cancel)
+	if err != nil {
+		Error(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+	defer s.closeStream(st)
+
+	conn, err := upgradeWebSocket(w, r, http.Header{
+		StreamIDHeader:   {st.id},
+		PrimaryPosHeader: {FormatPosMapHeader(s.store.PosMap())},
+	})
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("websocket stream connected")
+	defer log.Printf("websocket stream disconnected")
+
+	// Read in pos map.
+	posMap, err := ReadPosMapFrom(conn)
+	if err != nil {
+		log.Printf("http: websocket: cannot read pos map: %s", err)
+		return
+	}
+
+	// Continue reading from the connection so that control frames are
+	// processed. Cancel the stream once the client disconnects.
+	go func() {
+		defer cancel()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	sw := &countingStreamWriter{w: bufio.NewWriterSize(conn, wsStreamBufferSize), add: st.addWireBytes}
+	if err := s.stream(ctx, sw, false, posMap, st); err != nil {
+		log.Printf("http: websocket: %s", err)
+		return
+	}
+}
+
+// stream continually writes changes to w until ctx is canceled. The position
+// of each database on the client is tracked in posMap. If compress is true,
+// the stream is gzip-compressed before it is throttled so the rate limit
+// applies to the bytes sent over the connection.
+func (s *Server) stream(ctx context.Context, w streamWriter, compress bool, posMap map[uint32]litefs.Pos, st *serverStream) error {
+	w = newThrottledStreamWriter(ctx, w, s.MaxStreamBytesPerSec)
+	if compress {
+		w = newGzipStreamWriter(w)
+	}
+	w = &countingStreamWriter{w: w, add: st.addBytes}
+
+	// Subscribe to store changes
+	subscription := s.store.Subscribe()
+	defer subscription.Close()
+
+	// Periodically send heartbeats to the replica.
+	heartbeatInterval, idleTimeout := s.StreamTimeouts()
+	var tickCh <-chan time.Time
+	if heartbeatInterval > 0 {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		tickCh = ticker.C
+	}
+
+	// Check for heartbeats from the replica independently of the heartbeat
+	// interval so that dead streams are reaped even if heartbeats are disabled.
+	var idleCh <-chan time.Time
+	if idleTimeout > 0 {
+		ticker := time.NewTicker(streamIdleCheckInterval(idleTimeout))
+		defer ticker.Stop()
+		idleCh = ticker.C
+	}
+
+	dbs := s.store.DBs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID() < dbs[j].ID() })
+
+	// Frozen state of each database last sent to the client. The state is
+	// always sent once per stream as the client may have missed a change.
+	frozenMap := make(map[uint32]bool)
+
+	// Build initial dirty set of databases.
+	dirtySet := make(map[uint32]struct{})
+	for dbID := range posMap {
+		dirtySet[dbID] = struct{}{}
+	}
+	for _, db := range dbs {
+		dirtySet[db.ID()] = struct{}{}
+	}
+
+	// Continually iterate by writing dirty changes and then waiting for new changes.
+	for initial := true; ; initial = false {
+		// Send pending transactions for each database in ID order.
+		dbIDs := make([]uint32, 0, len(dirtySet))
+		for dbID := range dirtySet {
+			dbIDs = append(dbIDs, dbID)
+		}
+		sort.Slice(dbIDs, func(i, j int) bool { return dbIDs[i] < dbIDs[j] })
+
+		for _, dbID := range dbIDs {
+			if err := s.streamDB(ctx, w, dbID, posMap, frozenMap); err != nil {
+				return fmt.Errorf("stream error: db=%s err=%s", litefs.FormatDBID(dbID), err)
+			}
+		}
+		st.setPosMap(posMap)
+
+		// Send a heartbeat after the initial pass so the client knows it has
+		// caught up on every database as of when it connected.
+		if initial && heartbeatInterval > 0 {
+			if err := litefs.WriteStreamFrame(w, &litefs.HeartbeatStreamFrame{}); err != nil {
+				return fmt.Errorf("write heartbeat stream frame: %w", err)
+			} else if err := w.Flush(); err != nil {
+				return fmt.Errorf("flush heartbeat stream frame: %w", err)
+			}
+		}
+
+		// Wait for new changes, repeat.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-subscription.NotifyCh():
+			dirtySet = subscription.DirtySet()
+		case <-idleCh:
+			dirtySet = nil
+
+			if time.Since(st.lastHeartbeatAt()) > idleTimeout {
+				streamIdleTimeoutCountMetric.Inc()
+				log.Printf("stream idle timeout, closing: id=%s", st.id)
+				return nil
+			}
+
+		case <-tickCh:
+			dirtySet = nil
+
+			if err := litefs.WriteStreamFrame(w, &litefs.HeartbeatStreamFrame{}); err != nil {
+				return fmt.Errorf("write heartbeat stream frame: %w", err)
+			} else if err := w.Flush(); err != nil {
+				return fmt.Errorf("flush heartbeat stream frame: %w", err)
+			}
+		}
+	}
+}
+
+// openStream registers a new stream so it can receive heartbeats. The replica
+// is identified by the headers of r. The cancel function is called to
+// disconnect the stream when the server drains.
+func (s *Server) openStream(r *http.Request, transport string, cancel func()) (*serverStream, error) {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+
+	if s.draining {
+		return nil, fmt.Errorf("node is draining")
+	} else if err := s.checkFileDescriptors(); err != nil {
+		return nil, err
+	}
+
+	id, err := newStreamID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	st := &serverStream{
+		id:           id,
+		remoteAddr:   r.RemoteAddr,
+		nodeID:       r.Header.Get(NodeIDHeader),
+		advertiseURL: r.Header.Get(AdvertiseURLHeader),
+		transport:    transport,
+		cancel:       cancel,
+		connectedAt:  now,
+		heartbeatAt:  now,
+		windowAt:     now,
+	}
+	s.streams[st.id] = st
+	return st, nil
+}
+
+// newStreamID returns a random stream identifier. Identifiers are random so
+// that a client cannot heartbeat a stream that it does not own.
+func newStreamID() (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return "", fmt.Errorf("generate stream id: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// streamIdleCheckInterval returns how often a stream checks for replica
+// heartbeats so that an idle stream is closed shortly after idleTimeout.
+func streamIdleCheckInterval(idleTimeout time.Duration) time.Duration {
+	if d := idleTimeout / 4; d > 0 {
+		return d
+	}
+	return idleTimeout
+}
+
+// checkFileDescriptors returns an error if fewer than MinFreeFileDescriptors
+// remain. Streams are allowed if the descriptor usage cannot be determined.
+func (s *Server) checkFileDescriptors() error {
+	if s.MinFreeFileDescriptors <= 0 {
+		return nil
+	}
+
+	open, limit, err := s.FileDescriptorsFunc()
+	if err != nil {
+		s.errLog.Printf("cannot determine file descriptor usage: %s", err)
+		return nil
+	} else if open+uint64(s.MinFreeFileDescriptors) <= limit {
+		return nil
+	}
+
+	streamFDRejectCountMetric.Inc()
+	s.errLog.Printf("ALERT: rejecting replica stream, file descriptors low: open=%d limit=%d min-free=%d", open, limit, s.MinFreeFileDescriptors)
+	return fmt.Errorf("too few file descriptors available: open=%d limit=%d", open, limit)
+}
+
+// closeStream removes a stream from the server.
+func (s *Server) closeStream(st *serverStream) {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	delete(s.streams, st.id)
+}
+
+func (s *Server) streamDB(ctx context.Context, w streamWriter, dbID uint32, posMap map[uint32]litefs.Pos, frozenMap map[uint32]bool) error {
+	db := s.store.DB(dbID)
+	if db == nil {
+		return s.streamDropDB(w, dbID, posMap)
+	}
+
+	// Stream database frame if this is the first time we're sending data.
+	// If the client already has an identical database then it clones it.
+	// An empty database is only sent as this frame at TXID 0.
+	if _, ok := posMap[dbID]; !ok && s.DedupSnapshots {
+		if err := s.streamCloneDB(w, db, posMap); err != nil {
+			return err
+		}
+	}
+	if _, ok := posMap[dbID]; !ok {
+		log.Printf("send frame<db>: id=%d name=%q", db.ID(), db.Name())
+
+		frame := litefs.DBStreamFrame{DBID: db.ID(), Name: db.Name()}
+		if err := litefs.WriteStreamFrame(w, &frame); err != nil {
+			return fmt.Errorf("write db stream frame: %w", err)
+		} else if err := w.Flush(); err != nil {
+			return fmt.Errorf("flush db stream frame: %w", err)
+		}
+		posMap[dbID] = litefs.Pos{}
+	}
+
+	// Notify the client of the target position if it is more than one
+	// transaction behind so that it can report its catch-up progress.
+	if clientPos, dbPos := posMap[dbID], db.StreamPos(); dbPos.TXID > clientPos.TXID && dbPos.TXID-clientPos.TXID > 1 {
+		log.Printf("send frame<catch-up>: db=%d txid=%d", db.ID(), dbPos.TXID)
+
+		frame := litefs.CatchUpStreamFrame{DBID: db.ID(), TXID: dbPos.TXID}
+		if err := litefs.WriteStreamFrame(w, &frame); err != nil {
+			return fmt.Errorf("write catch up stream frame: %w", err)
+		}
+	}
+
+	for {
+		clientPos := posMap[dbID]
+		dbPos := db.StreamPos()
+
+		// Exit when client has caught up. The frozen state is sent afterward
+		// so it applies on top of the data the client has received.
+		if clientPos.TXID >= dbPos.TXID {
+			return s.streamFreezeDB(w, db, frozenMap)
+		}
+
+		newPos, err := s.streamLTX(ctx, w, db, clientPos.TXID+1)
+		if err != nil {
+			return fmt.Errorf("stream ltx: pos=%d: %w", clientPos.TXID, err)
+		}
+		posMap[dbID] = newPos
+	}
+}
+
+// streamCloneDB instructs the client to create db from a local copy of another
+// database if the client is caught up on one with identical contents. The
+// LTX checksum covers every page so matching checksums mean identical files.
+func (s *Server) streamCloneDB(w streamWriter, db *litefs.DB, posMap map[uint32]litefs.Pos) error {
+	pos := db.StreamPos()
+	if pos.TXID == 0 {
+		return nil
+	}
+
+	var src *litefs.DB
+	for _, other := range s.store.DBs() {
+		otherPos := other.StreamPos()
+		if clientPos, ok := posMap[other.ID()]; other.ID() != db.ID() && ok &&
+			clientPos.TXID == otherPos.TXID && otherPos.Chksum == pos.Chksum {
+			src = other
+			break
+		}
+	}
+	if src == nil {
+		return nil
+	}
+
+	log.Printf("send frame<clone>: id=%d name=%q src=%d tx=%d", db.ID(), db.Name(), src.ID(), pos.TXID)
+
+	frame := litefs.CloneDBStreamFrame{DBID: db.ID(), Name: db.Name(), SrcDBID: src.ID(), Pos: pos}
+	if err := litefs.WriteStreamFrame(w, &frame); err != nil {
+		return fmt.Errorf("write clone db stream frame: %w", err)
+	} else if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush clone db stream frame: %w", err)
+	}
+	posMap[db.ID()] = litefs.Pos{TXID: pos.TXID}
+	streamCloneCountMetric.Inc()
+
+	return nil
+}
+
+// streamFreezeDB notifies the client of the database's frozen state if it has
+// not been sent on this stream or if it has changed.
+func (s *Server) streamFreezeDB(w streamWriter, db *litefs.DB, frozenMap map[uint32]bool) error {
+	frozen := db.Frozen()
+	if prev, ok := frozenMap[db.ID()]; ok && prev == frozen {
+		return nil
+	}
+
+	log.Printf("send frame<freeze>: id=%d frozen=%v", db.ID(), frozen)
+
+	frame := litefs.FreezeDBStreamFrame{DBID: db.ID(), Frozen: frozen}
+	if err := litefs.WriteStreamFrame(w, &frame); err != nil {
+		return fmt.Errorf("write freeze db stream frame: %w", err)
+	} else if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush freeze db stream frame: %w", err)
+	}
+	frozenMap[db.ID()] = frozen
+
+	return nil
+}
+
+// streamDropDB notifies the client that a database it has was deleted.
+func (s *Server) streamDropDB(w streamWriter, dbID uint32, posMap map[uint32]litefs.Pos) error {
+	// Ignore databases the client doesn't have or that we have no record of.
+	if _, ok := posMap[dbID]; !ok {
+		return nil
+	} else if !s.store.IsDropped(dbID) {
+		return nil
+	}
+
+	log.Printf("send frame<drop>: id=%d", dbID)
+
+	frame := litefs.DropDBStreamFrame{DBID: dbID}
+	if err := litefs.WriteStreamFrame(w, &frame); err != nil {
+		return fmt.Errorf("write drop db stream frame: %w", err)
+	} else if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush drop db stream frame: %w", err)
+	}
+	delete(posMap, dbID)
+
+	return nil
+}
+
+func (s *Server) streamLTX(ctx context.Context, w streamWriter, db *litefs.DB, txID uint64) (newPos litefs.Pos, err error) {
+	// Open LTX file, read header. A group commit may contain several transactions.
+	f, err := db.OpenLTXFile(txID)
+	if err != nil {
+		return litefs.Pos{}, fmt.Errorf("open ltx file: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return litefs.Pos{}, fmt.Errorf("stat ltx file: %w", err)
+	}
+
+	// Parse header to extract max TXID & checksum.
+	buf := make([]byte, ltx.HeaderSize)
+	var hdr ltx.Header
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return litefs.Pos{}, fmt.Errorf("read ltx header: %w", err)
+	} else if err := hdr.UnmarshalBinary(buf); err != nil {
+		return litefs.Pos{}, fmt.Errorf("unmarshal ltx header: %w", err)
+	}
+
+	// Simulate replication lag, if enabled.
+	if s.DebugReplicationDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return litefs.Pos{}, ctx.Err()
+		case <-time.After(s.DebugReplicationDelay):
+		}
+	}
+
+	// Write frame. Large files are split into chunks, if enabled.
+	frame := litefs.LTXStreamFrame{Size: fi.Size(), NodeID: db.WriterAt(hdr.MaxTXID)}
+	if s.MaxFrameSize > 0 && frame.Size > s.MaxFrameSize {
+		frame.ChunkSize = s.MaxFrameSize
+	}
+	if err := litefs.WriteStreamFrame(w, &frame); err != nil {
+		return litefs.Pos{}, fmt.Errorf("write ltx stream frame: %w", err)
+	}
+
+	log.Printf("send frame<ltx>: db=%d tx=(%d,%d) size=%d chunk=%d", db.ID(), hdr.MinTXID, hdr.MaxTXID, frame.Size, frame.ChunkSize)
 
 	// Write LTX file.
-	if _, err := w.Write(buf); err != nil {
-		return litefs.Pos{}, fmt.Errorf("write ltx header: %w", err)
-	} else if _, err := io.CopyN(w, f, frame.Size-int64(len(buf))); err != nil {
-		return litefs.Pos{}, fmt.Errorf("write ltx file: %w", err)
+	if frame.ChunkSize == 0 {
+		if _, err := w.Write(buf); err != nil {
+			return litefs.Pos{}, fmt.Errorf("write ltx header: %w", err)
+		} else if _, err := io.CopyN(w, f, frame.Size-int64(len(buf))); err != nil {
+			return litefs.Pos{}, fmt.Errorf("write ltx file: %w", err)
+		}
+	} else if err := writeLTXChunks(w, io.MultiReader(bytes.NewReader(buf), f), frame.Size, frame.ChunkSize); err != nil {
+		return litefs.Pos{}, err
+	}
+	if err := w.Flush(); err != nil {
+		return litefs.Pos{}, fmt.Errorf("flush ltx file: %w", err)
 	}
-	w.(http.Flusher).Flush()
+	streamBatchSizeMetric.Observe(float64(hdr.MaxTXID - hdr.MinTXID + 1))
 
 	return litefs.Pos{TXID: hdr.MaxTXID}, nil
 }
 
+// writeLTXChunks writes size bytes from r as a series of chunk frames of at
+// most chunkSize bytes. Each chunk is flushed so that it can be received
+// before the next chunk is read from disk.
+func writeLTXChunks(w streamWriter, r io.Reader, size, chunkSize int64) error {
+	for size > 0 {
+		n := chunkSize
+		if n > size {
+			n = size
+		}
+
+		if err := litefs.WriteStreamFrame(w, &litefs.LTXChunkStreamFrame{Size: n}); err != nil {
+			return fmt.Errorf("write ltx chunk stream frame: %w", err)
+		} else if _, err := io.CopyN(w, r, n); err != nil {
+			return fmt.Errorf("write ltx chunk: %w", err)
+		} else if err := w.Flush(); err != nil {
+			return fmt.Errorf("flush ltx chunk: %w", err)
+		}
+		streamLTXChunkCountMetric.Inc()
+
+		size -= n
+	}
+	return nil
+}
+
+// handleGetStreamStats returns the bytes sent & throughput of each replica
+// stream so the benefit of compression can be measured on a given link.
+func (s *Server) handleGetStreamStats(w http.ResponseWriter, r *http.Request) {
+	s.streamsMu.Lock()
+	streams := make([]*serverStream, 0, len(s.streams))
+	for _, st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.streamsMu.Unlock()
+	sort.Slice(streams, func(i, j int) bool { return streams[i].id < streams[j].id })
+
+	info := StreamStatsInfo{Streams: make([]StreamStats, 0, len(streams))}
+	for _, st := range streams {
+		info.Streams = append(info.Streams, st.stats())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetTopology returns the node's upstream source & downstream consumers.
+func (s *Server) handleGetTopology(w http.ResponseWriter, r *http.Request) {
+	info := TopologyInfo{
+		NodeID:     s.store.ID,
+		Primary:    s.store.IsPrimary(),
+		Downstream: []TopologyEdge{},
+	}
+	if s.store.Leaser != nil {
+		info.URL = s.store.Leaser.AdvertiseURL()
+	}
+
+	// Determine the local position of each database & how far it is behind
+	// the last known position on the primary.
+	txids := make(map[uint32]uint64)
+	var lagTXID uint64
+	for _, db := range s.store.DBs() {
+		txid := db.TXID()
+		txids[db.ID()] = txid
+		if pos, ok := s.store.PrimaryPos(db.ID()); ok && pos.TXID > txid && pos.TXID-txid > lagTXID {
+			lagTXID = pos.TXID - txid
+		}
+	}
+
+	if !info.Primary {
+		if upstreamURL := s.store.UpstreamURL(); upstreamURL != "" {
+			info.Upstream = &TopologyEdge{URL: upstreamURL, LagTXID: lagTXID}
+		}
+	}
+
+	s.streamsMu.Lock()
+	streams := make([]*serverStream, 0, len(s.streams))
+	for _, st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.streamsMu.Unlock()
+	sort.Slice(streams, func(i, j int) bool { return streams[i].id < streams[j].id })
+
+	for _, st := range streams {
+		info.Downstream = append(info.Downstream, st.edge(txids))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// acceptsStreamEncoding returns true if the replica accepts the encoding.
+func acceptsStreamEncoding(r *http.Request, encoding string) bool {
+	for _, v := range strings.Split(r.Header.Get(StreamEncodingHeader), ",") {
+		if strings.TrimSpace(v) == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsErrorFormat returns true if the client sent format in the list of
+// error formats it accepts.
+func acceptsErrorFormat(r *http.Request, format string) bool {
+	for _, v := range strings.Split(r.Header.Get(ErrorFormatHeader), ",") {
+		if strings.TrimSpace(v) == format {
+			return true
+		}
+	}
+	return false
+}
+
+// serverStream tracks the state of a single replica stream.
+type serverStream struct {
+	id         string
+	remoteAddr string
+	transport  string
+	cancel     func() // disconnects the stream
+
+	mu           sync.Mutex
+	nodeID       string // node ID sent by the replica, if any
+	advertiseURL string // advertised URL sent by the replica, if any
+	encoding     string
+	posMap       map[uint32]litefs.Pos // positions sent to the replica
+	connectedAt  time.Time
+	heartbeatAt  time.Time
+
+	byteN     int64 // bytes before compression
+	wireByteN int64 // bytes written to the connection
+
+	windowAt    time.Time // start of current throughput window
+	windowByteN int64     // wire bytes written in current window
+	bytesPerSec float64   // throughput of the last full window
+}
+
+// setPosMap records the positions that have been sent to the replica.
+func (st *serverStream) setPosMap(posMap map[uint32]litefs.Pos) {
+	m := make(map[uint32]litefs.Pos, len(posMap))
+	for k, v := range posMap {
+		m[k] = v
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.posMap = m
+}
+
+// caughtUp returns true if every database in posMap has been sent.
+func (st *serverStream) caughtUp(posMap map[uint32]litefs.Pos) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for dbID, pos := range posMap {
+		if st.posMap[dbID].TXID < pos.TXID {
+			return false
+		}
+	}
+	return true
+}
+
+// edge returns the topology edge to the replica. The lag is the largest
+// number of transactions the replica is behind txids across all databases.
+func (st *serverStream) edge(txids map[uint32]uint64) TopologyEdge {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e := TopologyEdge{
+		NodeID:     st.nodeID,
+		URL:        st.advertiseURL,
+		RemoteAddr: st.remoteAddr,
+	}
+	for dbID, txid := range txids {
+		if sent := st.posMap[dbID].TXID; txid > sent && txid-sent > e.LagTXID {
+			e.LagTXID = txid - sent
+		}
+	}
+	return e
+}
+
+// setEncoding sets the encoding of the stream body.
+func (st *serverStream) setEncoding(encoding string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.encoding = encoding
+}
+
+// addBytes records n bytes of stream data before compression.
+func (st *serverStream) addBytes(n int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.byteN += int64(n)
+}
+
+// addWireBytes records n bytes written to the connection.
+func (st *serverStream) addWireBytes(n int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.wireByteN += int64(n)
+
+	// Start a new window once the current one is full.
+	if elapsed := time.Since(st.windowAt); elapsed >= streamStatsWindow {
+		st.bytesPerSec = float64(st.windowByteN) / elapsed.Seconds()
+		st.windowAt, st.windowByteN = time.Now(), 0
+	}
+	st.windowByteN += int64(n)
+}
+
+// stats returns a snapshot of the stream's counters.
+func (st *serverStream) stats() StreamStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	other := StreamStats{
+		ID:                st.id,
+		RemoteAddr:        st.remoteAddr,
+		Transport:         st.transport,
+		Encoding:          st.encoding,
+		ConnectedAt:       st.connectedAt,
+		UncompressedBytes: st.byteN,
+		CompressedBytes:   st.wireByteN,
+		CompressionRatio:  1,
+		BytesPerSec:       st.bytesPerSec,
+	}
+	if st.byteN > 0 {
+		other.CompressionRatio = float64(st.wireByteN) / float64(st.byteN)
+	}
+
+	// Use the current window until a full window has elapsed or once the
+	// stream has been idle for longer than a window.
+	if elapsed := time.Since(st.windowAt); elapsed > 0 && (st.bytesPerSec == 0 || elapsed >= streamStatsWindow) {
+		other.BytesPerSec = float64(st.windowByteN) / elapsed.Seconds()
+	}
+	return other
+}
+
+// heartbeat records that the replica is still alive.
+func (st *serverStream) heartbeat() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.heartbeatAt = time.Now()
+}
+
+// lastHeartbeatAt returns the time of the last heartbeat from the replica.
+func (st *serverStream) lastHeartbeatAt() time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.heartbeatAt
+}
+
+// streamWriter represents a writer that buffers stream frames until flushed.
+type streamWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// throttledStreamWriter wraps a streamWriter to track the bytes written to a
+// replica and, if a rate is set, to limit its throughput. Tokens accrue at
+// the rate up to a small burst so idle time cannot be saved up for later.
+type throttledStreamWriter struct {
+	ctx   context.Context
+	w     streamWriter
+	rate  float64 // bytes per second; zero is unlimited
+	burst int
+
+	tokens float64
+	last   time.Time
+}
+
+func newThrottledStreamWriter(ctx context.Context, w streamWriter, bytesPerSec int64) *throttledStreamWriter {
+	tw := &throttledStreamWriter{
+		ctx:  ctx,
+		w:    w,
+		rate: float64(bytesPerSec),
+		last: time.Now(),
+	}
+
+	// Allow a tenth of a second of data to be written at once.
+	if tw.burst = int(bytesPerSec / 10); tw.burst < 1 {
+		tw.burst = 1
+	}
+	return tw
+}
+
+// Write writes p to the underlying writer in chunks, waiting as needed so
+// the average throughput does not exceed the rate.
+func (w *throttledStreamWriter) Write(p []byte) (n int, err error) {
+	if w.rate <= 0 {
+		n, err = w.w.Write(p)
+		streamBytesMetric.Add(float64(n))
+		return n, err
+	}
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.burst {
+			chunk = chunk[:w.burst]
+		}
+		if err := w.wait(len(chunk)); err != nil {
+			return n, err
+		}
+
+		nn, err := w.w.Write(chunk)
+		n += nn
+		streamBytesMetric.Add(float64(nn))
+		if err != nil {
+			return n, err
+		}
+		p = p[nn:]
+	}
+	return n, nil
+}
+
+// wait blocks until n bytes can be written within the rate.
+func (w *throttledStreamWriter) wait(n int) error {
+	now := time.Now()
+	w.tokens += now.Sub(w.last).Seconds() * w.rate
+	if w.tokens > float64(w.burst) {
+		w.tokens = float64(w.burst)
+	}
+	w.last = now
+
+	w.tokens -= float64(n)
+	if w.tokens >= 0 {
+		return nil
+	}
+
+	// Wait until the deficit has been paid back.
+	delay := time.Duration(-w.tokens / w.rate * float64(time.Second))
+	streamThrottleSecondsMetric.Add(delay.Seconds())
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Flush flushes the underlying writer.
+func (w *throttledStreamWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// countingStreamWriter passes the number of bytes written to w to add.
+type countingStreamWriter struct {
+	w   streamWriter
+	add func(n int)
+}
+
+func (w *countingStreamWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.add(n)
+	return n, err
+}
+
+// Flush flushes the underlying writer.
+func (w *countingStreamWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// gzipStreamWriter compresses stream data written to the underlying writer.
+// Each flush completes a gzip block so the replica can decode the frames
+// that have been sent so far.
+type gzipStreamWriter struct {
+	zw *gzip.Writer
+	w  streamWriter
+}
+
+func newGzipStreamWriter(w streamWriter) *gzipStreamWriter {
+	zw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed) // level is always valid
+	return &gzipStreamWriter{zw: zw, w: w}
+}
+
+func (w *gzipStreamWriter) Write(p []byte) (int, error) {
+	return w.zw.Write(p)
+}
+
+// Flush writes any pending compressed data & flushes the underlying writer.
+func (w *gzipStreamWriter) Flush() error {
+	if err := w.zw.Flush(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// responseStreamWriter adapts an http.ResponseWriter to a streamWriter.
+type responseStreamWriter struct {
+	http.ResponseWriter
+}
+
+// Flush sends any buffered data to the client.
+func (w *responseStreamWriter) Flush() error {
+	w.ResponseWriter.(http.Flusher).Flush()
+	return nil
+}
+
+// Error writes err as an error response. Typed LiteFS errors use the status
+// code for their error code instead of code. The body is plain text unless the
+// client accepts the JSON error format.
 func Error(w http.ResponseWriter, r *http.Request, err error, code int) {
 	log.Printf("http: error: %s", err)
-	http.Error(w, err.Error(), code)
+
+	if !acceptsErrorFormat(r, ErrorFormatJSONV1) {
+		http.Error(w, err.Error(), StatusCode(err, code))
+		return
+	}
+
+	body := ErrorResponse{Error: err.Error()}
+	var e *litefs.Error
+	if errors.As(err, &e) {
+		body.Code = e.Code
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set(ErrorFormatHeader, ErrorFormatJSONV1)
+	w.WriteHeader(StatusCode(err, code))
+	_ = json.NewEncoder(w).Encode(body)
 }
+
+// Server metrics.
+var (
+	streamIdleTimeoutCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_http_stream_idle_timeout_count",
+		Help: "Number of replica streams closed after missing heartbeats.",
+	})
+
+	streamFDRejectCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_http_stream_fd_reject_count",
+		Help: "Number of replica streams rejected because file descriptors were low.",
+	})
+
+	streamCloneCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_http_stream_clone_count",
+		Help: "Number of databases cloned by replicas from an identical local database.",
+	})
+
+	streamBytesMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_http_stream_bytes",
+		Help: "Number of bytes written to replica streams.",
+	})
+
+	streamThrottleSecondsMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_http_stream_throttle_seconds",
+		Help: "Seconds replica streams spent waiting on the stream rate limit.",
+	})
+
+	streamLTXChunkCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_http_stream_ltx_chunk_count",
+		Help: "Number of LTX chunk frames sent to replicas for large transactions.",
+	})
+
+	streamBatchSizeMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "litefs_http_stream_batch_size",
+		Help:    "Number of transactions sent to a replica in each LTX frame.",
+		Buckets: []float64{1, 2, 5, 10, 25, 50, 100, 250},
+	})
+
+	freeSpaceMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_data_free_bytes",
+		Help: "Bytes of free space available on the data directory's file system.",
+	})
+
+	openFileDescriptorsMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_open_file_descriptors",
+		Help: "Number of file descriptors open in the process.",
+	})
+
+	maxFileDescriptorsMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_max_file_descriptors",
+		Help: "Limit on the number of file descriptors open in the process.",
+	})
+
+	secondsSinceLastRenewMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_seconds_since_last_renew",
+		Help: "Seconds since the primary lease was last renewed. Zero on replicas.",
+	})
+
+	catchUpTXIDMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_catch_up_txid",
+		Help: "Transaction ID applied by a replica catching up to the primary.",
+	}, []string{"db"})
+
+	catchUpTargetTXIDMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_catch_up_target_txid",
+		Help: "Primary transaction ID a replica is catching up to.",
+	}, []string{"db"})
+
+	catchUpPercentMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_catch_up_percent",
+		Help: "Percentage of transactions applied during a replica catch-up.",
+	}, []string{"db"})
+
+	catchUpRateMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_catch_up_rate",
+		Help: "Transactions applied per second during a replica catch-up.",
+	}, []string{"db"})
+
+	catchUpETAMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_catch_up_eta_seconds",
+		Help: "Estimated seconds until a replica catch-up completes.",
+	}, []string{"db"})
+
+	schemaVersionMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_db_schema_version",
+		Help: "Schema version reported by the configured query for a database.",
+	}, []string{"db"})
+)
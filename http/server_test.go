@@ -0,0 +1,3465 @@
+package http_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	gohttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/http"
+	"github.com/superfly/litefs/internal/testingutil"
+	"github.com/superfly/ltx"
+)
+
+// Ensure a replica syncs identically over each stream transport.
+func TestServer_Stream(t *testing.T) {
+	for _, transport := range []string{http.TransportHTTP, http.TransportWebSocket} {
+		t.Run(transport, func(t *testing.T) {
+			primary := newOpenStore(t, nil)
+			server := newOpenServer(t, primary)
+
+			db, f, err := primary.CreateDB("db")
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+			// Connect replica and write additional transactions while streaming.
+			replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()}, func(s *litefs.Store) {
+				client := http.NewClient()
+				client.Transport = transport
+				s.Client = client
+			})
+			testingutil.MustWriteTx(t, db, 2, 2, 1)
+			testingutil.MustWriteTx(t, db, 2, 2, 2)
+
+			waitForSync(t, primary, replica, 1)
+			if got, want := replica.DB(1).Pos(), db.Pos(); got != want {
+				t.Fatalf("pos=%#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+// Ensure a WebSocket handshake is aborted when its context is done, even if
+// the server accepts the connection but never responds.
+func TestClient_Stream_WebSocket_HandshakeTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := http.NewClient()
+	client.Transport = http.TransportWebSocket
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Stream(ctx, "http://"+ln.Addr().String(), nil)
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake did not time out")
+	}
+}
+
+// Ensure a failed WebSocket handshake reports the server's error message.
+func TestClient_Stream_WebSocket_Error(t *testing.T) {
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		gohttp.Error(w, "marker", gohttp.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	client := http.NewClient()
+	client.Transport = http.TransportWebSocket
+	if _, err := client.Stream(context.Background(), srv.URL, nil); err == nil || err.Error() != "invalid response: code=403: marker" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure WebSocket streams use the proxy of the client's transport & that the
+// default port is added to IPv6 hosts.
+func TestClient_Stream_WebSocket_Proxy(t *testing.T) {
+	hostCh := make(chan string, 1)
+	proxy := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.Method != gohttp.MethodConnect {
+			t.Errorf("unexpected method: %s", r.Method)
+		}
+		hostCh <- r.Host
+		w.WriteHeader(gohttp.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.NewClient()
+	client.Transport = http.TransportWebSocket
+	client.HTTPClient.Transport.(*gohttp.Transport).Proxy = gohttp.ProxyURL(proxyURL)
+	if _, err := client.Stream(context.Background(), "http://[::1]", nil); err == nil || !strings.Contains(err.Error(), "code=502") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if got, want := <-hostCh, "[::1]:80"; got != want {
+		t.Fatalf("host=%q, want %q", got, want)
+	}
+}
+
+// Ensure routes are served under the base path and replicas can stream
+// through the prefix.
+func TestServer_BasePath(t *testing.T) {
+	for _, transport := range []string{http.TransportHTTP, http.TransportWebSocket} {
+		t.Run(transport, func(t *testing.T) {
+			primary := newOpenStore(t, nil)
+			server := newOpenServer(t, primary, func(s *http.Server) {
+				s.BasePath = "/litefs/"
+			})
+			if got, want := server.URL(), fmt.Sprintf("http://localhost:%d/litefs", server.Port()); got != want {
+				t.Fatalf("URL=%s, want %s", got, want)
+			}
+
+			var info http.ServerInfo
+			getJSON(t, server.URL()+"/info", &info)
+			if got, want := info.ProtocolVersion, http.ProtocolVersion; got != want {
+				t.Fatalf("ProtocolVersion=%d, want %d", got, want)
+			}
+
+			// Routes outside the base path are not found.
+			resp, err := gohttp.Get(fmt.Sprintf("http://localhost:%d/info", server.Port()))
+			if err != nil {
+				t.Fatal(err)
+			} else if err := resp.Body.Close(); err != nil {
+				t.Fatal(err)
+			} else if got, want := resp.StatusCode, gohttp.StatusNotFound; got != want {
+				t.Fatalf("StatusCode=%d, want %d", got, want)
+			}
+
+			db, f, err := primary.CreateDB("db")
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+			replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()}, func(s *litefs.Store) {
+				client := http.NewClient()
+				client.Transport = transport
+				s.Client = client
+			})
+			testingutil.MustWriteTx(t, db, 2, 2, 1)
+			waitForSync(t, primary, replica, db.ID())
+		})
+	}
+}
+
+// Ensure requests are logged with their fields & the authorization redacted.
+func TestServer_AccessLog(t *testing.T) {
+	var buf logBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.BasePath = "/litefs"
+		s.AuthToken = "secret-token"
+		s.AccessLog = true
+	})
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	req, err := gohttp.NewRequest("GET", server.URL()+"/db/db/pages/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret-token")
+	resp, err := gohttp.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	} else if got, want := resp.StatusCode, gohttp.StatusOK; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+
+	// The line is logged after the response is sent so wait for it.
+	var line string
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		for _, s := range strings.Split(buf.String(), "\n") {
+			if strings.Contains(s, "http: access:") {
+				line = s
+				return nil
+			}
+		}
+		return fmt.Errorf("no access log line")
+	})
+
+	for _, field := range []string{
+		"method=GET",
+		"path=/litefs/db/db/pages/1",
+		"status=200",
+		"duration=",
+		"remote=127.0.0.1:",
+		`db="db"`,
+		"authorization=[REDACTED]",
+	} {
+		if !strings.Contains(line, field) {
+			t.Fatalf("expected %q in log line: %s", field, line)
+		}
+	}
+	if strings.Contains(buf.String(), "secret-token") {
+		t.Fatalf("expected token to be redacted: %s", buf.String())
+	}
+}
+
+// Ensure a replica can stream through another replica relaying the primary.
+func TestServer_Stream_Relay(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	relay := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	relayServer := newOpenServer(t, relay)
+
+	// The leaf cannot reach the primary so it must stream through the relay.
+	leaf := newOpenStore(t, &staticLeaser{primaryURL: "http://localhost:1"}, func(s *litefs.Store) {
+		s.SourceURL = relayServer.URL()
+	})
+
+	testingutil.MustWriteTx(t, db, 2, 2, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+	waitForSync(t, primary, leaf, db.ID())
+
+	if got, want := leaf.DB(db.ID()).Pos(), db.Pos(); got != want {
+		t.Fatalf("pos=%#v, want %#v", got, want)
+	}
+	if got, want := leaf.PrimaryURL(), "http://localhost:1"; got != want {
+		t.Fatalf("PrimaryURL=%s, want %s", got, want)
+	}
+}
+
+// Ensure each node in a relay chain reports its upstream & downstream nodes.
+func TestServer_Topology(t *testing.T) {
+	for _, transport := range []string{http.TransportHTTP, http.TransportWebSocket} {
+		t.Run(transport, func(t *testing.T) {
+			primary := newOpenStore(t, nil)
+			server := newOpenServer(t, primary)
+
+			db, f, err := primary.CreateDB("db")
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+			// Listen before the store opens so the node can advertise its own URL
+			// when it connects upstream.
+			newNode := func(sourceURL string) (*litefs.Store, *http.Server) {
+				var nodeServer *http.Server
+				store := newOpenStore(t, nil, func(s *litefs.Store) {
+					nodeServer = http.NewServer(s, "localhost:0")
+					if err := nodeServer.Listen(); err != nil {
+						t.Fatal(err)
+					}
+					s.Leaser = &staticLeaser{primaryURL: server.URL(), advertiseURL: nodeServer.URL()}
+					s.SourceURL = sourceURL
+
+					client := http.NewClient()
+					client.Transport = transport
+					client.NodeID = s.ID
+					client.AdvertiseURL = nodeServer.URL()
+					s.Client = client
+				})
+				nodeServer.Serve()
+				t.Cleanup(func() {
+					if err := nodeServer.Close(); err != nil {
+						t.Fatalf("cannot close server: %s", err)
+					}
+				})
+				return store, nodeServer
+			}
+			relay, relayServer := newNode("")
+			leaf, leafServer := newNode(relayServer.URL())
+
+			testingutil.MustWriteTx(t, db, 2, 2, 1)
+			waitForSync(t, primary, leaf, db.ID())
+
+			testingutil.RetryUntil(t, 1*time.Millisecond, 10*time.Second, func() error {
+				var info http.TopologyInfo
+				getJSON(t, server.URL()+"/topology", &info)
+				if !info.Primary {
+					return fmt.Errorf("primary: expected primary")
+				} else if info.Upstream != nil {
+					return fmt.Errorf("primary: unexpected upstream: %#v", info.Upstream)
+				} else if len(info.Downstream) != 1 {
+					return fmt.Errorf("primary: downstream=%#v", info.Downstream)
+				} else if got, want := info.Downstream[0], (http.TopologyEdge{NodeID: relay.ID, URL: relayServer.URL(), RemoteAddr: info.Downstream[0].RemoteAddr}); got != want {
+					return fmt.Errorf("primary: downstream=%#v, want %#v", got, want)
+				}
+
+				info = http.TopologyInfo{}
+				getJSON(t, relayServer.URL()+"/topology", &info)
+				if info.Primary {
+					return fmt.Errorf("relay: unexpected primary")
+				} else if got, want := info.Upstream, (&http.TopologyEdge{URL: server.URL()}); got == nil || *got != *want {
+					return fmt.Errorf("relay: upstream=%#v, want %#v", got, want)
+				} else if len(info.Downstream) != 1 {
+					return fmt.Errorf("relay: downstream=%#v", info.Downstream)
+				} else if got, want := info.Downstream[0], (http.TopologyEdge{NodeID: leaf.ID, URL: leafServer.URL(), RemoteAddr: info.Downstream[0].RemoteAddr}); got != want {
+					return fmt.Errorf("relay: downstream=%#v, want %#v", got, want)
+				}
+
+				info = http.TopologyInfo{}
+				getJSON(t, leafServer.URL()+"/topology", &info)
+				if got, want := info.URL, leafServer.URL(); got != want {
+					return fmt.Errorf("leaf: url=%s, want %s", got, want)
+				} else if got, want := info.Upstream, (&http.TopologyEdge{URL: relayServer.URL()}); got == nil || *got != *want {
+					return fmt.Errorf("leaf: upstream=%#v, want %#v", got, want)
+				} else if len(info.Downstream) != 0 {
+					return fmt.Errorf("leaf: downstream=%#v", info.Downstream)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+// Ensure transactions committed within the group commit window are combined
+// into fewer LTX files on the primary & streamed to replicas as such.
+func TestServer_Stream_GroupCommit(t *testing.T) {
+	primary := newOpenStore(t, nil, func(s *litefs.Store) {
+		s.GroupCommitWindow = 200 * time.Millisecond
+	})
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	waitForSync(t, primary, replica, db.ID())
+
+	// Issue many small writes from concurrent goroutines. SQLite serializes
+	// the commits so the test does the same with a mutex.
+	const n = 20
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			testingutil.MustWriteTx(t, db, 2, 2, byte(i))
+		}(i)
+	}
+	wg.Wait()
+
+	// Each commit is applied locally before the group is sent to replicas.
+	if got, want := db.TXID(), uint64(n+1); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+	waitForSync(t, primary, replica, db.ID())
+
+	if got, want := replica.DB(db.ID()).Pos(), db.Pos(); got != want {
+		t.Fatalf("pos=%#v, want %#v", got, want)
+	}
+
+	// Both nodes only hold the combined LTX files & no temporary files.
+	for _, dir := range []string{db.LTXDir(), replica.DB(db.ID()).LTXDir()} {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, max := len(ents), n/2; got > max {
+			t.Fatalf("%s: ltx files=%d, expected no more than %d", dir, got, max)
+		}
+		for _, ent := range ents {
+			if _, _, err := ltx.ParseFilename(ent.Name()); err != nil {
+				t.Fatalf("%s: unexpected file: %s", dir, ent.Name())
+			}
+		}
+	}
+}
+
+// Ensure a replica's stream throughput stays under the configured limit while
+// catching up on many transactions.
+func TestServer_Stream_MaxStreamBytesPerSec(t *testing.T) {
+	const n = 40
+	const maxBytesPerSec = 256 * 1024
+
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary, func(s *http.Server) {
+		s.MaxStreamBytesPerSec = maxBytesPerSec
+	})
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= n; i++ {
+		testingutil.MustWriteTx(t, db, 1, 1, byte(i))
+	}
+
+	// Total the LTX data the replica must receive.
+	ents, err := os.ReadDir(db.LTXDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, ent := range ents {
+		fi, err := ent.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+
+	start := time.Now()
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	waitForSync(t, primary, replica, db.ID())
+	elapsed := time.Since(start)
+
+	if rate := float64(size) / elapsed.Seconds(); rate > maxBytesPerSec {
+		t.Fatalf("throughput=%.0f bytes/sec, want at most %d", rate, maxBytesPerSec)
+	}
+}
+
+// Ensure the throughput limit of a compressed stream applies to the bytes
+// sent rather than the uncompressed stream data.
+func TestServer_Stream_MaxStreamBytesPerSec_Compression(t *testing.T) {
+	const n = 40
+	const maxBytesPerSec = 64 * 1024
+
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary, func(s *http.Server) {
+		s.MaxStreamBytesPerSec = maxBytesPerSec
+		s.StreamCompression = true
+	})
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= n; i++ {
+		testingutil.MustWriteTx(t, db, 1, 1, byte(i))
+	}
+
+	start := time.Now()
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	waitForSync(t, primary, replica, db.ID())
+	elapsed := time.Since(start)
+
+	var info http.StreamStatsInfo
+	getJSON(t, server.URL()+"/stream/stats", &info)
+	if got, want := len(info.Streams), 1; got != want {
+		t.Fatalf("len(Streams)=%d, want %d", got, want)
+	}
+	st := info.Streams[0]
+
+	// Only the compressed bytes are limited so the uncompressed data is sent
+	// faster than the limit.
+	if rate := float64(st.CompressedBytes) / elapsed.Seconds(); rate > maxBytesPerSec {
+		t.Fatalf("compressed throughput=%.0f bytes/sec, want at most %d", rate, maxBytesPerSec)
+	} else if rate := float64(st.UncompressedBytes) / elapsed.Seconds(); rate <= maxBytesPerSec {
+		t.Fatalf("uncompressed throughput=%.0f bytes/sec, want more than %d", rate, maxBytesPerSec)
+	}
+}
+
+// Ensure a compressed stream syncs the replica & reports its compression.
+func TestServer_Stream_Compression(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary, func(s *http.Server) {
+		s.StreamCompression = true
+	})
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		testingutil.MustWriteTx(t, db, 1, 1, byte(i))
+	}
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	waitForSync(t, primary, replica, db.ID())
+
+	var info http.StreamStatsInfo
+	getJSON(t, server.URL()+"/stream/stats", &info)
+	if got, want := len(info.Streams), 1; got != want {
+		t.Fatalf("len(Streams)=%d, want %d", got, want)
+	}
+
+	st := info.Streams[0]
+	if got, want := st.Encoding, http.StreamEncodingGzip; got != want {
+		t.Fatalf("Encoding=%q, want %q", got, want)
+	} else if st.UncompressedBytes == 0 || st.CompressedBytes == 0 {
+		t.Fatalf("expected bytes to be reported: %#v", st)
+	} else if st.CompressionRatio >= 1 {
+		t.Fatalf("CompressionRatio=%v, want less than 1", st.CompressionRatio)
+	} else if st.BytesPerSec <= 0 {
+		t.Fatalf("BytesPerSec=%v, want positive", st.BytesPerSec)
+	}
+}
+
+// Ensure new replica streams are rejected while file descriptors are low.
+func TestServer_Stream_MinFreeFileDescriptors(t *testing.T) {
+	var open uint64 = 90
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary, func(s *http.Server) {
+		s.MinFreeFileDescriptors = 20
+		s.FileDescriptorsFunc = func() (uint64, uint64, error) { return atomic.LoadUint64(&open), 100, nil }
+	})
+
+	var buf bytes.Buffer
+	if err := http.WritePosMapTo(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := gohttp.Post(server.URL()+"/stream", "application/octet-stream", &buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	} else if got, want := resp.StatusCode, gohttp.StatusServiceUnavailable; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+
+	// Streams are accepted once descriptors are freed.
+	atomic.StoreUint64(&open, 50)
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	waitForSync(t, primary, replica, db.ID())
+}
+
+// Ensure the debug delays on the primary & replica produce replication lag of
+// the configured duration.
+func TestServer_Stream_DebugDelay(t *testing.T) {
+	const delay = 200 * time.Millisecond
+
+	for _, tt := range []struct {
+		name       string
+		serverOpt  func(*http.Server)
+		replicaOpt func(*litefs.Store)
+	}{
+		{"Replication", func(s *http.Server) { s.DebugReplicationDelay = delay }, func(*litefs.Store) {}},
+		{"Apply", func(*http.Server) {}, func(s *litefs.Store) { s.DebugApplyDelay = delay }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := newOpenStore(t, nil)
+			server := newOpenServer(t, primary, tt.serverOpt)
+
+			db, f, err := primary.CreateDB("db")
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			testingutil.MustWriteTx(t, db, 1, 1, 1)
+
+			replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()}, tt.replicaOpt)
+			waitForSync(t, primary, replica, db.ID())
+
+			// Measure the lag of a transaction written once the replica is connected.
+			startTime := time.Now()
+			testingutil.MustWriteTx(t, db, 1, 1, 2)
+			waitForSync(t, primary, replica, db.ID())
+
+			if lag := time.Since(startTime); lag < delay {
+				t.Fatalf("lag=%s, want at least %s", lag, delay)
+			} else if lag > delay+time.Second {
+				t.Fatalf("lag=%s, want close to %s", lag, delay)
+			}
+		})
+	}
+}
+
+// Ensure a new replica applies databases concurrently during its initial sync
+// without exceeding the warmup concurrency.
+// Ensure a large transaction is split into chunks on the wire & is applied
+// atomically by the replica.
+func TestServer_Stream_MaxFrameSize(t *testing.T) {
+	const maxFrameSize = 4096
+
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary, func(s *http.Server) {
+		s.MaxFrameSize = maxFrameSize
+	})
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a single transaction that is much larger than the frame size.
+	pgnos := make([]uint32, 64)
+	for i := range pgnos {
+		pgnos[i] = uint32(i + 1)
+	}
+	testingutil.MustWriteTxPages(t, db, pgnos, uint32(len(pgnos)), 1)
+
+	sr, err := http.NewClient().Stream(context.Background(), server.URL(), map[uint32]litefs.Pos{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sr.Close()
+
+	var ltxFrame *litefs.LTXStreamFrame
+	for ltxFrame == nil {
+		frame, err := sr.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ltxFrame, _ = frame.(*litefs.LTXStreamFrame)
+	}
+	if ltxFrame.Size <= 16*maxFrameSize {
+		t.Fatalf("expected large ltx file, got %d bytes", ltxFrame.Size)
+	} else if got, want := ltxFrame.ChunkSize, int64(maxFrameSize); got != want {
+		t.Fatalf("ChunkSize=%d, want %d", got, want)
+	}
+
+	// The file should follow as a series of bounded chunks.
+	var n, chunkN int64
+	for n < ltxFrame.Size {
+		frame, err := sr.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, ok := frame.(*litefs.LTXChunkStreamFrame)
+		if !ok {
+			t.Fatalf("unexpected frame: %T", frame)
+		} else if chunk.Size > maxFrameSize {
+			t.Fatalf("chunk exceeds max frame size: %d", chunk.Size)
+		}
+
+		buf, err := io.ReadAll(sr)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := int64(len(buf)), chunk.Size; got != want {
+			t.Fatalf("chunk payload=%d, want %d", got, want)
+		}
+		n, chunkN = n+chunk.Size, chunkN+1
+	}
+	if got, want := n, ltxFrame.Size; got != want {
+		t.Fatalf("chunks=%d bytes, want %d", got, want)
+	} else if want := (ltxFrame.Size + maxFrameSize - 1) / maxFrameSize; chunkN != want {
+		t.Fatalf("chunkN=%d, want %d", chunkN, want)
+	}
+
+	// The replica should reassemble the chunks & apply the transaction.
+	waitForSync(t, primary, replica, db.ID())
+	if got, want := replica.DB(db.ID()).Pos(), db.Pos(); got != want {
+		t.Fatalf("Pos=%#v, want %#v", got, want)
+	}
+}
+
+func TestServer_Stream_WarmupConcurrency(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	var dbs []*litefs.DB
+	for i := 0; i < 6; i++ {
+		db, f, err := primary.CreateDB(fmt.Sprintf("db%d", i))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, db, 1, 1, 1)
+		testingutil.MustWriteTx(t, db, 1, 1, 2)
+		dbs = append(dbs, db)
+	}
+
+	// Slow down each apply so that concurrent applies overlap.
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()}, func(s *litefs.Store) {
+		s.WarmupConcurrency = 2
+		s.DebugApplyDelay = 50 * time.Millisecond
+	})
+
+	// Sample the number of concurrent applies until the replica has synced.
+	var maxN int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, db := range dbs {
+			for {
+				if n := int32(replica.WarmupSyncN()); n > atomic.LoadInt32(&maxN) {
+					atomic.StoreInt32(&maxN, n)
+				}
+				if rdb := replica.DB(db.ID()); rdb != nil && rdb.Pos() == db.Pos() {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for replica to sync")
+	}
+
+	if got, want := atomic.LoadInt32(&maxN), int32(2); got != want {
+		t.Fatalf("max concurrent applies=%d, want %d", got, want)
+	}
+	for _, db := range dbs {
+		if got, want := replica.DB(db.ID()).Pos(), db.Pos(); got != want {
+			t.Fatalf("Pos(%s)=%#v, want %#v", db.Name(), got, want)
+		}
+	}
+
+	// Once synced, the replica drops back to applying in order.
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if !replica.Ready() || replica.WarmupSyncN() != 0 {
+			return fmt.Errorf("replica not synced")
+		}
+		return nil
+	})
+}
+
+// Ensure a replica learns the primary's position when it connects & reports
+// its lag before any transaction is applied.
+func TestServer_Stream_PrimaryPos(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	for _, name := range []string{"a.db", "b.db"} {
+		if _, f, err := primary.CreateDB(name); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testingutil.MustWriteTx(t, primary.DBByName("a.db"), 1, 1, 1)
+	for i := uint32(1); i <= 3; i++ {
+		testingutil.MustWriteTx(t, primary.DBByName("b.db"), i, i, byte(i))
+	}
+
+	// Hold back every transaction so only the handshake can report lag.
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()}, func(s *litefs.Store) {
+		s.DebugApplyDelay = time.Hour
+	})
+	replicaServer := newOpenServer(t, replica)
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		for _, db := range primary.DBs() {
+			if pos, ok := replica.PrimaryPos(db.ID()); !ok {
+				return fmt.Errorf("primary position not received: db=%s", db.Name())
+			} else if got, want := pos, db.Pos(); got != want {
+				return fmt.Errorf("pos=%#v, want %#v", got, want)
+			}
+		}
+		if replica.DBByName("a.db") == nil {
+			return fmt.Errorf("database not created on replica")
+		}
+		return nil
+	})
+
+	var infos []http.DBInfo
+	getJSON(t, replicaServer.URL()+"/dbs", &infos)
+	if len(infos) == 0 {
+		t.Fatal("expected database on replica")
+	} else if got, want := infos[0].TXID, uint64(0); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	} else if got, want := infos[0].LagTXID, uint64(1); got != want {
+		t.Fatalf("LagTXID=%d, want %d", got, want)
+	}
+}
+
+// Ensure a database deleted on the primary is removed from replicas, unless
+// the replica is configured to ignore drops.
+func TestServer_Stream_DropDB(t *testing.T) {
+	for _, ignoreDrops := range []bool{false, true} {
+		t.Run(fmt.Sprintf("IgnoreDrops=%v", ignoreDrops), func(t *testing.T) {
+			primary := newOpenStore(t, nil)
+			server := newOpenServer(t, primary)
+
+			db, f, err := primary.CreateDB("db")
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+			replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()}, func(s *litefs.Store) {
+				s.IgnoreDrops = ignoreDrops
+			})
+			waitForSync(t, primary, replica, db.ID())
+
+			// Drop the database & create another so we know the drop was streamed.
+			if err := primary.DropDB("db"); err != nil {
+				t.Fatal(err)
+			}
+			other, f, err := primary.CreateDB("other")
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			testingutil.MustWriteTx(t, other, 1, 1, 0)
+			waitForSync(t, primary, replica, other.ID())
+
+			if ignoreDrops {
+				if replica.DBByName("db") == nil {
+					t.Fatal("expected database to be retained")
+				}
+				return
+			}
+
+			if replica.DBByName("db") != nil {
+				t.Fatal("expected database to be removed")
+			} else if !replica.IsDropped(db.ID()) {
+				t.Fatal("expected tombstone")
+			} else if _, err := os.Stat(filepath.Join(replica.DBDir(db.ID()), "database")); !os.IsNotExist(err) {
+				t.Fatalf("expected database file to be removed: %v", err)
+			}
+		})
+	}
+}
+
+// Ensure an empty database replicates without errors & the replica follows
+// once the primary initializes it.
+func TestServer_Stream_EmptyDB(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the empty database to be created on the replica.
+	waitForEmptyDB := func(replica *litefs.Store) {
+		t.Helper()
+		testingutil.RetryUntil(t, 1*time.Millisecond, 10*time.Second, func() error {
+			other := replica.DBByName("db")
+			if other == nil {
+				return fmt.Errorf("no database on replica")
+			} else if got, want := other.Pos(), (litefs.Pos{}); got != want {
+				return fmt.Errorf("pos=%#v, want %#v", got, want)
+			} else if fi, err := os.Stat(other.DatabasePath()); err != nil {
+				return err
+			} else if fi.Size() != 0 {
+				return fmt.Errorf("size=%d, want 0", fi.Size())
+			}
+			return nil
+		})
+	}
+
+	dir := t.TempDir()
+	replica := litefs.NewStore(dir)
+	replica.Client = http.NewClient()
+	replica.Leaser = &staticLeaser{primaryURL: server.URL()}
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	waitForEmptyDB(replica)
+
+	var infos []http.DBInfo
+	getJSON(t, server.URL()+"/dbs", &infos)
+	if len(infos) != 1 || infos[0].TXID != 0 {
+		t.Fatalf("unexpected dbs: %#v", infos)
+	}
+
+	// Reopen the replica while the database is still empty.
+	if err := replica.Close(); err != nil {
+		t.Fatal(err)
+	}
+	replica = newOpenStoreAt(t, dir, &staticLeaser{primaryURL: server.URL()})
+	waitForEmptyDB(replica)
+
+	// Initialize the database on the primary & ensure the replica follows.
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+	testingutil.MustWriteTx(t, db, 2, 2, 1)
+	waitForSync(t, primary, replica, db.ID())
+
+	if got, want := replica.DBByName("db").Pos(), db.Pos(); got != want {
+		t.Fatalf("pos=%#v, want %#v", got, want)
+	}
+	primaryData, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaData, err := os.ReadFile(replica.DBByName("db").DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(primaryData, replicaData) {
+		t.Fatal("database mismatch")
+	}
+}
+
+// Ensure the primary closes a stream when the replica stops sending heartbeats.
+func TestServer_Stream_IdleTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		heartbeatInterval time.Duration
+	}{
+		{"Heartbeat", 10 * time.Millisecond},
+		{"NoHeartbeat", 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newOpenStore(t, nil)
+			server := newOpenServer(t, store, func(s *http.Server) {
+				s.HeartbeatInterval = tt.heartbeatInterval
+				s.IdleTimeout = 100 * time.Millisecond
+			})
+
+			// Connect with a raw request that never sends a heartbeat.
+			resp, cancel := openRawStream(t, server.URL())
+			defer cancel()
+			defer resp.Body.Close()
+
+			// The stream should be reaped by the server after the idle timeout.
+			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+				t.Fatal("stream not closed by server:", err)
+			}
+		})
+	}
+}
+
+// Ensure stream IDs are random & cannot be used to heartbeat other streams.
+func TestServer_Stream_ID(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store)
+
+	resp0, cancel0 := openRawStream(t, server.URL())
+	defer cancel0()
+	defer resp0.Body.Close()
+	resp1, cancel1 := openRawStream(t, server.URL())
+	defer cancel1()
+	defer resp1.Body.Close()
+
+	id0, id1 := resp0.Header.Get(http.StreamIDHeader), resp1.Header.Get(http.StreamIDHeader)
+	if len(id0) != 32 || len(id1) != 32 {
+		t.Fatalf("unexpected stream id length: %q, %q", id0, id1)
+	} else if id0 == id1 {
+		t.Fatalf("duplicate stream id: %q", id0)
+	}
+
+	for _, tt := range []struct {
+		id   string
+		code int
+	}{
+		{id0, gohttp.StatusOK},
+		{"1", gohttp.StatusNotFound},
+		{"", gohttp.StatusBadRequest},
+	} {
+		resp, err := gohttp.Post(server.URL()+"/stream/heartbeat?id="+tt.id, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, tt.code; got != want {
+			t.Fatalf("id=%q: StatusCode=%d, want %d", tt.id, got, want)
+		}
+	}
+}
+
+// openRawStream connects to the stream endpoint without a client so that no
+// heartbeats are sent. The stream is aborted after 10 seconds.
+func openRawStream(tb testing.TB, serverURL string) (*gohttp.Response, context.CancelFunc) {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	if err := http.WritePosMapTo(&buf, map[uint32]litefs.Pos{}); err != nil {
+		tb.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	req, err := gohttp.NewRequestWithContext(ctx, "POST", serverURL+"/stream", &buf)
+	if err != nil {
+		cancel()
+		tb.Fatal(err)
+	}
+	resp, err := gohttp.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		tb.Fatal(err)
+	}
+	return resp, cancel
+}
+
+// Ensure a client sending heartbeats is not reaped by the primary.
+func TestClient_Stream_Heartbeat(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.HeartbeatInterval = 10 * time.Millisecond
+		s.IdleTimeout = 100 * time.Millisecond
+	})
+
+	client := http.NewClient()
+	client.HeartbeatInterval = 10 * time.Millisecond
+	sr, err := client.Stream(context.Background(), server.URL(), map[uint32]litefs.Pos{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sr.Close()
+
+	// Wait well past the idle timeout before writing.
+	time.Sleep(500 * time.Millisecond)
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	for {
+		frame, err := sr.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		} else if _, ok := frame.(*litefs.LTXStreamFrame); ok {
+			break
+		}
+	}
+}
+
+// Ensure a client disconnects if the primary stops sending data.
+func TestClient_Stream_ReadTimeout(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.HeartbeatInterval = 0
+	})
+
+	client := http.NewClient()
+	client.ReadTimeout = 100 * time.Millisecond
+	sr, err := client.Stream(context.Background(), server.URL(), map[uint32]litefs.Pos{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sr.Close()
+
+	if _, err := sr.NextFrame(); err != http.ErrStreamReadTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a client gives up quickly when the primary cannot be reached.
+func TestClient_Stream_DialTimeout(t *testing.T) {
+	client := http.NewClient()
+	client.SetTransportOptions(http.TransportOptions{DialTimeout: 100 * time.Millisecond})
+
+	// Connect to a reserved, unroutable address (TEST-NET-1) that never responds.
+	start := time.Now()
+	if _, err := client.Stream(context.Background(), "http://192.0.2.1:20202", map[uint32]litefs.Pos{}); err == nil {
+		t.Fatal("expected error")
+	} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("dial did not time out quickly: %s", elapsed)
+	}
+}
+
+// Ensure the server returns a list of databases sorted by ID.
+func TestServer_GetDBs(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store)
+
+	for _, name := range []string{"b.db", "a.db"} {
+		if _, f, err := store.CreateDB(name); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testingutil.MustWriteTx(t, store.DBByName("a.db"), 1, 1, 0)
+
+	var infos []http.DBInfo
+	getJSON(t, server.URL()+"/dbs", &infos)
+	if got, want := infos, []http.DBInfo{
+		{ID: 1, Name: "b.db", TXID: 0},
+		{ID: 2, Name: "a.db", TXID: 1},
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dbs=%#v, want %#v", got, want)
+	}
+}
+
+// Ensure the catalog reports each database's size from its page count.
+func TestServer_GetCatalog(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store)
+
+	for _, name := range []string{"a.db", "b.db"} {
+		if _, f, err := store.CreateDB(name); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testingutil.MustWriteTx(t, store.DBByName("a.db"), 1, 1, 1)
+	for i := uint32(1); i <= 3; i++ {
+		testingutil.MustWriteTx(t, store.DBByName("b.db"), i, i, byte(i))
+	}
+
+	var infos []http.CatalogInfo
+	getJSON(t, server.URL()+"/catalog", &infos)
+	if got, want := infos, []http.CatalogInfo{
+		{ID: 1, Name: "a.db", TXID: 1, PageSize: 4096, PageN: 1, Size: 4096, Status: http.CatalogStatusPrimary},
+		{ID: 2, Name: "b.db", TXID: 3, PageSize: 4096, PageN: 3, Size: 3 * 4096, Status: http.CatalogStatusPrimary},
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("catalog=%#v, want %#v", got, want)
+	}
+}
+
+// Ensure a combined snapshot waits for in-progress writes & captures every
+// database at the same moment.
+func TestServer_GetSnapshotAll(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store)
+
+	for _, name := range []string{"a.db", "b.db"} {
+		if _, f, err := store.CreateDB(name); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, store.DBByName(name), 1, 1, 1)
+	}
+
+	// Hold the RESERVED lock as if a write transaction were in progress.
+	guard := store.DBByName("b.db").ReservedLock().TryLock()
+	if guard == nil {
+		t.Fatal("cannot acquire reserved lock")
+	}
+
+	type result struct {
+		resp *gohttp.Response
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		resp, err := gohttp.Get(server.URL() + "/snapshot-all")
+		ch <- result{resp, err}
+	}()
+
+	// The snapshot must not complete until the transaction finishes.
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Fatal("snapshot completed during write transaction")
+	default:
+	}
+	testingutil.MustWriteTx(t, store.DBByName("a.db"), 1, 1, 2)
+	testingutil.MustWriteTx(t, store.DBByName("b.db"), 1, 1, 2)
+	guard.Unlock()
+
+	res := <-ch
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer res.resp.Body.Close()
+	if got, want := res.resp.StatusCode, gohttp.StatusOK; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+
+	// Restore the archive & verify each file matches its manifest position.
+	var manifest http.SnapshotManifest
+	files := make(map[string][]byte)
+	tr := tar.NewReader(res.resp.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == http.SnapshotManifestName {
+			if err := json.Unmarshal(buf, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		files[hdr.Name] = buf
+	}
+
+	if got, want := len(manifest.Databases), 2; got != want {
+		t.Fatalf("len(Databases)=%d, want %d", got, want)
+	}
+	for _, info := range manifest.Databases {
+		db := store.DBByName(info.Name)
+		if got, want := (litefs.Pos{TXID: info.TXID, Chksum: info.Chksum}), db.Pos(); got != want {
+			t.Fatalf("%s: Pos=%#v, want %#v", info.Name, got, want)
+		}
+
+		buf, ok := files[info.Name]
+		if !ok {
+			t.Fatalf("%s: missing from archive", info.Name)
+		}
+		const pageSize = 4096
+		var chksum uint64
+		for pgno := uint32(1); int(pgno)*pageSize <= len(buf); pgno++ {
+			chksum ^= ltx.ChecksumPage(pgno, buf[(pgno-1)*pageSize:pgno*pageSize])
+		}
+		if got, want := ltx.ChecksumFlag|chksum, info.Chksum; got != want {
+			t.Fatalf("%s: checksum=%016x, want %016x", info.Name, got, want)
+		}
+	}
+}
+
+// Ensure an external consumer can stream committed transactions for a
+// database without being a LiteFS node.
+func TestServer_GetDBStream(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.AuthToken = "secret"
+	})
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+	testingutil.MustWriteTx(t, db, 2, 2, 1)
+
+	// Requests without the token are rejected.
+	resp, err := gohttp.Get(server.URL() + "/db/db/stream")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	} else if got, want := resp.StatusCode, gohttp.StatusUnauthorized; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+
+	req, err := gohttp.NewRequest("GET", server.URL()+"/db/db/stream?since="+ltx.FormatTXID(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = gohttp.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, gohttp.StatusOK; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+
+	// Commit another transaction while the consumer is connected.
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+
+	for _, txID := range []uint64{2, 3} {
+		frame, err := litefs.ReadStreamFrame(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ltxFrame, ok := frame.(*litefs.LTXStreamFrame)
+		if !ok {
+			t.Fatalf("unexpected frame type: %T", frame)
+		}
+
+		buf := make([]byte, ltxFrame.Size)
+		if _, err := io.ReadFull(resp.Body, buf); err != nil {
+			t.Fatal(err)
+		}
+		var hdr ltx.Header
+		if err := hdr.UnmarshalBinary(buf[:ltx.HeaderSize]); err != nil {
+			t.Fatal(err)
+		} else if got, want := hdr.MinTXID, txID; got != want {
+			t.Fatalf("MinTXID=%d, want %d", got, want)
+		} else if got, want := hdr.MaxTXID, txID; got != want {
+			t.Fatalf("MaxTXID=%d, want %d", got, want)
+		}
+	}
+}
+
+// Ensure a stream is rejected before it starts if "since" falls within a
+// batch of transactions as no LTX file begins after it.
+func TestServer_GetDBStream_WithinBatch(t *testing.T) {
+	store := newOpenStore(t, nil, func(s *litefs.Store) {
+		s.GroupCommitWindow = time.Hour
+		s.GroupCommitMaxSize = 3
+	})
+	server := newOpenServer(t, store)
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+	testingutil.MustWriteTx(t, db, 1, 1, 3)
+
+	get := func(since uint64) *gohttp.Response {
+		resp, err := gohttp.Get(server.URL() + "/db/db/stream?since=" + ltx.FormatTXID(since))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := get(1)
+	if got, want := resp.StatusCode, gohttp.StatusBadRequest; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+	if buf, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(buf), "within a batch of transactions") {
+		t.Fatalf("unexpected body: %s", buf)
+	}
+
+	// Positions at the start & end of the batch are accepted.
+	for _, since := range []uint64{0, 3} {
+		if got, want := get(since).StatusCode, gohttp.StatusOK; got != want {
+			t.Fatalf("since=%d: StatusCode=%d, want %d", since, got, want)
+		}
+	}
+}
+
+// Ensure a single transaction's LTX file can be fetched by TXID.
+func TestServer_GetDBLTX(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.AuthToken = "secret"
+	})
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+
+	get := func(path, token string) *gohttp.Response {
+		req, err := gohttp.NewRequest("GET", server.URL()+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := gohttp.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		resp := get("/db/db/ltx/"+ltx.FormatTXID(2), "secret")
+		if got, want := resp.StatusCode, gohttp.StatusOK; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+
+		var spec ltx.FileSpec
+		if _, err := spec.ReadFrom(resp.Body); err != nil {
+			t.Fatal(err)
+		} else if got, want := spec.Header.MinTXID, uint64(2); got != want {
+			t.Fatalf("MinTXID=%d, want %d", got, want)
+		} else if got, want := spec.Header.MaxTXID, uint64(2); got != want {
+			t.Fatalf("MaxTXID=%d, want %d", got, want)
+		} else if got, want := spec.Header.PostChecksum, db.Pos().Chksum; got != want {
+			t.Fatalf("PostChecksum=%016x, want %016x", got, want)
+		}
+
+		// The transaction rewrites the header page & page 2.
+		if got, want := len(spec.PageHeaders), 2; got != want {
+			t.Fatalf("len(PageHeaders)=%d, want %d", got, want)
+		} else if got, want := spec.PageHeaders[1].Pgno, uint32(2); got != want {
+			t.Fatalf("Pgno=%d, want %d", got, want)
+		} else if !bytes.Equal(spec.PageData[1], bytes.Repeat([]byte{2}, 4096)) {
+			t.Fatal("unexpected page data")
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		if got, want := get("/db/db/ltx/"+ltx.FormatTXID(2), "").StatusCode, gohttp.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		if got, want := get("/db/db/ltx/"+ltx.FormatTXID(100), "secret").StatusCode, gohttp.StatusNotFound; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("InvalidTXID", func(t *testing.T) {
+		for _, arg := range []string{"xyz", "2", ltx.FormatTXID(0)} {
+			if got, want := get("/db/db/ltx/"+arg, "secret").StatusCode, gohttp.StatusBadRequest; got != want {
+				t.Fatalf("%s: StatusCode=%d, want %d", arg, got, want)
+			}
+		}
+	})
+}
+
+// Ensure a single page can be fetched from the current committed state along
+// with its checksum.
+func TestServer_GetDBPage(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.AuthToken = "secret"
+	})
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 7)
+
+	get := func(path, token string) *gohttp.Response {
+		req, err := gohttp.NewRequest("GET", server.URL()+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := gohttp.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		resp := get("/db/db/pages/2", "secret")
+		if got, want := resp.StatusCode, gohttp.StatusOK; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+
+		buf, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		} else if want := bytes.Repeat([]byte{7}, 4096); !bytes.Equal(buf, want) {
+			t.Fatal("unexpected page data")
+		}
+
+		if got, want := resp.Header.Get(http.PageChecksumHeader), fmt.Sprintf("%016x", ltx.ChecksumPage(2, buf)); got != want {
+			t.Fatalf("checksum=%s, want %s", got, want)
+		} else if got, want := resp.Header.Get(http.TXIDHeader), ltx.FormatTXID(2); got != want {
+			t.Fatalf("txid=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		if got, want := get("/db/db/pages/2", "").StatusCode, gohttp.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		if got, want := get("/db/db/pages/3", "secret").StatusCode, gohttp.StatusNotFound; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("InvalidPgno", func(t *testing.T) {
+		for _, arg := range []string{"0", "xyz", "4294967296"} {
+			if got, want := get("/db/db/pages/"+arg, "secret").StatusCode, gohttp.StatusBadRequest; got != want {
+				t.Fatalf("StatusCode(%s)=%d, want %d", arg, got, want)
+			}
+		}
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		// SQLite holds the SHARED lock exclusively while writing the database.
+		shared := db.SharedLock().TryLock()
+		if shared == nil {
+			t.Fatal("cannot acquire shared lock")
+		}
+		defer shared.Unlock()
+
+		if got, want := get("/db/db/pages/2", "secret").StatusCode, gohttp.StatusServiceUnavailable; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+}
+
+// Ensure database positions can be compared with a peer.
+// Ensure page checksums are reported for a database as of past transactions.
+func TestServer_GetChecksums(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store)
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// pageChecksums returns the checksum of each page of the database file.
+	pageChecksums := func() []uint64 {
+		data, err := os.ReadFile(db.DatabasePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a []uint64
+		for i := 0; i < len(data)/4096; i++ {
+			a = append(a, ltx.ChecksumPage(uint32(i+1), data[i*4096:(i+1)*4096]))
+		}
+		return a
+	}
+
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	want1 := pageChecksums()
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+	want2 := pageChecksums()
+	testingutil.MustWriteTx(t, db, 2, 2, 3)
+
+	for _, tt := range []struct {
+		txID uint64
+		want []uint64
+	}{{1, want1}, {2, want2}, {3, pageChecksums()}} {
+		var info http.PageChecksumsInfo
+		getJSON(t, fmt.Sprintf("%s/checksums?name=db&txid=%s", server.URL(), ltx.FormatTXID(tt.txID)), &info)
+		if got, want := info.TXID, tt.txID; got != want {
+			t.Fatalf("TXID=%d, want %d", got, want)
+		} else if pos, err := db.PosAt(tt.txID); err != nil {
+			t.Fatal(err)
+		} else if got, want := info.Chksum, pos.Chksum; got != want {
+			t.Fatalf("Chksum=%016x, want %016x", got, want)
+		} else if got, want := info.PageSize, uint32(4096); got != want {
+			t.Fatalf("PageSize=%d, want %d", got, want)
+		} else if got, want := info.Checksums, tt.want; !reflect.DeepEqual(got, want) {
+			t.Fatalf("txid=%d: Checksums=%x, want %x", tt.txID, got, want)
+		}
+	}
+
+	// Transactions that have not been committed are not found.
+	resp, err := gohttp.Get(server.URL() + "/checksums?name=db&txid=" + ltx.FormatTXID(4))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	} else if got, want := resp.StatusCode, gohttp.StatusNotFound; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+}
+
+func TestServer_GetDiff(t *testing.T) {
+	t.Run("Synced", func(t *testing.T) {
+		primary := newOpenStore(t, nil)
+		primaryServer := newOpenServer(t, primary)
+
+		db, f, err := primary.CreateDB("db")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, db, 1, 1, 1)
+
+		replica := newOpenStore(t, &staticLeaser{primaryURL: primaryServer.URL()})
+		replicaServer := newOpenServer(t, replica)
+		waitForSync(t, primary, replica, db.ID())
+
+		var info http.DiffInfo
+		getJSON(t, replicaServer.URL()+"/diff?peer="+url.QueryEscape(primaryServer.URL()), &info)
+		if !info.InSync {
+			t.Fatalf("expected in sync: %#v", info)
+		} else if got, want := info.DBs, []http.DBDiff{{
+			ID:         db.ID(),
+			Name:       "db",
+			Status:     http.DiffStatusMatch,
+			TXID:       1,
+			Chksum:     db.Pos().Chksum,
+			PeerTXID:   1,
+			PeerChksum: db.Pos().Chksum,
+		}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("DBs=%#v, want %#v", got, want)
+		}
+	})
+
+	// Two nodes with different contents at the same TXID have diverged.
+	t.Run("Diverged", func(t *testing.T) {
+		store0, store1 := newOpenStore(t, nil), newOpenStore(t, nil)
+		server0, server1 := newOpenServer(t, store0), newOpenServer(t, store1)
+
+		for i, store := range []*litefs.Store{store0, store1} {
+			for _, name := range []string{"a", "b"} {
+				db, f, err := store.CreateDB(name)
+				if err != nil {
+					t.Fatal(err)
+				} else if err := f.Close(); err != nil {
+					t.Fatal(err)
+				}
+				testingutil.MustWriteTx(t, db, 1, 1, 1)
+
+				// Only diverge the second database.
+				if name == "b" {
+					testingutil.MustWriteTx(t, db, 1, 1, byte(10+i))
+				}
+			}
+		}
+		testingutil.MustWriteTx(t, store1.DBByName("a"), 1, 1, 2)
+
+		var info http.DiffInfo
+		getJSON(t, server0.URL()+"/diff?peer="+url.QueryEscape(server1.URL()), &info)
+		if info.InSync {
+			t.Fatal("expected diff")
+		} else if got, want := len(info.DBs), 2; got != want {
+			t.Fatalf("len(DBs)=%d, want %d", got, want)
+		} else if got, want := info.DBs[0].Status, http.DiffStatusBehind; got != want {
+			t.Fatalf("Status=%s, want %s", got, want)
+		} else if got, want := info.DBs[1].Status, http.DiffStatusDiverged; got != want {
+			t.Fatalf("Status=%s, want %s", got, want)
+		} else if diff := info.DBs[1]; diff.TXID != diff.PeerTXID || diff.Chksum == diff.PeerChksum {
+			t.Fatalf("unexpected diff: %#v", diff)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		store := newOpenStore(t, nil)
+		server := newOpenServer(t, store, func(s *http.Server) {
+			s.AuthToken = "secret"
+		})
+
+		resp, err := gohttp.Get(server.URL() + "/diff?peer=" + url.QueryEscape(server.URL()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if got, want := resp.StatusCode, gohttp.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	})
+}
+
+// Ensure the schema version of each configured database is reported.
+func TestServer_GetDBs_SchemaVersion(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.SchemaVersionFunc = func(ctx context.Context, db *litefs.DB) (int64, bool, error) {
+			if db.Name() != "a.db" {
+				return 0, false, nil
+			}
+			return int64(db.TXID()), true, nil
+		}
+	})
+
+	for _, name := range []string{"a.db", "b.db"} {
+		db, f, err := store.CreateDB(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, db, 1, 1, 1)
+		testingutil.MustWriteTx(t, db, 1, 1, 2)
+	}
+
+	var infos []http.DBInfo
+	getJSON(t, server.URL()+"/dbs", &infos)
+	if got, want := len(infos), 2; got != want {
+		t.Fatalf("len=%d, want %d", got, want)
+	} else if v := infos[0].SchemaVersion; v == nil || *v != 2 {
+		t.Fatalf("SchemaVersion=%v, want 2", v)
+	} else if v := infos[1].SchemaVersion; v != nil {
+		t.Fatalf("SchemaVersion=%v, want nil", *v)
+	}
+}
+
+// Ensure the server reports the node ID of its store.
+func TestServer_GetInstanceID(t *testing.T) {
+	store := newOpenStore(t, nil, func(s *litefs.Store) { s.ID = "node-1" })
+	server := newOpenServer(t, store)
+
+	var info http.InstanceInfo
+	getJSON(t, server.URL()+"/instance/id", &info)
+	if got, want := info.ID, "node-1"; got != want {
+		t.Fatalf("ID=%q, want %q", got, want)
+	}
+}
+
+// Ensure the server reports its version, protocol & leaser backend.
+func TestServer_GetInfo(t *testing.T) {
+	t.Run("Leaser", func(t *testing.T) {
+		store := newOpenStore(t, &staticLeaser{primaryURL: "http://localhost:1", advertiseURL: "http://localhost:2"}, func(s *litefs.Store) {
+			s.GroupCommitWindow = time.Millisecond
+		})
+		server := newOpenServer(t, store, func(s *http.Server) {
+			s.Version = "v1.2.3"
+			s.MountDir = "/mnt/litefs"
+		})
+
+		var info http.ServerInfo
+		getJSON(t, server.URL()+"/info", &info)
+		if got, want := info, (http.ServerInfo{
+			NodeID:          store.ID,
+			Version:         "v1.2.3",
+			ProtocolVersion: http.ProtocolVersion,
+			Leaser:          "static",
+			MountDir:        "/mnt/litefs",
+			AdvertiseURL:    "http://localhost:2",
+			Features:        []string{"websocket", "group-commit"},
+		}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("info=%#v, want %#v", got, want)
+		}
+	})
+
+	// Leasers are not required to report their type.
+	t.Run("UntypedLeaser", func(t *testing.T) {
+		store := newOpenStore(t, &blockingLeaser{ttl: time.Minute})
+		server := newOpenServer(t, store)
+
+		var info http.ServerInfo
+		getJSON(t, server.URL()+"/info", &info)
+		if got, want := info.Leaser, ""; got != want {
+			t.Fatalf("Leaser=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("Standalone", func(t *testing.T) {
+		store := newOpenStore(t, nil, func(s *litefs.Store) { s.Standalone = true })
+		server := newOpenServer(t, store)
+
+		var info http.ServerInfo
+		getJSON(t, server.URL()+"/info", &info)
+		if got, want := info.Leaser, "standalone"; got != want {
+			t.Fatalf("Leaser=%q, want %q", got, want)
+		} else if got, want := info.ProtocolVersion, http.ProtocolVersion; got != want {
+			t.Fatalf("ProtocolVersion=%d, want %d", got, want)
+		}
+	})
+}
+
+// Ensure the server serves the same handlers on every listen address.
+func TestServer_Addrs(t *testing.T) {
+	store := newOpenStore(t, nil, func(s *litefs.Store) { s.Standalone = true })
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.Addrs = []string{"127.0.0.1:0"}
+	})
+
+	addrs := server.ListenAddrs()
+	if got, want := len(addrs), 2; got != want {
+		t.Fatalf("len(ListenAddrs)=%d, want %d", got, want)
+	} else if got, want := server.Port(), addrs[0].(*net.TCPAddr).Port; got != want {
+		t.Fatalf("Port=%d, want %d", got, want)
+	}
+
+	for _, addr := range addrs {
+		var info http.ServerInfo
+		getJSON(t, "http://"+addr.String()+"/info", &info)
+		if got, want := info.Leaser, "standalone"; got != want {
+			t.Fatalf("%s: Leaser=%q, want %q", addr, got, want)
+		}
+	}
+}
+
+// Ensure checksum mismatches detected by the store are reported.
+func TestServer_GetStatus_ChecksumMismatch(t *testing.T) {
+	newDB := func(store *litefs.Store, values ...byte) *litefs.DB {
+		db, f, err := store.CreateDB("db")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			testingutil.MustWriteTx(t, db, 1, 1, v)
+		}
+		return db
+	}
+
+	primaryDB := newDB(newOpenStore(t, nil), 1, 2)
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store)
+	db := newDB(store, 9)
+
+	var info http.StatusInfo
+	getJSON(t, server.URL()+"/status", &info)
+	if info.ChecksumMismatches != 0 || info.LastChecksumMismatch != nil {
+		t.Fatalf("unexpected mismatch: %#v", info)
+	}
+
+	if err := db.TryApplyLTX(primaryDB.LTXPath(2, 2)); !errors.Is(err, litefs.ErrChecksumMismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	getJSON(t, server.URL()+"/status", &info)
+	if got, want := info.ChecksumMismatches, uint64(1); got != want {
+		t.Fatalf("ChecksumMismatches=%d, want %d", got, want)
+	} else if m := info.LastChecksumMismatch; m == nil || m.DBID != db.ID() || m.TXID != 2 {
+		t.Fatalf("LastChecksumMismatch=%#v", m)
+	}
+}
+
+// Ensure a replica that cannot apply a transaction from the primary reports
+// the error & recovers by resyncing the database.
+func TestServer_GetStatus_ApplyError(t *testing.T) {
+	// Create a replica database that has diverged from the primary at TXID 1.
+	dir := t.TempDir()
+	replica := litefs.NewStore(dir)
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := replica.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 9)
+	if err := replica.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	primary := newOpenStore(t, nil)
+	primaryServer := newOpenServer(t, primary)
+	primaryDB, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, primaryDB, 1, 1, 1)
+	testingutil.MustWriteTx(t, primaryDB, 1, 1, 2)
+
+	replica = newOpenStoreAt(t, dir, &staticLeaser{primaryURL: primaryServer.URL()}, func(s *litefs.Store) {
+		s.ApplyErrorPolicy = litefs.ApplyErrorPolicyResync
+	})
+	server := newOpenServer(t, replica)
+
+	// The replica should fail to apply TXID 2 & resync from the primary.
+	waitForSync(t, primary, replica, primaryDB.ID())
+	if got, want := replica.DB(primaryDB.ID()).Pos(), primaryDB.Pos(); got != want {
+		t.Fatalf("Pos=%#v, want %#v", got, want)
+	}
+
+	var info http.StatusInfo
+	getJSON(t, server.URL()+"/status", &info)
+	if info.ApplyErrors < 1 {
+		t.Fatalf("ApplyErrors=%d, want at least 1", info.ApplyErrors)
+	} else if e := info.LastApplyError; e == nil || e.DBID != primaryDB.ID() || e.TXID != 2 || !strings.Contains(e.Error, "checksum mismatch") {
+		t.Fatalf("LastApplyError=%#v", e)
+	}
+}
+
+// Ensure the status reports the node that wrote the latest transactions & that
+// it changes to the new primary after a failover.
+func TestServer_GetStatus_LastWriter(t *testing.T) {
+	leaser := testingutil.NewLeaser()
+
+	newNode := func() (*litefs.Store, *http.Server) {
+		store := litefs.NewStore(t.TempDir())
+		store.Client = http.NewClient()
+		server := newOpenServer(t, store)
+		store.Leaser = leaser.Node(server.URL())
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := store.Close(); err != nil {
+				t.Fatalf("cannot close store: %s", err)
+			}
+		})
+		return store, server
+	}
+
+	// waitForWriter waits until the only database on a node reports nodeID as
+	// the writer of the transactions from txID onward.
+	waitForWriter := func(server *http.Server, nodeID string, txID uint64) {
+		t.Helper()
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			var info http.StatusInfo
+			getJSON(t, server.URL()+"/status", &info)
+			if len(info.DBs) != 1 {
+				return fmt.Errorf("unexpected databases: %#v", info.DBs)
+			} else if got := info.DBs[0]; got.LastWriter != nodeID || got.LastWriterTXID != txID {
+				return fmt.Errorf("unexpected writer: %#v", got)
+			}
+			return nil
+		})
+	}
+
+	primary, primaryServer := newNode()
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if !primary.IsPrimary() {
+			return fmt.Errorf("not primary")
+		}
+		return nil
+	})
+	replica, replicaServer := newNode()
+
+	// Write transactions on the original primary.
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+	waitForSync(t, primary, replica, db.ID())
+	waitForWriter(replicaServer, primary.ID, 1)
+
+	// Fail over to the replica & write on the new primary.
+	primaryServer.Drain()
+	testingutil.RetryUntil(t, 10*time.Millisecond, 10*time.Second, func() error {
+		if !replica.IsPrimary() {
+			return fmt.Errorf("replica not promoted")
+		} else if got, want := primary.PrimaryURL(), replicaServer.URL(); got != want {
+			return fmt.Errorf("PrimaryURL=%q, want %q", got, want)
+		}
+		return nil
+	})
+	testingutil.MustWriteTx(t, replica.DB(db.ID()), 1, 1, 3)
+	waitForSync(t, replica, primary, db.ID())
+
+	// Both nodes report the new primary as the writer of the latest
+	// transactions while the earlier transactions keep their writer.
+	waitForWriter(replicaServer, replica.ID, 3)
+	waitForWriter(primaryServer, replica.ID, 3)
+	if got, want := primary.DB(db.ID()).WriterAt(2), primary.ID; got != want {
+		t.Fatalf("WriterAt(2)=%q, want %q", got, want)
+	}
+}
+
+// Ensure the status reports the time since the last lease renewal and that it
+// continues to climb while renewals are blocked.
+func TestServer_GetStatus_SecondsSinceLastRenew(t *testing.T) {
+	leaser := &blockingLeaser{ttl: 100 * time.Millisecond}
+	store := newOpenStore(t, leaser)
+	server := newOpenServer(t, store)
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if !store.IsPrimary() {
+			return fmt.Errorf("not primary")
+		}
+		return nil
+	})
+
+	var prev http.StatusInfo
+	getJSON(t, server.URL()+"/status", &prev)
+	if !prev.IsPrimary {
+		t.Fatal("expected primary")
+	}
+
+	// Wait past the point where a renewal would normally have occurred.
+	time.Sleep(2 * leaser.ttl)
+
+	var info http.StatusInfo
+	getJSON(t, server.URL()+"/status", &info)
+	if info.SecondsSinceLastRenew <= prev.SecondsSinceLastRenew {
+		t.Fatalf("seconds_since_last_renew=%f, expected greater than %f", info.SecondsSinceLastRenew, prev.SecondsSinceLastRenew)
+	} else if min := (2 * leaser.ttl).Seconds(); info.SecondsSinceLastRenew < min {
+		t.Fatalf("seconds_since_last_renew=%f, expected at least %f", info.SecondsSinceLastRenew, min)
+	}
+}
+
+// Ensure a transaction is not streamed to replicas until its LTX file has been
+// fsynced on the primary.
+func TestServer_Stream_DurableBeforeReplicate(t *testing.T) {
+	var block int32
+	ltxSyncCh, releaseCh := make(chan string), make(chan struct{})
+	primary := newOpenStore(t, nil, func(s *litefs.Store) {
+		s.DurableBeforeReplicate = true
+		s.FsyncFunc = func(f *os.File) error {
+			if strings.HasSuffix(f.Name(), ".ltx") && atomic.LoadInt32(&block) == 1 {
+				ltxSyncCh <- f.Name()
+				<-releaseCh
+			}
+			return f.Sync()
+		}
+	})
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	waitForSync(t, primary, replica, db.ID())
+
+	// Block the fsync of the next transaction's LTX file.
+	atomic.StoreInt32(&block, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		testingutil.MustWriteTx(t, db, 2, 2, 1)
+	}()
+
+	select {
+	case path := <-ltxSyncCh:
+		if got, want := path, db.LTXPath(2, 2); got != want {
+			t.Fatalf("path=%s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for ltx fsync")
+	}
+
+	// Ensure the replica does not receive the transaction while it is unsynced.
+	time.Sleep(100 * time.Millisecond)
+	if got, want := replica.DB(db.ID()).TXID(), uint64(1); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+
+	close(releaseCh)
+	<-done
+	waitForSync(t, primary, replica, db.ID())
+}
+
+// Ensure a newly-started replica rejects reads until it has caught up with the
+// primary or until its read grace period has elapsed.
+func TestServer_Stream_ReadGracePeriod(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	// Sync the database to the replica & then disconnect it.
+	dir := t.TempDir()
+	replica := litefs.NewStore(dir)
+	replica.Client = http.NewClient()
+	replica.Leaser = &staticLeaser{primaryURL: server.URL()}
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, primary, replica, db.ID())
+	if err := replica.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write while the replica is offline.
+	testingutil.MustWriteTx(t, db, 2, 2, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+
+	// Restart without catching up & ensure reads resume after the grace period.
+	replica = litefs.NewStore(dir)
+	replica.Client = http.NewClient()
+	replica.Leaser = &staticLeaser{primaryURL: server.URL()}
+	replica.ReadGracePeriod = 100 * time.Millisecond
+	replica.PauseReplication()
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	} else if err := replica.CheckReadable(replica.DB(db.ID())); err != litefs.ErrSyncing {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := replica.CheckReadable(replica.DB(db.ID())); err != nil {
+		t.Fatal(err)
+	} else if err := replica.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart with a long grace period & ensure reads resume once caught up.
+	replica = newOpenStoreAt(t, dir, &staticLeaser{primaryURL: server.URL()}, func(s *litefs.Store) {
+		s.ReadGracePeriod = time.Minute
+		s.PauseReplication()
+	})
+	if err := replica.CheckReadable(replica.DB(db.ID())); err != litefs.ErrSyncing {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	replica.ResumeReplication()
+	waitForSync(t, primary, replica, db.ID())
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		return replica.CheckReadable(replica.DB(db.ID()))
+	})
+}
+
+// Ensure a replica stays at its position while replication is paused and
+// catches up once it is resumed.
+func TestServer_PauseReplication(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	replicaServer := newOpenServer(t, replica)
+	waitForSync(t, primary, replica, db.ID())
+
+	var info http.StatusInfo
+	postJSON(t, replicaServer.URL()+"/admin/replication/pause", &info)
+	if !info.Paused {
+		t.Fatal("expected replication to be paused")
+	}
+
+	// Write to the primary & ensure the replica does not receive it.
+	testingutil.MustWriteTx(t, db, 2, 2, 1)
+	time.Sleep(100 * time.Millisecond)
+	if got, want := replica.DB(db.ID()).TXID(), uint64(1); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+
+	postJSON(t, replicaServer.URL()+"/admin/replication/resume", &info)
+	if info.Paused {
+		t.Fatal("expected replication to be resumed")
+	}
+	waitForSync(t, primary, replica, db.ID())
+
+	// Pausing the primary or without the auth token is not allowed.
+	authServer := newOpenServer(t, replica, func(s *http.Server) {
+		s.AuthToken = "secret"
+	})
+	for _, tt := range []struct {
+		url  string
+		code int
+	}{
+		{server.URL() + "/admin/replication/pause", gohttp.StatusBadRequest},
+		{authServer.URL() + "/admin/replication/pause", gohttp.StatusUnauthorized},
+		{authServer.URL() + "/admin/replication/resume", gohttp.StatusUnauthorized},
+	} {
+		resp, err := gohttp.Post(tt.url, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := resp.Body.Close(); err != nil {
+			t.Fatal(err)
+		} else if got, want := resp.StatusCode, tt.code; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	}
+	if replica.ReplicationPaused() {
+		t.Fatal("expected replication to be resumed")
+	}
+}
+
+// Ensure the server waits for connected replicas to be sent recent writes.
+func TestServer_WaitForReplicas(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	waitForSync(t, primary, replica, db.ID())
+
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.WaitForReplicas(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, primary, replica, db.ID())
+}
+
+// Ensure a connected replica receives the last commit when the primary shuts
+// down gracefully within the drain window.
+func TestServer_Close_ReplicaDrainTimeout(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := http.NewServer(primary, "localhost:0")
+	server.ReplicaDrainTimeout = 5 * time.Second
+	server.DebugReplicationDelay = 200 * time.Millisecond // delay delivery past Close()
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	waitForSync(t, primary, replica, db.ID())
+
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, primary, replica, db.ID())
+}
+
+// Ensure a corrupted replica database can be discarded & restored from the primary.
+func TestServer_Resync(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	replicaServer := newOpenServer(t, replica)
+	waitForSync(t, primary, replica, db.ID())
+
+	// Corrupt the replica's copy of the database.
+	replicaDB := replica.DB(db.ID())
+	if err := os.WriteFile(replicaDB.DatabasePath(), bytes.Repeat([]byte{0xFF}, 4096), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pause so the database stays unreadable until replication resumes.
+	var info http.StatusInfo
+	postJSON(t, replicaServer.URL()+"/admin/replication/pause", &info)
+	postJSON(t, replicaServer.URL()+"/admin/resync?db=db", &info)
+	if err := replica.CheckReadable(replicaDB); err != litefs.ErrSyncing {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	postJSON(t, replicaServer.URL()+"/admin/replication/resume", &info)
+
+	want, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testingutil.RetryUntil(t, 1*time.Millisecond, 10*time.Second, func() error {
+		if err := replica.CheckReadable(replicaDB); err != nil {
+			return err
+		} else if got, err := os.ReadFile(replicaDB.DatabasePath()); err != nil {
+			return err
+		} else if !bytes.Equal(got, want) {
+			return fmt.Errorf("database mismatch")
+		} else if got, want := replicaDB.Pos(), db.Pos(); got != want {
+			return fmt.Errorf("pos=%v, want %v", got, want)
+		}
+		return nil
+	})
+
+	// Ensure the replica continues to receive new transactions.
+	testingutil.MustWriteTx(t, db, 1, 1, 3)
+	waitForSync(t, primary, replica, db.ID())
+
+	// Resyncing the primary, an unknown database, or without the auth token
+	// is not allowed.
+	authServer := newOpenServer(t, replica, func(s *http.Server) {
+		s.AuthToken = "secret"
+	})
+	for _, tt := range []struct {
+		url  string
+		code int
+	}{
+		{server.URL() + "/admin/resync", gohttp.StatusBadRequest},
+		{replicaServer.URL() + "/admin/resync?db=nosuchdb", gohttp.StatusNotFound},
+		{authServer.URL() + "/admin/resync?db=db", gohttp.StatusUnauthorized},
+	} {
+		resp, err := gohttp.Post(tt.url, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := resp.Body.Close(); err != nil {
+			t.Fatal(err)
+		} else if got, want := resp.StatusCode, tt.code; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	}
+}
+
+// Ensure a frozen database rejects writes on the primary & replicas until thawed.
+func TestServer_FreezeDB(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	replicaServer := newOpenServer(t, replica)
+	waitForSync(t, primary, replica, db.ID())
+
+	var info http.DBInfo
+	postJSON(t, server.URL()+"/db/db/freeze", &info)
+	if !info.Frozen {
+		t.Fatal("expected database to be frozen")
+	} else if _, err := db.CreateJournal(); err != litefs.ErrDatabaseFrozen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var infos []http.DBInfo
+	getJSON(t, server.URL()+"/dbs", &infos)
+	if len(infos) != 1 || !infos[0].Frozen {
+		t.Fatalf("expected frozen database in status: %#v", infos)
+	}
+
+	// Ensure the frozen state is replicated so a promoted replica respects it.
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if !replica.DB(db.ID()).Frozen() {
+			return fmt.Errorf("replica database not frozen")
+		}
+		return nil
+	})
+
+	// Freezing on a replica is not allowed.
+	resp, err := gohttp.Post(replicaServer.URL()+"/db/db/thaw", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	} else if got, want := resp.StatusCode, gohttp.StatusMisdirectedRequest; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+
+	info = http.DBInfo{}
+	postJSON(t, server.URL()+"/db/db/thaw", &info)
+	if info.Frozen {
+		t.Fatal("expected database to be thawed")
+	}
+	testingutil.MustWriteTx(t, db, 2, 2, 1)
+	waitForSync(t, primary, replica, db.ID())
+
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if replica.DB(db.ID()).Frozen() {
+			return fmt.Errorf("replica database still frozen")
+		}
+		return nil
+	})
+}
+
+// Ensure a replica reports its progress while catching up to the primary.
+func TestServer_Stream_CatchUp(t *testing.T) {
+	const n = 200
+
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= n; i++ {
+		testingutil.MustWriteTx(t, db, i, i, byte(i))
+	}
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	replicaServer := newOpenServer(t, replica)
+
+	// Sample progress until the replica has caught up.
+	var prev float64
+	testingutil.RetryUntil(t, 1*time.Millisecond, 10*time.Second, func() error {
+		replicaDB := replica.DB(db.ID())
+		if replicaDB == nil {
+			return fmt.Errorf("replica database not created")
+		}
+		c, ok := replicaDB.CatchUp()
+		if !ok {
+			return fmt.Errorf("no catch-up progress")
+		} else if c.Percent() < prev {
+			t.Fatalf("Percent=%f, want at least %f", c.Percent(), prev)
+		}
+		prev = c.Percent()
+
+		if prev < 100 {
+			return fmt.Errorf("catch-up incomplete: %f%%", prev)
+		}
+		return nil
+	})
+
+	var infos []http.DBInfo
+	getJSON(t, replicaServer.URL()+"/dbs", &infos)
+	if len(infos) != 1 || infos[0].CatchUp == nil {
+		t.Fatalf("expected catch-up progress: %#v", infos)
+	} else if got, want := *infos[0].CatchUp, (http.CatchUpInfo{TXID: n, TargetTXID: n, Percent: 100, Rate: infos[0].CatchUp.Rate}); got != want {
+		t.Fatalf("CatchUp=%#v, want %#v", got, want)
+	} else if got.Rate <= 0 {
+		t.Fatalf("Rate=%f, want positive", got.Rate)
+	}
+}
+
+// Ensure a replica clones a new database from an identical local database
+// instead of streaming its snapshot when deduplication is enabled.
+func TestServer_Stream_DedupSnapshots(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary, func(s *http.Server) { s.DedupSnapshots = true })
+
+	newDB := func(name string) *litefs.DB {
+		db, f, err := primary.CreateDB(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, db, 1, 1, 0)
+		testingutil.MustWriteTx(t, db, 2, 2, 1)
+		testingutil.MustWriteTx(t, db, 2, 2, 2)
+		return db
+	}
+
+	// Sync the first database to the replica & then disconnect it.
+	db0 := newDB("db0")
+	dir := t.TempDir()
+	replica := litefs.NewStore(dir)
+	replica.Client = http.NewClient()
+	replica.Leaser = &staticLeaser{primaryURL: server.URL()}
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, primary, replica, db0.ID())
+	if err := replica.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a second database with identical contents & reconnect.
+	db1 := newDB("db1")
+	if got, want := db1.Pos(), db0.Pos(); got != want {
+		t.Fatalf("Pos=%#v, want %#v", got, want)
+	}
+	replica = newOpenStoreAt(t, dir, &staticLeaser{primaryURL: server.URL()})
+	waitForSync(t, primary, replica, db1.ID())
+
+	// Ensure the replica seeded a snapshot instead of receiving each transaction.
+	ltxFilenames := func(db *litefs.DB) []string {
+		ents, err := os.ReadDir(db.LTXDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, ent := range ents {
+			names = append(names, ent.Name())
+		}
+		return names
+	}
+	if got, want := ltxFilenames(replica.DB(db1.ID())), []string{ltx.FormatFilename(1, 3)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ltx files=%v, want %v", got, want)
+	} else if got, want := replica.DB(db1.ID()).Name(), "db1"; got != want {
+		t.Fatalf("Name=%s, want %s", got, want)
+	}
+
+	// Ensure later transactions continue to stream incrementally.
+	testingutil.MustWriteTx(t, db1, 3, 3, 3)
+	waitForSync(t, primary, replica, db1.ID())
+	if got, want := replica.DB(db1.ID()).Pos(), db1.Pos(); got != want {
+		t.Fatalf("Pos=%#v, want %#v", got, want)
+	} else if got, want := ltxFilenames(replica.DB(db1.ID())), []string{ltx.FormatFilename(1, 3), ltx.FormatFilename(4, 4)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ltx files=%v, want %v", got, want)
+	}
+}
+
+// Ensure a draining primary hands off to a replica before reporting drained.
+func TestServer_Drain(t *testing.T) {
+	leaser := testingutil.NewLeaser()
+
+	newNode := func() (*litefs.Store, *http.Server) {
+		store := litefs.NewStore(t.TempDir())
+		store.Client = http.NewClient()
+		server := newOpenServer(t, store)
+		store.Leaser = leaser.Node(server.URL())
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := store.Close(); err != nil {
+				t.Fatalf("cannot close store: %s", err)
+			}
+		})
+		return store, server
+	}
+
+	primary, primaryServer := newNode()
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if !primary.IsPrimary() {
+			return fmt.Errorf("not primary")
+		}
+		return nil
+	})
+	replica, replicaServer := newNode()
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if got, want := replica.PrimaryURL(), primaryServer.URL(); got != want {
+			return fmt.Errorf("PrimaryURL=%q, want %q", got, want)
+		}
+		return nil
+	})
+
+	// Poll the drain status until the replica has taken over.
+	client := http.NewClient()
+	testingutil.RetryUntil(t, 10*time.Millisecond, 10*time.Second, func() error {
+		info, err := client.Drain(context.Background(), primaryServer.URL())
+		if err != nil {
+			return err
+		} else if !info.Draining {
+			t.Fatal("expected draining")
+		} else if !info.Drained {
+			return fmt.Errorf("not drained: %#v", info)
+		}
+		return nil
+	})
+
+	if !replica.IsPrimary() {
+		t.Fatal("expected replica to be promoted")
+	} else if primary.IsPrimary() {
+		t.Fatal("expected drained node to be demoted")
+	} else if got, want := primary.PrimaryURL(), replicaServer.URL(); got != want {
+		t.Fatalf("PrimaryURL=%q, want %q", got, want)
+	}
+
+	// New streams to the drained node are rejected.
+	if _, err := client.Stream(context.Background(), primaryServer.URL(), nil); err == nil {
+		t.Fatal("expected stream error")
+	}
+}
+
+// Ensure a node is only drained by a client with the server's auth token.
+func TestServer_Drain_Unauthorized(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.AuthToken = "secret"
+	})
+
+	client := http.NewClient()
+	if _, err := client.Drain(context.Background(), server.URL()); err == nil {
+		t.Fatal("expected error")
+	} else if !store.IsPrimary() {
+		t.Fatal("expected node to remain primary")
+	}
+
+	client.AuthToken = "secret"
+	if info, err := client.Drain(context.Background(), server.URL()); err != nil {
+		t.Fatal(err)
+	} else if !info.Draining {
+		t.Fatal("expected draining")
+	}
+}
+
+// Ensure a pinned database can only be written on its pinned node & becomes
+// read-only when another node takes over the lease.
+func TestServer_PinnedPrimary(t *testing.T) {
+	leaser := testingutil.NewLeaser()
+	pinned := map[string]string{"db": "node-a"}
+
+	newNode := func(id string) (*litefs.Store, *http.Server) {
+		store := litefs.NewStore(t.TempDir())
+		store.ID = id
+		store.Client = http.NewClient()
+		store.PinnedPrimaryNodeIDs = pinned
+		server := newOpenServer(t, store)
+		store.Leaser = leaser.Node(server.URL())
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		return store, server
+	}
+
+	primary, primaryServer := newNode("node-a")
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if !primary.IsPrimary() {
+			return fmt.Errorf("not primary")
+		}
+		return nil
+	})
+
+	// Writes succeed on the pinned node while it is primary.
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	other, f, err := primary.CreateDB("other")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, other, 1, 1, 0)
+
+	replica, replicaServer := newNode("node-b")
+	t.Cleanup(func() {
+		if err := replica.Close(); err != nil {
+			t.Fatalf("cannot close store: %s", err)
+		}
+	})
+	waitForSync(t, primary, replica, db.ID())
+	waitForSync(t, primary, replica, other.ID())
+	if _, err := replica.DB(db.ID()).CreateJournal(); err != litefs.ErrReadOnlyReplica {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Stop the pinned node so the other node acquires the lease.
+	primaryServer.Drain()
+	if err := primary.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if !replica.IsPrimary() {
+			return fmt.Errorf("not primary")
+		}
+		return nil
+	})
+
+	// The pinned database is read-only on the new primary.
+	if _, err := replica.DB(db.ID()).CreateJournal(); err != litefs.ErrPinnedPrimary {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := replica.DropDB("db"); err != litefs.ErrPinnedPrimary {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Other databases are still writable on the new primary.
+	testingutil.MustWriteTx(t, replica.DB(other.ID()), 2, 2, 1)
+
+	var infos []http.DBInfo
+	getJSON(t, replicaServer.URL()+"/dbs", &infos)
+	if len(infos) != 2 || infos[0].TXID != 1 || infos[1].TXID != 2 {
+		t.Fatalf("unexpected dbs: %#v", infos)
+	}
+}
+
+// Ensure a replica sharing the primary's node ID is detected & reported, and
+// rejected if configured.
+func TestServer_DuplicateNodeID(t *testing.T) {
+	t.Run("Report", func(t *testing.T) {
+		primary := newOpenStore(t, nil, func(s *litefs.Store) { s.ID = "node-1" })
+		server := newOpenServer(t, primary)
+
+		db, f, err := primary.CreateDB("db")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+		// The replica advertises a different URL so it is a different node.
+		newOpenStore(t, nil, func(s *litefs.Store) {
+			s.ID = "node-1"
+			s.Leaser = &staticLeaser{primaryURL: server.URL(), advertiseURL: "http://replica:20202"}
+
+			client := http.NewClient()
+			client.NodeID = s.ID
+			client.AdvertiseURL = "http://replica:20202"
+			s.Client = client
+		})
+		testingutil.RetryUntil(t, 1*time.Millisecond, 10*time.Second, func() error {
+			var info http.StatusInfo
+			getJSON(t, server.URL()+"/status", &info)
+			if info.NodeIDCollisions == 0 {
+				return fmt.Errorf("expected node id collision")
+			} else if got, want := info.LastNodeIDCollision.PeerURL, "http://replica:20202"; got != want {
+				return fmt.Errorf("PeerURL=%q, want %q", got, want)
+			}
+			return nil
+		})
+	})
+
+	for _, transport := range []string{http.TransportHTTP, http.TransportWebSocket} {
+		t.Run("Reject/"+transport, func(t *testing.T) {
+			primary := newOpenStore(t, nil, func(s *litefs.Store) { s.ID = "node-1" })
+			server := newOpenServer(t, primary, func(s *http.Server) { s.RejectDuplicateNodeID = true })
+
+			client := http.NewClient()
+			client.Transport = transport
+			client.NodeID, client.AdvertiseURL = "node-1", "http://replica:20202"
+			_, err := client.Stream(context.Background(), server.URL(), nil)
+			if err == nil || !strings.Contains(err.Error(), "code=409") {
+				t.Fatalf("unexpected error: %v", err)
+			} else if n, last := primary.NodeIDCollisions(); n != 1 {
+				t.Fatalf("NodeIDCollisions=%d, want 1", n)
+			} else if got, want := last.NodeID, "node-1"; got != want {
+				t.Fatalf("NodeID=%q, want %q", got, want)
+			}
+
+			// A replica with a unique ID can still connect.
+			client.NodeID = "node-2"
+			sr, err := client.Stream(context.Background(), server.URL(), nil)
+			if err != nil {
+				t.Fatal(err)
+			} else if err := sr.Close(); err != nil {
+				t.Fatal(err)
+			} else if n, _ := primary.NodeIDCollisions(); n != 1 {
+				t.Fatalf("NodeIDCollisions=%d, want 1", n)
+			}
+		})
+	}
+}
+
+// Ensure a replica in maintenance mode is not promoted after the primary stops
+// until maintenance mode is disabled.
+func TestServer_Maintenance(t *testing.T) {
+	leaser := testingutil.NewLeaser()
+
+	newNode := func() (*litefs.Store, *http.Server) {
+		store := litefs.NewStore(t.TempDir())
+		store.Client = http.NewClient()
+		server := newOpenServer(t, store)
+		store.Leaser = leaser.Node(server.URL())
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := store.Close(); err != nil {
+				t.Fatalf("cannot close store: %s", err)
+			}
+		})
+		return store, server
+	}
+
+	// setMaintenance toggles maintenance mode through the admin endpoint.
+	setMaintenance := func(server *http.Server, method string) http.StatusInfo {
+		req, err := gohttp.NewRequest(method, server.URL()+"/admin/maintenance", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := gohttp.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var info http.StatusInfo
+		if resp.StatusCode != gohttp.StatusOK {
+			t.Fatalf("unexpected status code: %d", resp.StatusCode)
+		} else if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	primary, primaryServer := newNode()
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if !primary.IsPrimary() {
+			return fmt.Errorf("not primary")
+		}
+		return nil
+	})
+	replica, replicaServer := newNode()
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if got, want := replica.PrimaryURL(), primaryServer.URL(); got != want {
+			return fmt.Errorf("PrimaryURL=%q, want %q", got, want)
+		}
+		return nil
+	})
+
+	if info := setMaintenance(replicaServer, gohttp.MethodPost); !info.Maintenance {
+		t.Fatal("expected maintenance mode")
+	}
+
+	// Stop the primary, which releases the lease & disconnects the replica.
+	primaryServer.Drain()
+	if err := primary.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replica does not acquire the free lease while in maintenance.
+	time.Sleep(3 * time.Second)
+	if replica.IsPrimary() {
+		t.Fatal("expected replica not to be promoted during maintenance")
+	}
+
+	// The replica is promoted once maintenance mode is disabled.
+	if info := setMaintenance(replicaServer, gohttp.MethodDelete); info.Maintenance {
+		t.Fatal("expected maintenance mode to be disabled")
+	}
+	testingutil.RetryUntil(t, 10*time.Millisecond, 10*time.Second, func() error {
+		if !replica.IsPrimary() {
+			return fmt.Errorf("replica not promoted")
+		}
+		return nil
+	})
+}
+
+// Ensure maintenance mode is only changed with the server's auth token.
+func TestServer_Maintenance_Unauthorized(t *testing.T) {
+	store := newOpenStore(t, nil)
+	server := newOpenServer(t, store, func(s *http.Server) {
+		s.AuthToken = "secret"
+	})
+
+	for _, method := range []string{gohttp.MethodPost, gohttp.MethodDelete} {
+		req, err := gohttp.NewRequest(method, server.URL()+"/admin/maintenance", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := gohttp.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := resp.Body.Close(); err != nil {
+			t.Fatal(err)
+		} else if got, want := resp.StatusCode, gohttp.StatusUnauthorized; got != want {
+			t.Fatalf("StatusCode=%d, want %d", got, want)
+		}
+	}
+	if store.Maintenance() {
+		t.Fatal("expected maintenance mode to be disabled")
+	}
+}
+
+// Ensure that, with coordinated elections, the most up-to-date candidate
+// becomes primary even when other candidates have a higher priority.
+func TestElectionCoordinator(t *testing.T) {
+	// The most advanced candidate has the lowest priority.
+	stores := openElectionNodes(t, []electionNode{
+		{txN: 2, priority: 10},
+		{txN: 5, priority: 1},
+		{txN: 3, priority: 5},
+	})
+	waitForElectionWinner(t, stores, 1)
+}
+
+// Ensure that, with coordinated elections, a candidate in the preferred zone
+// wins over higher priority candidates when positions are equal.
+func TestElectionCoordinator_PreferredZone(t *testing.T) {
+	stores := openElectionNodes(t, []electionNode{
+		{txN: 3, priority: 10, zone: "us-east-1a"},
+		{txN: 3, priority: 1, zone: "us-east-1b", preferred: true},
+		{txN: 3, priority: 5, zone: "us-east-1c"},
+	})
+	waitForElectionWinner(t, stores, 1)
+}
+
+// electionNode describes a candidate created by openElectionNodes.
+type electionNode struct {
+	txN       int // transactions written before the node starts
+	priority  int
+	zone      string
+	preferred bool
+}
+
+// openElectionNodes starts a coordinated candidate for each node. Each node
+// has a single database at the position set by its transaction count.
+func openElectionNodes(t *testing.T, nodes []electionNode) []*litefs.Store {
+	t.Helper()
+
+	leaser := testingutil.NewLeaser()
+
+	stores := make([]*litefs.Store, len(nodes))
+	servers := make([]*http.Server, len(nodes))
+	peers := make([]string, len(nodes))
+	for i, node := range nodes {
+		dir := t.TempDir()
+		func() {
+			store := litefs.NewStore(dir)
+			if err := store.Open(); err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			db, f, err := store.CreateDB("db")
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for j := 0; j < node.txN; j++ {
+				testingutil.MustWriteTx(t, db, 1, 1, byte(j))
+			}
+		}()
+
+		stores[i] = litefs.NewStore(dir)
+		stores[i].Client = http.NewClient()
+		servers[i] = http.NewServer(stores[i], "localhost:0")
+		servers[i].Priority = node.priority
+		servers[i].Zone, servers[i].Preferred = node.zone, node.preferred
+		if err := servers[i].Listen(); err != nil {
+			t.Fatal(err)
+		}
+		servers[i].Serve()
+		peers[i] = servers[i].URL()
+	}
+
+	// Attach coordinators once every peer URL is known & start all nodes.
+	for i, store := range stores {
+		coordinator := litefs.NewElectionCoordinator(leaser.Node(peers[i]), store, http.NewClient())
+		coordinator.Peers = peers
+		coordinator.Priority = nodes[i].priority
+		coordinator.Zone, coordinator.Preferred = nodes[i].zone, nodes[i].preferred
+		store.Leaser = coordinator
+		store.AcquireDelay = 200 * time.Millisecond
+	}
+	for i := range stores {
+		store, server := stores[i], servers[i]
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := server.Close(); err != nil {
+				t.Fatal(err)
+			} else if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	return stores
+}
+
+// waitForElectionWinner waits for the store at index want to become primary
+// & fails if any other store becomes primary first.
+func waitForElectionWinner(t *testing.T, stores []*litefs.Store, want int) {
+	t.Helper()
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		for i, store := range stores {
+			if store.IsPrimary() && i != want {
+				t.Fatalf("node %d became primary, expected node %d", i, want)
+			}
+		}
+		if !stores[want].IsPrimary() {
+			return fmt.Errorf("node %d is not primary", want)
+		}
+		return nil
+	})
+}
+
+// Ensure a standby replica syncs but rejects reads & reports as not ready
+// until it is promoted to primary.
+func TestServer_StandbyOnly(t *testing.T) {
+	leaser := testingutil.NewLeaser()
+
+	newNode := func(standby bool) (*litefs.Store, *http.Server) {
+		store := litefs.NewStore(t.TempDir())
+		store.Client = http.NewClient()
+		store.StandbyOnly = standby
+		server := newOpenServer(t, store)
+		store.Leaser = leaser.Node(server.URL())
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := store.Close(); err != nil {
+				t.Fatalf("cannot close store: %s", err)
+			}
+		})
+		return store, server
+	}
+
+	getReady := func(server *http.Server) int {
+		resp, err := gohttp.Get(server.URL() + "/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	primary, primaryServer := newNode(false)
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if !primary.IsPrimary() {
+			return fmt.Errorf("not primary")
+		}
+		return nil
+	})
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+
+	standby, standbyServer := newNode(true)
+	waitForSync(t, primary, standby, db.ID())
+
+	// The standby replicates but does not serve reads.
+	if err := standby.CheckReadable(standby.DB(db.ID())); err != litefs.ErrStandby {
+		t.Fatalf("unexpected error: %v", err)
+	} else if got, want := getReady(standbyServer), gohttp.StatusServiceUnavailable; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	} else if got, want := getReady(primaryServer), gohttp.StatusOK; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+
+	var info http.StatusInfo
+	getJSON(t, standbyServer.URL()+"/status", &info)
+	if info.Ready || !info.Standby {
+		t.Fatalf("unexpected status: %#v", info)
+	}
+
+	// Hand off the lease & ensure the standby is promoted & serves reads.
+	primaryServer.Drain()
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if !standby.IsPrimary() {
+			return fmt.Errorf("standby not promoted")
+		}
+		return nil
+	})
+
+	if err := standby.CheckReadable(standby.DB(db.ID())); err != nil {
+		t.Fatal(err)
+	} else if got, want := getReady(standbyServer), gohttp.StatusOK; got != want {
+		t.Fatalf("StatusCode=%d, want %d", got, want)
+	}
+	testingutil.MustWriteTx(t, standby.DB(db.ID()), 1, 1, 2)
+}
+
+// Ensure typed errors are written with the status code for their error code.
+func TestError_StatusCode(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		status int
+	}{
+		{litefs.ErrDatabaseNotFound, gohttp.StatusNotFound},
+		{litefs.ErrNoPrimary, gohttp.StatusServiceUnavailable},
+		{litefs.ErrPrimaryExists, gohttp.StatusConflict},
+		{litefs.ErrLeaseExpired, gohttp.StatusConflict},
+		{litefs.ErrLagExceeded, gohttp.StatusServiceUnavailable},
+		{litefs.ErrNotPrimary, gohttp.StatusMisdirectedRequest},
+		{fmt.Errorf("wrapped: %w", litefs.ErrNotPrimary), gohttp.StatusMisdirectedRequest},
+		{errors.New("marker"), gohttp.StatusTeapot},
+	} {
+		// Errors are plain text unless the client accepts the JSON format.
+		w := httptest.NewRecorder()
+		http.Error(w, httptest.NewRequest("GET", "/", nil), tt.err, gohttp.StatusTeapot)
+		if got, want := w.Code, tt.status; got != want {
+			t.Fatalf("%s: StatusCode=%d, want %d", tt.err, got, want)
+		} else if got, want := w.Body.String(), tt.err.Error()+"\n"; got != want {
+			t.Fatalf("body=%q, want %q", got, want)
+		} else if got := w.Header().Get(http.ErrorFormatHeader); got != "" {
+			t.Fatalf("unexpected error format: %q", got)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(http.ErrorFormatHeader, http.ErrorFormatJSONV1)
+		w = httptest.NewRecorder()
+		http.Error(w, r, tt.err, gohttp.StatusTeapot)
+		if got, want := w.Code, tt.status; got != want {
+			t.Fatalf("%s: StatusCode=%d, want %d", tt.err, got, want)
+		} else if got, want := w.Header().Get(http.ErrorFormatHeader), http.ErrorFormatJSONV1; got != want {
+			t.Fatalf("error format=%q, want %q", got, want)
+		}
+
+		var body http.ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		} else if got, want := body.Error, tt.err.Error(); got != want {
+			t.Fatalf("Error=%q, want %q", got, want)
+		}
+	}
+}
+
+// Ensure the client returns typed errors sent by the server.
+func TestClient_TypedError(t *testing.T) {
+	for _, want := range []error{
+		litefs.ErrNoPrimary,
+		litefs.ErrPrimaryExists,
+		litefs.ErrLeaseExpired,
+		litefs.ErrLagExceeded,
+		litefs.ErrNotPrimary,
+	} {
+		srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+			http.Error(w, r, want, gohttp.StatusInternalServerError)
+		}))
+
+		_, err := http.NewClient().DBs(context.Background(), srv.URL)
+		srv.Close()
+
+		var e *litefs.Error
+		if !errors.Is(err, want) {
+			t.Fatalf("unexpected error: %v, want %v", err, want)
+		} else if !errors.As(err, &e) {
+			t.Fatalf("expected typed error: %#v", err)
+		} else if got, want := e.Error(), want.Error(); got != want {
+			t.Fatalf("Error()=%q, want %q", got, want)
+		}
+	}
+
+	// Untyped errors only report the status code.
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		http.Error(w, r, errors.New("marker"), gohttp.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	var e *litefs.Error
+	if _, err := http.NewClient().DBs(context.Background(), srv.URL); err == nil || err.Error() != "invalid response: code=500: marker" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if errors.As(err, &e) {
+		t.Fatal("expected untyped error")
+	}
+}
+
+// Ensure a lagging replica reports how far it is behind the primary.
+func TestServer_GetDBs_ReplicaLag(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	replica := newOpenStore(t, &staticLeaser{primaryURL: server.URL()})
+	replicaServer := newOpenServer(t, replica, func(s *http.Server) {
+		s.ReplicaLagInterval = 10 * time.Millisecond
+	})
+	waitForSync(t, primary, replica, db.ID())
+
+	var infos []http.DBInfo
+	getJSON(t, replicaServer.URL()+"/dbs", &infos)
+	if got, want := infos, []http.DBInfo{{ID: 1, Name: "db", TXID: 1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dbs=%#v, want %#v", got, want)
+	}
+
+	// Hold the replica back while the primary moves ahead.
+	replica.PauseReplication()
+	testingutil.MustWriteTx(t, db, 2, 2, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+	time.Sleep(1100 * time.Millisecond)
+
+	getJSON(t, replicaServer.URL()+"/dbs", &infos)
+	if got, want := infos[0].LagTXID, uint64(2); got != want {
+		t.Fatalf("LagTXID=%d, want %d", got, want)
+	} else if got := infos[0].LagSeconds; got < 1 {
+		t.Fatalf("LagSeconds=%f, expected at least 1", got)
+	}
+
+	// Catch up & ensure lag is cleared.
+	replica.ResumeReplication()
+	waitForSync(t, primary, replica, db.ID())
+	infos = nil
+	getJSON(t, replicaServer.URL()+"/dbs", &infos)
+	if infos[0].LagTXID != 0 || infos[0].LagSeconds != 0 {
+		t.Fatalf("unexpected lag: %#v", infos[0])
+	}
+}
+
+// Ensure a replica can be seeded from a copy of the database and then
+// continue streaming incrementally from the primary.
+func TestServer_SeedReplica(t *testing.T) {
+	primary := newOpenStore(t, nil)
+	server := newOpenServer(t, primary)
+
+	db, f, err := primary.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+	testingutil.MustWriteTx(t, db, 2, 2, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+
+	// Copy the database file as if it were transferred out-of-band.
+	buf, err := os.ReadFile(db.DatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "db")
+	if err := os.WriteFile(src, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fetch the position of the copy from the primary's history.
+	info, err := http.NewClient().PosAt(context.Background(), server.URL(), "db", 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := info.Chksum, db.Pos().Chksum; got != want {
+		t.Fatalf("Chksum=%016x, want %016x", got, want)
+	}
+	pos := litefs.Pos{TXID: info.TXID, Chksum: info.Chksum}
+
+	replica := litefs.NewStore(t.TempDir())
+	replica.Client = http.NewClient()
+	replica.Leaser = &staticLeaser{primaryURL: server.URL()}
+
+	// A copy that does not match the primary's history is rejected.
+	if _, err := replica.SeedDB(info.ID, info.Name, src, litefs.Pos{TXID: 2, Chksum: pos.Chksum ^ 1}); !errors.Is(err, litefs.ErrChecksumMismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A position without a checksum, such as within a batch, is rejected.
+	if _, err := replica.SeedDB(info.ID, info.Name, src, litefs.Pos{TXID: 2}); err == nil || !strings.Contains(err.Error(), "no checksum") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A failed seed does not leave a partial database behind.
+	if ents, err := os.ReadDir(replica.Path()); err != nil {
+		t.Fatal(err)
+	} else if len(ents) != 0 {
+		t.Fatalf("unexpected files: %v", ents)
+	}
+
+	if _, err := replica.SeedDB(info.ID, info.Name, src, pos); err != nil {
+		t.Fatal(err)
+	} else if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := replica.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Write another transaction and ensure only it is streamed to the replica.
+	testingutil.MustWriteTx(t, db, 3, 3, 3)
+	waitForSync(t, primary, replica, info.ID)
+	if got, want := replica.DB(info.ID).Pos(), db.Pos(); got != want {
+		t.Fatalf("pos=%#v, want %#v", got, want)
+	}
+
+	ents, err := os.ReadDir(replica.DB(info.ID).LTXDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ent := range ents {
+		names = append(names, ent.Name())
+	}
+	if got, want := names, []string{
+		ltx.FormatFilename(1, 3),
+		ltx.FormatFilename(4, 4),
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ltx files=%v, want %v", got, want)
+	}
+}
+
+// newOpenStore returns a new, opened store. The store is primary if leaser is nil.
+func newOpenStore(tb testing.TB, leaser litefs.Leaser, opts ...func(*litefs.Store)) *litefs.Store {
+	tb.Helper()
+	return newOpenStoreAt(tb, tb.TempDir(), leaser, opts...)
+}
+
+// newOpenStoreAt returns a new, opened store in an existing data directory.
+func newOpenStoreAt(tb testing.TB, dir string, leaser litefs.Leaser, opts ...func(*litefs.Store)) *litefs.Store {
+	tb.Helper()
+
+	store := litefs.NewStore(dir)
+	store.Client = http.NewClient()
+	if leaser != nil {
+		store.Leaser = leaser
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	if err := store.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			tb.Fatalf("cannot close store: %s", err)
+		}
+	})
+	return store
+}
+
+// newOpenServer returns a new, running server attached to store.
+func newOpenServer(tb testing.TB, store *litefs.Store, opts ...func(*http.Server)) *http.Server {
+	tb.Helper()
+
+	server := http.NewServer(store, "localhost:0")
+	for _, opt := range opts {
+		opt(server)
+	}
+	if err := server.Listen(); err != nil {
+		tb.Fatal(err)
+	}
+	server.Serve()
+	tb.Cleanup(func() {
+		if err := server.Close(); err != nil {
+			tb.Fatalf("cannot close server: %s", err)
+		}
+	})
+	return server
+}
+
+// waitForSync waits until the replica database matches the primary's position.
+func waitForSync(tb testing.TB, primary, replica *litefs.Store, dbID uint32) {
+	tb.Helper()
+	testingutil.RetryUntil(tb, 1*time.Millisecond, 10*time.Second, func() error {
+		db := replica.DB(dbID)
+		if db == nil {
+			return fmt.Errorf("no database on replica")
+		} else if got, want := db.TXID(), primary.DB(dbID).TXID(); got != want {
+			return fmt.Errorf("waiting for sync: [%d,%d]", got, want)
+		}
+		return nil
+	})
+}
+
+// getJSON fetches rawurl and decodes the JSON response body into v.
+func getJSON(tb testing.TB, rawurl string, v interface{}) {
+	tb.Helper()
+
+	resp, err := gohttp.Get(rawurl)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != gohttp.StatusOK {
+		tb.Fatalf("unexpected status code: %d", resp.StatusCode)
+	} else if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// postJSON sends an empty POST to rawurl and decodes the JSON response body into v.
+func postJSON(tb testing.TB, rawurl string, v interface{}) {
+	tb.Helper()
+
+	resp, err := gohttp.Post(rawurl, "", nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != gohttp.StatusOK {
+		tb.Fatalf("unexpected status code: %d", resp.StatusCode)
+	} else if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// logBuffer is a buffer that is safe to capture log output into while other
+// goroutines are logging.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// staticLeaser is a leaser that always reports the same primary.
+type staticLeaser struct {
+	primaryURL   string
+	advertiseURL string
+}
+
+func (l *staticLeaser) Close() error         { return nil }
+func (l *staticLeaser) Type() string         { return "static" }
+func (l *staticLeaser) AdvertiseURL() string { return l.advertiseURL }
+
+func (l *staticLeaser) Acquire(ctx context.Context) (litefs.Lease, error) {
+	return nil, litefs.ErrPrimaryExists
+}
+
+func (l *staticLeaser) PrimaryURL(ctx context.Context) (string, error) {
+	return l.primaryURL, nil
+}
+
+// blockingLeaser is a leaser that always grants a lease whose renewals block
+// until the store shuts down.
+type blockingLeaser struct {
+	ttl time.Duration
+}
+
+func (l *blockingLeaser) Close() error         { return nil }
+func (l *blockingLeaser) AdvertiseURL() string { return "" }
+
+func (l *blockingLeaser) Acquire(ctx context.Context) (litefs.Lease, error) {
+	return &blockingLease{ttl: l.ttl, renewedAt: time.Now()}, nil
+}
+
+func (l *blockingLeaser) PrimaryURL(ctx context.Context) (string, error) {
+	return "", litefs.ErrNoPrimary
+}
+
+type blockingLease struct {
+	ttl       time.Duration
+	renewedAt time.Time
+}
+
+func (l *blockingLease) RenewedAt() time.Time { return l.renewedAt }
+func (l *blockingLease) TTL() time.Duration   { return l.ttl }
+func (l *blockingLease) Close() error         { return nil }
+
+func (l *blockingLease) Renew(ctx context.Context) error {
+	<-ctx.Done()
+	return litefs.ErrLeaseExpired
+}
@@ -0,0 +1,454 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocket opcodes. Only binary messages are used by the stream transport.
+// See: https://datatracker.ietf.org/doc/html/rfc6455#section-5.2
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsGUID is the magic value appended to the client key during the handshake.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxControlPayloadSize is the maximum size of a control frame payload.
+const wsMaxControlPayloadSize = 125
+
+var errWebSocketProtocol = errors.New("websocket protocol error")
+
+// wsConn is a minimal WebSocket connection that only supports binary messages.
+// Reads expose the concatenated payloads of all data frames as a byte stream.
+type wsConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isClient bool
+
+	wmu sync.Mutex // write lock
+
+	remaining uint64  // bytes remaining in current data frame
+	mask      [4]byte // mask key for current data frame
+	masked    bool    // true if current data frame is masked
+	maskPos   int     // position within mask key
+}
+
+func newWSConn(conn net.Conn, br *bufio.Reader, isClient bool) *wsConn {
+	return &wsConn{conn: conn, br: br, isClient: isClient}
+}
+
+// Read reads payload bytes from the current data frame. Control frames are
+// handled transparently. Returns io.EOF once the peer closes the connection.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextDataFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.remaining -= uint64(n)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextDataFrame reads frame headers until a data frame is found.
+func (c *wsConn) nextDataFrame() error {
+	for {
+		opcode, n, err := c.readFrameHeader()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case wsOpContinuation, wsOpBinary:
+			c.remaining = n
+			return nil
+
+		case wsOpPing, wsOpPong, wsOpClose:
+			if n > wsMaxControlPayloadSize {
+				return errWebSocketProtocol
+			}
+			payload := make([]byte, n)
+			if _, err := io.ReadFull(c.br, payload); err != nil {
+				return err
+			}
+			for i := range payload {
+				payload[i] ^= c.mask[i%4]
+			}
+
+			switch opcode {
+			case wsOpPing:
+				if err := c.writeFrame(wsOpPong, payload); err != nil {
+					return err
+				}
+			case wsOpClose:
+				_ = c.writeFrame(wsOpClose, nil)
+				return io.EOF
+			}
+
+		default:
+			return fmt.Errorf("unsupported websocket opcode: 0x%x", opcode)
+		}
+	}
+}
+
+// readFrameHeader reads the next frame header and prepares the mask state.
+func (c *wsConn) readFrameHeader() (opcode byte, n uint64, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return 0, 0, err
+	}
+	opcode = hdr[0] & 0x0F
+	c.masked = hdr[1]&0x80 != 0
+
+	// Servers must not mask frames and clients must always mask frames.
+	if c.masked == c.isClient {
+		return 0, 0, errWebSocketProtocol
+	}
+
+	switch n = uint64(hdr[1] & 0x7F); n {
+	case 126:
+		var v uint16
+		if err := binary.Read(c.br, binary.BigEndian, &v); err != nil {
+			return 0, 0, err
+		}
+		n = uint64(v)
+	case 127:
+		if err := binary.Read(c.br, binary.BigEndian, &n); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	c.mask, c.maskPos = [4]byte{}, 0
+	if c.masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return opcode, n, nil
+}
+
+// Write writes p as a single binary message.
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame writes a single, final frame with the given opcode & payload.
+// Client frames are masked as required by the protocol.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|opcode)
+
+	var maskBit byte
+	if c.isClient {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(n))
+	default:
+		buf = append(buf, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(n))
+	}
+
+	if !c.isClient {
+		buf = append(buf, payload...)
+	} else {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		for i, b := range payload {
+			buf = append(buf, b^mask[i%4])
+		}
+	}
+
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *wsConn) Close() error {
+	_ = c.writeFrame(wsOpClose, nil)
+
+	// The connection may already be closed if the stream's context is done.
+	if err := c.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// upgradeWebSocket performs the server side of the WebSocket handshake and
+// returns the hijacked connection. Additional response headers may be passed
+// in via hdr.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, hdr http.Header) (*wsConn, error) {
+	if r.Method != http.MethodGet {
+		return nil, fmt.Errorf("websocket: method must be GET")
+	} else if !headerContainsToken(r.Header, "Connection", "upgrade") {
+		return nil, fmt.Errorf("websocket: missing connection upgrade header")
+	} else if !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("websocket: missing upgrade header")
+	} else if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return nil, fmt.Errorf("websocket: unsupported version")
+	}
+
+	key := r.Header.Get("Sec-Websocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("websocket: key required")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("websocket: response does not support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusSwitchingProtocols,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     hdr.Clone(),
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set("Upgrade", "websocket")
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Sec-WebSocket-Accept", wsAcceptKey(key))
+
+	if err := resp.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newWSConn(conn, brw.Reader, false), nil
+}
+
+// dialWebSocket performs the client side of the WebSocket handshake against u
+// and returns the connection & the handshake response headers. The URL scheme
+// may be "http" or "https" and is mapped to "ws" or "wss". The connection is
+// dialed with the dialer, proxy & TLS settings of transport, if set, so that
+// it matches other requests from the client. The header is sent with the
+// handshake request.
+func dialWebSocket(ctx context.Context, transport *http.Transport, u *url.URL, header http.Header) (*wsConn, http.Header, error) {
+	if transport == nil {
+		transport = &http.Transport{}
+	}
+
+	conn, err := dialWebSocketConn(ctx, transport, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Close the connection if the context is canceled during the handshake
+	// or while streaming.
+	rawConn := conn
+	go func() {
+		<-ctx.Done()
+		rawConn.Close()
+	}()
+
+	if u.Scheme == "https" {
+		config := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			config = transport.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		config.NextProtos = []string{"http/1.1"} // upgrade requires HTTP/1.1
+
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("websocket: tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	c, hdr, err := handshakeWebSocket(conn, u, header)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return c, hdr, nil
+}
+
+// dialWebSocketConn opens a TCP connection to the host of u. If transport has
+// a proxy for u then the connection is tunneled through it with CONNECT.
+func dialWebSocketConn(ctx context.Context, transport *http.Transport, u *url.URL) (net.Conn, error) {
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	var proxyURL *url.URL
+	if transport.Proxy != nil {
+		var err error
+		if proxyURL, err = transport.Proxy(&http.Request{URL: u, Header: make(http.Header)}); err != nil {
+			return nil, fmt.Errorf("websocket: proxy: %w", err)
+		}
+	}
+	if proxyURL == nil {
+		return dial(ctx, "tcp", wsHostPort(u))
+	} else if proxyURL.Scheme != "http" {
+		return nil, fmt.Errorf("websocket: unsupported proxy scheme: %q", proxyURL.Scheme)
+	}
+
+	conn, err := dial(ctx, "tcp", wsHostPort(proxyURL))
+	if err != nil {
+		return nil, err
+	}
+
+	// Abort the tunnel request if the context is canceled.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: wsHostPort(u)},
+		Host:   wsHostPort(u),
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: write proxy connect: %w", err)
+	}
+
+	// The server does not send anything until the client starts its handshake
+	// so no data is lost when the buffered reader is discarded.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: read proxy connect: %w", err)
+	} else if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: proxy connect: code=%d", resp.StatusCode)
+	}
+	return conn, nil
+}
+
+// wsHostPort returns the host & port of u, using the default port for its
+// scheme if none is set.
+func wsHostPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return u.Host
+	} else if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func handshakeWebSocket(conn net.Conn, u *url.URL, header http.Header) (*wsConn, http.Header, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Host:       u.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if err := req.Write(conn); err != nil {
+		return nil, nil, fmt.Errorf("websocket: write handshake: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("websocket: read handshake: %w", err)
+	} else if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		return nil, nil, readError(resp)
+	} else if resp.Header.Get("Sec-Websocket-Accept") != wsAcceptKey(key) {
+		return nil, nil, fmt.Errorf("websocket: invalid accept key")
+	}
+	return newWSConn(conn, br, true), resp.Header, nil
+}
+
+// wsAcceptKey computes the Sec-WebSocket-Accept value for a client key.
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContainsToken returns true if a comma-separated header contains token.
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"os"
+	"syscall"
 )
 
 // Sync performs an fsync on the given path. Typically used for directories.
@@ -18,6 +19,36 @@ func Sync(path string) error {
 	return f.Close()
 }
 
+// FreeSpace returns the number of bytes available to unprivileged users on
+// the file system containing path.
+func FreeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// FileDescriptors returns the number of file descriptors open in the process
+// & the soft limit on open file descriptors.
+func FileDescriptors() (open, limit uint64, err error) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, 0, err
+	}
+
+	ents, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Exclude the descriptor used to read the directory.
+	if open = uint64(len(ents)); open > 0 {
+		open--
+	}
+	return open, uint64(rlimit.Cur), nil
+}
+
 func assert(condition bool, msg string) {
 	if !condition {
 		panic("assertion failed: " + msg)
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxRateLimitedLoggerEntries is the number of tracked messages before expired
+// entries are removed.
+const maxRateLimitedLoggerEntries = 100
+
+// RateLimitedLogger collapses repeated identical log messages. The first
+// occurrence of a message is written immediately. Further occurrences within
+// the interval are counted and a summary of the count is written once the
+// interval has elapsed, even if the message does not occur again.
+type RateLimitedLogger struct {
+	mu       sync.Mutex
+	logger   *log.Logger
+	interval time.Duration
+	entries  map[string]*rateLimitedLoggerEntry
+
+	// Returns the current time. Can be overridden for testing.
+	Now func() time.Time
+}
+
+type rateLimitedLoggerEntry struct {
+	loggedAt time.Time   // time message was last written
+	n        int         // occurrences suppressed since loggedAt
+	timer    *time.Timer // writes the summary once the interval elapses
+}
+
+// NewRateLimitedLogger returns a new instance of RateLimitedLogger that writes
+// to logger. If logger is nil, the standard logger is used.
+func NewRateLimitedLogger(logger *log.Logger, interval time.Duration) *RateLimitedLogger {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &RateLimitedLogger{
+		logger:   logger,
+		interval: interval,
+		entries:  make(map[string]*rateLimitedLoggerEntry),
+		Now:      time.Now,
+	}
+}
+
+// Printf writes a formatted message unless the same message was already
+// written within the interval.
+func (l *RateLimitedLogger) Printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Now()
+
+	// Suppress message if it has been written recently. The summary is
+	// written when the interval elapses if the message does not recur.
+	e := l.entries[msg]
+	if e != nil && now.Sub(e.loggedAt) < l.interval {
+		if e.n++; e.timer == nil {
+			e.timer = time.AfterFunc(e.loggedAt.Add(l.interval).Sub(now), func() { l.flush(msg) })
+		}
+		return
+	}
+
+	// Summarize suppressed messages since the message was last written.
+	line := msg
+	if e != nil && e.n > 0 {
+		line = fmt.Sprintf("%s (%d occurrences in the last %s)", msg, e.n+1, now.Sub(e.loggedAt).Truncate(time.Second))
+	}
+	_ = l.logger.Output(2, line)
+
+	if e == nil {
+		l.removeExpiredEntries(now)
+		e = &rateLimitedLoggerEntry{}
+		l.entries[msg] = e
+	}
+	e.reset(now)
+}
+
+// flush writes a summary of the occurrences of msg suppressed since it was
+// last written.
+func (l *RateLimitedLogger) flush(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Ignore timers from a previous interval that fired as they were stopped.
+	now := l.Now()
+	e := l.entries[msg]
+	if e == nil || e.n == 0 || now.Sub(e.loggedAt) < l.interval {
+		return
+	}
+
+	_ = l.logger.Output(2, fmt.Sprintf("%s (%d occurrences in the last %s)", msg, e.n, now.Sub(e.loggedAt).Truncate(time.Second)))
+	e.reset(now)
+}
+
+// reset marks the entry as written at now & cancels any pending summary.
+func (e *rateLimitedLoggerEntry) reset(now time.Time) {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	e.loggedAt, e.n = now, 0
+}
+
+// removeExpiredEntries removes messages that have not occurred within the
+// interval once the number of tracked messages grows too large.
+func (l *RateLimitedLogger) removeExpiredEntries(now time.Time) {
+	if len(l.entries) < maxRateLimitedLoggerEntries {
+		return
+	}
+	for msg, e := range l.entries {
+		if e.n == 0 && now.Sub(e.loggedAt) >= l.interval {
+			delete(l.entries, msg)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package internal_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs/internal"
+)
+
+// Ensure repeated messages are collapsed into periodic summaries.
+func TestRateLimitedLogger_Printf(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	l := internal.NewRateLimitedLogger(log.New(&buf, "", 0), 10*time.Second)
+	l.Now = func() time.Time { return now }
+
+	// Only the first message in a tight loop should be written.
+	for i := 0; i < 1000; i++ {
+		l.Printf("cannot connect: %s", "timeout")
+	}
+	if got, want := buf.String(), "cannot connect: timeout\n"; got != want {
+		t.Fatalf("output=%q, want %q", got, want)
+	}
+
+	// Different messages are not suppressed.
+	buf.Reset()
+	l.Printf("other error")
+	if got, want := buf.String(), "other error\n"; got != want {
+		t.Fatalf("output=%q, want %q", got, want)
+	}
+
+	// Suppressed messages are summarized once the interval elapses.
+	buf.Reset()
+	for i := 0; i < 100; i++ {
+		now = now.Add(100 * time.Millisecond)
+		l.Printf("cannot connect: %s", "timeout")
+	}
+	if got, want := strings.Split(strings.TrimSpace(buf.String()), "\n"), []string{
+		"cannot connect: timeout (1099 occurrences in the last 10s)",
+	}; len(got) != len(want) || got[0] != want[0] {
+		t.Fatalf("output=%q, want %q", got, want)
+	}
+}
+
+// Ensure suppressed messages are summarized once the interval elapses even if
+// the message does not occur again.
+func TestRateLimitedLogger_Flush(t *testing.T) {
+	var buf lockedBuffer
+	l := internal.NewRateLimitedLogger(log.New(&buf, "", 0), 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		l.Printf("cannot connect")
+	}
+	if got, want := buf.String(), "cannot connect\n"; got != want {
+		t.Fatalf("output=%q, want %q", got, want)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if got, want := buf.String(), "cannot connect\ncannot connect (2 occurrences in the last 0s)\n"; got != want {
+		t.Fatalf("output=%q, want %q", got, want)
+	}
+}
+
+// lockedBuffer is a buffer that is safe to write from other goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,151 @@
+package testingutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/superfly/litefs"
+)
+
+// DefaultLeaseTTL is the default TTL for leases acquired from Leaser.
+const DefaultLeaseTTL = 10 * time.Second
+
+// Leaser is an in-memory lease shared by multiple nodes within a single
+// process. It allows multi-node tests to run without an external Consul
+// server. Each node obtains its own litefs.Leaser by calling Node().
+type Leaser struct {
+	mu          sync.Mutex
+	nextLeaseID uint64
+	leaseID     uint64 // ID of the current lease, if any
+	primaryURL  string // advertise URL of the current lease holder
+	expiresAt   time.Time
+
+	// Time-to-live for acquired leases.
+	TTL time.Duration
+
+	// Returns the current time. May be replaced to test lease expiration.
+	Now func() time.Time
+}
+
+// NewLeaser returns a new instance of Leaser.
+func NewLeaser() *Leaser {
+	return &Leaser{
+		TTL: DefaultLeaseTTL,
+		Now: time.Now,
+	}
+}
+
+// Node returns a leaser for a single node that advertises advertiseURL when
+// it holds the lease.
+func (l *Leaser) Node(advertiseURL string) *LeaserNode {
+	return &LeaserNode{leaser: l, advertiseURL: advertiseURL}
+}
+
+// isActive returns true if a lease is held & has not expired. Lock must be held.
+func (l *Leaser) isActive() bool {
+	return l.leaseID != 0 && l.Now().Before(l.expiresAt)
+}
+
+var _ litefs.Leaser = (*LeaserNode)(nil)
+
+// LeaserNode represents a single node's view of a shared Leaser.
+type LeaserNode struct {
+	leaser       *Leaser
+	advertiseURL string
+}
+
+// Close is a no-op.
+func (n *LeaserNode) Close() error { return nil }
+
+// Type returns the name of the leaser backend.
+func (n *LeaserNode) Type() string { return "memory" }
+
+// AdvertiseURL returns the URL advertised by the node when it holds the lease.
+func (n *LeaserNode) AdvertiseURL() string { return n.advertiseURL }
+
+// Acquire obtains the lease if no other node holds an unexpired lease.
+// Returns litefs.ErrPrimaryExists if another node holds the lease.
+func (n *LeaserNode) Acquire(ctx context.Context) (litefs.Lease, error) {
+	l := n.leaser
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.isActive() {
+		return nil, litefs.ErrPrimaryExists
+	}
+
+	now := l.Now()
+	l.nextLeaseID++
+	l.leaseID = l.nextLeaseID
+	l.primaryURL = n.advertiseURL
+	l.expiresAt = now.Add(l.TTL)
+
+	return &Lease{leaser: l, id: l.leaseID, ttl: l.TTL, renewedAt: now}, nil
+}
+
+// PrimaryURL returns the advertise URL of the current lease holder.
+// Returns litefs.ErrNoPrimary if the lease is not held or has expired.
+func (n *LeaserNode) PrimaryURL(ctx context.Context) (string, error) {
+	l := n.leaser
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.isActive() {
+		return "", litefs.ErrNoPrimary
+	}
+	return l.primaryURL, nil
+}
+
+var _ litefs.Lease = (*Lease)(nil)
+
+// Lease represents a lease acquired from a Leaser.
+type Lease struct {
+	leaser *Leaser
+	id     uint64
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	renewedAt time.Time
+}
+
+// RenewedAt returns the time that the lease was acquired or last renewed.
+func (l *Lease) RenewedAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.renewedAt
+}
+
+// TTL returns the time-to-live of the lease.
+func (l *Lease) TTL() time.Duration { return l.ttl }
+
+// Renew extends the lease by its TTL. Returns litefs.ErrLeaseExpired if the
+// lease has expired or has been acquired by another node.
+func (l *Lease) Renew(ctx context.Context) error {
+	l.leaser.mu.Lock()
+	defer l.leaser.mu.Unlock()
+
+	if l.leaser.leaseID != l.id || !l.leaser.isActive() {
+		return litefs.ErrLeaseExpired
+	}
+
+	now := l.leaser.Now()
+	l.leaser.expiresAt = now.Add(l.ttl)
+
+	l.mu.Lock()
+	l.renewedAt = now
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Close releases the lease so that another node can acquire it immediately.
+func (l *Lease) Close() error {
+	l.leaser.mu.Lock()
+	defer l.leaser.mu.Unlock()
+
+	if l.leaser.leaseID == l.id {
+		l.leaser.leaseID, l.leaser.primaryURL, l.leaser.expiresAt = 0, "", time.Time{}
+	}
+	return nil
+}
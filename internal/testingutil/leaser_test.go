@@ -0,0 +1,113 @@
+package testingutil_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/internal/testingutil"
+)
+
+func TestLeaser(t *testing.T) {
+	t.Run("Acquire", func(t *testing.T) {
+		leaser := testingutil.NewLeaser()
+		n0, n1 := leaser.Node("http://n0"), leaser.Node("http://n1")
+
+		if _, err := n0.PrimaryURL(context.Background()); err != litefs.ErrNoPrimary {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := n0.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if _, err := n1.Acquire(context.Background()); err != litefs.ErrPrimaryExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if primaryURL, err := n1.PrimaryURL(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := primaryURL, "http://n0"; got != want {
+			t.Fatalf("PrimaryURL=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("Renew", func(t *testing.T) {
+		now := time.Unix(1000, 0)
+		leaser := testingutil.NewLeaser()
+		leaser.Now = func() time.Time { return now }
+		n0, n1 := leaser.Node("http://n0"), leaser.Node("http://n1")
+
+		lease, err := n0.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Renew just before expiration & ensure the lease is extended.
+		now = now.Add(leaser.TTL - time.Second)
+		if err := lease.Renew(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := lease.RenewedAt(), now; !got.Equal(want) {
+			t.Fatalf("RenewedAt=%s, want %s", got, want)
+		}
+
+		now = now.Add(leaser.TTL - time.Second)
+		if _, err := n1.Acquire(context.Background()); err != litefs.ErrPrimaryExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		now := time.Unix(1000, 0)
+		leaser := testingutil.NewLeaser()
+		leaser.Now = func() time.Time { return now }
+		n0, n1 := leaser.Node("http://n0"), leaser.Node("http://n1")
+
+		lease0, err := n0.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Let the lease expire & ensure it can no longer be renewed.
+		now = now.Add(leaser.TTL)
+		if _, err := n1.PrimaryURL(context.Background()); err != litefs.ErrNoPrimary {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := lease0.Renew(context.Background()); err != litefs.ErrLeaseExpired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Another node can now acquire it & the old lease stays expired.
+		if _, err := n1.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if err := lease0.Renew(context.Background()); err != litefs.ErrLeaseExpired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Handoff", func(t *testing.T) {
+		leaser := testingutil.NewLeaser()
+		n0, n1 := leaser.Node("http://n0"), leaser.Node("http://n1")
+
+		lease0, err := n0.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if err := lease0.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		lease1, err := n1.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if primaryURL, err := n0.PrimaryURL(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := primaryURL, "http://n1"; got != want {
+			t.Fatalf("PrimaryURL=%q, want %q", got, want)
+		}
+
+		// Closing the old lease must not release the new holder's lease.
+		if err := lease0.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := lease1.Renew(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
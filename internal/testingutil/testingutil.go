@@ -2,6 +2,7 @@ package testingutil
 
 import (
 	"database/sql"
+	"encoding/binary"
 	"io"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/superfly/litefs"
 )
 
 // OpenSQLDB opens a connection to a SQLite database.
@@ -110,3 +112,136 @@ func MustCopyDir(tb testing.TB, src, dst string) {
 		}
 	}
 }
+
+// MustWriteTx writes a single-page transaction to db using the same sequence
+// of calls as the FUSE layer: a database write followed by a journal commit.
+// The page is filled with the given byte value. Page 1 contains a valid SQLite
+// header so that the database size is tracked correctly. Existing pages are
+// copied to the journal first so the database checksum is maintained.
+func MustWriteTx(tb testing.TB, db *litefs.DB, pgno uint32, commit uint32, value byte) {
+	tb.Helper()
+	MustWriteHotTx(tb, db, pgno, commit, value)
+	if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// MustWriteTxPages writes a transaction like MustWriteTx that updates every
+// page in pgnos.
+func MustWriteTxPages(tb testing.TB, db *litefs.DB, pgnos []uint32, commit uint32, value byte) {
+	tb.Helper()
+	mustWriteHotTxPages(tb, db, pgnos, commit, value)
+	if err := db.CommitJournal(litefs.JournalModeDelete); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// MustWriteHotTx writes a transaction like MustWriteTx but does not commit
+// it. This leaves a hot journal behind as if the process stopped mid-write.
+func MustWriteHotTx(tb testing.TB, db *litefs.DB, pgno uint32, commit uint32, value byte) {
+	tb.Helper()
+	mustWriteHotTxPages(tb, db, []uint32{pgno}, commit, value)
+}
+
+func mustWriteHotTxPages(tb testing.TB, db *litefs.DB, pgnos []uint32, commit uint32, value byte) {
+	tb.Helper()
+
+	const pageSize = 4096
+	const sectorSize = 512
+
+	f, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	// Copy original pages into journal before they are overwritten.
+	hasHeader := false
+	journalPgnos := []uint32{1}
+	for _, pgno := range pgnos {
+		if pgno == 1 {
+			hasHeader = true
+		} else {
+			journalPgnos = append(journalPgnos, pgno)
+		}
+	}
+
+	var records []byte
+	var pageN uint32
+	for _, n := range journalPgnos {
+		if int64(n)*pageSize > fi.Size() {
+			continue
+		}
+		record := make([]byte, 4+pageSize+4) // pgno, data, checksum
+		binary.BigEndian.PutUint32(record[0:], n)
+		if _, err := f.ReadAt(record[4:4+pageSize], int64(n-1)*pageSize); err != nil {
+			tb.Fatal(err)
+		}
+		binary.BigEndian.PutUint32(record[4+pageSize:], journalChecksum(record[4:4+pageSize]))
+		records = append(records, record...)
+		pageN++
+	}
+
+	jf, err := db.CreateJournal()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	jhdr := make([]byte, sectorSize)
+	copy(jhdr, litefs.SQLITE_JOURNAL_HEADER_STRING)
+	binary.BigEndian.PutUint32(jhdr[8:], pageN)
+	binary.BigEndian.PutUint32(jhdr[16:], uint32(fi.Size()/pageSize))
+	binary.BigEndian.PutUint32(jhdr[20:], sectorSize)
+	binary.BigEndian.PutUint32(jhdr[24:], pageSize)
+	if _, err := jf.Write(append(jhdr, records...)); err != nil {
+		tb.Fatal(err)
+	} else if err := jf.Close(); err != nil {
+		tb.Fatal(err)
+	}
+
+	// Write the header page first if it is not one of the pages being updated.
+	if !hasHeader {
+		page := make([]byte, pageSize)
+		if _, err := f.ReadAt(page, 0); err != nil && err != io.EOF {
+			tb.Fatal(err)
+		}
+		writeDatabaseHeader(page, commit)
+		if err := db.WriteDatabase(f, page, 0); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	for _, pgno := range pgnos {
+		page := make([]byte, pageSize)
+		for i := range page {
+			page[i] = value
+		}
+		if pgno == 1 {
+			writeDatabaseHeader(page, commit)
+		}
+		if err := db.WriteDatabase(f, page, int64(pgno-1)*pageSize); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// journalChecksum returns the SQLite journal frame checksum of a page using
+// a zero nonce.
+func journalChecksum(data []byte) (chksum uint32) {
+	for i := len(data) - 200; i > 0; i -= 200 {
+		chksum += uint32(data[i])
+	}
+	return chksum
+}
+
+// writeDatabaseHeader writes the fields of the SQLite header used by LiteFS.
+func writeDatabaseHeader(page []byte, commit uint32) {
+	copy(page, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(page[16:], 4096) // page size
+	page[18], page[19] = 1, 1                   // rollback journal
+	binary.BigEndian.PutUint32(page[litefs.SQLITE_DATABASE_SIZE_OFFSET:], commit)
+}
@@ -2,28 +2,94 @@ package litefs
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"time"
 )
 
 // LiteFS errors
 var (
-	ErrDatabaseNotFound = fmt.Errorf("database not found")
+	ErrDatabaseNotFound = &Error{Code: ECodeDatabaseNotFound, Message: "database not found"}
 	ErrDatabaseExists   = fmt.Errorf("database already exists")
 
-	ErrNoPrimary     = errors.New("no primary")
-	ErrPrimaryExists = errors.New("primary exists")
-	ErrLeaseExpired  = errors.New("lease expired")
+	ErrNoPrimary     = &Error{Code: ECodeNoPrimary, Message: "no primary"}
+	ErrPrimaryExists = &Error{Code: ECodePrimaryExists, Message: "primary exists"}
+	ErrLeaseExpired  = &Error{Code: ECodeLeaseExpired, Message: "lease expired"}
+	ErrLagExceeded   = &Error{Code: ECodeLagExceeded, Message: "replication lag exceeded"}
+	ErrNotPrimary    = &Error{Code: ECodeNotPrimary, Message: "not primary"}
+
+	ErrReadOnlyReplica  = fmt.Errorf("cannot write: node is a read-only replica")
+	ErrNoSpace          = errors.New("insufficient free space")
+	ErrDatabaseTooLarge = errors.New("database size limit exceeded")
+	ErrCrossDBTx        = errors.New("cross-database transactions are not supported")
+	ErrDatabaseFrozen   = errors.New("database frozen")
+	ErrTXIDExhausted    = errors.New("transaction id exhausted")
+	ErrSyncing          = errors.New("replica syncing")
+	ErrStandby          = errors.New("node is a standby")
+	ErrRecoveryTimeout  = errors.New("recovery timeout")
+	ErrNotReplica       = errors.New("node is not a replica")
+	ErrShuttingDown     = errors.New("node is shutting down")
+	ErrStaleHandle      = errors.New("file handle invalidated by role change")
+	ErrPinnedPrimary    = errors.New("cannot write: database is pinned to another node")
+	ErrDuplicateNodeID  = errors.New("duplicate node id")
+
+	ErrPageSizeMismatch = errors.New("page size mismatch")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrStateMismatch    = errors.New("state mismatch")
+	ErrSegmentGap       = errors.New("ltx segment gap")
+
+	ErrPageNotFound   = errors.New("page not found")
+	ErrDatabaseLocked = errors.New("database locked")
+)
 
-	ErrReadOnlyReplica = fmt.Errorf("read only replica")
+// Error codes for typed errors. These are stable & are sent over the HTTP API.
+const (
+	ECodeDatabaseNotFound = "database_not_found"
+	ECodeNoPrimary        = "no_primary"
+	ECodePrimaryExists    = "primary_exists"
+	ECodeLeaseExpired     = "lease_expired"
+	ECodeLagExceeded      = "lag_exceeded"
+	ECodeNotPrimary       = "not_primary"
 )
 
+// Error represents an error with a machine-readable code. Errors match by
+// code with errors.Is() so an error decoded from an HTTP response matches
+// the corresponding sentinel error, such as ErrNoPrimary.
+type Error struct {
+	Code    string
+	Message string
+}
+
+// Error returns the error message.
+func (e *Error) Error() string { return e.Message }
+
+// Is returns true if target is an *Error with the same code.
+func (e *Error) Is(target error) bool {
+	other, ok := target.(*Error)
+	return ok && other.Code == e.Code
+}
+
 const PageSize = 4096
 
+// MaxTXID is the highest transaction ID a database can reach. Once reached,
+// new write transactions fail with ErrTXIDExhausted instead of wrapping to
+// zero, which would break the ordering of LTX files & replica positions.
+const MaxTXID = math.MaxUint64
+
+// DefaultTXIDWarnThreshold is the default transaction ID after which a
+// warning is logged that the database is approaching MaxTXID. This leaves
+// 2^32 transactions before the maximum is reached.
+const DefaultTXIDWarnThreshold = 0xFFFFFFFF00000000
+
+// ErrorLogInterval is the interval that repeated identical errors in
+// background loops are collapsed into a single log line.
+const ErrorLogInterval = 10 * time.Second
+
 // SQLite constants
 const (
 	WALHeaderSize      = 32
@@ -68,6 +134,119 @@ const (
 	JournalModeWAL      = "WAL"
 )
 
+// FsyncPolicy determines how often a replica fsyncs the database file after
+// applying transactions from the primary.
+type FsyncPolicy string
+
+const (
+	// Fsync after every applied transaction.
+	FsyncPolicyAlways = FsyncPolicy("always")
+
+	// Fsync at most once per interval. Transactions applied in between are
+	// replayed from their LTX files on recovery.
+	FsyncPolicyInterval = FsyncPolicy("interval")
+
+	// Never fsync explicitly & let the operating system flush pages.
+	FsyncPolicyOS = FsyncPolicy("os")
+)
+
+// DefaultReplicaFsyncInterval is the default interval for FsyncPolicyInterval.
+const DefaultReplicaFsyncInterval = 1 * time.Second
+
+// IsValid returns true if p is a valid fsync policy.
+func (p FsyncPolicy) IsValid() bool {
+	switch p {
+	case FsyncPolicyAlways, FsyncPolicyInterval, FsyncPolicyOS:
+		return true
+	default:
+		return false
+	}
+}
+
+// ApplyErrorPolicy determines how a replica recovers after it fails to apply
+// a transaction received from the primary.
+type ApplyErrorPolicy string
+
+const (
+	// Disconnect from the primary & retry the transaction after reconnecting.
+	ApplyErrorPolicyRetry = ApplyErrorPolicy("retry")
+
+	// Discard the local state of the database & restore it from the primary.
+	ApplyErrorPolicyResync = ApplyErrorPolicy("resync")
+)
+
+// IsValid returns true if p is a valid apply error policy.
+func (p ApplyErrorPolicy) IsValid() bool {
+	switch p {
+	case ApplyErrorPolicyRetry, ApplyErrorPolicyResync:
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultWriteBufferMaxSize is the default number of transactions per database
+// that the primary may commit without an fsync while the write buffer is enabled.
+const DefaultWriteBufferMaxSize = 1000
+
+// DefaultGroupCommitMaxSize is the default number of transactions per database
+// that the primary combines into a single LTX file while group commit is enabled.
+const DefaultGroupCommitMaxSize = 100
+
+// SegmentCompression determines how LTX files are compressed in the data
+// directory. LTX files are always decompressed before they are served so the
+// replication wire format does not depend on this setting.
+type SegmentCompression string
+
+const (
+	// Store LTX files uncompressed.
+	SegmentCompressionNone = SegmentCompression("none")
+
+	// Compress LTX files with gzip once they are no longer the latest.
+	SegmentCompressionGzip = SegmentCompression("gzip")
+)
+
+// SegmentCompressionInterval is how often LTX files are checked for compression.
+const SegmentCompressionInterval = 10 * time.Second
+
+// CommitHookBufferSize is the number of commit events queued for each commit
+// hook. Events are dropped once a hook falls this far behind.
+const CommitHookBufferSize = 1024
+
+// WriterHistorySize is the number of changes of writer, such as after a
+// failover, recorded for each database.
+const WriterHistorySize = 100
+
+// MaxWarmupConcurrency is the maximum number of databases that a replica may
+// apply concurrently during its initial sync.
+const MaxWarmupConcurrency = 32
+
+// WarmupMaxPendingFrames is the number of frames a replica receives ahead of
+// those being applied during its initial sync before it stops reading from
+// the primary.
+const WarmupMaxPendingFrames = 1024
+
+// SnapshotQuiesceTimeout is the maximum time Store.SnapshotDBs waits for
+// in-progress write transactions to finish before giving up.
+const SnapshotQuiesceTimeout = 5 * time.Second
+
+// Integrity checks read this many pages at a time & pause between batches so
+// that foreground reads & writes are not starved.
+const (
+	IntegrityCheckBatchSize  = 256
+	IntegrityCheckBatchDelay = 10 * time.Millisecond
+)
+
+// IsValid returns true if c is a valid segment compression.
+func (c SegmentCompression) IsValid() bool {
+	switch c {
+	case SegmentCompressionNone, SegmentCompressionGzip:
+		return true
+	default:
+		return false
+	}
+}
+
 // FileType represents a type of SQLite file.
 type FileType int
 
@@ -101,6 +280,18 @@ func (p Pos) IsZero() bool {
 	return p == (Pos{})
 }
 
+// NewNodeID returns a random identifier, formatted as a UUID, for a node that
+// does not have an ID configured.
+func NewNodeID() string {
+	var b [16]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		panic(fmt.Sprintf("cannot generate node id: %s", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 // FormatDBID formats id as a 16-character hex string.
 func FormatDBID(id uint32) string {
 	return fmt.Sprintf("%08x", id)
@@ -118,6 +309,19 @@ func ParseDBID(s string) (uint32, error) {
 	return uint32(v), nil
 }
 
+// ParseTXID parses a 16-character hex string, as returned by ltx.FormatTXID,
+// into a transaction ID.
+func ParseTXID(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("invalid formatted transaction id length: %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid transaction id format: %q", s)
+	}
+	return v, nil
+}
+
 // Client represents a client for connecting to other LiteFS nodes.
 type Client interface {
 	// Stream starts a long-running connection to stream changes from another node.
@@ -133,11 +337,23 @@ type StreamReader interface {
 	NextFrame() (StreamFrame, error)
 }
 
+// PrimaryPosMapper is implemented by stream readers that report the position
+// of each database on the primary when the stream is opened.
+type PrimaryPosMapper interface {
+	PrimaryPosMap() map[uint32]Pos
+}
+
 type StreamFrameType uint32
 
 const (
-	StreamFrameTypeDB  = StreamFrameType(1)
-	StreamFrameTypeLTX = StreamFrameType(2)
+	StreamFrameTypeDB        = StreamFrameType(1)
+	StreamFrameTypeLTX       = StreamFrameType(2)
+	StreamFrameTypeHeartbeat = StreamFrameType(3)
+	StreamFrameTypeDropDB    = StreamFrameType(4)
+	StreamFrameTypeFreezeDB  = StreamFrameType(5)
+	StreamFrameTypeCatchUp   = StreamFrameType(6)
+	StreamFrameTypeCloneDB   = StreamFrameType(7)
+	StreamFrameTypeLTXChunk  = StreamFrameType(8)
 )
 
 type StreamFrame interface {
@@ -159,6 +375,18 @@ func ReadStreamFrame(r io.Reader) (StreamFrame, error) {
 		f = &DBStreamFrame{}
 	case StreamFrameTypeLTX:
 		f = &LTXStreamFrame{}
+	case StreamFrameTypeHeartbeat:
+		f = &HeartbeatStreamFrame{}
+	case StreamFrameTypeDropDB:
+		f = &DropDBStreamFrame{}
+	case StreamFrameTypeFreezeDB:
+		f = &FreezeDBStreamFrame{}
+	case StreamFrameTypeCatchUp:
+		f = &CatchUpStreamFrame{}
+	case StreamFrameTypeCloneDB:
+		f = &CloneDBStreamFrame{}
+	case StreamFrameTypeLTXChunk:
+		f = &LTXChunkStreamFrame{}
 	default:
 		return nil, fmt.Errorf("invalid stream frame type: 0x%02x", typ)
 	}
@@ -226,8 +454,13 @@ func (f *DBStreamFrame) WriteTo(w io.Writer) (int64, error) {
 	return 0, nil
 }
 
+// LTXStreamFrame represents a frame that precedes an LTX file. If ChunkSize
+// is zero then the file follows as the frame's payload. Otherwise, the file
+// follows as a series of LTXChunkStreamFrames of at most ChunkSize bytes each.
 type LTXStreamFrame struct {
-	Size int64
+	Size      int64
+	ChunkSize int64
+	NodeID    string // node that originally wrote the transaction, if known
 }
 
 // Type returns the type of stream frame.
@@ -236,13 +469,230 @@ func (*LTXStreamFrame) Type() StreamFrameType { return StreamFrameTypeLTX }
 func (f *LTXStreamFrame) ReadFrom(r io.Reader) (int64, error) {
 	if err := binary.Read(r, binary.BigEndian, &f.Size); err != nil {
 		return 0, err
+	} else if err := binary.Read(r, binary.BigEndian, &f.ChunkSize); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
 	}
+
+	var nodeIDN uint32
+	if err := binary.Read(r, binary.BigEndian, &nodeIDN); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	nodeID := make([]byte, nodeIDN)
+	if _, err := io.ReadFull(r, nodeID); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	f.NodeID = string(nodeID)
+
 	return 0, nil
 }
 
 func (f *LTXStreamFrame) WriteTo(w io.Writer) (int64, error) {
 	if err := binary.Write(w, binary.BigEndian, f.Size); err != nil {
 		return 0, err
+	} else if err := binary.Write(w, binary.BigEndian, f.ChunkSize); err != nil {
+		return 0, err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint32(len(f.NodeID))); err != nil {
+		return 0, err
+	} else if _, err := w.Write([]byte(f.NodeID)); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// LTXChunkStreamFrame represents a frame carrying part of a chunked LTX file
+// as its payload. The replica only applies the file once every chunk has been
+// received.
+type LTXChunkStreamFrame struct {
+	Size int64
+}
+
+// Type returns the type of stream frame.
+func (*LTXChunkStreamFrame) Type() StreamFrameType { return StreamFrameTypeLTXChunk }
+
+func (f *LTXChunkStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	if err := binary.Read(r, binary.BigEndian, &f.Size); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (f *LTXChunkStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, f.Size); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// HeartbeatStreamFrame represents an empty frame sent periodically by the
+// primary so that replicas can detect dead connections.
+type HeartbeatStreamFrame struct{}
+
+// Type returns the type of stream frame.
+func (*HeartbeatStreamFrame) Type() StreamFrameType { return StreamFrameTypeHeartbeat }
+
+func (f *HeartbeatStreamFrame) ReadFrom(r io.Reader) (int64, error) { return 0, nil }
+func (f *HeartbeatStreamFrame) WriteTo(w io.Writer) (int64, error)  { return 0, nil }
+
+// DropDBStreamFrame represents a frame sent when a database has been deleted
+// on the primary so that replicas can remove their copy.
+type DropDBStreamFrame struct {
+	DBID uint32
+}
+
+// Type returns the type of stream frame.
+func (*DropDBStreamFrame) Type() StreamFrameType { return StreamFrameTypeDropDB }
+
+func (f *DropDBStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	if err := binary.Read(r, binary.BigEndian, &f.DBID); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (f *DropDBStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, f.DBID); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// FreezeDBStreamFrame represents a frame sent when a database is frozen or
+// thawed on the primary so that replicas persist the state. This ensures a
+// replica that is later promoted continues to reject writes.
+type FreezeDBStreamFrame struct {
+	DBID   uint32
+	Frozen bool
+}
+
+// Type returns the type of stream frame.
+func (*FreezeDBStreamFrame) Type() StreamFrameType { return StreamFrameTypeFreezeDB }
+
+func (f *FreezeDBStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	if err := binary.Read(r, binary.BigEndian, &f.DBID); err != nil {
+		return 0, err
+	} else if err := binary.Read(r, binary.BigEndian, &f.Frozen); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (f *FreezeDBStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, f.DBID); err != nil {
+		return 0, err
+	} else if err := binary.Write(w, binary.BigEndian, f.Frozen); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// CatchUpStreamFrame represents a frame sent before a replica is sent multiple
+// transactions so that it can report its progress toward the primary's TXID.
+type CatchUpStreamFrame struct {
+	DBID uint32
+	TXID uint64
+}
+
+// Type returns the type of stream frame.
+func (*CatchUpStreamFrame) Type() StreamFrameType { return StreamFrameTypeCatchUp }
+
+func (f *CatchUpStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	if err := binary.Read(r, binary.BigEndian, &f.DBID); err != nil {
+		return 0, err
+	} else if err := binary.Read(r, binary.BigEndian, &f.TXID); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (f *CatchUpStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, f.DBID); err != nil {
+		return 0, err
+	} else if err := binary.Write(w, binary.BigEndian, f.TXID); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// CloneDBStreamFrame represents a frame sent in place of a new database's
+// history when its contents are identical to another database the replica
+// already has. The replica copies its local source database instead of
+// receiving the snapshot over the network.
+type CloneDBStreamFrame struct {
+	DBID    uint32
+	Name    string
+	SrcDBID uint32
+	Pos     Pos
+}
+
+// Type returns the type of stream frame.
+func (*CloneDBStreamFrame) Type() StreamFrameType { return StreamFrameTypeCloneDB }
+
+func (f *CloneDBStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	if err := binary.Read(r, binary.BigEndian, &f.DBID); err != nil {
+		return 0, err
+	}
+
+	var nameN uint32
+	if err := binary.Read(r, binary.BigEndian, &nameN); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	name := make([]byte, nameN)
+	if _, err := io.ReadFull(r, name); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	f.Name = string(name)
+
+	if err := binary.Read(r, binary.BigEndian, &f.SrcDBID); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	} else if err := binary.Read(r, binary.BigEndian, &f.Pos.TXID); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	} else if err := binary.Read(r, binary.BigEndian, &f.Pos.Chksum); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (f *CloneDBStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, f.DBID); err != nil {
+		return 0, err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint32(len(f.Name))); err != nil {
+		return 0, err
+	} else if _, err := w.Write([]byte(f.Name)); err != nil {
+		return 0, err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, f.SrcDBID); err != nil {
+		return 0, err
+	} else if err := binary.Write(w, binary.BigEndian, f.Pos.TXID); err != nil {
+		return 0, err
+	} else if err := binary.Write(w, binary.BigEndian, f.Pos.Chksum); err != nil {
+		return 0, err
 	}
 	return 0, nil
 }
@@ -250,6 +700,14 @@ func (f *LTXStreamFrame) WriteTo(w io.Writer) (int64, error) {
 // Invalidator is a callback for the store to use to invalidate the kernel page cache.
 type Invalidator interface {
 	InvalidateDB(db *DB, offset, size int64) error
+
+	// InvalidateEntry invalidates a file in the root directory, such as a
+	// database that has been removed by replication.
+	InvalidateEntry(name string) error
+
+	// InvalidatePrimary invalidates any cached files that depend on whether
+	// the node is the primary. Called whenever the node changes roles.
+	InvalidatePrimary() error
 }
 
 // Leaser represents an API for obtaining a lease for leader election.
@@ -266,8 +724,17 @@ type Leaser interface {
 	PrimaryURL(ctx context.Context) (string, error)
 }
 
+// LeaserTyper is an optional interface implemented by a Leaser to report the
+// name of its backend, e.g. "consul".
+type LeaserTyper interface {
+	Type() string
+}
+
 // Lease represents an acquired lease from a Leaser.
 type Lease interface {
+	// Returns the time the lease was acquired or last renewed. This is for
+	// reporting only as it may be based on wall time. The store times lease
+	// renewals with the local monotonic clock instead.
 	RenewedAt() time.Time
 	TTL() time.Duration
 
@@ -2,6 +2,7 @@ package litefs_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -36,6 +37,22 @@ func TestFileType_IsValid(t *testing.T) {
 	})
 }
 
+func TestError_Is(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &litefs.Error{Code: litefs.ECodeNoPrimary, Message: "no primary"})
+	if !errors.Is(err, litefs.ErrNoPrimary) {
+		t.Fatal("expected error to match by code")
+	} else if errors.Is(err, litefs.ErrPrimaryExists) {
+		t.Fatal("expected error with different code not to match")
+	}
+
+	var e *litefs.Error
+	if !errors.As(err, &e) {
+		t.Fatal("expected typed error")
+	} else if got, want := e.Code, litefs.ECodeNoPrimary; got != want {
+		t.Fatalf("Code=%q, want %q", got, want)
+	}
+}
+
 func TestPos_IsZero(t *testing.T) {
 	if !(litefs.Pos{}).IsZero() {
 		t.Fatal("expected true")
@@ -96,6 +113,32 @@ func TestParseDBID(t *testing.T) {
 	})
 }
 
+func TestParseTXID(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		if v, err := litefs.ParseTXID("00000000000003e8"); err != nil {
+			t.Fatal(err)
+		} else if got, want := v, uint64(1000); got != want {
+			t.Fatalf("got=%d, want %d", got, want)
+		}
+
+		if v, err := litefs.ParseTXID("ffffffffffffffff"); err != nil {
+			t.Fatal(err)
+		} else if got, want := v, uint64(math.MaxUint64); got != want {
+			t.Fatalf("got=%d, want %d", got, want)
+		}
+	})
+	t.Run("ErrLength", func(t *testing.T) {
+		if _, err := litefs.ParseTXID("3e8"); err == nil || err.Error() != `invalid formatted transaction id length: "3e8"` {
+			t.Fatal(err)
+		}
+	})
+	t.Run("ErrInvalidFormat", func(t *testing.T) {
+		if _, err := litefs.ParseTXID("xxxxxxxxxxxxxxxx"); err == nil || err.Error() != `invalid transaction id format: "xxxxxxxxxxxxxxxx"` {
+			t.Fatal(err)
+		}
+	})
+}
+
 func TestReadWriteStreamFrame(t *testing.T) {
 	t.Run("DBStreamFrame", func(t *testing.T) {
 		frame := &litefs.DBStreamFrame{DBID: 1000, Name: "test.db"}
@@ -111,7 +154,91 @@ func TestReadWriteStreamFrame(t *testing.T) {
 		}
 	})
 	t.Run("LTXStreamFrame", func(t *testing.T) {
-		frame := &litefs.LTXStreamFrame{Size: 1000}
+		frame := &litefs.LTXStreamFrame{Size: 1000, ChunkSize: 100, NodeID: "node1"}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("DropDBStreamFrame", func(t *testing.T) {
+		frame := &litefs.DropDBStreamFrame{DBID: 1000}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("FreezeDBStreamFrame", func(t *testing.T) {
+		frame := &litefs.FreezeDBStreamFrame{DBID: 1000, Frozen: true}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("LTXChunkStreamFrame", func(t *testing.T) {
+		frame := &litefs.LTXChunkStreamFrame{Size: 1000}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("CloneDBStreamFrame", func(t *testing.T) {
+		frame := &litefs.CloneDBStreamFrame{DBID: 1000, Name: "test.db", SrcDBID: 2000, Pos: litefs.Pos{TXID: 3000, Chksum: 4000}}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("CatchUpStreamFrame", func(t *testing.T) {
+		frame := &litefs.CatchUpStreamFrame{DBID: 1000, TXID: 2000}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("HeartbeatStreamFrame", func(t *testing.T) {
+		frame := &litefs.HeartbeatStreamFrame{}
 
 		var buf bytes.Buffer
 		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
@@ -199,7 +326,7 @@ func TestLTXStreamFrame_ReadFrom(t *testing.T) {
 	})
 
 	t.Run("ErrUnexpectedEOF", func(t *testing.T) {
-		frame := &litefs.LTXStreamFrame{Size: 1000}
+		frame := &litefs.LTXStreamFrame{Size: 1000, NodeID: "node1"}
 		var buf bytes.Buffer
 		if _, err := frame.WriteTo(&buf); err != nil {
 			t.Fatal(err)
@@ -215,7 +342,7 @@ func TestLTXStreamFrame_ReadFrom(t *testing.T) {
 
 func TestLTXStreamFrame_WriteTo(t *testing.T) {
 	t.Run("ErrUnexpectedEOF", func(t *testing.T) {
-		frame := &litefs.LTXStreamFrame{Size: 1000}
+		frame := &litefs.LTXStreamFrame{Size: 1000, NodeID: "node1"}
 		var buf bytes.Buffer
 		if _, err := frame.WriteTo(&buf); err != nil {
 			t.Fatal(err)
@@ -0,0 +1,328 @@
+package litefs
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/superfly/litefs/internal"
+	"github.com/superfly/ltx"
+)
+
+// StateVersion is the version of the state archive format.
+const StateVersion = 1
+
+// StateManifestName is the name of the manifest entry in a state archive.
+const StateManifestName = "state.json"
+
+// State is the manifest of a state archive. It records the ID, name &
+// position of each database so an import can be validated after extraction.
+type State struct {
+	Version int       `json:"version"`
+	DBs     []StateDB `json:"dbs"`
+	Dropped []uint32  `json:"dropped,omitempty"` // tombstoned database IDs
+}
+
+// StateDB represents a single database entry in a state manifest.
+type StateDB struct {
+	ID     uint32 `json:"id"`
+	Name   string `json:"name"`
+	TXID   uint64 `json:"txid"`
+	Chksum uint64 `json:"chksum"`
+}
+
+// ExportState writes an archive of the store's databases to w. The archive
+// holds a manifest followed by the files in each database directory, which
+// includes the transaction files used to recover each position on import.
+//
+// Writes should be stopped while exporting as only changes committed through
+// the store are blocked while a database is copied.
+func (s *Store) ExportState(w io.Writer) error {
+	s.mu.Lock()
+	dropped := make([]uint32, 0, len(s.droppedDBIDs))
+	for id := range s.droppedDBIDs {
+		dropped = append(dropped, id)
+	}
+	s.mu.Unlock()
+	sort.Slice(dropped, func(i, j int) bool { return dropped[i] < dropped[j] })
+
+	dbs := s.DBs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID() < dbs[j].ID() })
+
+	// Hold each database lock for the remainder of the export so that the
+	// manifest positions match the copied files.
+	state := State{Version: StateVersion, Dropped: dropped}
+	for _, db := range dbs {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		state.DBs = append(state.DBs, StateDB{
+			ID:     db.id,
+			Name:   db.name,
+			TXID:   db.pos.TXID,
+			Chksum: db.pos.Chksum,
+		})
+	}
+
+	tw := tar.NewWriter(w)
+
+	buf, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	} else if err := tw.WriteHeader(&tar.Header{
+		Name:     StateManifestName,
+		Typeflag: tar.TypeReg,
+		Mode:     0666,
+		Size:     int64(len(buf)),
+	}); err != nil {
+		return err
+	} else if _, err := tw.Write(buf); err != nil {
+		return err
+	}
+
+	for _, db := range dbs {
+		if err := exportStateDir(tw, db.path, FormatDBID(db.id)); err != nil {
+			return fmt.Errorf("export database: db=%s err=%w", FormatDBID(db.id), err)
+		}
+	}
+
+	// Tombstones only hold a marker file so they can be written directly.
+	for _, id := range dropped {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     path.Join(FormatDBID(id), "dropped"),
+			Typeflag: tar.TypeReg,
+			Mode:     0666,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// exportStateDir recursively writes the files in dir to tw under prefix.
+// Journals are skipped as any uncommitted transaction is rolled back on open.
+func exportStateDir(tw *tar.Writer, dir, prefix string) error {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, ent := range ents {
+		name := path.Join(prefix, ent.Name())
+		if ent.IsDir() {
+			if err := exportStateDir(tw, filepath.Join(dir, ent.Name()), name); err != nil {
+				return err
+			}
+			continue
+		} else if ent.Name() == "journal" || !ent.Type().IsRegular() {
+			continue
+		}
+
+		if err := exportStateFile(tw, filepath.Join(dir, ent.Name()), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportStateFile(tw *tar.Writer, filename, name string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	} else if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0666,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+	}); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(tw, f, fi.Size()); err != nil {
+		return fmt.Errorf("copy %s: %w", name, err)
+	}
+	return nil
+}
+
+// ImportState extracts a state archive written by ExportState into the data
+// directory at dir, which must be empty or not exist. The extracted databases
+// are then opened to verify that their names, positions & checksums match the
+// manifest. On failure, the extracted files are removed.
+func ImportState(dir string, r io.Reader) (err error) {
+	if ents, err := os.ReadDir(dir); err != nil && !os.IsNotExist(err) {
+		return err
+	} else if len(ents) > 0 {
+		return fmt.Errorf("data directory is not empty: %s", dir)
+	} else if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	// Remove anything partially imported so the import can be retried.
+	defer func() {
+		if err == nil {
+			return
+		}
+		ents, _ := os.ReadDir(dir)
+		for _, ent := range ents {
+			_ = os.RemoveAll(filepath.Join(dir, ent.Name()))
+		}
+	}()
+
+	state, err := extractState(dir, r)
+	if err != nil {
+		return err
+	}
+	return validateState(dir, state)
+}
+
+// extractState writes the files in the archive to dir & returns the manifest.
+func extractState(dir string, r io.Reader) (*State, error) {
+	tr := tar.NewReader(r)
+
+	// The manifest is always written first.
+	hdr, err := tr.Next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty archive", ErrStateMismatch)
+	} else if err != nil {
+		return nil, err
+	} else if hdr.Name != StateManifestName {
+		return nil, fmt.Errorf("%w: expected manifest, got %q", ErrStateMismatch, hdr.Name)
+	}
+
+	var state State
+	if err := json.NewDecoder(tr).Decode(&state); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	} else if state.Version != StateVersion {
+		return nil, fmt.Errorf("unsupported state version: %d", state.Version)
+	}
+
+	dirs := make(map[string]struct{})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		} else if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected archive entry type: %q", hdr.Name)
+		}
+
+		// Only accept paths within a database directory.
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("invalid archive path: %q", hdr.Name)
+		} else if _, err := ParseDBID(strings.SplitN(name, "/", 2)[0]); err != nil {
+			return nil, fmt.Errorf("invalid archive path: %q", hdr.Name)
+		}
+
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			return nil, err
+		}
+		dirs[filepath.Dir(filename)] = struct{}{}
+
+		if err := extractStateFile(filename, tr); err != nil {
+			return nil, fmt.Errorf("extract %s: %w", name, err)
+		}
+	}
+
+	for d := range dirs {
+		if err := internal.Sync(d); err != nil {
+			return nil, err
+		}
+	}
+	if err := internal.Sync(dir); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func extractStateFile(filename string, r io.Reader) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// validateState opens the databases extracted to dir & ensures they match the
+// manifest. Positions are recovered from the extracted transaction files so a
+// missing file or modified database is reported as a mismatch.
+func validateState(dir string, state *State) error {
+	s := NewStore(dir)
+	defer func() { _ = s.Close() }()
+
+	if err := s.openDatabases(); err != nil {
+		return fmt.Errorf("%w: %s", ErrStateMismatch, err)
+	}
+
+	if n := len(s.DBs()); n != len(state.DBs) {
+		return fmt.Errorf("%w: found %d databases, expected %d", ErrStateMismatch, n, len(state.DBs))
+	}
+	for _, id := range state.Dropped {
+		if !s.IsDropped(id) {
+			return fmt.Errorf("%w: missing tombstone: db=%s", ErrStateMismatch, FormatDBID(id))
+		}
+	}
+
+	for _, sdb := range state.DBs {
+		db := s.DB(sdb.ID)
+		if db == nil {
+			return fmt.Errorf("%w: missing database: db=%s", ErrStateMismatch, FormatDBID(sdb.ID))
+		} else if db.Name() != sdb.Name {
+			return fmt.Errorf("%w: db=%s name=%q, expected %q", ErrStateMismatch, FormatDBID(sdb.ID), db.Name(), sdb.Name)
+		}
+
+		pos := db.Pos()
+		if pos.TXID != sdb.TXID || pos.Chksum != sdb.Chksum {
+			return fmt.Errorf("%w: db=%s pos=%s/%016x, expected %s/%016x", ErrStateMismatch,
+				FormatDBID(sdb.ID), ltx.FormatTXID(pos.TXID), pos.Chksum, ltx.FormatTXID(sdb.TXID), sdb.Chksum)
+		} else if pos.TXID == 0 {
+			continue
+		}
+
+		// Ensure the database file itself matches its recovered position.
+		if err := validateStateDatabase(db, pos); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateStateDatabase(db *DB, pos Pos) error {
+	f, err := os.Open(db.DatabasePath())
+	if err != nil {
+		return fmt.Errorf("%w: db=%s: %s", ErrStateMismatch, FormatDBID(db.ID()), err)
+	}
+	defer f.Close()
+
+	if _, _, chksum, err := checksumDatabaseFile(f); err != nil {
+		return fmt.Errorf("checksum: db=%s err=%w", FormatDBID(db.ID()), err)
+	} else if chksum != pos.Chksum {
+		return fmt.Errorf("%w: db=%s chksum=%016x, expected %016x", ErrChecksumMismatch, FormatDBID(db.ID()), chksum, pos.Chksum)
+	}
+	return nil
+}
@@ -2,35 +2,95 @@ package litefs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/superfly/litefs/internal"
 	"github.com/superfly/ltx"
 	"golang.org/x/sync/errgroup"
 )
 
 // Store represents a collection of databases.
+//
+// Each database is stored in a directory named after its formatted ID with a
+// "name" file holding the database name. This keeps the name-to-ID mapping
+// stable across restarts and new IDs are always assigned past the highest
+// existing ID so they are never reused. Deleted databases leave a tombstone
+// directory containing only a "dropped" file for the same reason.
 type Store struct {
 	mu   sync.Mutex
 	path string
 
-	nextDBID    uint32
-	dbsByID     map[uint32]*DB
-	dbsByName   map[string]*DB
-	subscribers map[*Subscriber]struct{}
+	nextDBID     uint32
+	dbsByID      map[uint32]*DB
+	dbsByName    map[string]*DB
+	droppedDBIDs map[uint32]struct{} // tombstones of deleted databases
+	subscribers  map[*Subscriber]struct{}
+	commitHooks  map[*CommitHook]struct{}
+
+	isPrimary   bool      // if true, store is current primary
+	primaryURL  string    // if non-blank, contains the advertise URL of the current primary
+	lastRenewAt time.Time // time of the last successful lease acquisition or renewal
+
+	primaryContactAt time.Time // time the replica last received data from the primary
+	upstreamURL      string    // URL of the node currently streaming to the replica
+
+	primaryPosMap map[uint32]Pos // last known position of each database on the primary
+
+	openedAt time.Time // time the store was opened
+	synced   bool      // if true, the replica has completed an initial sync with the primary
+
+	warmupSyncN int32 // number of databases being applied concurrently during the initial sync
+
+	lowSpace bool // if true, free space is below MinFreeSpace & writes are rejected
+
+	replicationPaused bool          // if true, replica does not stream from the primary
+	replicationCh     chan struct{} // closed when replication is paused, resumed, or reset
 
-	isPrimary  bool   // if true, store is current primary
-	primaryURL string // if non-blank, contains the advertise URL of the current primary
+	resyncDBIDs map[uint32]struct{} // databases to reset before reconnecting
+
+	writesStopped bool // if true, new write transactions are rejected for shutdown
+
+	// Number of checksum mismatches detected & the most recent one. Guarded
+	// separately as mismatches are recorded while a database lock is held.
+	checksumMu           sync.Mutex
+	checksumMismatchN    uint64
+	lastChecksumMismatch ChecksumMismatch
+
+	// Number of errors applying replicated transactions & the most recent one.
+	applyErrorN    uint64
+	lastApplyError ApplyError
+
+	// Number of peers seen with this node's ID & the most recent one.
+	nodeIDCollisionN    uint64
+	lastNodeIDCollision NodeIDCollision
+
+	demoted  bool          // if true, the lease is released & not acquired again
+	demoteCh chan struct{} // closed when demoted
+
+	maintenance bool // if true, the node does not acquire the lease
 
 	ctx    context.Context
 	cancel func()
 	g      errgroup.Group
 
+	// Collapses repeated errors from the lease & replication loops.
+	errLog *internal.RateLimitedLogger
+
+	// Unique identifier for the node. Defaults to a random ID generated by
+	// NewStore but may be set to a stable value, such as the hostname.
+	ID string
+
 	// Client used to connect to other LiteFS instances.
 	Client Client
 
@@ -39,6 +99,142 @@ type Store struct {
 
 	// Callback to notify kernel of file changes.
 	Invalidator Invalidator
+
+	// If true, replicas keep their copy of a database that is deleted on the
+	// primary instead of removing it.
+	IgnoreDrops bool
+
+	// Time to wait before attempting to acquire the lease when no primary
+	// exists. Nodes with a shorter delay are preferred during an election.
+	// A node with a delay still becomes primary if no other node does.
+	AcquireDelay time.Duration
+
+	// Additional time to wait before attempting to acquire the lease if any
+	// database is behind its last known position on the primary. This lets
+	// nodes that are caught up acquire first so fewer transactions are lost.
+	BehindAcquireDelay time.Duration
+
+	// If set, replicas stream changes from this URL instead of the primary.
+	// This is typically a nearby replica relaying the primary's stream. The
+	// primary is used directly if the source cannot be reached.
+	SourceURL string
+
+	// If true, the store is always the primary and no leaser is used.
+	// Replicas may still connect to stream changes.
+	Standalone bool
+
+	// If greater than zero, writes that would grow a database file beyond this
+	// many bytes are rejected with ErrDatabaseTooLarge. Limits for individual
+	// databases, by name, in MaxDatabaseBytesOverrides take precedence. An
+	// override of zero disables the limit for that database.
+	MaxDatabaseBytes          int64
+	MaxDatabaseBytesOverrides map[string]int64
+
+	// Maps database names to the ID of the only node allowed to write them.
+	// Writes to a pinned database fail with ErrPinnedPrimary on any other
+	// node, even if it holds the lease, so the database becomes read-only
+	// everywhere while the pinned node is not the primary.
+	PinnedPrimaryNodeIDs map[string]string
+
+	// If non-zero, new write transactions are rejected with ErrNoSpace while
+	// the data directory has fewer than this many bytes of free space.
+	MinFreeSpace uint64
+
+	// Transaction ID after which a warning is logged that a database is
+	// approaching MaxTXID. Defaults to DefaultTXIDWarnThreshold.
+	TXIDWarnThreshold uint64
+
+	// If greater than zero, a newly-started replica rejects reads with
+	// ErrSyncing until it has caught up with the primary or until this period
+	// has elapsed since the store was opened. The replica is caught up once it
+	// receives its first heartbeat, which the primary sends after the initial
+	// sync of every database.
+	ReadGracePeriod time.Duration
+
+	// If greater than zero, Open returns ErrRecoveryTimeout if recovering
+	// the databases in the data directory takes longer than this duration.
+	MaxRecoveryDuration time.Duration
+
+	// Number of transactions a database may trail the primary by while
+	// catching up & still be readable during the read grace period.
+	ReadMaxLag uint64
+
+	// If true, the node replicates & can be promoted to primary but rejects
+	// reads with ErrStandby & never reports as ready while it is a replica.
+	StandbyOnly bool
+
+	// Returns the free space, in bytes, of the file system containing path.
+	// Defaults to using statfs() but may be replaced for testing.
+	FreeSpaceFunc func(path string) (uint64, error)
+
+	// If true, the primary fsyncs each transaction's LTX file & directory
+	// before the transaction becomes visible to replicas so that replicas are
+	// never ahead of the primary's durable state. This adds latency to every
+	// write transaction.
+	DurableBeforeReplicate bool
+
+	// If greater than zero, the primary defers fsyncing the database file after
+	// each commit & instead fsyncs in the background within this window. This
+	// absorbs bursts of writes. The LTX file of each commit is still fsynced
+	// so that buffered commits are replayed after a host crash or power loss.
+	// Ignored if DurableBeforeReplicate is set.
+	WriteBufferWindow time.Duration
+
+	// Maximum number of transactions per database that may be buffered before
+	// a commit waits for an fsync. Defaults to DefaultWriteBufferMaxSize.
+	WriteBufferMaxSize int
+
+	// If greater than zero, the primary holds each commit for up to this
+	// window before it is visible to replication streams. Transactions
+	// committed within the window are combined into a single LTX file to
+	// reduce per-transaction replication overhead.
+	GroupCommitWindow time.Duration
+
+	// Maximum number of transactions combined into a single LTX file by a
+	// group commit. Defaults to DefaultGroupCommitMaxSize.
+	GroupCommitMaxSize int
+
+	// Fsyncs a file written by a transaction on the primary. Defaults to
+	// (*os.File).Sync() but may be replaced for testing.
+	FsyncFunc func(f *os.File) error
+
+	// Determines how often applied transactions are fsynced to the database
+	// file while running as a replica. Defaults to FsyncPolicyAlways.
+	ReplicaFsyncPolicy   FsyncPolicy
+	ReplicaFsyncInterval time.Duration
+
+	// Determines how the replica recovers after failing to apply a transaction
+	// from the primary. Defaults to ApplyErrorPolicyRetry.
+	ApplyErrorPolicy ApplyErrorPolicy
+
+	// If greater than zero, the replica waits this long before applying each
+	// LTX frame received from the primary. This simulates lag for testing.
+	DebugApplyDelay time.Duration
+
+	// Number of databases a replica applies transactions to concurrently
+	// until it completes its initial sync with the primary. Afterward, or if
+	// one or less, transactions are applied in the order they are received.
+	// Must not exceed MaxWarmupConcurrency.
+	WarmupConcurrency int
+
+	// If true, each database's LTX files are checked at startup for missing
+	// transactions between the earliest file & the current position. Startup
+	// fails with ErrSegmentGap if any are missing. Otherwise, the position is
+	// recovered from the latest LTX file available.
+	StrictSegmentContinuity bool
+
+	// Compression applied to LTX files stored in the data directory. Files are
+	// decompressed before they are served so this does not affect replication.
+	// Defaults to SegmentCompressionNone.
+	SegmentCompression SegmentCompression
+
+	// If greater than zero, the checksum of every database file is recomputed
+	// on this interval & compared against its position to detect drift.
+	IntegrityCheckInterval time.Duration
+
+	// If non-zero, the page size that all databases are expected to use.
+	// Writes & replicated transactions with a different page size are rejected.
+	PageSize uint32
 }
 
 // NewStore returns a new instance of Store.
@@ -47,10 +243,36 @@ func NewStore(path string) *Store {
 		path:     path,
 		nextDBID: 1,
 
+		ID: NewNodeID(),
+
 		dbsByID:   make(map[uint32]*DB),
 		dbsByName: make(map[string]*DB),
 
+		droppedDBIDs: make(map[uint32]struct{}),
+
 		subscribers: make(map[*Subscriber]struct{}),
+		commitHooks: make(map[*CommitHook]struct{}),
+
+		replicationCh: make(chan struct{}),
+		demoteCh:      make(chan struct{}),
+
+		resyncDBIDs: make(map[uint32]struct{}),
+
+		FreeSpaceFunc: internal.FreeSpace,
+		FsyncFunc:     (*os.File).Sync,
+
+		ReplicaFsyncPolicy:   FsyncPolicyAlways,
+		ReplicaFsyncInterval: DefaultReplicaFsyncInterval,
+
+		WriteBufferMaxSize: DefaultWriteBufferMaxSize,
+		GroupCommitMaxSize: DefaultGroupCommitMaxSize,
+
+		SegmentCompression: SegmentCompressionNone,
+		ApplyErrorPolicy:   ApplyErrorPolicyRetry,
+
+		TXIDWarnThreshold: DefaultTXIDWarnThreshold,
+
+		errLog: internal.NewRateLimitedLogger(nil, ErrorLogInterval),
 	}
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
@@ -67,22 +289,61 @@ func (s *Store) DBDir(id uint32) string {
 
 // Open initializes the store based on files in the data directory.
 func (s *Store) Open() error {
+	if !s.SegmentCompression.IsValid() {
+		return fmt.Errorf("invalid segment compression: %q", s.SegmentCompression)
+	} else if !s.ApplyErrorPolicy.IsValid() {
+		return fmt.Errorf("invalid apply error policy: %q", s.ApplyErrorPolicy)
+	} else if s.WarmupConcurrency > MaxWarmupConcurrency {
+		return fmt.Errorf("warmup concurrency cannot exceed %d", MaxWarmupConcurrency)
+	}
+
 	if err := os.MkdirAll(s.path, 0777); err != nil {
 		return err
 	}
 
+	// Maintenance mode remains enabled across restarts.
+	if _, err := os.Stat(s.MaintenancePath()); err == nil {
+		log.Printf("maintenance mode enabled, lease will not be acquired")
+		s.maintenance = true
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("stat maintenance file: %w", err)
+	}
+
 	if err := s.openDatabases(); err != nil {
 		return fmt.Errorf("open databases: %w", err)
 	}
 
 	// Begin background replication monitor.
-	if s.Leaser != nil {
+	s.markPrimaryContact()
+	s.openedAt = time.Now()
+	if s.Standalone {
+		if s.Leaser != nil {
+			return fmt.Errorf("leaser cannot be used in standalone mode")
+		}
+		log.Printf("running in standalone mode as primary")
+		s.isPrimary = true
+	} else if s.Leaser != nil {
 		s.g.Go(func() error { return s.monitor(s.ctx) })
 	} else {
 		log.Printf("WARNING: no leaser assigned, running as defacto primary (for testing only)")
 		s.isPrimary = true
 	}
 
+	// Begin background compression of stored LTX files, if enabled.
+	if s.SegmentCompression != SegmentCompressionNone {
+		s.g.Go(func() error { s.monitorSegmentCompression(s.ctx); return nil })
+	}
+
+	// Begin background integrity checks, if enabled.
+	if s.IntegrityCheckInterval > 0 {
+		s.g.Go(func() error { s.monitorIntegrity(s.ctx); return nil })
+	}
+
+	// Begin background flushes of buffered writes, if enabled.
+	if s.writeBufferEnabled() {
+		s.g.Go(func() error { s.monitorWriteBuffer(s.ctx); return nil })
+	}
+
 	return nil
 }
 
@@ -97,12 +358,37 @@ func (s *Store) openDatabases() error {
 	if err != nil {
 		return fmt.Errorf("readdir: %w", err)
 	}
+
+	var dbIDs []uint32
 	for _, fi := range fis {
 		dbID, err := ParseDBID(fi.Name())
 		if err != nil {
 			log.Printf("not a database directory, skipping: %q", fi.Name())
 			continue
-		} else if err := s.openDatabase(dbID); err != nil {
+		} else if s.dbsByID[dbID] != nil {
+			continue // already opened, e.g. seeded before open
+		}
+
+		// Track tombstones so dropped IDs are never reused.
+		if _, err := os.Stat(filepath.Join(s.DBDir(dbID), "dropped")); err == nil {
+			s.droppedDBIDs[dbID] = struct{}{}
+			if s.nextDBID <= dbID {
+				s.nextDBID = dbID + 1
+			}
+			continue
+		}
+		dbIDs = append(dbIDs, dbID)
+	}
+
+	// Open each database, recovering from its LTX files & any hot journal.
+	// Stop if recovery is taking too long so a supervisor can intervene.
+	start := time.Now()
+	for i, dbID := range dbIDs {
+		if elapsed := time.Since(start); i > 0 && s.MaxRecoveryDuration > 0 && elapsed > s.MaxRecoveryDuration {
+			return fmt.Errorf("%w: recovered %d of %d databases in %s", ErrRecoveryTimeout, i, len(dbIDs), elapsed.Round(time.Millisecond))
+		}
+
+		if err := s.openDatabase(dbID); err != nil {
 			return fmt.Errorf("open database: db=%s err=%w", FormatDBID(dbID), err)
 		}
 	}
@@ -135,6 +421,14 @@ func (s *Store) Close() error {
 	return s.g.Wait()
 }
 
+// LastRenewAt returns the time the primary lease was last acquired or
+// successfully renewed. Returns a zero time if the store does not hold a lease.
+func (s *Store) LastRenewAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastRenewAt
+}
+
 // IsPrimary returns true if store has a lease to be the primary.
 func (s *Store) IsPrimary() bool {
 	s.mu.Lock()
@@ -149,6 +443,390 @@ func (s *Store) PrimaryURL() string {
 	return s.primaryURL
 }
 
+// NoPrimaryDuration returns how long a replica has gone without receiving
+// data from the primary. Returns zero if the store is the primary or if
+// replication has been paused intentionally.
+func (s *Store) NoPrimaryDuration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isPrimary || s.replicationPaused {
+		return 0
+	}
+	return time.Since(s.primaryContactAt)
+}
+
+// markPrimaryContact records that the primary was reachable.
+func (s *Store) markPrimaryContact() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.primaryContactAt = time.Now()
+}
+
+// isSynced returns true if the replica has caught up with the primary since
+// the store was opened.
+func (s *Store) isSynced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.synced
+}
+
+// WarmupSyncN returns the number of databases the replica is currently
+// applying transactions to concurrently during its initial sync.
+func (s *Store) WarmupSyncN() int {
+	return int(atomic.LoadInt32(&s.warmupSyncN))
+}
+
+// markSynced records that the replica has caught up with the primary since
+// the store was opened.
+func (s *Store) markSynced() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.synced {
+		return
+	}
+	if s.ReadGracePeriod > 0 {
+		log.Printf("replica synced with primary, serving reads")
+	}
+	s.synced = true
+}
+
+// Ready returns true if the node can serve reads. The primary is always
+// ready. A replica is ready once it has synced with the primary or its read
+// grace period has elapsed, unless it is a standby.
+func (s *Store) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready()
+}
+
+func (s *Store) ready() bool {
+	if s.isPrimary {
+		return true
+	} else if s.StandbyOnly {
+		return false
+	}
+	return s.ReadGracePeriod <= 0 || s.synced || time.Since(s.openedAt) >= s.ReadGracePeriod
+}
+
+// CheckReadable returns ErrSyncing if db should not be read because the
+// replica has recently started & has not yet caught up with the primary.
+// Reads are always allowed on the primary or once the grace period elapses.
+// Standby replicas reject all reads with ErrStandby & databases being
+// resynced on a replica return ErrSyncing.
+func (s *Store) CheckReadable(db *DB) error {
+	s.mu.Lock()
+	ready, isPrimary := s.ready(), s.isPrimary
+	s.mu.Unlock()
+	if !isPrimary && db.Resyncing() {
+		return ErrSyncing
+	} else if ready {
+		return nil
+	} else if s.StandbyOnly {
+		return ErrStandby
+	}
+
+	// Allow reads on a database that is catching up & within the lag limit.
+	if c, ok := db.CatchUp(); ok && c.TXID+s.ReadMaxLag >= c.TargetTXID {
+		return nil
+	}
+	return ErrSyncing
+}
+
+// FreeSpace returns the number of bytes available on the data directory's file system.
+func (s *Store) FreeSpace() (uint64, error) {
+	return s.FreeSpaceFunc(s.path)
+}
+
+// MaxDatabaseBytesFor returns the size limit of the named database. Returns
+// zero if the database is unlimited.
+func (s *Store) MaxDatabaseBytesFor(name string) int64 {
+	if n, ok := s.MaxDatabaseBytesOverrides[name]; ok {
+		return n
+	}
+	return s.MaxDatabaseBytes
+}
+
+// CheckPinnedPrimary returns ErrPinnedPrimary if the named database is pinned
+// to a node other than this one.
+func (s *Store) CheckPinnedPrimary(name string) error {
+	if id, ok := s.PinnedPrimaryNodeIDs[name]; ok && id != s.ID {
+		return ErrPinnedPrimary
+	}
+	return nil
+}
+
+// CheckFreeSpace returns ErrNoSpace if the free space on the data directory
+// is below MinFreeSpace. Writes are allowed if free space cannot be determined.
+func (s *Store) CheckFreeSpace() error {
+	if s.MinFreeSpace == 0 {
+		return nil
+	}
+
+	free, err := s.FreeSpace()
+	if err != nil {
+		s.errLog.Printf("cannot determine free space, allowing writes: %s", err)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if free < s.MinFreeSpace {
+		if !s.lowSpace {
+			log.Printf("ALERT: free space of %d bytes is below minimum of %d bytes, rejecting writes", free, s.MinFreeSpace)
+		}
+		s.lowSpace = true
+		return ErrNoSpace
+	}
+
+	if s.lowSpace {
+		log.Printf("free space of %d bytes is above minimum, accepting writes", free)
+	}
+	s.lowSpace = false
+	return nil
+}
+
+// ReplicationPaused returns true if replication from the primary is paused.
+func (s *Store) ReplicationPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replicationPaused
+}
+
+// PauseReplication freezes a replica at its current position until
+// ResumeReplication() is called. The stream from the primary is disconnected
+// while paused so that changes are not buffered.
+func (s *Store) PauseReplication() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replicationPaused {
+		return
+	}
+	log.Printf("replication paused")
+	s.replicationPaused = true
+	close(s.replicationCh)
+	s.replicationCh = make(chan struct{})
+}
+
+// ResumeReplication reconnects a paused replica so it catches up to the primary.
+func (s *Store) ResumeReplication() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.replicationPaused {
+		return
+	}
+	log.Printf("replication resumed")
+	s.replicationPaused = false
+	s.primaryContactAt = time.Now()
+	close(s.replicationCh)
+	s.replicationCh = make(chan struct{})
+}
+
+// ResyncDB discards the local state of the named database, or of every
+// database if name is blank, & reconnects to the primary so that the state is
+// restored from a snapshot followed by incremental transactions. This is used
+// to recover a replica that is known to be corrupt. Reads of the database
+// return ErrSyncing until the replica has caught up. Only valid on a replica.
+func (s *Store) ResyncDB(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isPrimary {
+		return ErrNotReplica
+	}
+
+	var dbs []*DB
+	if name == "" {
+		for _, db := range s.dbsByID {
+			dbs = append(dbs, db)
+		}
+	} else if db := s.dbsByName[name]; db != nil {
+		dbs = append(dbs, db)
+	} else {
+		return ErrDatabaseNotFound
+	}
+
+	// Reads are rejected immediately. The state is discarded by the
+	// replication loop after it disconnects so it does not race an apply.
+	for _, db := range dbs {
+		log.Printf("resyncing database from primary: db=%s name=%q", FormatDBID(db.ID()), db.Name())
+		db.setResyncing(true)
+		s.resyncDBIDs[db.ID()] = struct{}{}
+	}
+
+	close(s.replicationCh)
+	s.replicationCh = make(chan struct{})
+	return nil
+}
+
+// resetResyncDBs discards the state of databases awaiting a resync. Must be
+// called while disconnected from the primary.
+func (s *Store) resetResyncDBs() error {
+	s.mu.Lock()
+	dbs := make([]*DB, 0, len(s.resyncDBIDs))
+	for id := range s.resyncDBIDs {
+		if db := s.dbsByID[id]; db != nil {
+			dbs = append(dbs, db)
+		} else {
+			delete(s.resyncDBIDs, id) // dropped since requested
+		}
+	}
+	s.mu.Unlock()
+
+	for _, db := range dbs {
+		if err := db.Reset(); err != nil {
+			return fmt.Errorf("reset database: db=%s err=%w", FormatDBID(db.ID()), err)
+		}
+
+		s.mu.Lock()
+		delete(s.resyncDBIDs, db.ID())
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// markResynced allows reads of databases that have been restored after a
+// resync. Called once the replica has caught up with the primary.
+func (s *Store) markResynced() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, db := range s.dbsByID {
+		if _, pending := s.resyncDBIDs[id]; pending || !db.Resyncing() {
+			continue
+		}
+		log.Printf("database resynced: db=%s txid=%s", FormatDBID(id), ltx.FormatTXID(db.TXID()))
+		db.setResyncing(false)
+	}
+}
+
+// replicationState returns whether replication is paused and a channel that
+// is closed the next time replication is paused or resumed.
+func (s *Store) replicationState() (paused bool, ch <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replicationPaused, s.replicationCh
+}
+
+// StopWrites rejects new write transactions with ErrShuttingDown. This is the
+// first step of a clean shutdown. Transactions already in progress may still
+// commit so callers should then wait for them with Flush().
+func (s *Store) StopWrites() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.writesStopped {
+		log.Printf("stopping writes for shutdown")
+	}
+	s.writesStopped = true
+}
+
+// WritesStopped returns true if new write transactions are rejected.
+func (s *Store) WritesStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writesStopped
+}
+
+// Flush waits for write transactions in progress to complete, until ctx is
+// done, & then fsyncs every database so that all committed transactions are
+// durable.
+func (s *Store) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for _, db := range s.DBs() {
+	WAIT:
+		for db.InWriteTx() {
+			select {
+			case <-ctx.Done():
+				log.Printf("write transaction still in progress, flushing anyway: db=%s", FormatDBID(db.ID()))
+				break WAIT
+			case <-ticker.C:
+			}
+		}
+
+		if err := db.Sync(); err != nil {
+			return fmt.Errorf("sync database: db=%s err=%w", FormatDBID(db.ID()), err)
+		}
+	}
+	return nil
+}
+
+// Demoted returns true if the store has been demoted.
+func (s *Store) Demoted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.demoted
+}
+
+// Demote releases the lease, if held, so that another node can become the
+// primary. The store does not attempt to acquire the lease again & continues
+// as a replica. This is used to drain a node before it is shut down.
+func (s *Store) Demote() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.demoted {
+		return
+	}
+	log.Printf("demoting node, lease will not be acquired")
+	s.demoted = true
+	close(s.demoteCh)
+}
+
+// MaintenancePath returns the path to the file that marks the node as being
+// in maintenance mode.
+func (s *Store) MaintenancePath() string {
+	return filepath.Join(s.path, "maintenance")
+}
+
+// Maintenance returns true if the node is in maintenance mode.
+func (s *Store) Maintenance() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maintenance
+}
+
+// SetMaintenance enables or disables maintenance mode. While enabled, the
+// node does not acquire the lease even if there is no primary so that a
+// primary which is stopped deliberately is not replaced. A current primary
+// keeps its lease & replication is unaffected.
+func (s *Store) SetMaintenance(enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if enabled == s.maintenance {
+		return nil
+	}
+
+	if enabled {
+		if err := os.WriteFile(s.MaintenancePath(), nil, 0666); err != nil {
+			return fmt.Errorf("write maintenance file: %w", err)
+		}
+		log.Printf("maintenance mode enabled, lease will not be acquired")
+	} else {
+		if err := os.Remove(s.MaintenancePath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove maintenance file: %w", err)
+		}
+		log.Printf("maintenance mode disabled")
+	}
+	s.maintenance = enabled
+
+	return nil
+}
+
+// shouldSyncReplica returns true if applied transactions should be fsynced
+// given the time the database file was last fsynced.
+func (s *Store) shouldSyncReplica(syncedAt time.Time) bool {
+	switch s.ReplicaFsyncPolicy {
+	case FsyncPolicyInterval:
+		return time.Since(syncedAt) >= s.ReplicaFsyncInterval
+	case FsyncPolicyOS:
+		return false
+	default:
+		return true
+	}
+}
+
 // DB returns a database by ID. Returns nil if the database does not exist.
 func (s *Store) DB(id uint32) *DB {
 	s.mu.Lock()
@@ -179,7 +857,17 @@ func (s *Store) DBs() []*DB {
 // CreateDB creates a new database with the given name. The returned file handle
 // must be closed by the caller. Returns an error if a database with the same
 // name already exists.
+//
+// The database starts as a zero-length file at TXID 0, such as when an
+// application creates the file before initializing it. An empty database is
+// replicated as an empty file & no LTX files until its first transaction.
 func (s *Store) CreateDB(name string) (*DB, *os.File, error) {
+	if err := s.CheckPinnedPrimary(name); err != nil {
+		return nil, nil, err
+	} else if err := s.CheckFreeSpace(); err != nil {
+		return nil, nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -259,47 +947,355 @@ func (s *Store) ForceCreateDB(id uint32, name string) (*DB, error) {
 	return db, nil
 }
 
-// PosMap returns a map of databases and their transactional position.
-func (s *Store) PosMap() map[uint32]Pos {
+// SeedDB creates a database with the given ID & name from an existing SQLite
+// file at src. This allows a new replica to start from a local copy of the
+// database instead of streaming its full history from the primary. The file
+// must match pos, which should be the position the primary recorded for
+// pos.TXID, or ErrChecksumMismatch is returned. Replication then continues
+// from the transaction after pos.
+func (s *Store) SeedDB(id uint32, name string, src string, pos Pos) (*DB, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	m := make(map[uint32]Pos, len(s.dbsByID))
-	for _, db := range s.dbsByID {
-		m[db.ID()] = db.Pos()
+	if _, ok := s.dbsByID[id]; ok {
+		return nil, ErrDatabaseExists
+	} else if _, ok := s.dbsByName[name]; ok {
+		return nil, ErrDatabaseExists
+	} else if pos.TXID == 0 {
+		return nil, fmt.Errorf("seed position required")
+	} else if pos.Chksum == 0 {
+		return nil, fmt.Errorf("seed position has no checksum, tx %s may be within a batch of transactions", ltx.FormatTXID(pos.TXID))
 	}
-	return m
-}
-
-// Subscribe creates a new subscriber for store changes.
-func (s *Store) Subscribe() *Subscriber {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	sub := newSubscriber(s)
-	s.subscribers[sub] = struct{}{}
-	return sub
-}
 
-// Unsubscribe removes a subscriber from the store.
-func (s *Store) Unsubscribe(sub *Subscriber) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.subscribers, sub)
-}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-// MarkDirty marks a database ID dirty on all subscribers.
-func (s *Store) MarkDirty(dbID uint32) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.markDirty(dbID)
-}
+	// Ensure the file is identical to the primary's database at the position.
+	pageSize, commit, chksum, err := checksumDatabaseFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("checksum: %w", err)
+	} else if chksum != pos.Chksum {
+		return nil, fmt.Errorf("%w: tx=%d chksum=%016x, expected %016x", ErrChecksumMismatch, pos.TXID, chksum, pos.Chksum)
+	} else if s.PageSize != 0 && pageSize != s.PageSize {
+		return nil, fmt.Errorf("%w: got %d, expected %d", ErrPageSizeMismatch, pageSize, s.PageSize)
+	}
 
-func (s *Store) markDirty(dbID uint32) {
+	// Generate the database directory with a name file, a copy of the
+	// database & its snapshot in a temporary directory & then rename it into
+	// place so a failed seed does not leave a partial database behind.
+	dbDir := s.DBDir(id)
+	tmpDir := dbDir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := s.writeSeedDir(id, tmpDir, name, f, pageSize, commit, pos); err != nil {
+		return nil, err
+	} else if err := os.Rename(tmpDir, dbDir); err != nil {
+		return nil, err
+	} else if err := internal.Sync(s.path); err != nil {
+		return nil, err
+	}
+
+	db := NewDB(s, id, dbDir)
+	if err := db.Open(); err != nil {
+		_ = os.RemoveAll(dbDir)
+		return nil, err
+	}
+	s.dbsByID[id] = db
+	s.dbsByName[name] = db
+
+	// Ensure next DBID is higher than DB's id
+	if s.nextDBID <= id {
+		s.nextDBID = id + 1
+	}
+
+	// Notify listeners of change.
+	s.markDirty(id)
+
+	return db, nil
+}
+
+// writeSeedDir writes the files for a seeded database into dir. The first
+// commit pages of f are copied as the database file & a snapshot is written
+// so the position is recovered when the database opens.
+func (s *Store) writeSeedDir(id uint32, dir, name string, f *os.File, pageSize, commit uint32, pos Pos) error {
+	db := NewDB(s, id, dir)
+	if err := os.MkdirAll(db.LTXDir(), 0777); err != nil {
+		return err
+	} else if err := os.WriteFile(filepath.Join(dir, "name"), []byte(name), 0666); err != nil {
+		return err
+	}
+
+	dbFile, err := os.OpenFile(db.DatabasePath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer dbFile.Close()
+
+	if _, err := io.Copy(dbFile, io.NewSectionReader(f, 0, int64(commit)*int64(pageSize))); err != nil {
+		return fmt.Errorf("copy database: %w", err)
+	} else if err := dbFile.Sync(); err != nil {
+		return fmt.Errorf("sync database: %w", err)
+	}
+
+	if err := db.writeSnapshot(dbFile, pageSize, commit, pos); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	} else if err := internal.Sync(db.LTXDir()); err != nil {
+		return err
+	} else if err := internal.Sync(dir); err != nil {
+		return err
+	}
+	return dbFile.Close()
+}
+
+// DropDB deletes the database with the given name & notifies replicas so that
+// they remove their copy. Only valid on the primary.
+func (s *Store) DropDB(name string) error {
+	if !s.IsPrimary() {
+		return ErrReadOnlyReplica
+	} else if err := s.CheckPinnedPrimary(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db := s.dbsByName[name]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if err := s.dropDB(db); err != nil {
+		return err
+	}
+
+	// Notify listeners of change.
+	s.markDirty(db.ID())
+
+	return nil
+}
+
+// dropDB removes db from the store and replaces its data directory with a
+// tombstone so that its ID is not reused. Sidecar files, such as the journal,
+// are removed along with the database. Must be called under lock.
+func (s *Store) dropDB(db *DB) error {
+	delete(s.dbsByID, db.ID())
+	delete(s.dbsByName, db.Name())
+	s.droppedDBIDs[db.ID()] = struct{}{}
+
+	// Write the tombstone first so a partial removal is not reopened.
+	if err := os.WriteFile(filepath.Join(db.Path(), "dropped"), nil, 0666); err != nil {
+		return fmt.Errorf("write tombstone: %w", err)
+	}
+
+	ents, err := os.ReadDir(db.Path())
+	if err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		if ent.Name() == "dropped" {
+			continue
+		} else if err := os.RemoveAll(filepath.Join(db.Path(), ent.Name())); err != nil {
+			return err
+		}
+	}
+	return internal.Sync(db.Path())
+}
+
+// FreezeDB freezes or thaws the named database so that new write transactions
+// are rejected cluster-wide. Only valid on the primary.
+func (s *Store) FreezeDB(name string, frozen bool) error {
+	if !s.IsPrimary() {
+		return ErrNotPrimary
+	}
+
+	db := s.DBByName(name)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	return db.SetFrozen(frozen)
+}
+
+// DBSnapshot is a point-in-time copy of a database returned by SnapshotDBs.
+type DBSnapshot struct {
+	ID   uint32
+	Name string
+	Pos  Pos
+
+	// Unlinked copy of the database file. Must be closed by the caller.
+	File *os.File
+}
+
+// SnapshotDBs copies every database while writes to all of them are paused
+// so the copies reflect a single moment across databases. New write
+// transactions wait until the copies are made. Returns an error if in-progress
+// write transactions do not finish within SnapshotQuiesceTimeout.
+func (s *Store) SnapshotDBs(ctx context.Context) (_ []*DBSnapshot, err error) {
+	dbs := s.DBs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID() < dbs[j].ID() })
+
+	// Obtain the RESERVED lock on every database so no write transaction can
+	// be in progress. Locks are released & retried if any are unavailable.
+	guards, err := s.quiesceDBs(ctx, dbs)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, guard := range guards {
+			guard.Unlock()
+		}
+	}()
+
+	// Replicated transactions are applied without the RESERVED lock so each
+	// database lock is held until every copy is made.
+	for _, db := range dbs {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+
+	snapshots := make([]*DBSnapshot, 0, len(dbs))
+	defer func() {
+		if err != nil {
+			for _, snapshot := range snapshots {
+				_ = snapshot.File.Close()
+			}
+		}
+	}()
+
+	for _, db := range dbs {
+		f, err := db.copyDatabaseFile()
+		if err != nil {
+			return nil, fmt.Errorf("copy database %q: %w", db.Name(), err)
+		}
+		snapshots = append(snapshots, &DBSnapshot{ID: db.id, Name: db.name, Pos: db.pos, File: f})
+	}
+	return snapshots, nil
+}
+
+// quiesceDBs obtains the RESERVED lock on every database in dbs.
+func (s *Store) quiesceDBs(ctx context.Context, dbs []*DB) ([]*RWMutexGuard, error) {
+	ctx, cancel := context.WithTimeout(ctx, SnapshotQuiesceTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(1 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		guards := make([]*RWMutexGuard, 0, len(dbs))
+		for _, db := range dbs {
+			guard := db.reservedLock.TryLock()
+			if guard == nil {
+				break
+			}
+			guards = append(guards, guard)
+		}
+		if len(guards) == len(dbs) {
+			return guards, nil
+		}
+
+		for _, guard := range guards {
+			guard.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for write transactions: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// IsDropped returns true if a database with the given ID has been deleted.
+func (s *Store) IsDropped(id uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.droppedDBIDs[id]
+	return ok
+}
+
+// PosMap returns a map of databases and their transactional position. The
+// store lock is not held while reading positions as commits hold a database
+// lock while marking the store dirty.
+func (s *Store) PosMap() map[uint32]Pos {
+	dbs := s.DBs()
+	m := make(map[uint32]Pos, len(dbs))
+	for _, db := range dbs {
+		m[db.ID()] = db.Pos()
+	}
+	return m
+}
+
+// Subscribe creates a new subscriber for store changes.
+func (s *Store) Subscribe() *Subscriber {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := newSubscriber(s)
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes a subscriber from the store.
+func (s *Store) Unsubscribe(sub *Subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, sub)
+}
+
+// MarkDirty marks a database ID dirty on all subscribers.
+func (s *Store) MarkDirty(dbID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markDirty(dbID)
+}
+
+func (s *Store) markDirty(dbID uint32) {
 	for sub := range s.subscribers {
 		sub.MarkDirty(dbID)
 	}
 }
 
+// AddCommitHook registers fn to be called with each transaction committed on
+// this node. Only the primary commits transactions. The hook is called on its
+// own goroutine in commit order & never blocks commits. Events are dropped if
+// the hook falls more than CommitHookBufferSize events behind.
+func (s *Store) AddCommitHook(fn func(CommitEvent)) *CommitHook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook := newCommitHook(s, fn)
+	s.commitHooks[hook] = struct{}{}
+	return hook
+}
+
+// RemoveCommitHook stops delivering events to a commit hook.
+func (s *Store) RemoveCommitHook(hook *CommitHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.commitHooks[hook]; !ok {
+		return
+	}
+	delete(s.commitHooks, hook)
+	close(hook.ch)
+}
+
+// notifyCommit queues a commit event on all commit hooks.
+func (s *Store) notifyCommit(event CommitEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hook := range s.commitHooks {
+		select {
+		case hook.ch <- event:
+		default:
+			commitHookDropCountMetric.Inc()
+			s.errLog.Printf("commit hook is falling behind, dropping event: db=%s tx=%s",
+				FormatDBID(event.DBID), ltx.FormatTXID(event.TXID))
+		}
+	}
+}
+
 // monitor continuously handles either the leader lease or replicates from the primary.
 func (s *Store) monitor(ctx context.Context) error {
 	for {
@@ -311,7 +1307,7 @@ func (s *Store) monitor(ctx context.Context) error {
 		// Attempt to either obtain a primary lock or read the current primary.
 		lease, primaryURL, err := s.acquireLeaseOrPrimaryURL(ctx)
 		if err != nil {
-			log.Printf("cannot acquire lease or find primary, retrying: %s", err)
+			s.errLog.Printf("cannot acquire lease or find primary, retrying: %s", err)
 			time.Sleep(1 * time.Second)
 			continue
 		}
@@ -327,8 +1323,8 @@ func (s *Store) monitor(ctx context.Context) error {
 
 		// Monitor as replica if another primary already exists.
 		log.Printf("existing primary found (%s), connecting as replica", primaryURL)
-		if err := s.monitorAsReplica(ctx, primaryURL); err != nil {
-			log.Printf("replica disconected, retrying: %s", err)
+		if err := s.monitorAsReplica(ctx, primaryURL); err != nil && !s.ReplicationPaused() {
+			s.errLog.Printf("replica disconected, retrying: %s", err)
 			time.Sleep(1 * time.Second)
 		}
 	}
@@ -343,6 +1339,27 @@ func (s *Store) acquireLeaseOrPrimaryURL(ctx context.Context) (Lease, string, er
 		return nil, primaryURL, nil
 	}
 
+	// A demoted node waits for another node to become primary.
+	if s.Demoted() {
+		return nil, "", fmt.Errorf("node is demoted, waiting for a new primary")
+	}
+
+	// A node in maintenance mode waits for maintenance to end or for another
+	// node to become primary.
+	if s.Maintenance() {
+		return nil, "", fmt.Errorf("node is in maintenance mode, waiting for a primary")
+	}
+
+	// Defer to nodes with a higher priority, or that are more up-to-date,
+	// before attempting to acquire.
+	if delay := s.acquireDelay(); delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
 	// If no primary, attempt to become primary.
 	lease, err := s.Leaser.Acquire(ctx)
 	if err != nil && err != ErrPrimaryExists {
@@ -372,16 +1389,26 @@ func (s *Store) monitorAsPrimary(ctx context.Context, lease Lease) error {
 		}
 	}()
 
+	// Renewals are timed with the local monotonic clock rather than the lease's
+	// RenewedAt() as that may be based on wall time, which can jump backward
+	// or forward & incorrectly extend or shrink the lease.
+	renewedAt := time.Now()
+
 	// Mark as the primary node while we're in this function.
 	s.mu.Lock()
 	s.isPrimary = true
+	s.lastRenewAt = renewedAt
 	s.mu.Unlock()
+	s.invalidatePrimary()
 
 	// Ensure that we are no longer marked as primary once we exit this function.
 	defer func() {
 		s.mu.Lock()
-		defer s.mu.Unlock()
 		s.isPrimary = false
+		s.lastRenewAt = time.Time{}
+		s.primaryContactAt = time.Now()
+		s.mu.Unlock()
+		s.invalidatePrimary()
 	}()
 
 	waitDur := lease.TTL() / 2
@@ -394,49 +1421,118 @@ func (s *Store) monitorAsPrimary(ctx context.Context, lease Lease) error {
 			//
 			// If we just have a connection error then we'll try to more
 			// aggressively retry the renewal until we exceed TTL.
+			renewStartedAt := time.Now()
 			if err := lease.Renew(ctx); err == ErrLeaseExpired {
 				return err
 			} else if err != nil {
 				// If our next renewal will exceed TTL, exit now.
-				if time.Since(lease.RenewedAt())+timeout > lease.TTL() {
+				if time.Since(renewedAt)+timeout > lease.TTL() {
 					time.Sleep(timeout)
 					return ErrLeaseExpired
 				}
 
 				// Otherwise log error and try again after a shorter period.
-				log.Printf("lease renewal error, retrying: %s", err)
+				s.errLog.Printf("lease renewal error, retrying: %s", err)
 				waitDur = time.Second
 				continue
 			}
 
-			// Renewal was successful, restart with low frequency.
+			// Renewal was successful, restart with low frequency. The lease
+			// is extended from when the request was sent, not when it returned.
+			renewedAt = renewStartedAt
+			s.mu.Lock()
+			s.lastRenewAt = renewedAt
+			s.mu.Unlock()
 			waitDur = lease.TTL() / 2
 
+		case <-s.demoteCh:
+			log.Printf("primary demoted, releasing lease")
+			return nil
+
 		case <-ctx.Done():
 			return nil // release lease when we shut down
 		}
 	}
 }
 
+// invalidatePrimary notifies the invalidator, if any, that the role of the
+// node has changed so cached primary status files are refreshed.
+func (s *Store) invalidatePrimary() {
+	if s.Invalidator == nil {
+		return
+	}
+	if err := s.Invalidator.InvalidatePrimary(); err != nil {
+		log.Printf("cannot invalidate primary status: %s", err)
+	}
+}
+
 // monitorAsReplica tries to connect to the primary node and stream down changes.
 func (s *Store) monitorAsReplica(ctx context.Context, primaryURL string) error {
 	// Store the URL of the primary while we're in this function.
 	s.mu.Lock()
 	s.primaryURL = primaryURL
 	s.mu.Unlock()
+	s.invalidatePrimary()
 
 	// Clear the primary URL once we leave this function since we can no longer connect.
 	defer func() {
 		s.mu.Lock()
-		defer s.mu.Unlock()
 		s.primaryURL = ""
+		s.mu.Unlock()
+		s.invalidatePrimary()
 	}()
 
-	posMap := s.PosMap()
-	st, err := s.Client.Stream(ctx, primaryURL, posMap)
+	// Wait while replication is paused.
+	paused, notify := s.replicationState()
+	for paused {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notify:
+		}
+		paused, notify = s.replicationState()
+	}
+
+	// Disconnect from the primary if replication is paused while streaming.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-notify:
+			cancel()
+		}
+	}()
+
+	// Discard the state of any databases being resynced so the primary sends
+	// them from scratch.
+	if err := s.resetResyncDBs(); err != nil {
+		return err
+	}
+
+	st, upstreamURL, err := s.openStream(ctx, primaryURL, s.PosMap())
 	if err != nil {
 		return fmt.Errorf("connect to primary: %s", err)
 	}
+	s.setUpstreamURL(upstreamURL)
+	defer s.setUpstreamURL("")
+
+	// Record the primary's positions so lag is known before any frames arrive.
+	if m, ok := st.(PrimaryPosMapper); ok {
+		s.setPrimaryPosMap(m.PrimaryPosMap())
+	}
+
+	// Apply frames for different databases concurrently until the replica
+	// first syncs with the primary, if enabled.
+	var warmup *warmupApplier
+	if s.WarmupConcurrency > 1 && !s.isSynced() {
+		warmup = newWarmupApplier(ctx, s, s.WarmupConcurrency)
+		defer func() {
+			if warmup != nil {
+				warmup.Close()
+			}
+		}()
+	}
 
 	for {
 		frame, err := st.NextFrame()
@@ -445,6 +1541,13 @@ func (s *Store) monitorAsReplica(ctx context.Context, primaryURL string) error {
 		} else if err != nil {
 			return fmt.Errorf("next frame: %w", err)
 		}
+		s.markPrimaryContact()
+
+		if warmup != nil {
+			if err := warmup.Err(); err != nil {
+				return err
+			}
+		}
 
 		switch frame := frame.(type) {
 		case *DBStreamFrame:
@@ -452,15 +1555,176 @@ func (s *Store) monitorAsReplica(ctx context.Context, primaryURL string) error {
 				return fmt.Errorf("process db stream frame: %w", err)
 			}
 		case *LTXStreamFrame:
-			if err := s.processLTXStreamFrame(ctx, frame, st); err != nil {
+			if warmup == nil {
+				if err := s.processLTXStreamFrame(ctx, frame, st); err != nil {
+					return fmt.Errorf("process ltx stream frame: %w", err)
+				}
+				break
+			}
+
+			dbID, apply, err := s.receiveLTXStreamFrame(ctx, frame, st)
+			if err != nil {
 				return fmt.Errorf("process ltx stream frame: %w", err)
+			} else if apply != nil {
+				warmup.Go(dbID, func(ctx context.Context) error {
+					if err := apply(ctx); err != nil {
+						return fmt.Errorf("process ltx stream frame: %w", err)
+					}
+					return nil
+				})
+			}
+		case *LTXChunkStreamFrame:
+			// Remaining chunks of a skipped LTX file. The payload is discarded
+			// by the next call to NextFrame().
+		case *DropDBStreamFrame:
+			if warmup != nil {
+				warmup.Go(frame.DBID, func(ctx context.Context) error {
+					if err := s.processDropDBStreamFrame(ctx, frame); err != nil {
+						return fmt.Errorf("process drop db stream frame: %w", err)
+					}
+					return nil
+				})
+			} else if err := s.processDropDBStreamFrame(ctx, frame); err != nil {
+				return fmt.Errorf("process drop db stream frame: %w", err)
+			}
+		case *FreezeDBStreamFrame:
+			if warmup != nil {
+				warmup.Go(frame.DBID, func(ctx context.Context) error {
+					if err := s.processFreezeDBStreamFrame(ctx, frame); err != nil {
+						return fmt.Errorf("process freeze db stream frame: %w", err)
+					}
+					return nil
+				})
+			} else if err := s.processFreezeDBStreamFrame(ctx, frame); err != nil {
+				return fmt.Errorf("process freeze db stream frame: %w", err)
+			}
+		case *CloneDBStreamFrame:
+			// The source database must be fully applied before it is copied.
+			if warmup != nil {
+				if err := warmup.Wait(); err != nil {
+					return err
+				}
+			}
+			if err := s.processCloneDBStreamFrame(ctx, frame); err != nil {
+				return fmt.Errorf("process clone db stream frame: %w", err)
+			}
+		case *CatchUpStreamFrame:
+			if err := s.processCatchUpStreamFrame(ctx, frame); err != nil {
+				return fmt.Errorf("process catch up stream frame: %w", err)
 			}
+		case *HeartbeatStreamFrame:
+			// The first heartbeat follows the initial sync of every database
+			// so frames are applied in order from here on.
+			if warmup != nil {
+				if err := warmup.Wait(); err != nil {
+					return err
+				}
+				warmup.Close()
+				warmup = nil
+				log.Printf("initial sync complete, applying transactions in order")
+			}
+
+			s.markSynced()
+			s.markResynced()
 		default:
 			return fmt.Errorf("invalid stream frame type: 0x%02x", frame.Type())
 		}
 	}
 }
 
+// PrimaryPos returns the last known position of a database on the primary.
+// Returns false if the primary's position has not been received.
+func (s *Store) PrimaryPos(dbID uint32) (Pos, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pos, ok := s.primaryPosMap[dbID]
+	return pos, ok
+}
+
+// acquireDelay returns the time to wait before acquiring the lease. This is
+// extended by BehindAcquireDelay if any database is behind the primary.
+func (s *Store) acquireDelay() time.Duration {
+	s.mu.Lock()
+	primaryPosMap := make(map[uint32]Pos, len(s.primaryPosMap))
+	for dbID, pos := range s.primaryPosMap {
+		primaryPosMap[dbID] = pos
+	}
+	s.mu.Unlock()
+
+	posMap := s.PosMap()
+	for dbID, pos := range primaryPosMap {
+		if posMap[dbID].TXID < pos.TXID {
+			return s.AcquireDelay + s.BehindAcquireDelay
+		}
+	}
+	return s.AcquireDelay
+}
+
+// setPrimaryPosMap records the primary's position of each database when a
+// stream is opened & starts a catch-up for local databases that are more than
+// one transaction behind.
+func (s *Store) setPrimaryPosMap(m map[uint32]Pos) {
+	s.mu.Lock()
+	s.primaryPosMap = make(map[uint32]Pos, len(m))
+	for dbID, pos := range m {
+		s.primaryPosMap[dbID] = pos
+	}
+	s.mu.Unlock()
+
+	for dbID, pos := range m {
+		if db := s.DB(dbID); db != nil && pos.TXID > db.TXID()+1 {
+			db.SetCatchUpTarget(pos.TXID)
+		}
+	}
+}
+
+// advancePrimaryPos updates the primary's known position of a database if the
+// replica has applied a later transaction.
+func (s *Store) advancePrimaryPos(dbID uint32, pos Pos) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.primaryPosMap == nil {
+		s.primaryPosMap = make(map[uint32]Pos)
+	}
+	if pos.TXID > s.primaryPosMap[dbID].TXID {
+		s.primaryPosMap[dbID] = pos
+	}
+}
+
+// openStream connects to the replication source, if set, and otherwise
+// streams directly from the primary. Returns the URL of the connected node.
+func (s *Store) openStream(ctx context.Context, primaryURL string, posMap map[uint32]Pos) (StreamReader, string, error) {
+	if s.SourceURL != "" && s.SourceURL != primaryURL {
+		st, err := s.Client.Stream(ctx, s.SourceURL, posMap)
+		if err == nil {
+			log.Printf("streaming from replication source %s", s.SourceURL)
+			return st, s.SourceURL, nil
+		}
+		s.errLog.Printf("cannot connect to replication source, falling back to primary: %s", err)
+	}
+	st, err := s.Client.Stream(ctx, primaryURL, posMap)
+	if err != nil {
+		return nil, "", err
+	}
+	return st, primaryURL, nil
+}
+
+// UpstreamURL returns the URL of the node the replica is currently streaming
+// from. This is the replication source when relaying & otherwise the primary.
+// Returns blank if the store is not connected to a stream.
+func (s *Store) UpstreamURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.upstreamURL
+}
+
+func (s *Store) setUpstreamURL(u string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upstreamURL = u
+}
+
 func (s *Store) processDBStreamFrame(ctx context.Context, frame *DBStreamFrame) error {
 	log.Printf("recv frame<db>: id=%d name=%q", frame.DBID, frame.Name)
 	if _, err := s.ForceCreateDB(frame.DBID, frame.Name); err != nil {
@@ -469,61 +1733,325 @@ func (s *Store) processDBStreamFrame(ctx context.Context, frame *DBStreamFrame)
 	return nil
 }
 
-func (s *Store) processLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame, r io.Reader) error {
+func (s *Store) processDropDBStreamFrame(ctx context.Context, frame *DropDBStreamFrame) error {
+	log.Printf("recv frame<drop>: id=%d", frame.DBID)
+
+	s.mu.Lock()
+	db := s.dbsByID[frame.DBID]
+	if db == nil {
+		s.mu.Unlock()
+		return nil // already removed
+	} else if s.IgnoreDrops {
+		s.mu.Unlock()
+		log.Printf("database dropped on primary, retaining local copy: id=%d name=%q", frame.DBID, db.Name())
+		return nil
+	}
+
+	err := s.dropDB(db)
+	s.markDirty(frame.DBID)
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("drop db: id=%d err=%w", frame.DBID, err)
+	}
+
+	// Remove the database from the kernel cache. This must occur outside the
+	// store lock as the kernel may call back into the file system.
+	if s.Invalidator != nil {
+		if err := s.Invalidator.InvalidateEntry(db.Name()); err != nil {
+			return fmt.Errorf("invalidate entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) processFreezeDBStreamFrame(ctx context.Context, frame *FreezeDBStreamFrame) error {
+	db := s.DB(frame.DBID)
+	if db == nil {
+		return fmt.Errorf("database not found: %s", FormatDBID(frame.DBID))
+	}
+
+	if frame.Frozen != db.Frozen() {
+		log.Printf("recv frame<freeze>: db=%s frozen=%v", FormatDBID(frame.DBID), frame.Frozen)
+	}
+	return db.SetFrozen(frame.Frozen)
+}
+
+func (s *Store) processCloneDBStreamFrame(ctx context.Context, frame *CloneDBStreamFrame) error {
+	log.Printf("recv frame<clone>: id=%d name=%q src=%d tx=%s", frame.DBID, frame.Name, frame.SrcDBID, ltx.FormatTXID(frame.Pos.TXID))
+
+	src := s.DB(frame.SrcDBID)
+	if src == nil {
+		return fmt.Errorf("source database not found: %s", FormatDBID(frame.SrcDBID))
+	} else if _, err := s.SeedDB(frame.DBID, frame.Name, src.DatabasePath(), frame.Pos); err != nil {
+		return fmt.Errorf("seed db from %q: %w", src.Name(), err)
+	}
+	return nil
+}
+
+func (s *Store) processCatchUpStreamFrame(ctx context.Context, frame *CatchUpStreamFrame) error {
+	db := s.DB(frame.DBID)
+	if db == nil {
+		return fmt.Errorf("database not found: %s", FormatDBID(frame.DBID))
+	}
+
+	log.Printf("recv frame<catch-up>: db=%s txid=%s", FormatDBID(frame.DBID), ltx.FormatTXID(frame.TXID))
+	db.SetCatchUpTarget(frame.TXID)
+	return nil
+}
+
+func (s *Store) processLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame, st StreamReader) error {
+	_, apply, err := s.receiveLTXStreamFrame(ctx, frame, st)
+	if err != nil || apply == nil {
+		return err
+	}
+	return apply(ctx)
+}
+
+// receiveLTXStreamFrame reads an LTX file from the stream into a temporary
+// file & returns a function that applies it to its database. The function is
+// nil if the transaction has already been applied. Otherwise it must be
+// called to remove the temporary file, even if ctx is done.
+//
+// Chunked files are read from the chunk frames that follow. A transaction is
+// only applied once all of its chunks have been received.
+func (s *Store) receiveLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame, st StreamReader) (dbID uint32, apply func(context.Context) error, err error) {
+	var r io.Reader = st
+	if frame.ChunkSize > 0 {
+		r = &ltxChunkReader{st: st, n: frame.Size}
+	}
+
 	// Parse header.
 	buf := make([]byte, ltx.HeaderSize)
 	var hdr ltx.Header
 	if _, err := io.ReadFull(r, buf); err != nil {
-		return fmt.Errorf("read header: %w", err)
+		return 0, nil, fmt.Errorf("read header: %w", err)
 	} else if err := hdr.UnmarshalBinary(buf); err != nil {
-		return fmt.Errorf("unmarshal header: %w", err)
+		return 0, nil, fmt.Errorf("unmarshal header: %w", err)
 	}
 
 	// Look up database.
 	db := s.DB(hdr.DBID)
 	if db == nil {
-		return fmt.Errorf("database not found: %s", FormatDBID(hdr.DBID))
+		return 0, nil, fmt.Errorf("database not found: %s", FormatDBID(hdr.DBID))
+	}
+
+	// Exit if the transaction has already been applied, e.g. from a seed.
+	if hdr.MaxTXID <= db.TXID() {
+		log.Printf("ltx file already applied, skipping: db=%d tx=(%d,%d)", hdr.DBID, hdr.MinTXID, hdr.MaxTXID)
+		return 0, nil, nil
 	}
 
 	// Exit if LTX file does already exists.
 	path := db.LTXPath(hdr.MinTXID, hdr.MaxTXID)
 	if _, err := os.Stat(path); err == nil {
 		log.Printf("ltx file already exists, skipping: %s", path)
-		return nil
+		return 0, nil, nil
 	}
 
 	log.Printf("recv frame<ltx>: db=%d tx=(%d,%d) size=%d", hdr.DBID, hdr.MinTXID, hdr.MaxTXID, frame.Size)
 
 	// Write LTX file to a temporary file and we'll atomically rename later.
 	tmpPath := path + ".tmp"
-	defer os.Remove(tmpPath)
-
 	f, err := os.Create(tmpPath)
 	if err != nil {
-		return fmt.Errorf("cannot create temp ltx file: %w", err)
+		return 0, nil, s.applyError(db, hdr, fmt.Errorf("cannot create temp ltx file: %w", err))
 	}
-	defer f.Close()
+	cleanup := func() { _ = os.Remove(tmpPath) }
 
-	// Write LTX contents.
+	// Write LTX contents. Read errors are from the connection to the primary
+	// so they are not counted as apply errors.
 	if _, err := f.Write(buf); err != nil {
-		return fmt.Errorf("write ltx header: %w", err)
+		_ = f.Close()
+		cleanup()
+		return 0, nil, s.applyError(db, hdr, fmt.Errorf("write ltx header: %w", err))
 	} else if _, err := io.CopyN(f, r, frame.Size-int64(len(buf))); err != nil {
-		return fmt.Errorf("write ltx file: %w", err)
-	} else if err := f.Sync(); err != nil {
-		return fmt.Errorf("fsync ltx file: %w", err)
+		_ = f.Close()
+		cleanup()
+		return 0, nil, fmt.Errorf("write ltx file: %w", err)
+	}
+
+	// Close the file until it is applied so that pending files received
+	// during warmup do not each hold a file descriptor.
+	if err := f.Close(); err != nil {
+		cleanup()
+		return 0, nil, s.applyError(db, hdr, fmt.Errorf("close temp ltx file: %w", err))
+	}
+
+	return db.ID(), func(ctx context.Context) error {
+		defer cleanup()
+
+		// Simulate replication lag, if enabled.
+		if s.DebugApplyDelay > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(s.DebugApplyDelay):
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := internal.Sync(tmpPath); err != nil {
+			return s.applyError(db, hdr, fmt.Errorf("fsync ltx file: %w", err))
+		}
+
+		// Atomically rename file.
+		if err := os.Rename(tmpPath, path); err != nil {
+			return s.applyError(db, hdr, fmt.Errorf("rename ltx file: %w", err))
+		}
+
+		// Attempt to apply the LTX file to the database. The file is removed on
+		// failure so that it is received again instead of skipped on retry.
+		if err := db.TryApplyLTX(path); err != nil {
+			if e := os.Remove(path); e != nil && !os.IsNotExist(e) {
+				log.Printf("cannot remove unapplied ltx file: %s", e)
+			}
+			return s.applyError(db, hdr, fmt.Errorf("apply ltx: %w", err))
+		}
+		s.advancePrimaryPos(db.ID(), db.Pos())
+
+		if err := db.RecordWriter(frame.NodeID, hdr.MinTXID); err != nil {
+			s.errLog.Printf("cannot record writer: db=%s err=%s", db.Name(), err)
+		}
+
+		return nil
+	}, nil
+}
+
+// ltxChunkReader reads a chunked LTX file as a single stream by reading each
+// chunk frame from st as the previous chunk is exhausted.
+type ltxChunkReader struct {
+	st StreamReader
+	n  int64 // bytes remaining in the file
+	cn int64 // bytes remaining in the current chunk
+}
+
+func (r *ltxChunkReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
 	}
 
-	// Atomically rename file.
-	if err := os.Rename(tmpPath, path); err != nil {
-		return fmt.Errorf("rename ltx file: %w", err)
+	for r.cn == 0 {
+		frame, err := r.st.NextFrame()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, fmt.Errorf("next chunk frame: %w", err)
+		}
+
+		chunk, ok := frame.(*LTXChunkStreamFrame)
+		if !ok {
+			return 0, fmt.Errorf("unexpected frame during chunked ltx file: %T", frame)
+		} else if chunk.Size > r.n {
+			return 0, fmt.Errorf("ltx chunk exceeds file size: %d > %d", chunk.Size, r.n)
+		}
+		r.cn = chunk.Size
 	}
 
-	// Attempt to apply the LTX file to the database.
-	if err := db.TryApplyLTX(path); err != nil {
-		return fmt.Errorf("apply ltx: %w", err)
+	if int64(len(p)) > r.cn {
+		p = p[:r.cn]
+	}
+	n, err := r.st.Read(p)
+	r.cn -= int64(n)
+	r.n -= int64(n)
+	if err == io.EOF && r.cn > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
 	}
+	return n, err
+}
 
-	return nil
+// warmupApplier applies stream frames for different databases concurrently
+// during a replica's initial sync. Frames for the same database are applied in
+// the order they were received. All methods except Err must be called from the
+// goroutine reading the stream.
+type warmupApplier struct {
+	store  *Store
+	ctx    context.Context
+	cancel func()
+	sem    chan struct{}            // limits frames being applied
+	queue  chan struct{}            // limits frames received but not yet applied
+	last   map[uint32]chan struct{} // closed once the last frame for a database is applied
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // first error applying a frame
+}
+
+func newWarmupApplier(ctx context.Context, store *Store, concurrency int) *warmupApplier {
+	ctx, cancel := context.WithCancel(ctx)
+	return &warmupApplier{
+		store:  store,
+		ctx:    ctx,
+		cancel: cancel,
+		sem:    make(chan struct{}, concurrency),
+		queue:  make(chan struct{}, WarmupMaxPendingFrames),
+		last:   make(map[uint32]chan struct{}),
+	}
+}
+
+// Go applies a frame in the background once the previous frame for the same
+// database has been applied. Blocks while the maximum number of frames are
+// pending so the replica does not read too far ahead of what it can apply.
+// After an error, fn is called with a canceled context to release resources.
+func (a *warmupApplier) Go(dbID uint32, fn func(context.Context) error) {
+	select {
+	case a.queue <- struct{}{}:
+	case <-a.ctx.Done():
+		_ = fn(a.ctx)
+		return
+	}
+
+	prev, done := a.last[dbID], make(chan struct{})
+	a.last[dbID] = done
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer func() { <-a.queue }()
+		defer close(done)
+
+		if prev != nil {
+			<-prev
+		}
+		a.sem <- struct{}{}
+		defer func() { <-a.sem }()
+
+		atomic.AddInt32(&a.store.warmupSyncN, 1)
+		warmupSyncConcurrencyMetric.Inc()
+		defer func() {
+			atomic.AddInt32(&a.store.warmupSyncN, -1)
+			warmupSyncConcurrencyMetric.Dec()
+		}()
+
+		if err := fn(a.ctx); err != nil && a.ctx.Err() == nil {
+			a.mu.Lock()
+			a.err = err
+			a.mu.Unlock()
+			a.cancel()
+		}
+	}()
+}
+
+// Err returns the first error that occurred while applying a frame.
+func (a *warmupApplier) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Wait blocks until all frames have been applied & returns the first error.
+func (a *warmupApplier) Wait() error {
+	a.wg.Wait()
+	return a.Err()
+}
+
+// Close cancels frames that have not been applied & waits for the rest.
+func (a *warmupApplier) Close() {
+	a.cancel()
+	a.wg.Wait()
 }
 
 // Subscriber subscribes to changes to databases in the store.
@@ -581,3 +2109,290 @@ func (s *Subscriber) DirtySet() map[uint32]struct{} {
 	s.dirtySet = make(map[uint32]struct{})
 	return dirtySet
 }
+
+// CommitEvent describes a transaction committed on the primary. It is
+// delivered to hooks registered with Store.AddCommitHook.
+type CommitEvent struct {
+	DBID      uint32
+	Name      string
+	TXID      uint64
+	PageN     uint32 // number of pages changed by the transaction
+	Timestamp time.Time
+}
+
+// CommitHook delivers commit events to a callback on its own goroutine.
+type CommitHook struct {
+	store *Store
+	fn    func(CommitEvent)
+	ch    chan CommitEvent
+}
+
+// newCommitHook returns a new instance of CommitHook & begins delivering events.
+func newCommitHook(store *Store, fn func(CommitEvent)) *CommitHook {
+	h := &CommitHook{
+		store: store,
+		fn:    fn,
+		ch:    make(chan CommitEvent, CommitHookBufferSize),
+	}
+	go h.run()
+	return h
+}
+
+// Close removes the hook from the store. Queued events are still delivered.
+func (h *CommitHook) Close() error {
+	h.store.RemoveCommitHook(h)
+	return nil
+}
+
+func (h *CommitHook) run() {
+	for event := range h.ch {
+		h.fn(event)
+	}
+}
+
+// ApplyError describes an error applying a transaction received from the
+// primary on a replica.
+type ApplyError struct {
+	DBID       uint32
+	TXID       uint64
+	Err        string
+	OccurredAt time.Time
+}
+
+// NodeIDCollision describes a peer that reported the same node ID as this
+// node, such as a replica started from a cloned config or image.
+type NodeIDCollision struct {
+	NodeID     string
+	PeerURL    string
+	RemoteAddr string
+	DetectedAt time.Time
+}
+
+// ChecksumMismatch describes a checksum mismatch detected on a database, such
+// as a replicated transaction that does not follow the local database state.
+type ChecksumMismatch struct {
+	DBID       uint32
+	TXID       uint64
+	DetectedAt time.Time
+}
+
+// monitorSegmentCompression periodically compresses stored LTX files until ctx is done.
+func (s *Store) monitorSegmentCompression(ctx context.Context) {
+	ticker := time.NewTicker(SegmentCompressionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.CompressSegments(); err != nil {
+				s.errLog.Printf("segment compression: %s", err)
+			}
+		}
+	}
+}
+
+// CompressSegments compresses stored LTX files for all databases according to
+// the store's SegmentCompression setting.
+func (s *Store) CompressSegments() error {
+	if s.SegmentCompression != SegmentCompressionGzip {
+		return nil
+	}
+
+	for _, db := range s.DBs() {
+		if _, err := db.CompressLTXFiles(); err != nil {
+			return fmt.Errorf("db %s: %w", FormatDBID(db.ID()), err)
+		}
+	}
+	return nil
+}
+
+// monitorIntegrity periodically verifies the checksum of every database until
+// ctx is done. Mismatches are recorded by the database.
+func (s *Store) monitorIntegrity(ctx context.Context) {
+	ticker := time.NewTicker(s.IntegrityCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, db := range s.DBs() {
+			// Checks interrupted by a change are retried on the next tick.
+			_, err := db.VerifyChecksum(ctx, IntegrityCheckBatchSize, IntegrityCheckBatchDelay)
+			if ctx.Err() != nil {
+				return
+			} else if err != nil && !errors.Is(err, ErrChecksumMismatch) {
+				s.errLog.Printf("integrity check: db=%s err=%s", db.Name(), err)
+			}
+		}
+	}
+}
+
+// writeBufferEnabled returns true if the primary may defer fsyncs of commits.
+func (s *Store) writeBufferEnabled() bool {
+	return s.WriteBufferWindow > 0 && !s.DurableBeforeReplicate
+}
+
+// groupCommitEnabled returns true if the primary combines commits made within
+// the group commit window into a single LTX file.
+func (s *Store) groupCommitEnabled() bool {
+	return s.GroupCommitWindow > 0 && s.GroupCommitMaxSize > 1
+}
+
+// monitorWriteBuffer periodically fsyncs buffered transactions on every
+// database until ctx is done. Flushing twice per window ensures transactions
+// are durable within the window.
+func (s *Store) monitorWriteBuffer(ctx context.Context) {
+	ticker := time.NewTicker(s.WriteBufferWindow / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, db := range s.DBs() {
+			if err := db.FlushWriteBuffer(); err != nil {
+				s.errLog.Printf("flush write buffer: db=%s err=%s", db.Name(), err)
+			}
+		}
+	}
+}
+
+// ApplyErrors returns the number of errors applying replicated transactions
+// since the store was created & the most recent error.
+func (s *Store) ApplyErrors() (n uint64, last ApplyError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.applyErrorN, s.lastApplyError
+}
+
+// NodeIDCollisions returns the number of peers seen with the same node ID as
+// this node since the store was created & the most recent one.
+func (s *Store) NodeIDCollisions() (n uint64, last NodeIDCollision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nodeIDCollisionN, s.lastNodeIDCollision
+}
+
+// RecordNodeIDCollision records a peer at peerURL that reported the same node
+// ID as this node. Primacy & write attribution are ambiguous while two nodes
+// share an ID so the collision is logged as an alert.
+func (s *Store) RecordNodeIDCollision(peerURL, remoteAddr string) {
+	s.mu.Lock()
+	s.nodeIDCollisionN++
+	s.lastNodeIDCollision = NodeIDCollision{
+		NodeID:     s.ID,
+		PeerURL:    peerURL,
+		RemoteAddr: remoteAddr,
+		DetectedAt: time.Now(),
+	}
+	s.mu.Unlock()
+	nodeIDCollisionCountMetric.Inc()
+
+	log.Printf("ALERT: duplicate node id detected: id=%q peer=%q remote=%s; each node must have a unique id", s.ID, peerURL, remoteAddr)
+}
+
+// applyError records an error applying a replicated transaction & begins
+// recovery according to the ApplyErrorPolicy. Returns err so the replica
+// disconnects from the primary.
+func (s *Store) applyError(db *DB, hdr ltx.Header, err error) error {
+	s.mu.Lock()
+	s.applyErrorN++
+	s.lastApplyError = ApplyError{
+		DBID:       db.ID(),
+		TXID:       hdr.MaxTXID,
+		Err:        err.Error(),
+		OccurredAt: time.Now(),
+	}
+	s.mu.Unlock()
+	applyErrorCountMetric.Inc()
+
+	log.Printf("ALERT: cannot apply transaction: db=%s tx=%s policy=%s err=%s",
+		FormatDBID(db.ID()), ltx.FormatTXIDRange(hdr.MinTXID, hdr.MaxTXID), s.ApplyErrorPolicy, err)
+
+	if s.ApplyErrorPolicy == ApplyErrorPolicyResync {
+		if e := s.ResyncDB(db.Name()); e != nil {
+			log.Printf("cannot resync database after apply error: db=%s err=%s", FormatDBID(db.ID()), e)
+		}
+	}
+	return err
+}
+
+// ChecksumMismatches returns the number of checksum mismatches detected since
+// the store was created & the most recent mismatch.
+func (s *Store) ChecksumMismatches() (n uint64, last ChecksumMismatch) {
+	s.checksumMu.Lock()
+	defer s.checksumMu.Unlock()
+	return s.checksumMismatchN, s.lastChecksumMismatch
+}
+
+// recordChecksumMismatch records a checksum mismatch detected on a database
+// at the given transaction.
+func (s *Store) recordChecksumMismatch(dbID uint32, txID uint64) {
+	s.checksumMu.Lock()
+	defer s.checksumMu.Unlock()
+
+	s.checksumMismatchN++
+	s.lastChecksumMismatch = ChecksumMismatch{DBID: dbID, TXID: txID, DetectedAt: time.Now()}
+	checksumMismatchCountMetric.Inc()
+
+	log.Printf("ALERT: checksum mismatch detected: db=%s tx=%s", FormatDBID(dbID), ltx.FormatTXID(txID))
+}
+
+// Store metrics.
+var (
+	checksumMismatchCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_checksum_mismatch_total",
+		Help: "Number of page or transaction checksum mismatches detected.",
+	})
+
+	commitHookDropCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_commit_hook_dropped_total",
+		Help: "Number of commit events dropped because a commit hook fell behind.",
+	})
+
+	applyErrorCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_replica_apply_error_total",
+		Help: "Number of errors applying transactions received from the primary.",
+	})
+
+	nodeIDCollisionCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_node_id_collision_total",
+		Help: "Number of peers seen with the same node ID as this node.",
+	})
+
+	warmupSyncConcurrencyMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_warmup_sync_concurrency",
+		Help: "Number of databases a replica is applying concurrently during its initial sync.",
+	})
+
+	writeBufferDepthMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_write_buffer_depth",
+		Help: "Number of committed transactions waiting to be fsynced by the primary.",
+	})
+
+	groupCommitSizeMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "litefs_group_commit_size",
+		Help:    "Number of transactions combined into each group commit on the primary.",
+		Buckets: []float64{1, 2, 5, 10, 25, 50, 100, 250},
+	})
+
+	invalidateCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_invalidate_total",
+		Help: "Number of page cache invalidations issued while applying transactions.",
+	})
+
+	invalidateSecondsMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "litefs_invalidate_seconds",
+		Help:    "Time spent issuing each page cache invalidation while applying transactions.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	})
+)
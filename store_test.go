@@ -1,14 +1,61 @@
 package litefs_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/superfly/litefs"
 	"github.com/superfly/litefs/internal/testingutil"
+	"github.com/superfly/ltx"
 )
 
+// Ensure writes are rejected while free space is below the minimum and
+// accepted again once space is freed.
+func TestStore_MinFreeSpace(t *testing.T) {
+	var free uint64 = 1 << 30
+	store := newStore(t)
+	store.MinFreeSpace = 1 << 20
+	store.FreeSpaceFunc = func(path string) (uint64, error) { return free, nil }
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+
+	// Drop below the threshold.
+	free = 1 << 10
+	if _, err := db.CreateJournal(); err != litefs.ErrNoSpace {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, _, err := store.CreateDB("other"); err != litefs.ErrNoSpace {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Free up space & ensure writes resume.
+	free = 1 << 30
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	if got, want := db.TXID(), uint64(2); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+}
+
 // Ensure store can create a new, empty database.
 func TestStore_CreateDB(t *testing.T) {
 	store := newOpenStore(t)
@@ -89,8 +136,701 @@ func TestStore_Open(t *testing.T) {
 	})
 }
 
+// Ensure database IDs are stable across restarts.
+// Ensure a standalone store is primary immediately without a leaser.
+func TestStore_Open_Standalone(t *testing.T) {
+	store := newStore(t)
+	store.Standalone = true
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if !store.IsPrimary() {
+		t.Fatal("expected primary")
+	}
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 0)
+	if got, want := db.TXID(), uint64(1); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+}
+
+// Ensure new write transactions are rejected once writes are stopped for
+// shutdown & that committed transactions can still be flushed.
+func TestStore_StopWrites(t *testing.T) {
+	store := newOpenStore(t)
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+
+	store.StopWrites()
+	if _, err := db.CreateJournal(); err != litefs.ErrShuttingDown {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := store.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := db.SyncedPos(), db.Pos(); got != want {
+		t.Fatalf("SyncedPos=%v, want %v", got, want)
+	}
+}
+
+// Ensure a dropped database leaves a tombstone so its ID is not reused.
+func TestStore_DropDB(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.db", "b.db"} {
+		if _, f, err := store.CreateDB(name); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.DropDB("b.db"); err != nil {
+		t.Fatal(err)
+	} else if store.DBByName("b.db") != nil {
+		t.Fatal("expected database to be removed")
+	} else if err := store.DropDB("b.db"); err != litefs.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen and ensure the dropped ID is not reused.
+	store = litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if got, want := len(store.DBs()), 1; got != want {
+		t.Fatalf("len(DBs)=%d, want %d", got, want)
+	} else if !store.IsDropped(2) {
+		t.Fatal("expected tombstone")
+	}
+
+	db, f, err := store.CreateDB("b.db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.ID(), uint32(3); got != want {
+		t.Fatalf("ID=%v, want %v", got, want)
+	}
+}
+
+func TestStore_Reopen(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.db", "b.db"} {
+		if _, f, err := store.CreateDB(name); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen store on the same data directory.
+	store = litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if db := store.DBByName("a.db"); db == nil {
+		t.Fatal("expected database")
+	} else if got, want := db.ID(), uint32(1); got != want {
+		t.Fatalf("ID=%v, want %v", got, want)
+	}
+	if db := store.DBByName("b.db"); db == nil {
+		t.Fatal("expected database")
+	} else if got, want := db.ID(), uint32(2); got != want {
+		t.Fatalf("ID=%v, want %v", got, want)
+	}
+
+	// Ensure new databases do not reuse existing identifiers.
+	db, f, err := store.CreateDB("c.db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.ID(), uint32(3); got != want {
+		t.Fatalf("ID=%v, want %v", got, want)
+	}
+}
+
+// Ensure compressed LTX files take less space than uncompressed files & can
+// still be replayed & recovered.
+func TestStore_CompressSegments(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	store.SegmentCompression = litefs.SegmentCompressionGzip
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	const txN = 10
+	for i := uint32(1); i <= txN; i++ {
+		testingutil.MustWriteTx(t, db, i, i, byte(i))
+	}
+	pos := db.Pos()
+
+	uncompressedSize := dirSize(t, db.LTXDir())
+	if err := store.CompressSegments(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dirSize(t, db.LTXDir()); got >= uncompressedSize {
+		t.Fatalf("size=%d, expected less than %d", got, uncompressedSize)
+	}
+
+	// Only the latest LTX file should remain uncompressed.
+	if _, err := os.Stat(db.LTXPath(1, 1)); !os.IsNotExist(err) {
+		t.Fatalf("expected uncompressed file to be removed: %v", err)
+	} else if _, err := os.Stat(db.LTXPath(1, 1) + litefs.CompressedLTXExt); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(db.LTXPath(txN, txN)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay the compressed files onto a replica.
+	replicaDB, _ := newDB(t, "db")
+	for i := uint64(1); i <= txN; i++ {
+		if err := replicaDB.TryApplyLTX(db.LTXPath(i, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := replicaDB.Pos(), pos; got != want {
+		t.Fatalf("replica pos=%#v, want %#v", got, want)
+	}
+
+	// Ensure compressed files are served decompressed.
+	ltxFile, err := db.OpenLTXFile(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ltxFile.Close()
+
+	var hdr ltx.Header
+	if err := ltx.NewHeaderBlockReader(ltxFile).ReadHeader(&hdr); err != nil {
+		t.Fatal(err)
+	} else if got, want := hdr.MaxTXID, uint64(1); got != want {
+		t.Fatalf("MaxTXID=%d, want %d", got, want)
+	}
+
+	// Reopen the store & ensure the position is recovered.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store = litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if got, want := store.DBByName("db").Pos(), pos; got != want {
+		t.Fatalf("pos=%#v, want %#v", got, want)
+	}
+	if committedAt, err := store.DBByName("db").CommittedAt(1); err != nil {
+		t.Fatal(err)
+	} else if committedAt.IsZero() {
+		t.Fatal("expected commit time from compressed file")
+	}
+}
+
+// Ensure the background integrity check detects a page corrupted on disk.
+func TestStore_IntegrityCheck(t *testing.T) {
+	store := newStore(t)
+	store.IntegrityCheckInterval = 50 * time.Millisecond
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= 3; i++ {
+		testingutil.MustWriteTx(t, db, i, i, byte(i))
+	}
+
+	// An intact database passes verification.
+	if ok, err := db.VerifyChecksum(context.Background(), 1, 0); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected verification to complete")
+	}
+
+	// Flip a byte in the second page as if the disk had corrupted it.
+	dbf, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := dbf.WriteAt([]byte{0xFF}, 4096+100); err != nil {
+		t.Fatal(err)
+	} else if err := dbf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if n, _ := store.ChecksumMismatches(); n == 0 {
+			return fmt.Errorf("mismatch not detected")
+		}
+		return nil
+	})
+	if _, last := store.ChecksumMismatches(); last.DBID != db.ID() {
+		t.Fatalf("DBID=%d, want %d", last.DBID, db.ID())
+	} else if got, want := last.TXID, uint64(3); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+
+	if _, err := db.VerifyChecksum(context.Background(), 1, 0); !errors.Is(err, litefs.ErrChecksumMismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// dirSize returns the total size of the files in dir.
+func dirSize(tb testing.TB, dir string) (n int64) {
+	tb.Helper()
+
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, ent := range ents {
+		fi, err := ent.Info()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		n += fi.Size()
+	}
+	return n
+}
+
+// Ensure opening a store fails if recovery takes longer than the limit.
+func TestStore_Open_MaxRecoveryDuration(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.db", "b.db", "c.db"} {
+		db, f, err := store.CreateDB(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, db, 1, 1, 1)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Unlimited", func(t *testing.T) {
+		store := litefs.NewStore(path)
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		} else if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Any recovery is slower than a nanosecond so the limit is exceeded
+	// after the first database is opened.
+	t.Run("Exceeded", func(t *testing.T) {
+		store := litefs.NewStore(path)
+		store.MaxRecoveryDuration = time.Nanosecond
+		defer func() { _ = store.Close() }()
+
+		err := store.Open()
+		if !errors.Is(err, litefs.ErrRecoveryTimeout) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !strings.Contains(err.Error(), "recovered 1 of 3 databases") {
+			t.Fatalf("expected progress in error: %s", err)
+		}
+	})
+}
+
+// Ensure a store's state can be exported, wiped & imported so the node resumes
+// with the same database IDs & positions.
+func TestStore_ExportImportState(t *testing.T) {
+	path := t.TempDir()
+
+	store := litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.db", "b.db", "c.db"} {
+		db, f, err := store.CreateDB(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testingutil.MustWriteTx(t, db, 1, 1, 1)
+	}
+	testingutil.MustWriteTx(t, store.DBByName("c.db"), 1, 1, 2)
+	if err := store.DropDB("b.db"); err != nil {
+		t.Fatal(err)
+	}
+	posMap := store.PosMap()
+
+	var buf bytes.Buffer
+	if err := store.ExportState(&buf); err != nil {
+		t.Fatal(err)
+	} else if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wipe the data directory & restore it from the archive.
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	} else if err := litefs.ImportState(path, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	store = litefs.NewStore(path)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if got, want := store.PosMap(), posMap; !reflect.DeepEqual(got, want) {
+		t.Fatalf("PosMap=%#v, want %#v", got, want)
+	} else if db := store.DBByName("a.db"); db == nil || db.ID() != 1 {
+		t.Fatalf("unexpected database: %#v", db)
+	} else if db := store.DBByName("c.db"); db == nil || db.ID() != 3 {
+		t.Fatalf("unexpected database: %#v", db)
+	} else if !store.IsDropped(2) {
+		t.Fatal("expected tombstone")
+	}
+
+	// Ensure writes resume from the imported position.
+	db := store.DBByName("c.db")
+	testingutil.MustWriteTx(t, db, 1, 1, 3)
+	if got, want := db.TXID(), uint64(3); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+
+	// Ensure importing into a non-empty data directory fails.
+	if err := litefs.ImportState(path, bytes.NewReader(buf.Bytes())); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure an import is rejected & removed if the archive does not match its
+// manifest, such as when a transaction file is missing.
+func TestStore_ImportState_Mismatch(t *testing.T) {
+	store := newOpenStore(t)
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 1, 1, 2)
+
+	var buf bytes.Buffer
+	if err := store.ExportState(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the archive without the latest transaction file.
+	var other bytes.Buffer
+	tr, tw := tar.NewReader(&buf), tar.NewWriter(&other)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		} else if strings.HasSuffix(hdr.Name, "0000000000000002-0000000000000002.ltx") {
+			continue
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		} else if _, err := io.Copy(tw, tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := t.TempDir()
+	if err := litefs.ImportState(path, &other); !errors.Is(err, litefs.ErrStateMismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ents, err := os.ReadDir(path); err != nil {
+		t.Fatal(err)
+	} else if len(ents) != 0 {
+		t.Fatalf("expected empty data directory, got %d entries", len(ents))
+	}
+}
+
+// Ensure the node with the shorter acquire delay wins the election when both
+// nodes are available.
+func TestStore_AcquireDelay(t *testing.T) {
+	leaser := testingutil.NewLeaser()
+
+	low := newStore(t)
+	low.Leaser = leaser.Node("http://low")
+	low.Client = &blockingClient{}
+	low.AcquireDelay = 500 * time.Millisecond
+
+	high := newStore(t)
+	high.Leaser = leaser.Node("http://high")
+	high.Client = &blockingClient{}
+
+	// Start the low priority node first to ensure it defers.
+	if err := low.Open(); err != nil {
+		t.Fatal(err)
+	} else if err := high.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if !high.IsPrimary() {
+			return fmt.Errorf("high priority node is not primary")
+		} else if got, want := low.PrimaryURL(), "http://high"; got != want {
+			return fmt.Errorf("PrimaryURL=%q, want %q", got, want)
+		}
+		return nil
+	})
+	if low.IsPrimary() {
+		t.Fatal("expected low priority node to be a replica")
+	}
+}
+
+// Ensure a node that is behind the primary's last known position defers to an
+// up-to-date node, even if the up-to-date node has a longer acquire delay.
+func TestStore_AcquireDelay_Behind(t *testing.T) {
+	leaser := testingutil.NewLeaser()
+	lease, err := leaser.Node("http://old").Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	behind := newStore(t)
+	behind.Leaser = leaser.Node("http://behind")
+	behind.Client = &primaryPosClient{done: done, posMap: map[uint32]litefs.Pos{1: {TXID: 5}}}
+	behind.BehindAcquireDelay = time.Second
+
+	current := newStore(t)
+	current.Leaser = leaser.Node("http://current")
+	current.Client = &primaryPosClient{done: done}
+	current.AcquireDelay = 200 * time.Millisecond
+
+	if err := behind.Open(); err != nil {
+		t.Fatal(err)
+	} else if err := current.Open(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if _, ok := behind.PrimaryPos(1); !ok {
+			return fmt.Errorf("primary position not received")
+		}
+		return nil
+	})
+
+	// Lose the primary & disconnect both replicas at the same time.
+	if err := lease.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 10*time.Second, func() error {
+		if !current.IsPrimary() {
+			return fmt.Errorf("up-to-date node is not primary")
+		} else if got, want := behind.PrimaryURL(), "http://current"; got != want {
+			return fmt.Errorf("PrimaryURL=%q, want %q", got, want)
+		}
+		return nil
+	})
+	if behind.IsPrimary() {
+		t.Fatal("expected node behind the primary to be a replica")
+	}
+}
+
+// Ensure a backward jump of the wall clock does not extend the lease when
+// renewals fail.
+func TestStore_LeaseClockJump(t *testing.T) {
+	store := newStore(t)
+	store.Client = &blockingClient{}
+	store.Leaser = &unreachableLeaser{
+		ttl: 2 * time.Second,
+
+		// The lease reports a renewal an hour ahead of the local wall clock,
+		// as if the local clock jumped backward after the lease was renewed.
+		now: func() time.Time { return time.Now().Add(time.Hour).Round(0) },
+	}
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if !store.IsPrimary() {
+			return fmt.Errorf("store is not primary")
+		}
+		return nil
+	})
+	if d := time.Since(store.LastRenewAt()); d < 0 {
+		t.Fatalf("last renewal reported %s in the future", -d)
+	}
+
+	// The store must step down once the TTL passes without a renewal.
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if store.IsPrimary() {
+			return fmt.Errorf("store is still primary")
+		}
+		return nil
+	})
+}
+
+// Ensure commit hooks receive every commit in order & stop once closed.
+func TestStore_CommitHook(t *testing.T) {
+	store := newOpenStore(t)
+	db, f, err := store.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan litefs.CommitEvent, 10)
+	hook := store.AddCommitHook(func(event litefs.CommitEvent) { ch <- event })
+
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+	testingutil.MustWriteTx(t, db, 2, 2, 2)
+	testingutil.MustWriteTx(t, db, 3, 3, 3)
+
+	for i, pageN := range []uint32{1, 2, 2} {
+		select {
+		case event := <-ch:
+			if got, want := event.TXID, uint64(i+1); got != want {
+				t.Fatalf("TXID=%d, want %d", got, want)
+			} else if event.DBID != db.ID() || event.Name != "db" {
+				t.Fatalf("unexpected database: %#v", event)
+			} else if got, want := event.PageN, pageN; got != want {
+				t.Fatalf("PageN=%d, want %d", got, want)
+			} else if event.Timestamp.IsZero() {
+				t.Fatal("expected timestamp")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for commit event")
+		}
+	}
+
+	// No events are delivered after the hook is closed.
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 3, 4)
+	select {
+	case event := <-ch:
+		t.Fatalf("unexpected event: %#v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 // newStore returns a new instance of a Store on a temporary directory.
 // This store will automatically close when the test ends.
+// Ensure a chunked LTX file is not applied if the stream disconnects before
+// every chunk has been received.
+func TestStore_ChunkedLTX_Partial(t *testing.T) {
+	// Generate an LTX file on a separate store.
+	db, f := newDB(t, "db")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.MustWriteTx(t, db, 1, 1, 1)
+
+	lf, err := db.OpenLTXFile(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(lf)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Send only the first of several chunks before disconnecting.
+	const chunkSize = 1024
+	client := &chunkedStreamClient{frames: []chunkedStreamFrame{
+		{frame: &litefs.DBStreamFrame{DBID: db.ID(), Name: "db"}},
+		{frame: &litefs.LTXStreamFrame{Size: int64(len(data)), ChunkSize: chunkSize}},
+		{frame: &litefs.LTXChunkStreamFrame{Size: chunkSize}, payload: data[:chunkSize]},
+	}}
+
+	leaser := testingutil.NewLeaser()
+	if _, err := leaser.Node("http://primary").Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newStore(t)
+	store.Leaser = leaser.Node("http://replica")
+	store.Client = client
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the replica to reconnect after the partial stream.
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if atomic.LoadInt32(&client.n) < 2 {
+			return fmt.Errorf("waiting for reconnect")
+		}
+		return nil
+	})
+
+	other := store.DB(db.ID())
+	if other == nil {
+		t.Fatal("expected database")
+	} else if got, want := other.TXID(), uint64(0); got != want {
+		t.Fatalf("TXID=%d, want %d", got, want)
+	}
+	if ents, err := os.ReadDir(other.LTXDir()); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	} else if len(ents) != 0 {
+		t.Fatalf("expected no ltx files, got %s", ents[0].Name())
+	}
+}
+
 func newStore(tb testing.TB) *litefs.Store {
 	store := litefs.NewStore(tb.TempDir())
 	tb.Cleanup(func() {
@@ -117,3 +857,124 @@ func newStoreFromFixture(tb testing.TB, path string) *litefs.Store {
 	testingutil.MustCopyDir(tb, path, store.Path())
 	return store
 }
+
+// unreachableLeaser grants a single lease whose renewals always fail with a
+// transient error. The lease reports renewal times from the now function.
+type unreachableLeaser struct {
+	ttl     time.Duration
+	now     func() time.Time
+	granted int32
+}
+
+func (l *unreachableLeaser) Close() error         { return nil }
+func (l *unreachableLeaser) AdvertiseURL() string { return "http://localhost:20202" }
+
+func (l *unreachableLeaser) Acquire(ctx context.Context) (litefs.Lease, error) {
+	if !atomic.CompareAndSwapInt32(&l.granted, 0, 1) {
+		return nil, litefs.ErrPrimaryExists
+	}
+	return &unreachableLease{ttl: l.ttl, renewedAt: l.now()}, nil
+}
+
+func (l *unreachableLeaser) PrimaryURL(ctx context.Context) (string, error) {
+	return "", litefs.ErrNoPrimary
+}
+
+type unreachableLease struct {
+	ttl       time.Duration
+	renewedAt time.Time
+}
+
+func (l *unreachableLease) RenewedAt() time.Time { return l.renewedAt }
+func (l *unreachableLease) TTL() time.Duration   { return l.ttl }
+func (l *unreachableLease) Close() error         { return nil }
+
+func (l *unreachableLease) Renew(ctx context.Context) error {
+	return fmt.Errorf("connection refused")
+}
+
+// chunkedStreamClient returns a stream that sends frames & then disconnects.
+// Subsequent streams block until they are closed.
+type chunkedStreamClient struct {
+	n      int32
+	frames []chunkedStreamFrame
+}
+
+type chunkedStreamFrame struct {
+	frame   litefs.StreamFrame
+	payload []byte
+}
+
+func (c *chunkedStreamClient) Stream(ctx context.Context, rawurl string, posMap map[uint32]litefs.Pos) (litefs.StreamReader, error) {
+	if atomic.AddInt32(&c.n, 1) > 1 {
+		return &blockingStreamReader{ctx: ctx}, nil
+	}
+	return &chunkedStreamReader{frames: c.frames}, nil
+}
+
+type chunkedStreamReader struct {
+	frames []chunkedStreamFrame
+	r      bytes.Reader
+}
+
+func (r *chunkedStreamReader) Read(p []byte) (int, error) { return r.r.Read(p) }
+func (r *chunkedStreamReader) Close() error               { return nil }
+
+func (r *chunkedStreamReader) NextFrame() (litefs.StreamFrame, error) {
+	if len(r.frames) == 0 {
+		return nil, io.EOF
+	}
+	frame := r.frames[0]
+	r.frames = r.frames[1:]
+	r.r.Reset(frame.payload)
+	return frame.frame, nil
+}
+
+// primaryPosClient returns streams that report posMap as the primary's
+// position & block until done is closed.
+type primaryPosClient struct {
+	done   chan struct{}
+	posMap map[uint32]litefs.Pos
+}
+
+func (c *primaryPosClient) Stream(ctx context.Context, rawurl string, posMap map[uint32]litefs.Pos) (litefs.StreamReader, error) {
+	return &primaryPosStreamReader{ctx: ctx, done: c.done, posMap: c.posMap}, nil
+}
+
+type primaryPosStreamReader struct {
+	ctx    context.Context
+	done   chan struct{}
+	posMap map[uint32]litefs.Pos
+}
+
+func (r *primaryPosStreamReader) Read(p []byte) (int, error)           { return 0, io.EOF }
+func (r *primaryPosStreamReader) Close() error                         { return nil }
+func (r *primaryPosStreamReader) PrimaryPosMap() map[uint32]litefs.Pos { return r.posMap }
+
+func (r *primaryPosStreamReader) NextFrame() (litefs.StreamFrame, error) {
+	select {
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	case <-r.done:
+		return nil, io.EOF
+	}
+}
+
+// blockingClient is a client whose streams block until they are closed.
+type blockingClient struct{}
+
+func (c *blockingClient) Stream(ctx context.Context, rawurl string, posMap map[uint32]litefs.Pos) (litefs.StreamReader, error) {
+	return &blockingStreamReader{ctx: ctx}, nil
+}
+
+type blockingStreamReader struct {
+	ctx context.Context
+}
+
+func (r *blockingStreamReader) Read(p []byte) (int, error) { return 0, io.EOF }
+func (r *blockingStreamReader) Close() error               { return nil }
+
+func (r *blockingStreamReader) NextFrame() (litefs.StreamFrame, error) {
+	<-r.ctx.Done()
+	return nil, r.ctx.Err()
+}